@@ -9,15 +9,10 @@ import (
 	"syscall"
 	"time"
 
-	_ "github.com/sergeyreshetnyakov/notion/docs"
-	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/app"
 	"github.com/sergeyreshetnyakov/notion/internal/config"
-	notehandler "github.com/sergeyreshetnyakov/notion/internal/handlers/note"
 	"github.com/sergeyreshetnyakov/notion/internal/lib/logger"
 	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
-	"github.com/sergeyreshetnyakov/notion/internal/middlewares"
-	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
-	httpSwagger "github.com/swaggo/http-swagger/v2"
 )
 
 //	@title			Notion
@@ -35,21 +30,21 @@ import (
 //	@host		localhost:8080
 //	@BasePath	/api/v1
 
+//	@securityDefinitions.apikey	AdminToken
+//	@in							header
+//	@name						Authorization
+//	@description				"Bearer " followed by the admin_token of the config.
+
 func main() {
 	cfg := config.MustLoad()
 	log := logger.SetupLogger(cfg.Env)
 
-	mux := http.NewServeMux()
-
-	mux.Handle("GET /swagger/", httpSwagger.WrapHandler)
-
-	storage, shutdownDB := notestorage.New(cfg.StoragePath, log)
-	notehandler.New(log, notes.New(storage)).HandleRoutes(mux)
-
-	wrappedMux := middlewares.LoggingMiddleware(mux, log)
+	application := app.New(cfg, log)
+	// Imports, restores and uploads lift the read timeout, exports, backups
+	// and downloads the write timeout.
 	server := http.Server{
 		Addr:           cfg.Port,
-		Handler:        wrappedMux,
+		Handler:        application.Handler,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		MaxHeaderBytes: 1 << 20,
@@ -73,6 +68,8 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		log.Error("HTTP shutdown error: %v", sl.Err(err))
 	}
-	shutdownDB()
+	if err := application.Close(); err != nil {
+		log.Error("Storage shutdown error", sl.Err(err))
+	}
 	log.Info("Graceful shutdown complete")
 }
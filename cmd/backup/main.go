@@ -0,0 +1,115 @@
+// Command backup writes the notes database to a JSON Lines backup, or
+// restores one into an empty database:
+//
+//	backup -config_path ./configs/config_dev.yaml [-file notes.jsonl] dump
+//	backup -config_path ./configs/config_dev.yaml [-file notes.jsonl] restore
+//
+// Without -file the backup is written to stdout and read from stdin.
+// Attachments are backed up as metadata only: copy the attachment directory
+// along with the backup.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/config"
+	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
+)
+
+func main() {
+	file := flag.String("file", "", "backup file, stdin or stdout if empty")
+	cfg := config.MustLoad()
+
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: backup [-config_path path] [-file path] dump|restore")
+		os.Exit(2)
+	}
+
+	// Messages go to stderr, stdout may hold the backup.
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	storage, shutdown := open(cfg, log)
+	defer shutdown()
+	n := notes.New(storage, notes.Limits{})
+
+	var err error
+	switch flag.Arg(0) {
+	case "dump":
+		err = dump(n, *file)
+	case "restore":
+		err = restore(n, *file)
+	default:
+		err = fmt.Errorf("unknown command %q, want dump or restore", flag.Arg(0))
+	}
+	if err != nil {
+		shutdown()
+		fmt.Fprintln(os.Stderr, "backup:", err)
+		os.Exit(1)
+	}
+}
+
+func open(cfg config.Config, log *slog.Logger) (notes.Storage, func() error) {
+	if cfg.Storage == config.StorageMemory {
+		return notestorage.NewMemory(cfg.SnapshotPath, log)
+	}
+	if cfg.StoragePath == "" {
+		panic("storage_path is empty")
+	}
+	masterKey, err := cfg.MasterKey()
+	if err != nil {
+		panic("cannot read the master key: " + err.Error())
+	}
+	// Backups and restores take as long as they need.
+	return notestorage.New(cfg.StoragePath, log, notestorage.Options{
+		MasterKey:  masterKey,
+		TitleIndex: cfg.EncryptionIndex == config.EncryptionIndexTitles,
+	})
+}
+
+func dump(n notes.Notes, path string) error {
+	f := os.Stdout
+	if path != "" {
+		var err error
+		if f, err = os.Create(path); err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+
+	trailer, err := n.Backup(context.Background(), f)
+	if err != nil {
+		return err
+	}
+	// A backup is only complete once it is on disk.
+	if err := f.Sync(); err != nil && path != "" {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "backed up %d records, sha256 %s\n", trailer.Records, trailer.SHA256)
+	return nil
+}
+
+func restore(n notes.Notes, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	report, err := n.Restore(context.Background(), r)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "restored %d notes and %d links, sha256 %s\n", report.Notes, report.Links, report.SHA256)
+	return nil
+}
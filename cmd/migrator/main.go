@@ -5,9 +5,8 @@ import (
 	"fmt"
 
 	"github.com/golang-migrate/migrate/v4"
-	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/sergeyreshetnyakov/notion/internal/config"
+	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
 )
 
 func main() {
@@ -20,16 +19,7 @@ func main() {
 		panic("migrations_path is empty")
 	}
 
-	var migrationsTable string
-	m, err := migrate.New(
-		"file://"+cfg.MigrationsPath,
-		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", cfg.StoragePath, migrationsTable),
-	)
-	if err != nil {
-		panic(err)
-	}
-
-	if err := m.Up(); err != nil {
+	if err := notestorage.Migrate(cfg.StoragePath, cfg.MigrationsPath); err != nil {
 		if errors.Is(err, migrate.ErrNoChange) {
 			fmt.Println("no migrations to apply")
 
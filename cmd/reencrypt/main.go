@@ -0,0 +1,69 @@
+// Command reencrypt encrypts every note of the SQLite database under a new
+// data key, and drops the old ones:
+//
+//	reencrypt -config_path ./configs/config_dev.yaml [-new_key_file master.key]
+//
+// The new data key is wrapped by the master key of the config, or by the one
+// in -new_key_file, which must then replace it in the config. Run it once
+// encryption is turned on to encrypt the notes stored before, after changing
+// encryption_index to apply it to stored notes, and to rotate keys. Run it
+// as well on databases encrypted before values were bound to their note, so
+// that notes can't be swapped in the file. The server must be stopped
+// meanwhile, it keeps the data keys it loaded.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/sergeyreshetnyakov/notion/internal/config"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/envelope"
+	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
+)
+
+func main() {
+	newKeyFile := flag.String("new_key_file", "", "file holding the new base64 master key, the current one is kept if empty")
+	cfg := config.MustLoad()
+
+	if cfg.Storage != config.StorageSQLite {
+		fail(errors.New("encryption at rest needs the sqlite storage"))
+	}
+	masterKey, err := cfg.MasterKey()
+	if err != nil {
+		fail(fmt.Errorf("cannot read the master key: %w", err))
+	}
+	if masterKey == nil {
+		fail(errors.New("neither encryption_key nor encryption_key_file is set"))
+	}
+	var newKey []byte
+	if *newKeyFile != "" {
+		if newKey, err = envelope.ReadKeyFile(*newKeyFile); err != nil {
+			fail(fmt.Errorf("cannot read the new master key: %w", err))
+		}
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	storage, shutdown := notestorage.New(cfg.StoragePath, log, notestorage.Options{
+		MasterKey:  masterKey,
+		TitleIndex: cfg.EncryptionIndex == config.EncryptionIndexTitles,
+	})
+	report, err := storage.Reencrypt(context.Background(), newKey)
+	if closeErr := shutdown(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "encrypted %d notes, %d links and %d kept responses under master key %s\n",
+		report.Notes, report.Links, report.IdempotencyKeys, report.MasterKeyId)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "reencrypt:", err)
+	os.Exit(1)
+}
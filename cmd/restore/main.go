@@ -0,0 +1,150 @@
+// Command restore rebuilds the notes database as it was at a point in time,
+// from a snapshot and the change journal:
+//
+//	restore -config_path ./configs/config_dev.yaml -out restored.db [-snapshot notes-...db] [-until 2025-01-02T15:04:05Z]
+//
+// The snapshot is copied to -out and the journal of journal_dir replayed
+// over it up to -until, or to its end. Without -snapshot the journal is
+// replayed into an empty database, which only restores everything if the
+// journal was kept from the start and none of it was pruned with old
+// snapshots. The journal is verified before anything is
+// replayed; -check only verifies it.
+//
+// Attachments are restored without their content, which stays in the blobs
+// of attachment_dir: an attachment deleted after -until comes back with its
+// content only if no sweep has removed its blob since.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/sergeyreshetnyakov/notion/internal/config"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/journal"
+	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
+)
+
+func main() {
+	out := flag.String("out", "", "path of the restored database, which must not exist")
+	snapshot := flag.String("snapshot", "", "snapshot to start from")
+	untilFlag := flag.String("until", "", "RFC 3339 time to restore to, the end of the journal if empty")
+	check := flag.Bool("check", false, "only verify the journal")
+	cfg := config.MustLoad()
+
+	if cfg.JournalDir == "" {
+		fail(errors.New("journal_dir is empty"))
+	}
+	if *check {
+		entries, err := verify(cfg.JournalDir)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Fprintf(os.Stderr, "journal is intact, %d entries\n", entries)
+		return
+	}
+
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "usage: restore [-config_path path] -out path [-snapshot path] [-until time] | -check")
+		os.Exit(2)
+	}
+	until := time.Now()
+	if *untilFlag != "" {
+		var err error
+		if until, err = time.Parse(time.RFC3339Nano, *untilFlag); err != nil {
+			fail(fmt.Errorf("invalid -until: %w", err))
+		}
+	}
+
+	if _, err := verify(cfg.JournalDir); err != nil && !errors.Is(err, journal.ErrTorn) {
+		fail(err)
+	}
+	if err := prepare(*out, *snapshot, until, cfg.MigrationsPath); err != nil {
+		fail(err)
+	}
+
+	log := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	storage, shutdown := notestorage.New(*out, log, notestorage.Options{})
+	report, err := storage.Replay(context.Background(), cfg.JournalDir, until)
+	if closeErr := shutdown(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	if report.Torn {
+		fmt.Fprintln(os.Stderr, "warning: the journal ends in a torn line, which was left out")
+	}
+	fmt.Fprintf(os.Stderr, "replayed %d entries (%d changes, %d aborted), up to entry %d at %s\n",
+		report.Entries, report.Changes, report.Aborted, report.LastSeq, report.LastTime.Format(time.RFC3339Nano))
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "restore:", err)
+	os.Exit(1)
+}
+
+// verify reads the whole journal, checking every segment.
+func verify(dir string) (entries int, err error) {
+	err = journal.Read(dir, func(journal.Entry) error {
+		entries++
+		return nil
+	})
+	return entries, err
+}
+
+// prepare creates the database at out from the snapshot, or empty, and
+// migrates it.
+func prepare(out string, snapshot string, until time.Time, migrationsPath string) error {
+	if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("%s already exists", out)
+	}
+
+	if snapshot != "" {
+		if taken, ok := snapshotTime(snapshot); ok && taken.After(until) {
+			return fmt.Errorf("snapshot was taken at %s, after %s", taken.Format(time.RFC3339Nano), until.Format(time.RFC3339Nano))
+		}
+		if err := copyFile(snapshot, out); err != nil {
+			return err
+		}
+	}
+
+	if err := notestorage.Migrate(out, migrationsPath); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return err
+	}
+	return nil
+}
+
+// snapshotTime reads the time a snapshot was taken from its name.
+func snapshotTime(path string) (time.Time, bool) {
+	name := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "notes-"), ".db")
+	t, err := time.Parse("20060102T150405.000Z", name)
+	return t, err == nil
+}
+
+func copyFile(from string, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -61,19 +61,25 @@ const docTemplate = `{
                     "404": {
                         "description": "page not found",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "500": {
                         "description": "internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     }
                 }
             },
             "post": {
-                "description": "Adds a new note",
+                "description": "Adds a new note\nA note of type \"encrypted\" is encrypted end to end: its content is the base64 blob the client encrypted and kdf the parameters it derives the key with. The server doesn't render it or parse links in it.",
                 "consumes": [
                     "application/json"
                 ],
@@ -99,6 +105,31 @@ const docTemplate = `{
                         "schema": {
                             "type": "string"
                         }
+                    },
+                    {
+                        "description": "Note type, empty for Markdown",
+                        "name": "type",
+                        "in": "body",
+                        "schema": {
+                            "type": "string",
+                            "enum": [
+                                "encrypted"
+                            ]
+                        }
+                    },
+                    {
+                        "description": "Key derivation parameters of an encrypted note",
+                        "name": "kdf",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/models.KDF"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe: a retry with the same key replays the first response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -106,15 +137,45 @@ const docTemplate = `{
                         "description": "OK"
                     },
                     "400": {
-                        "description": "bad request body",
+                        "description": "malformed request body",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "a request with the same Idempotency-Key is in progress",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields or Idempotency-Key was used with a different request",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "500": {
                         "description": "internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "507": {
+                        "description": "quota exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     }
                 }
@@ -144,21 +205,33 @@ const docTemplate = `{
                         "description": "OK"
                     },
                     "400": {
-                        "description": "bad request body",
+                        "description": "malformed request body",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "404": {
                         "description": "note not found",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "500": {
                         "description": "internal server error",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     }
                 }
@@ -188,44 +261,1861 @@ const docTemplate = `{
                         "description": "OK"
                     },
                     "400": {
-                        "description": "bad request body",
+                        "description": "malformed request body or nothing to change",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "404": {
                         "description": "note not found",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid fields",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "507": {
+                        "description": "quota exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/attachments/sweep": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Removes stored content that no attachment refers to anymore, as deleting notes leaves it behind, and its thumbnails. This also runs on a schedule.\nThe space thumbnails took is counted as freed.\nOnly available when attachments are configured.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Sweep unreferenced attachment content",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.SweepReport"
+                        }
+                    },
+                    "401": {
+                        "description": "missing or wrong admin token",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "attachments are not configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/backup": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Streams every note, link and attachment as JSON Lines: a header with the format version, one record per line and a trailer with the number of records and their SHA-256.\nAttachments are backed up as metadata: their content is the blob named by their sha256 in the attachment directory, which is copied with the files.\nThe backup is read from one consistent view of the database, writes go on meanwhile. An error after the first line aborts the response and leaves the backup without a trailer.",
+                "produces": [
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Back up the database",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "401": {
+                        "description": "missing or wrong admin token",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
                         }
                     },
                     "500": {
                         "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/restore": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Restores a backup made by GET /admin/backup into an empty database, in one transaction. Notes and attachments keep their ids and timestamps; the blobs of the attachments are expected in the attachment directory.\nThe restored data is read back and its checksum compared with the trailer of the backup; nothing is stored if they differ.",
+                "consumes": [
+                    "application/x-ndjson"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Restore a backup",
+                "parameters": [
+                    {
+                        "description": "Backup",
+                        "name": "backup",
+                        "in": "body",
+                        "required": true,
                         "schema": {
                             "type": "string"
                         }
                     }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.RestoreReport"
+                        }
+                    },
+                    "401": {
+                        "description": "missing or wrong admin token",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "database is not empty",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid backup",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.Note": {
-            "type": "object",
-            "properties": {
-                "content": {
-                    "type": "string",
-                    "example": "at 3 pm"
-                },
-                "header": {
-                    "type": "string",
-                    "example": "go for a walk"
-                },
-                "id": {
-                    "type": "integer",
-                    "example": 1
+        },
+        "/admin/snapshots": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Returns the snapshots of the database, newest first. Only available when snapshots are configured.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List snapshots",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Snapshot"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "missing or wrong admin token",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "snapshots are not configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Takes a consistent copy of the SQLite database into the snapshot directory while the server keeps running, then removes the oldest snapshots past the retention.\nOnly available when snapshots are configured.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Take a snapshot",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Snapshot"
+                        }
+                    },
+                    "401": {
+                        "description": "missing or wrong admin token",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "snapshots are not configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
                 }
             }
+        },
+        "/batch": {
+            "post": {
+                "description": "Runs an ordered list of create, edit and delete operations.\nBy default the batch is atomic: the first failing operation rolls back the whole batch.\nWith \"atomic\": false every operation is applied on its own and failures are reported per operation.\nA failed batch is reported as a problem with the per operation results in \"results\".",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Batch operations",
+                "parameters": [
+                    {
+                        "description": "Operations",
+                        "name": "batch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/notehandler.batchRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries safe: a retry with the same key replays the first response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/notehandler.batchResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "malformed request body or nothing to change",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/problem.Problem"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "results": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.BatchResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "404": {
+                        "description": "note not found",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/problem.Problem"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "results": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.BatchResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "413": {
+                        "description": "too many operations or request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid operations",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/problem.Problem"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "results": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.BatchResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/problem.Problem"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "results": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.BatchResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/problem.Problem"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "results": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.BatchResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "507": {
+                        "description": "quota exceeded",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/problem.Problem"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "results": {
+                                            "type": "array",
+                                            "items": {
+                                                "$ref": "#/definitions/models.BatchResult"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
+        "/export": {
+            "get": {
+                "description": "Streams a zip archive with one Markdown file per note, named \u003cid\u003e-\u003cheader\u003e.md.\nEvery file starts with a YAML front matter block holding the id, header and timestamps of the note.\nWhen attachments are configured, the attachments of a note follow it in a folder named like its file without .md, as \u003cattachment id\u003e-\u003cname\u003e.\nEnd-to-end encrypted notes aren't Markdown and are left out, backups keep them.\nThe archive is written while the notes are read: an error after the first note aborts the response and leaves the archive truncated.",
+                "produces": [
+                    "application/zip"
+                ],
+                "summary": "Export notes",
+                "parameters": [
+                    {
+                        "enum": [
+                            "markdown"
+                        ],
+                        "type": "string",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "422": {
+                        "description": "unsupported format",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/graph": {
+            "get": {
+                "description": "Returns the graph of notes and the resolved [[wiki links]] between them as JSON with degree stats, GraphViz DOT or GraphML.\nBroken links are left out and several links between the same notes make one edge.\nWith a note, only the notes at most depth links away from it are returned, following links in either direction.",
+                "produces": [
+                    "application/json",
+                    "text/vnd.graphviz",
+                    "application/graphml+xml"
+                ],
+                "summary": "Export the link graph",
+                "parameters": [
+                    {
+                        "enum": [
+                            "json",
+                            "dot",
+                            "graphml"
+                        ],
+                        "type": "string",
+                        "description": "Export format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Id of the note to start from",
+                        "name": "note",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 5,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 1,
+                        "description": "Links to follow from the note",
+                        "name": "depth",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Graph"
+                        }
+                    },
+                    "404": {
+                        "description": "note not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid format, note or depth",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/import": {
+            "post": {
+                "description": "Creates a note from every Markdown file (.md, .markdown) of a zip archive or of a multipart upload of files, e.g. a folder. Zip files in a multipart upload are unpacked.\nThe header comes from the header or title of the YAML front matter, or else from the first heading; created_at and updated_at in the front matter are kept.\nOther files are skipped. Files that don't make a valid note fail without stopping the import; all notes are created in a single transaction.\nAn Evernote export (.enex) is imported as well: its notes are converted to Markdown and reported as \"note 1\", \"note 2\" and so on. Created and updated dates are kept, tags are appended to the content as #hashtags and attachments are replaced by their file name.\nWhen attachments are configured, the attachments of an Evernote note are stored as attachments of the note it makes; one that can't be stored is reported in the error of its note, which is still created.\nWith dry_run nothing is stored, the report shows what the import would do.",
+                "consumes": [
+                    "application/zip",
+                    "multipart/form-data",
+                    "application/enex+xml"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Import notes",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Report without storing anything",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "description": "Zip archive of Markdown files or Evernote export",
+                        "name": "archive",
+                        "in": "body",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportReport"
+                        }
+                    },
+                    "400": {
+                        "description": "malformed archive or upload",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "unsupported Content-Type",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid dry_run, invalid Evernote export or no files",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "507": {
+                        "description": "quota exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/links/broken": {
+            "get": {
+                "description": "Returns the links of all notes that resolve to no note.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get broken links",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Link"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/notes/{id}": {
+            "get": {
+                "description": "Returns a note as JSON, as an HTML page with the content rendered from Markdown, or as its raw Markdown content.\nThe format query parameter wins over the Accept header.\nEnd-to-end encrypted notes are only returned as JSON, their content being opaque to the server: the Accept header is ignored for them and other formats are refused.\nRendering supports CommonMark with GFM tables, task lists, strikethrough and autolinks, footnotes and highlighted code blocks; the HTML is sanitized.",
+                "produces": [
+                    "application/json",
+                    "text/html",
+                    "text/markdown"
+                ],
+                "summary": "Get note",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "json",
+                            "html",
+                            "markdown"
+                        ],
+                        "type": "string",
+                        "description": "Representation",
+                        "name": "format",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Note"
+                        }
+                    },
+                    "400": {
+                        "description": "malformed id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "note not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id or format",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Applies a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to the note {\"header\", \"content\", \"id\"} and returns the result.\nUnlike PATCH /, fields can be cleared: a merge patch with \"content\": null or a JSON Patch remove of /content stores an empty content.\nA plain application/json body is treated as a merge patch.\nThe type of a note can't be changed; the kdf of an encrypted note can, but only together with its content.",
+                "consumes": [
+                    "application/merge-patch+json",
+                    "application/json-patch+json",
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Patch note",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Merge patch object or array of JSON Patch operations",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Note"
+                        }
+                    },
+                    "400": {
+                        "description": "malformed patch or id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "note not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "a test operation failed",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "request body too large",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "unsupported patch format",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "patch doesn't apply or the result is not a valid note",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "507": {
+                        "description": "quota exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/notes/{id}/attachments": {
+            "get": {
+                "description": "Returns the attachments of a note ordered by id. Only available when attachments are configured.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "List attachments",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Attachment"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "malformed id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "note not found or attachments are not configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Attaches the file of the \"file\" form field to a note. Other form fields are ignored.\nThe media type of the file part is kept, unless it is missing or application/octet-stream: then it is detected from the content.\nFiles with the same content are stored once. Images in JPEG, PNG, GIF or WebP have their dimensions and format set.\nWith strip_gps the GPS position is removed from the EXIF and XMP data of JPEG images before they are stored.\nOnly available when attachments are configured.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Upload an attachment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Remove the GPS position of JPEG images",
+                        "name": "strip_gps",
+                        "in": "query"
+                    },
+                    {
+                        "type": "file",
+                        "description": "File to attach",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Attachment"
+                        }
+                    },
+                    "400": {
+                        "description": "malformed id or upload",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "note not found or attachments are not configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "file too large",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "unsupported Content-Type",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id, strip_gps, no file, invalid file name or a malformed JPEG to strip",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "507": {
+                        "description": "quota exceeded",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/notes/{id}/attachments/{attachmentId}": {
+            "get": {
+                "description": "Returns the content of an attachment with its media type, as a download named after the file.\nSupports Range requests for parts of the content and conditional requests by ETag, which is the SHA-256 of the content.\nOnly available when attachments are configured.",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "summary": "Download an attachment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Attachment id",
+                        "name": "attachmentId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Byte ranges to return",
+                        "name": "Range",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "206": {
+                        "description": "Partial Content",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "malformed id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "attachment not found or attachments are not configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "416": {
+                        "description": "unsatisfiable range",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Removes an attachment from a note, and its content unless another attachment has the same content.\nOnly available when attachments are configured.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete an attachment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Attachment id",
+                        "name": "attachmentId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK"
+                    },
+                    "400": {
+                        "description": "malformed id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "attachment not found or attachments are not configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/notes/{id}/attachments/{attachmentId}/thumbnail": {
+            "get": {
+                "description": "Returns the image of an attachment scaled down to fit a square of the size, turned as its EXIF orientation says.\nThumbnails of JPEG images are JPEG, of PNG, GIF and WebP images PNG. A thumbnail is made on first request and kept.\nOnly available when attachments are configured.",
+                "produces": [
+                    "image/jpeg",
+                    "image/png"
+                ],
+                "summary": "Get a thumbnail of an image attachment",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Attachment id",
+                        "name": "attachmentId",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            64,
+                            256,
+                            1024
+                        ],
+                        "type": "integer",
+                        "default": 256,
+                        "description": "Bound of both dimensions",
+                        "name": "size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "malformed id or size",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "attachment not found, not an image or attachments are not configured",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id or size",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/notes/{id}/backlinks": {
+            "get": {
+                "description": "Returns the links of other notes that resolve to this note, by its id or its header.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get backlinks of a note",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Link"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "malformed id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "note not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/notes/{id}/links": {
+            "get": {
+                "description": "Returns the [[wiki links]] in the content of a note, written as [[Title]] or [[#id]] with an optional |label.\nA title resolves to the oldest note with that header, ignoring case; target_id is 0 if the link resolves to no note.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get links of a note",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Note id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Link"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "malformed id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "note not found",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "invalid id",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/usage": {
+            "get": {
+                "description": "Returns how many notes and attachments are stored and how many bytes they take, with the quotas they are held to.\nBytes are the UTF-8 bytes of headers and contents plus the bytes of attachments as uploaded. Quotas that are not set are left out.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get storage usage",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Usage"
+                        }
+                    },
+                    "500": {
+                        "description": "internal server error",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    },
+                    "503": {
+                        "description": "query timed out",
+                        "schema": {
+                            "$ref": "#/definitions/problem.Problem"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "models.Attachment": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "example": "2025-01-02T15:04:05.000Z"
+                },
+                "format": {
+                    "type": "string",
+                    "enum": [
+                        "jpeg",
+                        "png",
+                        "gif",
+                        "webp"
+                    ],
+                    "example": "jpeg"
+                },
+                "height": {
+                    "type": "integer",
+                    "example": 3024
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "media_type": {
+                    "type": "string",
+                    "example": "application/pdf"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "receipt.pdf"
+                },
+                "note_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "sha256": {
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+                },
+                "size": {
+                    "type": "integer",
+                    "example": 48213
+                },
+                "width": {
+                    "type": "integer",
+                    "example": 4032
+                }
+            }
+        },
+        "models.BatchOperation": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "type": "string",
+                    "example": "at 3 pm"
+                },
+                "header": {
+                    "type": "string",
+                    "example": "go for a walk"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "op": {
+                    "type": "string",
+                    "enum": [
+                        "create",
+                        "edit",
+                        "delete"
+                    ],
+                    "example": "create"
+                }
+            }
+        },
+        "models.BatchResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "note not found"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "op": {
+                    "type": "string",
+                    "example": "create"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "ok",
+                        "failed",
+                        "rolled_back",
+                        "skipped"
+                    ],
+                    "example": "ok"
+                }
+            }
+        },
+        "models.FieldError": {
+            "type": "object",
+            "properties": {
+                "field": {
+                    "type": "string",
+                    "example": "header"
+                },
+                "message": {
+                    "type": "string",
+                    "example": "must contain any characters"
+                }
+            }
+        },
+        "models.Graph": {
+            "type": "object",
+            "properties": {
+                "edges": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.GraphEdge"
+                    }
+                },
+                "nodes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.GraphNode"
+                    }
+                },
+                "stats": {
+                    "$ref": "#/definitions/models.GraphStats"
+                }
+            }
+        },
+        "models.GraphEdge": {
+            "type": "object",
+            "properties": {
+                "links": {
+                    "description": "Links is the number of links the edge stands for.",
+                    "type": "integer",
+                    "example": 1
+                },
+                "source": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "target": {
+                    "type": "integer",
+                    "example": 2
+                }
+            }
+        },
+        "models.GraphNode": {
+            "type": "object",
+            "properties": {
+                "header": {
+                    "type": "string",
+                    "example": "go for a walk"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "in_degree": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "out_degree": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "models.GraphStats": {
+            "type": "object",
+            "properties": {
+                "average_degree": {
+                    "type": "number",
+                    "example": 1.33
+                },
+                "edges": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "isolated": {
+                    "description": "Isolated counts the nodes without edges.",
+                    "type": "integer",
+                    "example": 1
+                },
+                "max_in_degree": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "max_out_degree": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "nodes": {
+                    "type": "integer",
+                    "example": 3
+                }
+            }
+        },
+        "models.ImportReport": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "failed": {
+                    "type": "integer",
+                    "example": 0
+                },
+                "files": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ImportResult"
+                    }
+                },
+                "skipped": {
+                    "type": "integer",
+                    "example": 1
+                }
+            }
+        },
+        "models.ImportResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string",
+                    "example": "header: must contain any characters"
+                },
+                "header": {
+                    "type": "string",
+                    "example": "Groceries"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "name": {
+                    "type": "string",
+                    "example": "notes/groceries.md"
+                },
+                "status": {
+                    "type": "string",
+                    "enum": [
+                        "created",
+                        "skipped",
+                        "failed"
+                    ],
+                    "example": "created"
+                }
+            }
+        },
+        "models.KDF": {
+            "type": "object",
+            "properties": {
+                "algorithm": {
+                    "type": "string",
+                    "example": "pbkdf2-sha256"
+                },
+                "iterations": {
+                    "type": "integer",
+                    "example": 600000
+                },
+                "memory": {
+                    "type": "integer"
+                },
+                "note_id": {
+                    "type": "string",
+                    "example": "bm90ZWlkbm90ZWlkbm90ZQ=="
+                },
+                "parallelism": {
+                    "type": "integer"
+                },
+                "salt": {
+                    "type": "string",
+                    "example": "c2FsdHNhbHRzYWx0c2FsdA=="
+                }
+            }
+        },
+        "models.Link": {
+            "type": "object",
+            "properties": {
+                "source_id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "target": {
+                    "type": "string",
+                    "example": "Shopping list"
+                },
+                "target_id": {
+                    "type": "integer",
+                    "example": 2
+                }
+            }
+        },
+        "models.Note": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "type": "string",
+                    "example": "at 3 pm"
+                },
+                "created_at": {
+                    "description": "CreatedAt and UpdatedAt are kept by the storage. They are zero for\nnotes stored before timestamps were.",
+                    "type": "string",
+                    "example": "2025-01-02T15:04:05.000Z"
+                },
+                "header": {
+                    "type": "string",
+                    "example": "go for a walk"
+                },
+                "id": {
+                    "type": "integer",
+                    "example": 1
+                },
+                "kdf": {
+                    "$ref": "#/definitions/models.KDF"
+                },
+                "type": {
+                    "description": "Type is empty for Markdown notes. The content of an encrypted note is\na client-encrypted blob in base64 that the server never reads, KDF\nholds the parameters the client derives its key with.",
+                    "type": "string",
+                    "enum": [
+                        "encrypted"
+                    ]
+                },
+                "updated_at": {
+                    "type": "string",
+                    "example": "2025-01-02T15:04:05.000Z"
+                }
+            }
+        },
+        "models.RestoreReport": {
+            "type": "object",
+            "properties": {
+                "attachments": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "links": {
+                    "type": "integer",
+                    "example": 17
+                },
+                "notes": {
+                    "type": "integer",
+                    "example": 42
+                },
+                "sha256": {
+                    "type": "string",
+                    "example": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+                }
+            }
+        },
+        "models.Snapshot": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string",
+                    "example": "2025-01-02T15:04:05.006Z"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "notes-20250102T150405.006Z.db"
+                },
+                "size": {
+                    "type": "integer",
+                    "example": 32768
+                }
+            }
+        },
+        "models.SweepReport": {
+            "type": "object",
+            "properties": {
+                "freed": {
+                    "type": "integer",
+                    "example": 96426
+                },
+                "removed": {
+                    "type": "integer",
+                    "example": 2
+                }
+            }
+        },
+        "models.Usage": {
+            "type": "object",
+            "properties": {
+                "attachment_bytes": {
+                    "type": "integer",
+                    "example": 20871502
+                },
+                "attachments": {
+                    "type": "integer",
+                    "example": 14
+                },
+                "bytes": {
+                    "type": "integer",
+                    "example": 21403612
+                },
+                "max_bytes": {
+                    "type": "integer",
+                    "example": 1073741824
+                },
+                "max_notes": {
+                    "type": "integer",
+                    "example": 10000
+                },
+                "note_bytes": {
+                    "type": "integer",
+                    "example": 532110
+                },
+                "notes": {
+                    "type": "integer",
+                    "example": 120
+                }
+            }
+        },
+        "notehandler.batchRequest": {
+            "type": "object",
+            "properties": {
+                "atomic": {
+                    "description": "Atomic defaults to true: the batch is applied entirely or not at all.",
+                    "type": "boolean",
+                    "example": true
+                },
+                "operations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.BatchOperation"
+                    }
+                }
+            }
+        },
+        "notehandler.batchResponse": {
+            "type": "object",
+            "properties": {
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.BatchResult"
+                    }
+                }
+            }
+        },
+        "problem.Problem": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "not_found"
+                },
+                "detail": {
+                    "type": "string",
+                    "example": "Failed to edit note: note not found"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.FieldError"
+                    }
+                },
+                "instance": {
+                    "type": "string",
+                    "example": "/notes/1"
+                },
+                "request_id": {
+                    "type": "string",
+                    "example": "4bf92f3577b34da6a3ce929d0e0e4736"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 404
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Not Found"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "urn:notion:problem:not_found"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "AdminToken": {
+            "description": "\"Bearer \" followed by the admin_token of the config.",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -0,0 +1,207 @@
+// Package app wires storage, business logic and HTTP handlers into a server.
+package app
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	_ "github.com/sergeyreshetnyakov/notion/docs"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/attachments"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/snapshots"
+	"github.com/sergeyreshetnyakov/notion/internal/config"
+	notehandler "github.com/sergeyreshetnyakov/notion/internal/handlers/note"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/journal"
+	"github.com/sergeyreshetnyakov/notion/internal/middlewares"
+	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
+	httpSwagger "github.com/swaggo/http-swagger/v2"
+)
+
+type App struct {
+	Handler    http.Handler
+	shutdownDB func() error
+	// stopScheduled stops the scheduled jobs, e.g. snapshots, and waits for
+	// the ones running.
+	stopScheduled []func()
+}
+
+// storage is what every storage backend provides.
+type storage interface {
+	notes.Storage
+	attachments.Storage
+	middlewares.IdempotencyStore
+}
+
+// job is run on a schedule, every interval until ctx is done.
+type job interface {
+	Run(ctx context.Context, interval time.Duration)
+}
+
+// New builds the full handler stack described by cfg.
+func New(cfg config.Config, log *slog.Logger) *App {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /swagger/", httpSwagger.WrapHandler)
+
+	var storage storage
+	var shutdownDB func() error
+	// notesStorage and attachmentStorage are storage, unless notes and
+	// attachments are written through the journal.
+	var notesStorage notes.Storage
+	var attachmentStorage attachments.Storage
+	// Snapshots and the journal are kept for the SQLite database, the
+	// memory storage has its own snapshot.
+	var snaps *snapshots.Snapshots
+	switch cfg.Storage {
+	case config.StorageMemory:
+		if cfg.EncryptionKey != "" || cfg.EncryptionKeyFile != "" {
+			panic("encryption at rest needs the sqlite storage")
+		}
+		storage, shutdownDB = notestorage.NewMemory(cfg.SnapshotPath, log)
+		notesStorage, attachmentStorage = storage, storage
+	default:
+		masterKey, err := cfg.MasterKey()
+		if err != nil {
+			panic("cannot read the master key: " + err.Error())
+		}
+		if masterKey != nil && cfg.JournalDir != "" {
+			panic("journal_dir can't be set with encryption at rest, the journal keeps notes in plaintext")
+		}
+		sqlStorage, shutdown := notestorage.New(cfg.StoragePath, log, notestorage.Options{
+			QueryTimeout:       cfg.QueryTimeout,
+			SlowQueryThreshold: cfg.SlowQueryThreshold,
+			MasterKey:          masterKey,
+			TitleIndex:         cfg.EncryptionIndex == config.EncryptionIndexTitles,
+		})
+		storage, shutdownDB = sqlStorage, shutdown
+		notesStorage, attachmentStorage = sqlStorage, sqlStorage
+		if cfg.JournalDir != "" {
+			changes, err := journal.Open(cfg.JournalDir, cfg.JournalSegmentSize)
+			if err != nil {
+				panic("cannot open journal: " + err.Error())
+			}
+			journaled, err := notestorage.NewJournaled(sqlStorage, changes, log)
+			if err != nil {
+				panic("cannot open journal: " + err.Error())
+			}
+			notesStorage, attachmentStorage = journaled, journaled
+			shutdownDB = func() error {
+				return errors.Join(shutdown(), changes.Close())
+			}
+		}
+		if cfg.SnapshotDir != "" {
+			opts := snapshots.Options{
+				Dir:       cfg.SnapshotDir,
+				Retention: cfg.SnapshotRetention,
+			}
+			if cfg.JournalDir != "" {
+				opts.Prune = func(oldest string) error {
+					removed, err := notestorage.PruneJournal(cfg.JournalDir, oldest)
+					for _, name := range removed {
+						log.Info("Journal segment removed", slog.String("name", name))
+					}
+					return err
+				}
+			}
+			snaps = snapshots.New(sqlStorage, log, opts)
+		}
+	}
+
+	quotas := notes.Quotas{MaxNotes: cfg.MaxNotes, MaxBytes: cfg.MaxStoredBytes}
+	opts := notehandler.Options{MaxImportSize: cfg.MaxImportSize}
+	var stopScheduled []func()
+	if snaps != nil {
+		opts.Snapshots = snaps
+		if cfg.SnapshotInterval > 0 {
+			stopScheduled = append(stopScheduled, schedule(snaps, cfg.SnapshotInterval))
+		}
+	}
+	if cfg.AttachmentDir != "" {
+		files := attachments.New(attachmentStorage, log, attachments.Options{
+			Dir:     cfg.AttachmentDir,
+			MaxSize: cfg.MaxAttachmentSize,
+			Quotas:  quotas,
+		})
+		opts.Attachments = files
+		if cfg.AttachmentSweepInterval > 0 {
+			stopScheduled = append(stopScheduled, schedule(files, cfg.AttachmentSweepInterval))
+		}
+	}
+	notesService := notes.New(notesStorage, notes.Limits{
+		MaxBatchSize:     cfg.MaxBatchSize,
+		MaxHeaderLength:  cfg.MaxHeaderLength,
+		MaxContentLength: cfg.MaxContentLength,
+		Quotas:           quotas,
+	})
+	// Links were only kept from some version on, the notes stored before
+	// have them parsed once.
+	backfilled, err := notesService.BackfillLinks(context.Background())
+	if err != nil {
+		panic("cannot backfill links: " + err.Error())
+	}
+	if backfilled > 0 {
+		log.Info("Backfilled the links of notes stored before links were kept", slog.Int("notes", backfilled))
+	}
+	notehandler.New(log, notesService, opts).HandleRoutes(mux)
+
+	var handler http.Handler = mux
+	if cfg.IdempotencyTTL > 0 {
+		handler = middlewares.IdempotencyMiddleware(handler, storage, cfg.IdempotencyTTL, cfg.IdempotencyLease, log)
+	}
+	if cfg.MaxBodySize > 0 {
+		// An upload carries a file besides what any request may.
+		maxUploadSize := int64(0)
+		if cfg.MaxAttachmentSize > 0 {
+			maxUploadSize = cfg.MaxAttachmentSize + cfg.MaxBodySize
+		}
+		handler = middlewares.BodyLimitMiddleware(handler, cfg.MaxBodySize, map[string]int64{
+			"POST /import": cfg.MaxImportSize,
+			// A backup is as large as the database.
+			"POST /admin/restore":          cfg.MaxRestoreSize,
+			"POST /notes/{id}/attachments": maxUploadSize,
+		})
+	}
+
+	handler = middlewares.BodyDeadlineMiddleware(handler,
+		"POST /import",
+		"POST /admin/restore",
+		"POST /notes/{id}/attachments",
+	)
+
+	// Admin requests are authorized before anything is read or stored for
+	// them.
+	handler = middlewares.AdminMiddleware(handler, cfg.AdminToken)
+
+	return &App{
+		Handler:       middlewares.RequestIdMiddleware(middlewares.LoggingMiddleware(handler, log)),
+		shutdownDB:    shutdownDB,
+		stopScheduled: stopScheduled,
+	}
+}
+
+// schedule runs j every interval until the returned func is called.
+func schedule(j job, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		j.Run(ctx, interval)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// Close stops the scheduled jobs and releases the storage. It must be called
+// after the server is stopped.
+func (a *App) Close() error {
+	for _, stop := range a.stopScheduled {
+		stop()
+	}
+	return a.shutdownDB()
+}
@@ -0,0 +1,453 @@
+// Package attachments keeps the files uploaded to notes. The content of a file
+// is stored once per SHA-256 digest as a blob in a directory, the storage
+// keeps what is attached to which note. Images get thumbnails, kept next to
+// the blobs.
+package attachments
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/exif"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
+)
+
+var (
+	ErrAttachmentNotFound = &notes.Error{Code: notes.CodeNotFound, Message: "attachment not found"}
+	ErrTooLarge           = &notes.Error{Code: notes.CodeTooLarge, Message: "attachment is too large"}
+)
+
+const (
+	// maxNameLength bounds file names, counted in characters.
+	maxNameLength = 255
+	// staleUploadAge is how old a temporary file is before a sweep takes it
+	// for the leftover of an upload that never finished.
+	staleUploadAge = 24 * time.Hour
+)
+
+type Storage interface {
+	GetById(ctx context.Context, id int64) (note models.Note, err error)
+	// AddAttachment stores attachment, whose id is ignored, and returns its
+	// id. It fails with notes.ErrNoteNotFound if the note doesn't exist.
+	AddAttachment(ctx context.Context, attachment models.Attachment) (id int64, err error)
+	// GetAttachments returns the attachments of a note ordered by id.
+	GetAttachments(ctx context.Context, noteId int64) (attachments []models.Attachment, err error)
+	// GetAttachment and DeleteAttachment fail with ErrAttachmentNotFound if
+	// the note has no attachment with that id.
+	GetAttachment(ctx context.Context, noteId int64, id int64) (attachment models.Attachment, err error)
+	DeleteAttachment(ctx context.Context, noteId int64, id int64) (err error)
+	// CountBlobReferences returns how many attachments have the digest.
+	CountBlobReferences(ctx context.Context, sha256 string) (n int, err error)
+	// GetBlobs returns the digests attachments have, each once.
+	GetBlobs(ctx context.Context) (digests []string, err error)
+	Usage(ctx context.Context) (usage models.Usage, err error)
+}
+
+type Options struct {
+	// Dir is where blobs are kept, in blobs/ under the first two characters
+	// of their digest, their thumbnails, in thumbs/, and uploads are
+	// received, in tmp/.
+	Dir string
+	// MaxSize bounds an attachment in bytes. Zero doesn't.
+	MaxSize int64
+	// Quotas bound what is stored with notes, of which uploads count
+	// against MaxBytes.
+	Quotas notes.Quotas
+}
+
+type Attachments struct {
+	storage Storage
+	log     *slog.Logger
+	opts    Options
+	// mu orders writing and removing blobs against adding and removing the
+	// attachments that refer to them, so that a blob is never removed while
+	// an attachment is about to refer to it.
+	mu sync.Mutex
+	// renders holds a token for each thumbnail being made.
+	renders chan struct{}
+}
+
+func New(storage Storage, log *slog.Logger, opts Options) *Attachments {
+	return &Attachments{storage: storage, log: log, opts: opts, renders: make(chan struct{}, maxRenders)}
+}
+
+type UploadOptions struct {
+	// StripGPS removes the GPS position from the EXIF data of JPEG images.
+	StripGPS bool
+}
+
+// Upload attaches the content read from r to a note. A media type that is
+// empty or application/octet-stream is detected from the content. Content
+// that is already stored isn't stored again. Images have their dimensions
+// and format probed.
+func (a *Attachments) Upload(ctx context.Context, noteId int64, name string, mediaType string, r io.Reader, opts UploadOptions) (attachment models.Attachment, err error) {
+	if err := validateUpload(noteId, name, mediaType); err != nil {
+		return models.Attachment{}, err
+	}
+	if _, err := a.storage.GetById(ctx, noteId); err != nil {
+		return models.Attachment{}, err
+	}
+
+	upload, err := a.receive(r, opts.StripGPS)
+	if upload.path != "" {
+		defer os.Remove(upload.path)
+	}
+	if err != nil {
+		return models.Attachment{}, err
+	}
+
+	if base, _, _ := mime.ParseMediaType(mediaType); base == "" || base == "application/octet-stream" {
+		mediaType = upload.detected
+	}
+	attachment = models.Attachment{
+		NoteId:    noteId,
+		Name:      name,
+		MediaType: mediaType,
+		Size:      upload.size,
+		SHA256:    upload.sha256,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	if strings.HasPrefix(upload.detected, "image/") {
+		attachment.Width, attachment.Height, attachment.Format = probe(upload.path)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.reserve(ctx, attachment.Size); err != nil {
+		return models.Attachment{}, err
+	}
+	if err := a.keep(upload); err != nil {
+		return models.Attachment{}, err
+	}
+	if attachment.Id, err = a.storage.AddAttachment(ctx, attachment); err != nil {
+		a.release(ctx, attachment.SHA256)
+		return models.Attachment{}, err
+	}
+
+	return attachment, nil
+}
+
+// List returns the attachments of a note ordered by id.
+func (a *Attachments) List(ctx context.Context, noteId int64) (attachments []models.Attachment, err error) {
+	if noteId <= 0 {
+		return nil, notes.Invalid("id", "must be a positive number")
+	}
+	if _, err := a.storage.GetById(ctx, noteId); err != nil {
+		return nil, err
+	}
+
+	return a.storage.GetAttachments(ctx, noteId)
+}
+
+// Open returns an attachment of a note with its content, which the caller
+// must close.
+func (a *Attachments) Open(ctx context.Context, noteId int64, id int64) (attachment models.Attachment, content io.ReadSeekCloser, err error) {
+	if err := validateIds(noteId, id); err != nil {
+		return models.Attachment{}, nil, err
+	}
+
+	attachment, err = a.storage.GetAttachment(ctx, noteId, id)
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+
+	f, err := os.Open(a.blobPath(attachment.SHA256))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Deleted since it was looked up.
+		return models.Attachment{}, nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return models.Attachment{}, nil, err
+	}
+
+	return attachment, f, nil
+}
+
+// Delete removes an attachment of a note, and its blob unless another
+// attachment has the same content.
+func (a *Attachments) Delete(ctx context.Context, noteId int64, id int64) (err error) {
+	if err := validateIds(noteId, id); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	attachment, err := a.storage.GetAttachment(ctx, noteId, id)
+	if err != nil {
+		return err
+	}
+	if err := a.storage.DeleteAttachment(ctx, noteId, id); err != nil {
+		return err
+	}
+	a.release(ctx, attachment.SHA256)
+
+	return nil
+}
+
+// Sweep removes the blobs no attachment refers to, which deleting notes
+// leaves behind, with their thumbnails, and uploads that never finished.
+// The space thumbnails took is counted as freed.
+func (a *Attachments) Sweep(ctx context.Context) (report models.SweepReport, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	digests, err := a.storage.GetBlobs(ctx)
+	if err != nil {
+		return models.SweepReport{}, err
+	}
+	referenced := make(map[string]bool, len(digests))
+	for _, digest := range digests {
+		referenced[digest] = true
+	}
+
+	err = filepath.WalkDir(filepath.Join(a.opts.Dir, "blobs"), func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || !entry.Type().IsRegular() || referenced[entry.Name()] || !isDigest(entry.Name()) {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		report.Removed++
+		report.Freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	err = filepath.WalkDir(filepath.Join(a.opts.Dir, "thumbs"), func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		if digest := thumbnailDigest(entry.Name()); digest == "" || referenced[digest] {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		report.Freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	entries, err := os.ReadDir(filepath.Join(a.opts.Dir, "tmp"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return report, err
+	}
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > staleUploadAge {
+			os.Remove(filepath.Join(a.opts.Dir, "tmp", entry.Name()))
+		}
+	}
+
+	if report.Removed > 0 {
+		a.log.Info("Unreferenced blobs removed", slog.Int("removed", report.Removed), slog.Int64("freed", report.Freed))
+	}
+	return report, nil
+}
+
+// Run sweeps every interval until ctx is done. Failed sweeps are logged and
+// retried at the next tick.
+func (a *Attachments) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := a.Sweep(ctx); err != nil && ctx.Err() == nil {
+				a.log.Error("Failed to sweep blobs", sl.Err(err))
+			}
+		}
+	}
+}
+
+// upload is content received into a temporary file.
+type upload struct {
+	path     string
+	size     int64
+	sha256   string
+	detected string
+}
+
+// receive writes r to a temporary file and syncs it, hashing it on the way.
+// The GPS position is stripped from JPEG images if stripGPS is set. The path
+// of the upload is set if the file was created, even on failure.
+func (a *Attachments) receive(r io.Reader, stripGPS bool) (upload upload, err error) {
+	dir := filepath.Join(a.opts.Dir, "tmp")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return upload, err
+	}
+	f, err := os.CreateTemp(dir, "upload-*")
+	if err != nil {
+		return upload, err
+	}
+	defer f.Close()
+	upload.path = f.Name()
+
+	br := bufio.NewReaderSize(r, 512)
+	head, _ := br.Peek(512)
+	upload.detected = http.DetectContentType(head)
+
+	var src io.Reader = br
+	if stripGPS && upload.detected == "image/jpeg" {
+		pr, pw := io.Pipe()
+		stripped := make(chan struct{})
+		go func() {
+			defer close(stripped)
+			pw.CloseWithError(exif.StripGPS(pw, br))
+		}()
+		defer func() {
+			pr.Close()
+			<-stripped
+		}()
+		src = pr
+	}
+	if a.opts.MaxSize > 0 {
+		// One byte more than allowed tells content of the maximum size from
+		// larger content.
+		src = io.LimitReader(src, a.opts.MaxSize+1)
+	}
+	hash := sha256.New()
+	if upload.size, err = io.Copy(io.MultiWriter(f, hash), src); err != nil {
+		if errors.Is(err, exif.ErrMalformed) {
+			return upload, notes.Invalid("file", "must be a well-formed JPEG file to strip its GPS position")
+		}
+		return upload, err
+	}
+	if a.opts.MaxSize > 0 && upload.size > a.opts.MaxSize {
+		return upload, fmt.Errorf("%w: the limit is %d bytes", ErrTooLarge, a.opts.MaxSize)
+	}
+	upload.sha256 = hex.EncodeToString(hash.Sum(nil))
+
+	if err := f.Sync(); err != nil {
+		return upload, err
+	}
+	return upload, f.Close()
+}
+
+// reserve checks the quotas before size bytes more are attached. Notes
+// written meanwhile aren't accounted for, so they may go over by as much.
+func (a *Attachments) reserve(ctx context.Context, size int64) error {
+	if a.opts.Quotas.MaxBytes <= 0 {
+		return nil
+	}
+	usage, err := a.storage.Usage(ctx)
+	if err != nil {
+		return err
+	}
+	return a.opts.Quotas.Check(usage, 0, size)
+}
+
+// keep moves an upload to its blob, unless the blob exists already.
+func (a *Attachments) keep(upload upload) error {
+	path := a.blobPath(upload.sha256)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(upload.path, path)
+}
+
+// release removes a blob and its thumbnails if no attachment refers to it
+// anymore. Failures are only logged: the next sweep removes them.
+func (a *Attachments) release(ctx context.Context, digest string) {
+	n, err := a.storage.CountBlobReferences(ctx, digest)
+	if err == nil && n == 0 {
+		if err = os.Remove(a.blobPath(digest)); errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		err = errors.Join(err, a.removeThumbnails(digest))
+	}
+	if err != nil {
+		a.log.Error("Failed to remove blob", slog.String("sha256", digest), sl.Err(err))
+	}
+}
+
+func (a *Attachments) blobPath(digest string) string {
+	return filepath.Join(a.opts.Dir, "blobs", digest[:2], digest)
+}
+
+func isDigest(name string) bool {
+	_, err := hex.DecodeString(name)
+	return len(name) == sha256.Size*2 && err == nil
+}
+
+func validateUpload(noteId int64, name string, mediaType string) error {
+	var v notes.ValidationError
+	if noteId <= 0 {
+		v.Add("id", "must be a positive number")
+	}
+	switch {
+	case strings.TrimSpace(name) == "":
+		v.Add("name", "must contain any characters")
+	case !utf8.ValidString(name):
+		v.Add("name", "must be valid UTF-8")
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		v.Add("name", "must not contain control characters")
+	case utf8.RuneCountInString(name) > maxNameLength:
+		v.Add("name", fmt.Sprintf("must be at most %d characters long", maxNameLength))
+	}
+	if mediaType != "" {
+		if _, _, err := mime.ParseMediaType(mediaType); err != nil {
+			v.Add("media_type", "must be a media type")
+		}
+	}
+	return v.Err()
+}
+
+func validateIds(noteId int64, id int64) error {
+	var v notes.ValidationError
+	if noteId <= 0 {
+		v.Add("id", "must be a positive number")
+	}
+	if id <= 0 {
+		v.Add("attachment_id", "must be a positive number")
+	}
+	return v.Err()
+}
@@ -0,0 +1,297 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+
+	"golang.org/x/image/draw"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/exif"
+)
+
+var ErrNoThumbnail = &notes.Error{Code: notes.CodeNotFound, Message: "attachment has no thumbnail"}
+
+// ThumbnailSizes are the sizes thumbnails are made in, each bounding both
+// dimensions of a thumbnail. DefaultThumbnailSize is the one shown in lists.
+var ThumbnailSizes = []int{64, 256, 1024}
+
+const DefaultThumbnailSize = 256
+
+const (
+	// maxImagePixels bounds the images thumbnails are made of, as decoding
+	// one takes 4 bytes a pixel.
+	maxImagePixels = 50_000_000
+	// maxRenders bounds how many thumbnails are made at once, for the same
+	// reason.
+	maxRenders = 2
+	// thumbnailQuality is the JPEG quality of thumbnails of JPEG images.
+	thumbnailQuality = 85
+)
+
+// probe reads the dimensions and format of the image in the file at path,
+// the dimensions as displayed after its EXIF orientation. Content that isn't
+// an image of a known format has none.
+func probe(path string) (width int, height int, format string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, ""
+	}
+	defer f.Close()
+
+	config, format, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, ""
+	}
+	if format == "jpeg" {
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			if orientation, _ := exif.Orientation(f); orientation >= 5 {
+				config.Width, config.Height = config.Height, config.Width
+			}
+		}
+	}
+	return config.Width, config.Height, format
+}
+
+// Thumbnail returns a thumbnail of an image attachment, at most size pixels
+// wide and high, with its media type. Thumbnails of JPEG images are JPEG,
+// others are PNG to keep transparency. A thumbnail is made on first request
+// and kept with the blob of the image.
+func (a *Attachments) Thumbnail(ctx context.Context, noteId int64, id int64, size int) (attachment models.Attachment, mediaType string, content io.ReadSeekCloser, err error) {
+	if err := validateThumbnail(noteId, id, size); err != nil {
+		return models.Attachment{}, "", nil, err
+	}
+
+	attachment, err = a.storage.GetAttachment(ctx, noteId, id)
+	if err != nil {
+		return models.Attachment{}, "", nil, err
+	}
+	format := attachment.Format
+	if format == "" {
+		// Uploaded before images were probed.
+		_, _, format = probe(a.blobPath(attachment.SHA256))
+	}
+	if format == "" {
+		return models.Attachment{}, "", nil, fmt.Errorf("%w: it isn't an image", ErrNoThumbnail)
+	}
+
+	path, mediaType := a.thumbnailPath(attachment.SHA256, size, format)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := a.render(ctx, attachment.SHA256, size, path); err != nil {
+			return models.Attachment{}, "", nil, err
+		}
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return models.Attachment{}, "", nil, err
+	}
+
+	return attachment, mediaType, f, nil
+}
+
+// render makes the thumbnail of a blob at path. Thumbnails made at the same
+// time by concurrent requests replace each other whole.
+func (a *Attachments) render(ctx context.Context, digest string, size int, path string) error {
+	select {
+	case a.renders <- struct{}{}:
+		defer func() { <-a.renders }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	src, err := os.Open(a.blobPath(digest))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Deleted since it was looked up.
+		return ErrAttachmentNotFound
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	config, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoThumbnail, err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return fmt.Errorf("%w: the image has more than %d pixels", ErrNoThumbnail, maxImagePixels)
+	}
+	orientation := 1
+	if format == "jpeg" {
+		if _, err := src.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		orientation, _ = exif.Orientation(src)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoThumbnail, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	thumbnail := orient(scale(img, size, format == "jpeg"), orientation)
+
+	tmp := filepath.Join(a.opts.Dir, "tmp")
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(tmp, "thumbnail-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if format == "jpeg" {
+		err = jpeg.Encode(f, thumbnail, &jpeg.Options{Quality: thumbnailQuality})
+	} else {
+		err = png.Encode(f, thumbnail)
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// scale fits img into a square of size pixels, keeping its aspect ratio.
+// Images that fit already keep their dimensions. Opaque images are scaled
+// to RGBA, others to NRGBA so that PNG keeps their alpha as is.
+func scale(img image.Image, size int, opaque bool) draw.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	var dst draw.Image
+	if opaque {
+		dst = image.NewRGBA(image.Rect(0, 0, width, height))
+	} else {
+		dst = image.NewNRGBA(image.Rect(0, 0, width, height))
+	}
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
+
+// orient turns img as its EXIF orientation says it is displayed.
+func orient(img draw.Image, orientation int) draw.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	rect := image.Rect(0, 0, w, h)
+	if orientation >= 5 {
+		rect = image.Rect(0, 0, h, w)
+	}
+	var dst draw.Image
+	if _, ok := img.(*image.RGBA); ok {
+		dst = image.NewRGBA(rect)
+	} else {
+		dst = image.NewNRGBA(rect)
+	}
+
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// thumbnailPath returns where the thumbnail of a blob in a size is kept, in
+// thumbs/ under the first two characters of the digest, with its media type.
+func (a *Attachments) thumbnailPath(digest string, size int, format string) (path string, mediaType string) {
+	ext, mediaType := ".png", "image/png"
+	if format == "jpeg" {
+		ext, mediaType = ".jpg", "image/jpeg"
+	}
+	return filepath.Join(a.opts.Dir, "thumbs", digest[:2], digest+"-"+strconv.Itoa(size)+ext), mediaType
+}
+
+// removeThumbnails removes the thumbnails of a blob.
+func (a *Attachments) removeThumbnails(digest string) error {
+	paths, err := filepath.Glob(filepath.Join(a.opts.Dir, "thumbs", digest[:2], digest+"-*"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// thumbnailDigest returns the digest of the blob a thumbnail file is named
+// after, or "" if the file isn't a thumbnail.
+func thumbnailDigest(name string) string {
+	digest, _, ok := strings.Cut(name, "-")
+	if !ok || !isDigest(digest) {
+		return ""
+	}
+	return digest
+}
+
+func validateThumbnail(noteId int64, id int64, size int) error {
+	var v notes.ValidationError
+	if noteId <= 0 {
+		v.Add("id", "must be a positive number")
+	}
+	if id <= 0 {
+		v.Add("attachment_id", "must be a positive number")
+	}
+	if !slices.Contains(ThumbnailSizes, size) {
+		v.Add("size", "must be one of 64, 256, 1024")
+	}
+	return v.Err()
+}
@@ -0,0 +1,216 @@
+package notes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/backup"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/wikilink"
+)
+
+// Record types of a backup. Notes come first, in id order, followed by
+// links, ordered by source and target, and attachments in id order.
+// Attachments are their metadata: the content is the blob named by their
+// SHA-256 in the attachment directory, which is backed up with the files.
+const (
+	recordNote       = "note"
+	recordLink       = "link"
+	recordAttachment = "attachment"
+)
+
+var (
+	ErrNotEmpty = &Error{Code: CodeConflict, Message: "database is not empty"}
+	// errNotEmpty stops looking for notes at the first one.
+	errNotEmpty = errors.New("not empty")
+)
+
+// backupLink is a link as it is written, the note it resolves to follows
+// from the notes.
+type backupLink struct {
+	SourceId int64  `json:"source_id"`
+	Target   string `json:"target"`
+}
+
+// Backup writes every note, link and attachment to w, see package backup. The data is
+// read from one consistent view, writes go on meanwhile.
+func (n Notes) Backup(ctx context.Context, w io.Writer) (trailer backup.Trailer, err error) {
+	err = n.storage.WithReadTx(ctx, func(tx Storage) error {
+		trailer, err = dump(ctx, tx, backup.NewWriter(w, time.Now().UTC()))
+		return err
+	})
+	if err != nil {
+		return backup.Trailer{}, err
+	}
+
+	return trailer, nil
+}
+
+func dump(ctx context.Context, s Storage, w *backup.Writer) (backup.Trailer, error) {
+	err := s.EachNote(ctx, func(note models.Note) error {
+		return w.Write(recordNote, note)
+	})
+	if err != nil {
+		return backup.Trailer{}, err
+	}
+
+	links, err := s.GetAllLinks(ctx)
+	if err != nil {
+		return backup.Trailer{}, err
+	}
+	for _, link := range links {
+		if err := w.Write(recordLink, backupLink{SourceId: link.SourceId, Target: link.Target}); err != nil {
+			return backup.Trailer{}, err
+		}
+	}
+
+	attachments, err := s.GetAllAttachments(ctx)
+	if err != nil {
+		return backup.Trailer{}, err
+	}
+	for _, attachment := range attachments {
+		if err := w.Write(recordAttachment, attachment); err != nil {
+			return backup.Trailer{}, err
+		}
+	}
+
+	return w.Close()
+}
+
+// Restore reads a backup written by Backup into an empty database, in one
+// transaction. Notes and attachments keep their ids and timestamps and links
+// are restored as they were, without being parsed from the content again.
+//
+// Once stored, the data is read back and its checksum compared with the one
+// of the backup; the restore is rolled back if they differ. A backup that
+// isn't valid is a ValidationError of the body, a database with notes is
+// ErrNotEmpty.
+func (n Notes) Restore(ctx context.Context, r io.Reader) (report models.RestoreReport, err error) {
+	br, err := backup.NewReader(r)
+	if err != nil {
+		return models.RestoreReport{}, restoreError(err)
+	}
+
+	err = n.storage.WithTx(ctx, func(tx Storage) error {
+		report = models.RestoreReport{}
+
+		err := tx.EachNote(ctx, func(models.Note) error { return errNotEmpty })
+		if errors.Is(err, errNotEmpty) {
+			return ErrNotEmpty
+		}
+		if err != nil {
+			return err
+		}
+
+		// Links are set note by note, they come ordered by source.
+		var sourceId int64
+		var targets []models.LinkTarget
+		setLinks := func() error {
+			if len(targets) == 0 {
+				return nil
+			}
+			err := tx.SetLinks(ctx, sourceId, targets)
+			targets = nil
+			return err
+		}
+
+		for {
+			rec, err := br.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return restoreError(err)
+			}
+
+			switch rec.Type {
+			case recordNote:
+				var note models.Note
+				if err := decodeRecord(rec, &note); err != nil {
+					return err
+				}
+				if note.Id <= 0 {
+					return restoreError(fmt.Errorf("%w: note without an id", backup.ErrInvalid))
+				}
+				if err := tx.Restore(ctx, note); err != nil {
+					return err
+				}
+				report.Notes++
+			case recordLink:
+				var link backupLink
+				if err := decodeRecord(rec, &link); err != nil {
+					return err
+				}
+				if link.SourceId != sourceId {
+					if err := setLinks(); err != nil {
+						return err
+					}
+					sourceId = link.SourceId
+				}
+				targets = append(targets, wikilink.ParseTarget(link.Target))
+				report.Links++
+			case recordAttachment:
+				var attachment models.Attachment
+				if err := decodeRecord(rec, &attachment); err != nil {
+					return err
+				}
+				if attachment.Id <= 0 {
+					return restoreError(fmt.Errorf("%w: attachment without an id", backup.ErrInvalid))
+				}
+				err := tx.RestoreAttachment(ctx, attachment)
+				if errors.Is(err, ErrNoteNotFound) {
+					return restoreError(fmt.Errorf("%w: attachment %d of a missing note %d", backup.ErrInvalid, attachment.Id, attachment.NoteId))
+				}
+				if err != nil {
+					return err
+				}
+				report.Attachments++
+			default:
+				return restoreError(fmt.Errorf("%w: unknown record type %q", backup.ErrInvalid, rec.Type))
+			}
+		}
+		if err := setLinks(); err != nil {
+			return err
+		}
+
+		restored, err := dump(ctx, tx, backup.NewWriter(io.Discard, time.Time{}))
+		if err != nil {
+			return err
+		}
+		if restored != br.Trailer() {
+			return fmt.Errorf("restored data doesn't match the backup: checksum %s, want %s", restored.SHA256, br.Trailer().SHA256)
+		}
+		report.SHA256 = restored.SHA256
+
+		return nil
+	})
+	if err != nil {
+		return models.RestoreReport{}, err
+	}
+
+	return report, nil
+}
+
+// decodeRecord decodes the data of a record into v, refusing fields v
+// doesn't have: they would be lost.
+func decodeRecord(rec backup.Record, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(rec.Data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return restoreError(fmt.Errorf("%w: %s record: %w", backup.ErrInvalid, rec.Type, err))
+	}
+	return nil
+}
+
+// restoreError makes the errors of invalid backups a ValidationError.
+func restoreError(err error) error {
+	if errors.Is(err, backup.ErrInvalid) {
+		return Invalid("body", err.Error())
+	}
+	return err
+}
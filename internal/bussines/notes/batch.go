@@ -0,0 +1,125 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+var (
+	ErrEmptyBatch    = Invalid("operations", "must contain at least one operation")
+	ErrBatchTooLarge = &Error{Code: CodeTooLarge, Message: "batch contains too many operations"}
+)
+
+// BatchError reports the operation that made a batch fail. Fields of a
+// ValidationError it wraps are relative to the operation.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Batch runs ops in order and reports the outcome of each of them.
+//
+// If atomic is set all operations run in one transaction: the first failure
+// rolls the whole batch back and is returned as a *BatchError. Otherwise every
+// operation is applied on its own, failures are only reported in the results
+// and don't stop the operations after them.
+func (n Notes) Batch(ctx context.Context, ops []models.BatchOperation, atomic bool) (results []models.BatchResult, err error) {
+	if len(ops) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if n.limits.MaxBatchSize > 0 && len(ops) > n.limits.MaxBatchSize {
+		return nil, fmt.Errorf("%w: %d, the limit is %d", ErrBatchTooLarge, len(ops), n.limits.MaxBatchSize)
+	}
+
+	results = make([]models.BatchResult, len(ops))
+	for i, op := range ops {
+		results[i] = models.BatchResult{Op: op.Op, Status: models.BatchSkipped}
+	}
+
+	if !atomic {
+		for i, op := range ops {
+			err := n.limits.validateOperation(op)
+			if err == nil {
+				err = n.storage.WithTx(ctx, func(tx Storage) error {
+					id, err := n.apply(ctx, tx, op)
+					results[i].Id = id
+					return err
+				})
+			}
+			setResult(&results[i], err)
+		}
+		return results, nil
+	}
+
+	// Malformed operations are reported before anything is written.
+	for i, op := range ops {
+		if err := n.limits.validateOperation(op); err != nil {
+			setResult(&results[i], err)
+			return results, &BatchError{Index: i, Err: err}
+		}
+	}
+
+	failed := -1
+	err = n.storage.WithTx(ctx, func(tx Storage) error {
+		for i, op := range ops {
+			id, err := n.apply(ctx, tx, op)
+			results[i].Id = id
+			if err != nil {
+				failed = i
+				return &BatchError{Index: i, Err: err}
+			}
+			results[i].Status = models.BatchOk
+		}
+		return nil
+	})
+	if err != nil {
+		// failed stays negative if the commit itself failed.
+		for i := range results {
+			switch {
+			case failed < 0 || i < failed:
+				// Ids of rolled back creations don't exist anymore.
+				if ops[i].Op == models.BatchCreate {
+					results[i].Id = 0
+				}
+				results[i].Status = models.BatchRolledBack
+			case i == failed:
+				setResult(&results[i], errors.Unwrap(err))
+			}
+		}
+		return results, err
+	}
+
+	return results, nil
+}
+
+// apply runs one validated operation and returns the id of the note it touched.
+func (n Notes) apply(ctx context.Context, storage Storage, op models.BatchOperation) (id int64, err error) {
+	switch op.Op {
+	case models.BatchCreate:
+		return n.add(ctx, storage, op.Header, op.Content)
+	case models.BatchEdit:
+		return op.Id, n.edit(ctx, storage, op.Header, op.Content, op.Id)
+	default:
+		return op.Id, storage.Delete(ctx, op.Id)
+	}
+}
+
+func setResult(result *models.BatchResult, err error) {
+	if err != nil {
+		result.Status = models.BatchFailed
+		result.Error = err.Error()
+		return
+	}
+	result.Status = models.BatchOk
+}
@@ -0,0 +1,120 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/enex"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/enml"
+)
+
+// ImportENEX creates a note from every note of an Evernote export read from
+// r, the way Import does with Markdown files. Notes are read one at a time,
+// all of them before any is stored, and reported as "note 1", "note 2" and
+// so on.
+//
+// The content is converted to Markdown, tags are appended to it as #hashtags
+// and the created and updated dates are kept. Embedded resources are replaced
+// by a mention of their file name and, once the notes are stored, passed to
+// attach as attachments of their note; a nil attach drops them. A file that
+// is not a well-formed export is a ValidationError of the body.
+func (n Notes) ImportENEX(ctx context.Context, r io.Reader, dryRun bool, attach AttachFunc) (report models.ImportReport, err error) {
+	dec := enex.NewDecoder(r)
+	index := 0
+
+	return n.importNotes(ctx, func() (importItem, error) {
+		note, err := dec.Next()
+		if errors.Is(err, io.EOF) {
+			return importItem{}, io.EOF
+		}
+		index++
+		item := importItem{name: fmt.Sprintf("note %d", index)}
+
+		var noteErr *enex.NoteError
+		switch {
+		case errors.As(err, &noteErr):
+			item.note.Header, item.err = noteErr.Title, err
+			return item, nil
+		case errors.Is(err, enex.ErrInvalid):
+			return importItem{}, Invalid("body", err.Error())
+		case err != nil:
+			return importItem{}, err
+		}
+
+		item.note, item.err = fromENEX(note)
+		if attach != nil {
+			item.attachments = enexAttachments(note.Resources)
+		}
+		return item, nil
+	}, dryRun, attach)
+}
+
+func fromENEX(note enex.Note) (models.Note, error) {
+	resources := make(map[string]enex.Resource, len(note.Resources))
+	for _, r := range note.Resources {
+		resources[r.Hash] = r
+	}
+
+	content, err := enml.ToMarkdown(note.Content, func(hash string, mediaType string) string {
+		return "[attachment: " + resourceName(resources[hash], mediaType) + "]"
+	})
+	if err != nil {
+		return models.Note{Header: note.Title}, fmt.Errorf("invalid content: %w", err)
+	}
+
+	if tags := hashtags(note.Tags); tags != "" {
+		if content = strings.TrimRight(content, "\n"); content != "" {
+			content += "\n\n"
+		}
+		content += tags + "\n"
+	}
+
+	updated := note.Updated
+	if updated.IsZero() {
+		updated = note.Created
+	}
+	return models.Note{Header: note.Title, Content: content, CreatedAt: note.Created, UpdatedAt: updated}, nil
+}
+
+// enexAttachments returns the resources of a note as attachments.
+func enexAttachments(resources []enex.Resource) []ImportAttachment {
+	attachments := make([]ImportAttachment, 0, len(resources))
+	for _, r := range resources {
+		attachments = append(attachments, ImportAttachment{
+			Name:      resourceName(r, r.MediaType),
+			MediaType: r.MediaType,
+			Data:      r.Data,
+		})
+	}
+	return attachments
+}
+
+// resourceName names a resource after its file, or else after its media
+// type, which is all a pasted image has.
+func resourceName(r enex.Resource, mediaType string) string {
+	if r.FileName != "" {
+		return r.FileName
+	}
+	if mediaType != "" {
+		return mediaType
+	}
+	return "attachment"
+}
+
+// hashtags writes tags as #hashtags, with the white space in them replaced by
+// dashes.
+func hashtags(tags []string) string {
+	var written []string
+	for _, tag := range tags {
+		tag = strings.Join(strings.Fields(strings.TrimLeft(strings.TrimSpace(tag), "#")), "-")
+		if tag != "" && !slices.Contains(written, "#"+tag) {
+			written = append(written, "#"+tag)
+		}
+	}
+	return strings.Join(written, " ")
+}
@@ -0,0 +1,84 @@
+package notes
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// Code is a stable, machine-readable identifier of a kind of error.
+type Code string
+
+const (
+	CodeNotFound        Code = "not_found"
+	CodeConflict        Code = "conflict"
+	CodeValidation      Code = "validation_failed"
+	CodeNothingToChange Code = "nothing_to_change"
+	CodeTooLarge        Code = "too_large"
+	CodeCanceled        Code = "canceled"
+	CodeTimeout         Code = "timeout"
+	CodeQuotaExceeded   Code = "quota_exceeded"
+)
+
+// Error is an error of a known kind. The errors of this package are
+// compared with errors.Is, Code tells what kind of error they are.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ValidationError lists the fields of a request that are invalid. Every
+// ValidationError matches ErrValidation.
+type ValidationError struct {
+	Fields []models.FieldError
+}
+
+// Invalid returns a ValidationError for a single field.
+func Invalid(field string, message string) *ValidationError {
+	return &ValidationError{Fields: []models.FieldError{{Field: field, Message: message}}}
+}
+
+// Add records that field is invalid.
+func (e *ValidationError) Add(field string, message string) {
+	e.Fields = append(e.Fields, models.FieldError{Field: field, Message: message})
+}
+
+// Err returns e if any field was recorded and nil otherwise.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// CodeOf returns the code of the first error of this package in err's chain,
+// or "" if there is none.
+func CodeOf(err error) Code {
+	var validationErr *ValidationError
+	var e *Error
+	switch {
+	case errors.As(err, &validationErr):
+		return CodeValidation
+	case errors.As(err, &e):
+		return e.Code
+	default:
+		return ""
+	}
+}
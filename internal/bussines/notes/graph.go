@@ -0,0 +1,133 @@
+package notes
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// MaxGraphDepth bounds the neighbourhood a graph query may ask for.
+const MaxGraphDepth = 5
+
+// GraphQuery selects the part of the link graph to return. A zero Root
+// selects the whole graph, otherwise the notes at most Depth links away from
+// Root are returned, following links in either direction.
+type GraphQuery struct {
+	Root  int64
+	Depth int
+}
+
+// Graph returns the graph of notes and the resolved links between them.
+// Broken links are left out; several links between the same notes make one
+// edge.
+func (n Notes) Graph(ctx context.Context, q GraphQuery) (graph models.Graph, err error) {
+	v := validator{limits: n.limits}
+	if q.Root != 0 {
+		v.id("note", q.Root)
+		if q.Depth < 1 || q.Depth > MaxGraphDepth {
+			v.Add("depth", fmt.Sprintf("must be between 1 and %d", MaxGraphDepth))
+		}
+	} else if q.Depth != 0 {
+		v.Add("depth", "requires a note")
+	}
+	if err := v.Err(); err != nil {
+		return models.Graph{}, err
+	}
+
+	all, err := n.storage.GetAll(ctx)
+	if err != nil {
+		return models.Graph{}, err
+	}
+	links, err := n.storage.GetAllLinks(ctx)
+	if err != nil {
+		return models.Graph{}, err
+	}
+
+	headers := make(map[int64]string, len(all))
+	for _, note := range all {
+		headers[note.Id] = note.Header
+	}
+
+	type pair struct{ source, target int64 }
+	counts := make(map[pair]int)
+	for _, link := range links {
+		// Links can outlive a note deleted since GetAll.
+		_, source := headers[link.SourceId]
+		_, target := headers[link.TargetId]
+		if source && target {
+			counts[pair{link.SourceId, link.TargetId}]++
+		}
+	}
+
+	include := func(id int64) bool { return true }
+	if q.Root != 0 {
+		if _, ok := headers[q.Root]; !ok {
+			return models.Graph{}, ErrNoteNotFound
+		}
+
+		neighbours := make(map[int64][]int64)
+		for p := range counts {
+			neighbours[p.source] = append(neighbours[p.source], p.target)
+			neighbours[p.target] = append(neighbours[p.target], p.source)
+		}
+
+		reached := map[int64]bool{q.Root: true}
+		frontier := []int64{q.Root}
+		for depth := 0; depth < q.Depth && len(frontier) > 0; depth++ {
+			var next []int64
+			for _, id := range frontier {
+				for _, neighbour := range neighbours[id] {
+					if !reached[neighbour] {
+						reached[neighbour] = true
+						next = append(next, neighbour)
+					}
+				}
+			}
+			frontier = next
+		}
+		include = func(id int64) bool { return reached[id] }
+	}
+
+	index := make(map[int64]int)
+	graph.Nodes = []models.GraphNode{}
+	for _, note := range all {
+		if include(note.Id) {
+			index[note.Id] = len(graph.Nodes)
+			graph.Nodes = append(graph.Nodes, models.GraphNode{Id: note.Id, Header: note.Header})
+		}
+	}
+
+	graph.Edges = []models.GraphEdge{}
+	for p, count := range counts {
+		if include(p.source) && include(p.target) {
+			graph.Edges = append(graph.Edges, models.GraphEdge{Source: p.source, Target: p.target, Links: count})
+			graph.Nodes[index[p.source]].OutDegree++
+			graph.Nodes[index[p.target]].InDegree++
+		}
+	}
+	slices.SortFunc(graph.Edges, func(a, b models.GraphEdge) int {
+		return cmp.Or(cmp.Compare(a.Source, b.Source), cmp.Compare(a.Target, b.Target))
+	})
+
+	graph.Stats = graphStats(graph)
+	return graph, nil
+}
+
+func graphStats(graph models.Graph) models.GraphStats {
+	stats := models.GraphStats{Nodes: len(graph.Nodes), Edges: len(graph.Edges)}
+	for _, node := range graph.Nodes {
+		stats.MaxInDegree = max(stats.MaxInDegree, node.InDegree)
+		stats.MaxOutDegree = max(stats.MaxOutDegree, node.OutDegree)
+		if node.InDegree == 0 && node.OutDegree == 0 {
+			stats.Isolated++
+		}
+	}
+	if stats.Nodes > 0 {
+		stats.AverageDegree = float64(2*stats.Edges) / float64(stats.Nodes)
+	}
+
+	return stats
+}
@@ -0,0 +1,247 @@
+package notes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/frontmatter"
+)
+
+var (
+	ErrNoImportFiles = Invalid("files", "must contain at least one file")
+	errNoHeader      = errors.New("no header in the front matter and no heading")
+	// errDryRun rolls back the transaction of a dry run.
+	errDryRun = errors.New("dry run")
+)
+
+// ImportFile is a file to import. Name is its path inside the imported folder.
+type ImportFile struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
+// ImportAttachment is a file that comes with an imported note.
+type ImportAttachment struct {
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
+// AttachFunc stores an attachment of a note created by an import.
+type AttachFunc func(ctx context.Context, noteId int64, attachment ImportAttachment) error
+
+// importItem is an entry of an import: a note to create, with the files it
+// comes with, an entry to skip or the reason the entry doesn't make a note.
+type importItem struct {
+	name        string
+	note        models.Note
+	attachments []ImportAttachment
+	skip        bool
+	err         error
+}
+
+// Import creates a note from every Markdown file, in one transaction. Files
+// that are not Markdown are skipped, files that don't make a valid note are
+// reported as failed and left out; an error of the storage rolls back the
+// whole import. A dry run reports the same outcome without storing anything.
+//
+// The header of a note comes from the front matter, or else from the first
+// heading of the file. Timestamps in the front matter are kept, ids are not.
+func (n Notes) Import(ctx context.Context, files []ImportFile, dryRun bool) (report models.ImportReport, err error) {
+	if len(files) == 0 {
+		return models.ImportReport{}, ErrNoImportFiles
+	}
+
+	i := 0
+	return n.importNotes(ctx, func() (importItem, error) {
+		if i == len(files) {
+			return importItem{}, io.EOF
+		}
+		file := files[i]
+		i++
+
+		if !isMarkdown(file.Name) {
+			return importItem{name: file.Name, skip: true}, nil
+		}
+		note, err := readNote(file)
+		return importItem{name: file.Name, note: note, err: err}, nil
+	}, dryRun, nil)
+}
+
+// importNotes creates the notes next returns until it returns io.EOF, see
+// Import. Any other error of next fails the import. Every entry is read and
+// validated before the transaction starts, so that a slow upload or a large
+// archive doesn't hold up other writes; only the notes to create are kept
+// meanwhile.
+//
+// Once the notes are stored, attach stores the attachments of each; a file
+// it fails on is reported in the error of its note, which stays created.
+// Without attach the attachments are dropped.
+func (n Notes) importNotes(ctx context.Context, next func() (importItem, error), dryRun bool, attach AttachFunc) (report models.ImportReport, err error) {
+	report = models.ImportReport{DryRun: dryRun, Files: []models.ImportResult{}}
+	// pending are the notes to create, and where they are in the report.
+	type pending struct {
+		note        models.Note
+		attachments []ImportAttachment
+		result      int
+	}
+	var create []pending
+	for {
+		item, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return models.ImportReport{}, err
+		}
+
+		result := models.ImportResult{Name: item.name, Header: item.note.Header}
+		if item.err == nil && !item.skip {
+			item.err = n.limits.validateNote(item.note)
+		}
+		switch {
+		case item.skip:
+			result.Status = models.ImportSkipped
+			report.Skipped++
+		case item.err != nil:
+			result.Status, result.Error = models.ImportFailed, item.err.Error()
+			report.Failed++
+		default:
+			create = append(create, pending{item.note, item.attachments, len(report.Files)})
+			result.Status = models.ImportCreated
+			report.Created++
+		}
+		report.Files = append(report.Files, result)
+	}
+
+	err = n.storage.WithTx(ctx, func(tx Storage) error {
+		for _, p := range create {
+			id, err := n.insert(ctx, tx, p.note)
+			if err != nil {
+				return err
+			}
+			if !dryRun {
+				report.Files[p.result].Id = id
+			}
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errDryRun) {
+		return models.ImportReport{}, err
+	}
+	if dryRun || attach == nil {
+		return report, nil
+	}
+
+	for _, p := range create {
+		result := &report.Files[p.result]
+		var errs []string
+		for _, attachment := range p.attachments {
+			if err := attach(ctx, result.Id, attachment); err != nil {
+				errs = append(errs, fmt.Sprintf("attachment %s: %v", attachment.Name, err))
+			}
+		}
+		result.Error = strings.Join(errs, "; ")
+	}
+
+	return report, nil
+}
+
+// readNote reads a Markdown file. The note is returned even if it has no
+// header, so that what there is of it can be reported.
+func readNote(file ImportFile) (note models.Note, err error) {
+	r, err := file.Open()
+	if err != nil {
+		return models.Note{}, err
+	}
+	defer r.Close()
+
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return models.Note{}, err
+	}
+	if !utf8.Valid(src) {
+		return models.Note{}, Invalid("content", "must be valid UTF-8")
+	}
+
+	meta, body, err := frontmatter.Parse(src)
+	if err != nil {
+		return models.Note{}, err
+	}
+
+	note = models.Note{
+		Header:    meta.Header,
+		Content:   string(body),
+		CreatedAt: meta.CreatedAt,
+		UpdatedAt: meta.UpdatedAt,
+	}
+	if note.UpdatedAt.IsZero() {
+		note.UpdatedAt = note.CreatedAt
+	}
+	if note.Header == "" {
+		if note.Header = firstHeading(note.Content); note.Header == "" {
+			return note, errNoHeader
+		}
+	}
+
+	return note, nil
+}
+
+// isMarkdown tells Markdown files from anything else a folder may hold,
+// including the metadata files of operating systems.
+func isMarkdown(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return false
+		}
+	}
+
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// firstHeading returns the text of the first ATX heading outside fenced code
+// blocks, or "".
+func firstHeading(content string) string {
+	fence := ""
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fence = trimmed[:3]
+			continue
+		}
+
+		level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+		if level < 1 || level > 6 || level < len(trimmed) && trimmed[level] != ' ' && trimmed[level] != '\t' {
+			continue
+		}
+		// A closing sequence of #s isn't part of the heading.
+		text := strings.TrimSpace(trimmed[level:])
+		if stripped := strings.TrimRight(text, "#"); stripped == "" || strings.HasSuffix(stripped, " ") {
+			text = strings.TrimSpace(stripped)
+		}
+		if text != "" {
+			return text
+		}
+	}
+	return ""
+}
@@ -2,25 +2,78 @@ package notes
 
 import (
 	"context"
-	"errors"
 
 	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/wikilink"
+)
+
+var (
+	ErrNoteNotFound    = &Error{Code: CodeNotFound, Message: "note not found"}
+	ErrNothingToChange = &Error{Code: CodeNothingToChange, Message: "nothing to change"}
+	ErrValidation      = &Error{Code: CodeValidation, Message: "validation failed"}
+	// ErrConflict is returned by storages when a write clashes with the data
+	// already stored.
+	ErrConflict = &Error{Code: CodeConflict, Message: "conflicting change"}
+	// ErrCanceled and ErrTimeout are returned by storages when the caller's
+	// context is canceled or the query runs out of time.
+	ErrCanceled = &Error{Code: CodeCanceled, Message: "request canceled"}
+	ErrTimeout  = &Error{Code: CodeTimeout, Message: "query timed out"}
 )
 
 type Storage interface {
 	GetAll(ctx context.Context) (notes []models.Note, err error)
+	// EachNote calls fn for every note in id order until fn returns an error,
+	// without holding all notes in memory. It isn't bound by the query
+	// timeout, fn may take as long as it needs.
+	EachNote(ctx context.Context, fn func(note models.Note) error) (err error)
 	GetById(ctx context.Context, id int64) (note models.Note, err error)
 	Add(ctx context.Context, header string, content string) (id int64, err error)
+	// Insert stores note as it is. A zero id is assigned like Add does and
+	// zero timestamps are set to the current time; an id that is taken is
+	// an ErrConflict.
+	Insert(ctx context.Context, note models.Note) (id int64, err error)
+	// Restore stores note exactly as it is, zero timestamps included. The id
+	// must be set; an id that is taken is an ErrConflict.
+	Restore(ctx context.Context, note models.Note) (err error)
 	Edit(ctx context.Context, header string, content string, id int64) (err error)
+	// SetKDF replaces the key derivation parameters of a note, which Edit
+	// keeps like its type.
+	SetKDF(ctx context.Context, id int64, kdf models.KDF) (err error)
 	Delete(ctx context.Context, id int64) (err error)
+	// SetLinks replaces the links of a note. Links are removed together with
+	// the note they are in.
+	SetLinks(ctx context.Context, sourceId int64, targets []models.LinkTarget) (err error)
+	// GetLinks returns the links of a note ordered by target, GetBacklinks
+	// the links resolving to a note ordered by source, GetBrokenLinks the
+	// links resolving to no note ordered by source and target.
+	GetLinks(ctx context.Context, sourceId int64) (links []models.Link, err error)
+	GetBacklinks(ctx context.Context, targetId int64) (links []models.Link, err error)
+	GetBrokenLinks(ctx context.Context) (links []models.Link, err error)
+	// GetAllLinks returns the links of all notes ordered by source and target.
+	GetAllLinks(ctx context.Context) (links []models.Link, err error)
+	// GetAllAttachments returns the attachments of all notes ordered by id.
+	GetAllAttachments(ctx context.Context) (attachments []models.Attachment, err error)
+	// RestoreAttachment stores attachment exactly as it is, id included; an
+	// id that is taken is an ErrConflict and a missing note ErrNoteNotFound.
+	RestoreAttachment(ctx context.Context, attachment models.Attachment) (err error)
+	// Usage counts the notes and attachments stored and their bytes, see
+	// models.Usage. The quota fields and the total are left zero.
+	Usage(ctx context.Context) (usage models.Usage, err error)
+	// WithTx runs fn atomically: every call fn makes on tx is committed if fn
+	// returns nil and discarded otherwise.
+	WithTx(ctx context.Context, fn func(tx Storage) error) (err error)
+	// WithReadTx runs fn on a consistent view of the data, which writes
+	// don't wait for. fn must not write through tx.
+	WithReadTx(ctx context.Context, fn func(tx Storage) error) (err error)
 }
 
 type Notes struct {
 	storage Storage
+	limits  Limits
 }
 
-func New(storage Storage) Notes {
-	return Notes{storage}
+func New(storage Storage, limits Limits) Notes {
+	return Notes{storage, limits}
 }
 
 func (n Notes) GetAll(ctx context.Context) (notes []models.Note, err error) {
@@ -32,13 +85,105 @@ func (n Notes) GetAll(ctx context.Context) (notes []models.Note, err error) {
 	return notes, nil
 }
 
+func (n Notes) GetById(ctx context.Context, id int64) (note models.Note, err error) {
+	v := validator{limits: n.limits}
+	v.id("id", id)
+	if err := v.Err(); err != nil {
+		return models.Note{}, err
+	}
+
+	return n.storage.GetById(ctx, id)
+}
+
 func (n Notes) Add(ctx context.Context, header string, content string) (id int64, err error) {
-	id, err = n.storage.Add(ctx, header, content)
-	return id, err
+	if err := n.limits.validateNote(models.Note{Header: header, Content: content}); err != nil {
+		return 0, err
+	}
+
+	err = n.storage.WithTx(ctx, func(tx Storage) error {
+		id, err = n.add(ctx, tx, header, content)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// AddEncrypted adds a note encrypted end to end: content is the blob the
+// client encrypted, in base64, and kdf how it derives the key from its
+// passphrase. The server can't read the content, so it has no links.
+func (n Notes) AddEncrypted(ctx context.Context, header string, content string, kdf models.KDF) (id int64, err error) {
+	note := models.Note{Header: header, Content: content, Type: models.NoteTypeEncrypted, KDF: kdf}
+	if err := n.limits.validateNote(note); err != nil {
+		return 0, err
+	}
+
+	err = n.storage.WithTx(ctx, func(tx Storage) error {
+		id, err = n.insert(ctx, tx, note)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// linksOf returns the links in the content of a note. Encrypted notes have
+// none, their content is opaque.
+func linksOf(note models.Note) []models.LinkTarget {
+	if note.Type == models.NoteTypeEncrypted {
+		return nil
+	}
+	return wikilink.Parse(note.Content)
+}
+
+// insert stores a note as it is together with the links in its content.
+func (n Notes) insert(ctx context.Context, storage Storage, note models.Note) (id int64, err error) {
+	if err := n.limits.reserve(ctx, storage, 1, noteBytes(note.Header, note.Content)); err != nil {
+		return 0, err
+	}
+
+	id, err = storage.Insert(ctx, note)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := storage.SetLinks(ctx, id, linksOf(note)); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// add stores a note together with the links in its content.
+func (n Notes) add(ctx context.Context, storage Storage, header string, content string) (id int64, err error) {
+	if err := n.limits.reserve(ctx, storage, 1, noteBytes(header, content)); err != nil {
+		return 0, err
+	}
+
+	id, err = storage.Add(ctx, header, content)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := storage.SetLinks(ctx, id, wikilink.Parse(content)); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
 func (n Notes) Edit(ctx context.Context, header string, content string, id int64) (err error) {
-	note, err := n.storage.GetById(ctx, id)
+	if err := n.limits.validateEdit(header, content, id); err != nil {
+		return err
+	}
+
+	return n.storage.WithTx(ctx, func(tx Storage) error {
+		return n.edit(ctx, tx, header, content, id)
+	})
+}
+
+func (n Notes) edit(ctx context.Context, storage Storage, header string, content string, id int64) (err error) {
+	note, err := storage.GetById(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -52,18 +197,171 @@ func (n Notes) Edit(ctx context.Context, header string, content string, id int64
 	}
 
 	if header == note.Header && content == note.Content {
-		return errors.New("nothing to change")
+		return ErrNothingToChange
+	}
+	if note.Type == models.NoteTypeEncrypted {
+		v := validator{limits: n.limits}
+		v.encrypted("content", content)
+		if err := v.Err(); err != nil {
+			return err
+		}
+	}
+	if err := n.limits.reserve(ctx, storage, 0, noteBytes(header, content)-noteBytes(note.Header, note.Content)); err != nil {
+		return err
 	}
 
-	err = n.storage.Edit(ctx, header, content, id)
+	err = storage.Edit(ctx, header, content, id)
 	if err != nil {
 		return err
 	}
 
+	if content != note.Content {
+		note.Content = content
+		return storage.SetLinks(ctx, id, linksOf(note))
+	}
 	return nil
 }
 
+// Patch replaces the note with the given id by what fn makes of it. fn runs
+// in the same transaction as the write, so the note can't change in between.
+// Unlike Edit, empty fields are stored as they are; a patch that changes
+// nothing is not an error and the note is returned unchanged.
+func (n Notes) Patch(ctx context.Context, id int64, fn func(note models.Note) (models.Note, error)) (note models.Note, err error) {
+	err = n.storage.WithTx(ctx, func(tx Storage) error {
+		current, err := tx.GetById(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		note, err = fn(current)
+		if err != nil {
+			return err
+		}
+		// The id and the timestamps are not fn's to change.
+		note.Id, note.CreatedAt, note.UpdatedAt = id, current.CreatedAt, current.UpdatedAt
+		if note.Type != current.Type {
+			return Invalid("type", "can't be changed")
+		}
+		// Content encrypted with a key derived one way can't be read with
+		// a key derived another way.
+		if note.KDF != current.KDF && note.Content == current.Content {
+			return Invalid("kdf", "can only change together with content")
+		}
+
+		if err := n.limits.validateNote(note); err != nil {
+			return err
+		}
+		if note == current {
+			return nil
+		}
+		if err := n.limits.reserve(ctx, tx, 0, noteBytes(note.Header, note.Content)-noteBytes(current.Header, current.Content)); err != nil {
+			return err
+		}
+
+		if err := tx.Edit(ctx, note.Header, note.Content, id); err != nil {
+			return err
+		}
+		if note.KDF != current.KDF {
+			if err := tx.SetKDF(ctx, id, note.KDF); err != nil {
+				return err
+			}
+		}
+		if note.Content != current.Content {
+			if err := tx.SetLinks(ctx, id, linksOf(note)); err != nil {
+				return err
+			}
+		}
+
+		note, err = tx.GetById(ctx, id)
+		return err
+	})
+	if err != nil {
+		return models.Note{}, err
+	}
+
+	return note, nil
+}
+
 func (n Notes) Delete(ctx context.Context, id int64) (err error) {
+	v := validator{limits: n.limits}
+	v.id("id", id)
+	if err := v.Err(); err != nil {
+		return err
+	}
+
 	err = n.storage.Delete(ctx, id)
 	return err
 }
+
+// Export calls fn for every note in id order, stopping at the first error.
+func (n Notes) Export(ctx context.Context, fn func(note models.Note) error) (err error) {
+	return n.storage.EachNote(ctx, fn)
+}
+
+// Links returns the [[wiki links]] in the content of a note. A link that
+// resolves to no note has a zero target id.
+func (n Notes) Links(ctx context.Context, id int64) (links []models.Link, err error) {
+	if _, err := n.GetById(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return n.storage.GetLinks(ctx, id)
+}
+
+// Backlinks returns the links pointing at a note, by its id or its header.
+func (n Notes) Backlinks(ctx context.Context, id int64) (links []models.Link, err error) {
+	if _, err := n.GetById(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return n.storage.GetBacklinks(ctx, id)
+}
+
+// BrokenLinks returns the links of all notes that resolve to no note.
+func (n Notes) BrokenLinks(ctx context.Context) (links []models.Link, err error) {
+	return n.storage.GetBrokenLinks(ctx)
+}
+
+// BackfillLinks parses the links of every note if no link is stored at all,
+// as in databases written before links were kept, and returns how many notes
+// links were found in. Once any link is stored it does nothing, so it can be
+// run on every start.
+func (n Notes) BackfillLinks(ctx context.Context) (notes int, err error) {
+	usage, err := n.storage.Usage(ctx)
+	if err != nil || usage.Notes == 0 {
+		return 0, err
+	}
+
+	type noteLinks struct {
+		id      int64
+		targets []models.LinkTarget
+	}
+	err = n.storage.WithTx(ctx, func(tx Storage) error {
+		links, err := tx.GetAllLinks(ctx)
+		if err != nil || len(links) > 0 {
+			return err
+		}
+
+		var found []noteLinks
+		err = tx.EachNote(ctx, func(note models.Note) error {
+			if targets := linksOf(note); len(targets) > 0 {
+				found = append(found, noteLinks{note.Id, targets})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, f := range found {
+			if err := tx.SetLinks(ctx, f.id, f.targets); err != nil {
+				return err
+			}
+		}
+		notes = len(found)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return notes, nil
+}
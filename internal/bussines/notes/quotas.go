@@ -0,0 +1,62 @@
+package notes
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+var ErrQuotaExceeded = &Error{Code: CodeQuotaExceeded, Message: "quota exceeded"}
+
+// Quotas bound what is stored in total rather than what a single request
+// may ask for, see models.Usage for what is counted. Zero doesn't limit.
+type Quotas struct {
+	MaxNotes int
+	MaxBytes int64
+}
+
+// Check fails with ErrQuotaExceeded if storing notes more notes and bytes
+// more bytes on top of usage would go over a quota. Changes that don't grow
+// the usage always pass, so that notes can still be shortened and deleted
+// once a quota is lowered below the usage.
+func (q Quotas) Check(usage models.Usage, notes int, bytes int64) error {
+	if q.MaxNotes > 0 && notes > 0 && usage.Notes+notes > q.MaxNotes {
+		return fmt.Errorf("%w: %d notes are stored, the limit is %d", ErrQuotaExceeded, usage.Notes, q.MaxNotes)
+	}
+	if stored := usage.NoteBytes + usage.AttachmentBytes; q.MaxBytes > 0 && bytes > 0 && stored+bytes > q.MaxBytes {
+		return fmt.Errorf("%w: %d bytes are stored and %d more would be, the limit is %d bytes", ErrQuotaExceeded, stored, bytes, q.MaxBytes)
+	}
+	return nil
+}
+
+// reserve checks the quotas against what storage holds before notes more
+// notes and bytes more bytes are written to it. The write must be part of
+// the same transaction for the check to hold.
+func (q Quotas) reserve(ctx context.Context, storage Storage, notes int, bytes int64) error {
+	if q.MaxNotes <= 0 && q.MaxBytes <= 0 {
+		return nil
+	}
+	usage, err := storage.Usage(ctx)
+	if err != nil {
+		return err
+	}
+	return q.Check(usage, notes, bytes)
+}
+
+// Usage returns what is stored with the quotas it is held to.
+func (n Notes) Usage(ctx context.Context) (usage models.Usage, err error) {
+	usage, err = n.storage.Usage(ctx)
+	if err != nil {
+		return models.Usage{}, err
+	}
+	usage.Bytes = usage.NoteBytes + usage.AttachmentBytes
+	usage.MaxNotes, usage.MaxBytes = n.limits.MaxNotes, n.limits.MaxBytes
+
+	return usage, nil
+}
+
+// noteBytes is what a note adds to the usage.
+func noteBytes(header string, content string) int64 {
+	return int64(len(header) + len(content))
+}
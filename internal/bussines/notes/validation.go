@@ -0,0 +1,159 @@
+package notes
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// Limits bound what a single request may ask for, and with Quotas what is
+// stored in total. Zero means no limit.
+type Limits struct {
+	MaxBatchSize int
+	// MaxHeaderLength and MaxContentLength are counted in characters.
+	MaxHeaderLength  int
+	MaxContentLength int
+	Quotas
+}
+
+// validator collects the field errors of a request.
+type validator struct {
+	limits Limits
+	ValidationError
+}
+
+// header checks a note header. A header is a single line of printable text
+// with at least one non-space character.
+func (v *validator) header(field string, header string) {
+	switch {
+	case strings.TrimSpace(header) == "":
+		v.Add(field, "must contain any characters")
+	case !utf8.ValidString(header):
+		v.Add(field, "must be valid UTF-8")
+	case strings.IndexFunc(header, unicode.IsControl) >= 0:
+		v.Add(field, "must not contain control characters or line breaks")
+	case v.limits.MaxHeaderLength > 0 && utf8.RuneCountInString(header) > v.limits.MaxHeaderLength:
+		v.Add(field, fmt.Sprintf("must be at most %d characters long", v.limits.MaxHeaderLength))
+	}
+}
+
+// content checks a note content, which may span several lines.
+func (v *validator) content(field string, content string) {
+	switch {
+	case !utf8.ValidString(content):
+		v.Add(field, "must be valid UTF-8")
+	case strings.IndexFunc(content, isForbiddenInContent) >= 0:
+		v.Add(field, "must not contain control characters other than tabs and line breaks")
+	case v.limits.MaxContentLength > 0 && utf8.RuneCountInString(content) > v.limits.MaxContentLength:
+		v.Add(field, fmt.Sprintf("must be at most %d characters long", v.limits.MaxContentLength))
+	}
+}
+
+// minSaltSize is the size in bytes below which a salt is too short to keep
+// the keys derived with it apart.
+const minSaltSize = 16
+
+// maxNoteIdLength caps the note id clients bind encrypted contents to.
+const maxNoteIdLength = 64
+
+// encrypted checks the content of an encrypted note. It is opaque to the
+// server, which only checks that it is base64.
+func (v *validator) encrypted(field string, content string) {
+	switch {
+	case content == "":
+		v.Add(field, "must not be empty for an encrypted note")
+	case !isBase64(content):
+		v.Add(field, "must be base64 for an encrypted note")
+	case v.limits.MaxContentLength > 0 && len(content) > v.limits.MaxContentLength:
+		v.Add(field, fmt.Sprintf("must be at most %d characters long", v.limits.MaxContentLength))
+	}
+}
+
+// kdf checks the key derivation parameters of an encrypted note. The
+// algorithm is the client's to pick, the server only stores its name.
+func (v *validator) kdf(field string, kdf models.KDF) {
+	salt, err := base64.StdEncoding.DecodeString(kdf.Salt)
+	switch {
+	case kdf.Algorithm == "" || len(kdf.Algorithm) > 32 || strings.IndexFunc(kdf.Algorithm, isForbiddenInAlgorithm) >= 0:
+		v.Add(field+".algorithm", "must be 1 to 32 lowercase letters, digits and dashes")
+	case err != nil || len(salt) < minSaltSize:
+		v.Add(field+".salt", fmt.Sprintf("must be at least %d bytes in base64", minSaltSize))
+	case kdf.Iterations < 1:
+		v.Add(field+".iterations", "must be a positive number")
+	case kdf.Memory < 0 || kdf.Parallelism < 0:
+		v.Add(field, "must not have a negative memory or parallelism")
+	case len(kdf.NoteId) > maxNoteIdLength || !isBase64(kdf.NoteId):
+		v.Add(field+".note_id", fmt.Sprintf("must be at most %d characters of base64", maxNoteIdLength))
+	}
+}
+
+func isBase64(s string) bool {
+	_, err := base64.StdEncoding.DecodeString(s)
+	return err == nil
+}
+
+func isForbiddenInAlgorithm(r rune) bool {
+	return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-')
+}
+
+func (v *validator) id(field string, id int64) {
+	if id <= 0 {
+		v.Add(field, "must be a positive number")
+	}
+}
+
+func isForbiddenInContent(r rune) bool {
+	return unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t'
+}
+
+// validateNote checks a note to be stored as a whole.
+func (l Limits) validateNote(note models.Note) error {
+	v := validator{limits: l}
+	v.header("header", note.Header)
+	switch note.Type {
+	case "":
+		v.content("content", note.Content)
+		if note.KDF != (models.KDF{}) {
+			v.Add("kdf", "must be empty for a Markdown note")
+		}
+	case models.NoteTypeEncrypted:
+		v.encrypted("content", note.Content)
+		v.kdf("kdf", note.KDF)
+	default:
+		v.Add("type", fmt.Sprintf("unknown type %q, want empty or %q", note.Type, models.NoteTypeEncrypted))
+	}
+	return v.Err()
+}
+
+// validateEdit checks the arguments of Edit, where empty fields are kept.
+func (l Limits) validateEdit(header string, content string, id int64) error {
+	v := validator{limits: l}
+	v.id("id", id)
+	if header != "" {
+		v.header("header", header)
+	}
+	if content != "" {
+		v.content("content", content)
+	}
+	return v.Err()
+}
+
+// validateOperation checks a batch operation before anything is written.
+func (l Limits) validateOperation(op models.BatchOperation) error {
+	switch op.Op {
+	case models.BatchCreate:
+		return l.validateNote(models.Note{Header: op.Header, Content: op.Content})
+	case models.BatchEdit:
+		return l.validateEdit(op.Header, op.Content, op.Id)
+	case models.BatchDelete:
+		v := validator{limits: l}
+		v.id("id", op.Id)
+		return v.Err()
+	default:
+		return Invalid("op", fmt.Sprintf("unknown operation %q", op.Op))
+	}
+}
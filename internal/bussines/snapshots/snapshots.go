@@ -0,0 +1,191 @@
+// Package snapshots takes consistent copies of the database while the server
+// runs, on a schedule or on request, and rotates them.
+package snapshots
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
+)
+
+// Snapshots are named after the time they are taken, so that their names
+// sort by age.
+const (
+	namePrefix = "notes-"
+	nameSuffix = ".db"
+	timeLayout = "20060102T150405.000Z"
+)
+
+type Storage interface {
+	// Snapshot writes a consistent copy of the database to path, which
+	// must not exist.
+	Snapshot(ctx context.Context, path string) (err error)
+}
+
+type Options struct {
+	// Dir is where snapshots are kept. Files in it that are not snapshots
+	// are left alone.
+	Dir string
+	// Retention is how many of the newest snapshots are kept. Zero keeps
+	// all of them.
+	Retention int
+	// Prune, if set, is called with the path of the oldest snapshot kept
+	// after old ones were removed, to remove what only those needed, e.g.
+	// the journal to replay over them.
+	Prune func(oldest string) error
+}
+
+type Snapshots struct {
+	storage Storage
+	log     *slog.Logger
+	opts    Options
+	// mu keeps scheduled and requested snapshots from running at once.
+	mu sync.Mutex
+}
+
+func New(storage Storage, log *slog.Logger, opts Options) *Snapshots {
+	return &Snapshots{storage: storage, log: log, opts: opts}
+}
+
+// Take takes a snapshot and removes the ones past the retention. The snapshot
+// is written under a temporary name first, so that a snapshot that is listed
+// is complete.
+func (s *Snapshots) Take(ctx context.Context) (snapshot models.Snapshot, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.opts.Dir, 0o755); err != nil {
+		return models.Snapshot{}, err
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Millisecond)
+	name := namePrefix + createdAt.Format(timeLayout) + nameSuffix
+	path := filepath.Join(s.opts.Dir, name)
+	tmp := path + ".tmp"
+
+	start := time.Now()
+	os.Remove(tmp)
+	if err := s.storage.Snapshot(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return models.Snapshot{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return models.Snapshot{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.Snapshot{}, err
+	}
+	s.log.Info("Snapshot taken", slog.String("name", name), slog.Duration("elapsed", time.Since(start)))
+
+	if err := s.rotate(ctx); err != nil {
+		// The snapshot is taken, the old ones go with the next one.
+		s.log.Error("Failed to remove old snapshots", sl.Err(err))
+	}
+
+	return models.Snapshot{Name: name, CreatedAt: createdAt, Size: info.Size()}, nil
+}
+
+// List returns the snapshots, newest first.
+func (s *Snapshots) List(ctx context.Context) (snapshots []models.Snapshot, err error) {
+	entries, err := os.ReadDir(s.opts.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []models.Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots = []models.Snapshot{}
+	for _, entry := range entries {
+		createdAt, ok := parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed by a rotation meanwhile.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, models.Snapshot{Name: entry.Name(), CreatedAt: createdAt, Size: info.Size()})
+	}
+	slices.SortFunc(snapshots, func(a, b models.Snapshot) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+// Run takes a snapshot every interval until ctx is done. Failed snapshots are
+// logged and retried at the next tick.
+func (s *Snapshots) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.Take(ctx); err != nil && ctx.Err() == nil {
+				s.log.Error("Failed to take snapshot", sl.Err(err))
+			}
+		}
+	}
+}
+
+// rotate removes the oldest snapshots past the retention, then prunes what
+// the oldest one kept doesn't need.
+func (s *Snapshots) rotate(ctx context.Context) error {
+	if s.opts.Retention <= 0 {
+		return nil
+	}
+
+	snapshots, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	kept := snapshots[:min(s.opts.Retention, len(snapshots))]
+
+	var errs []error
+	for _, snapshot := range snapshots[len(kept):] {
+		err := os.Remove(filepath.Join(s.opts.Dir, snapshot.Name))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		s.log.Info("Snapshot removed", slog.String("name", snapshot.Name))
+	}
+	// What an old snapshot that failed to be removed needs is kept.
+	if len(errs) == 0 && s.opts.Prune != nil && len(kept) > 0 {
+		errs = append(errs, s.opts.Prune(filepath.Join(s.opts.Dir, kept[len(kept)-1].Name)))
+	}
+	return errors.Join(errs...)
+}
+
+func parseName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	if stamp, ok = strings.CutSuffix(stamp, nameSuffix); !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(timeLayout, stamp)
+	return t, err == nil
+}
@@ -1,19 +1,97 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"os"
+	"time"
 
 	"github.com/ilyakaznacheev/cleanenv"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/envelope"
 )
 
 type Config struct {
 	Env            string `yaml:"env"`
+	Storage        string `yaml:"storage" env-default:"sqlite"`
 	StoragePath    string `yaml:"storage_path"`
+	SnapshotPath   string `yaml:"snapshot_path"`
 	MigrationsPath string `yaml:"migrations_path"`
 	Port           string `yaml:"port"`
+
+	QueryTimeout       time.Duration `yaml:"query_timeout" env-default:"5s"`
+	SlowQueryThreshold time.Duration `yaml:"slow_query_threshold" env-default:"200ms"`
+
+	MaxBatchSize int `yaml:"max_batch_size" env-default:"100"`
+	// MaxHeaderLength and MaxContentLength are counted in characters,
+	// MaxBodySize in bytes. Zero disables a limit.
+	MaxHeaderLength  int   `yaml:"max_header_length" env-default:"255"`
+	MaxContentLength int   `yaml:"max_content_length" env-default:"100000"`
+	MaxBodySize      int64 `yaml:"max_body_size" env-default:"1048576"`
+	// MaxImportSize replaces MaxBodySize for imports and also bounds every
+	// file unpacked from an imported archive.
+	MaxImportSize int64 `yaml:"max_import_size" env-default:"33554432"`
+	// IdempotencyTTL is how long responses to requests with an
+	// Idempotency-Key are kept for replay. Zero disables idempotency keys.
+	// IdempotencyLease is how long a request with a key may be in progress
+	// before it is taken for abandoned and the key may be used again; it
+	// must outlast the slowest request.
+	IdempotencyTTL   time.Duration `yaml:"idempotency_ttl" env-default:"24h"`
+	IdempotencyLease time.Duration `yaml:"idempotency_lease" env-default:"1m"`
+
+	// SnapshotDir is where snapshots of the SQLite database are kept, empty
+	// disables them. A snapshot is taken every SnapshotInterval, or only on
+	// request if it is zero, and the newest SnapshotRetention are kept, or
+	// all of them if it is zero.
+	SnapshotDir       string        `yaml:"snapshot_dir"`
+	SnapshotInterval  time.Duration `yaml:"snapshot_interval" env-default:"24h"`
+	SnapshotRetention int           `yaml:"snapshot_retention" env-default:"7"`
+	// JournalDir is where every change to the SQLite database is journaled
+	// for point-in-time restores, empty disables the journal. A journal
+	// segment is sealed once it grows past JournalSegmentSize bytes. With a
+	// snapshot retention, segments the oldest snapshot kept holds all the
+	// changes of are removed with the old snapshots.
+	JournalDir         string `yaml:"journal_dir"`
+	JournalSegmentSize int64  `yaml:"journal_segment_size" env-default:"67108864"`
+	// AttachmentDir is where the content of attachments is kept, empty
+	// disables attachments. An attachment may be up to MaxAttachmentSize
+	// bytes, zero doesn't limit it. Content no attachment refers to anymore,
+	// which deleting notes leaves behind, is removed every
+	// AttachmentSweepInterval, or only on request if it is zero.
+	AttachmentDir           string        `yaml:"attachment_dir"`
+	MaxAttachmentSize       int64         `yaml:"max_attachment_size" env-default:"26214400"`
+	AttachmentSweepInterval time.Duration `yaml:"attachment_sweep_interval" env-default:"1h"`
+	// MaxNotes and MaxStoredBytes are quotas on all that is stored: notes,
+	// and the bytes of their headers, contents and attachments. Writes that
+	// would go over them fail, zero disables a quota.
+	MaxNotes       int   `yaml:"max_notes"`
+	MaxStoredBytes int64 `yaml:"max_stored_bytes"`
+	// EncryptionKey is a base64 master key, EncryptionKeyFile a file holding
+	// one; setting either encrypts the headers and contents of notes in the
+	// SQLite database. `openssl rand -base64 32` makes a key. EncryptionIndex
+	// says what is kept to look notes up by despite it: "titles" keeps a
+	// keyed hash of titles, which [[title]] links need to resolve, and "none"
+	// nothing.
+	EncryptionKey     string `yaml:"encryption_key"`
+	EncryptionKeyFile string `yaml:"encryption_key_file"`
+	EncryptionIndex   string `yaml:"encryption_index" env-default:"none"`
+	// AdminToken is the bearer token the /admin/ routes need, which back up,
+	// restore and snapshot the database. Empty doesn't serve them at all.
+	// MaxRestoreSize bounds the backup a restore reads, zero doesn't limit
+	// it.
+	AdminToken     string `yaml:"admin_token"`
+	MaxRestoreSize int64  `yaml:"max_restore_size" env-default:"1073741824"`
 }
 
+const (
+	StorageSQLite = "sqlite"
+	StorageMemory = "memory"
+)
+
+const (
+	EncryptionIndexNone   = "none"
+	EncryptionIndexTitles = "titles"
+)
+
 func MustLoad() Config {
 	configPath := fetchConfigPath()
 	if configPath == "" {
@@ -29,9 +107,34 @@ func MustLoadPath(configPath string) Config {
 	if err := cleanenv.ReadConfig(configPath, &cfg); err != nil {
 		panic("cannot read config:" + err.Error())
 	}
+
+	switch cfg.Storage {
+	case StorageSQLite, StorageMemory:
+	default:
+		panic("invalid storage value " + cfg.Storage)
+	}
+	switch cfg.EncryptionIndex {
+	case EncryptionIndexNone, EncryptionIndexTitles:
+	default:
+		panic("invalid encryption_index value " + cfg.EncryptionIndex)
+	}
 	return cfg
 }
 
+// MasterKey returns the key set by EncryptionKey or EncryptionKeyFile, nil
+// if encryption is off.
+func (c Config) MasterKey() ([]byte, error) {
+	switch {
+	case c.EncryptionKey != "" && c.EncryptionKeyFile != "":
+		return nil, errors.New("encryption_key and encryption_key_file are both set")
+	case c.EncryptionKey != "":
+		return envelope.ParseKey(c.EncryptionKey)
+	case c.EncryptionKeyFile != "":
+		return envelope.ReadKeyFile(c.EncryptionKeyFile)
+	}
+	return nil, nil
+}
+
 func fetchConfigPath() string {
 	var res string
 
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Attachment is a file uploaded to a note. SHA256 is the hex digest of its
+// content, attachments with the same content share it. Images also have
+// their dimensions, as displayed, and the format they are decoded from.
+type Attachment struct {
+	Id        int64     `json:"id" example:"1"`
+	NoteId    int64     `json:"note_id" example:"1"`
+	Name      string    `json:"name" example:"receipt.pdf"`
+	MediaType string    `json:"media_type" example:"application/pdf"`
+	Size      int64     `json:"size" example:"48213"`
+	SHA256    string    `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+	Width     int       `json:"width,omitempty" example:"4032"`
+	Height    int       `json:"height,omitempty" example:"3024"`
+	Format    string    `json:"format,omitempty" enums:"jpeg,png,gif,webp" example:"jpeg"`
+	CreatedAt time.Time `json:"created_at" example:"2025-01-02T15:04:05.000Z"`
+}
+
+// SweepReport tells what a sweep of unreferenced blobs removed.
+type SweepReport struct {
+	Removed int   `json:"removed" example:"2"`
+	Freed   int64 `json:"freed" example:"96426"`
+}
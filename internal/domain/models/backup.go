@@ -0,0 +1,10 @@
+package models
+
+// RestoreReport sums up a restored backup. SHA256 is the checksum of the
+// restored records, which matches the trailer of the backup.
+type RestoreReport struct {
+	Notes       int    `json:"notes" example:"42"`
+	Links       int    `json:"links" example:"17"`
+	Attachments int    `json:"attachments" example:"3"`
+	SHA256      string `json:"sha256" example:"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"`
+}
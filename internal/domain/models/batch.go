@@ -0,0 +1,28 @@
+package models
+
+const (
+	BatchCreate = "create"
+	BatchEdit   = "edit"
+	BatchDelete = "delete"
+)
+
+type BatchOperation struct {
+	Op      string `json:"op" example:"create" enums:"create,edit,delete"`
+	Id      int64  `json:"id,omitempty" example:"1"`
+	Header  string `json:"header,omitempty" example:"go for a walk"`
+	Content string `json:"content,omitempty" example:"at 3 pm"`
+}
+
+const (
+	BatchOk         = "ok"
+	BatchFailed     = "failed"
+	BatchRolledBack = "rolled_back"
+	BatchSkipped    = "skipped"
+)
+
+type BatchResult struct {
+	Op     string `json:"op" example:"create"`
+	Status string `json:"status" example:"ok" enums:"ok,failed,rolled_back,skipped"`
+	Id     int64  `json:"id,omitempty" example:"1"`
+	Error  string `json:"error,omitempty" example:"note not found"`
+}
@@ -0,0 +1,33 @@
+package models
+
+// Graph is the graph of notes linking to each other. An edge stands for one
+// or more resolved links from the source note to the target note.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+	Stats GraphStats  `json:"stats"`
+}
+
+type GraphNode struct {
+	Id        int64  `json:"id" example:"1"`
+	Header    string `json:"header" example:"go for a walk"`
+	InDegree  int    `json:"in_degree" example:"2"`
+	OutDegree int    `json:"out_degree" example:"1"`
+}
+
+type GraphEdge struct {
+	Source int64 `json:"source" example:"1"`
+	Target int64 `json:"target" example:"2"`
+	// Links is the number of links the edge stands for.
+	Links int `json:"links" example:"1"`
+}
+
+type GraphStats struct {
+	Nodes         int     `json:"nodes" example:"3"`
+	Edges         int     `json:"edges" example:"2"`
+	MaxInDegree   int     `json:"max_in_degree" example:"2"`
+	MaxOutDegree  int     `json:"max_out_degree" example:"1"`
+	AverageDegree float64 `json:"average_degree" example:"1.33"`
+	// Isolated counts the nodes without edges.
+	Isolated int `json:"isolated" example:"1"`
+}
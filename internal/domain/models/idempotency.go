@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the stored outcome of a request made with an
+// Idempotency-Key header. Status is zero while the request is in progress.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
@@ -0,0 +1,25 @@
+package models
+
+const (
+	ImportCreated = "created"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// ImportReport is the outcome of an import. In a dry run nothing is stored
+// and created files have no id.
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Created int            `json:"created" example:"2"`
+	Skipped int            `json:"skipped" example:"1"`
+	Failed  int            `json:"failed" example:"0"`
+	Files   []ImportResult `json:"files"`
+}
+
+type ImportResult struct {
+	Name   string `json:"name" example:"notes/groceries.md"`
+	Status string `json:"status" example:"created" enums:"created,skipped,failed"`
+	Id     int64  `json:"id,omitempty" example:"1"`
+	Header string `json:"header,omitempty" example:"Groceries"`
+	Error  string `json:"error,omitempty" example:"header: must contain any characters"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "strconv"
+
+// LinkTarget is what a [[wiki link]] points at: a note id for [[#42]], a note
+// title for [[Title]].
+type LinkTarget struct {
+	Id    int64  `json:"id,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// String returns the target the way it is written inside the brackets.
+func (t LinkTarget) String() string {
+	if t.Id != 0 {
+		return "#" + strconv.FormatInt(t.Id, 10)
+	}
+	return t.Title
+}
+
+// Link is a wiki link from one note to another. TargetId is the note the
+// link resolves to, or 0 if the link is broken.
+type Link struct {
+	SourceId int64  `json:"source_id" example:"1"`
+	Target   string `json:"target" example:"Shopping list"`
+	TargetId int64  `json:"target_id" example:"2"`
+}
@@ -1,7 +1,34 @@
 package models
 
+import "time"
+
 type Note struct {
 	Header  string `json:"header" example:"go for a walk"`
 	Content string `json:"content" example:"at 3 pm"`
 	Id      int64  `json:"id" example:"1"`
+	// Type is empty for Markdown notes. The content of an encrypted note is
+	// a client-encrypted blob in base64 that the server never reads, KDF
+	// holds the parameters the client derives its key with.
+	Type string `json:"type,omitempty" enums:"encrypted"`
+	KDF  KDF    `json:"kdf,omitzero"`
+	// CreatedAt and UpdatedAt are kept by the storage. They are zero for
+	// notes stored before timestamps were.
+	CreatedAt time.Time `json:"created_at,omitzero" example:"2025-01-02T15:04:05.000Z"`
+	UpdatedAt time.Time `json:"updated_at,omitzero" example:"2025-01-02T15:04:05.000Z"`
+}
+
+// NoteTypeEncrypted is the type of end-to-end encrypted notes.
+const NoteTypeEncrypted = "encrypted"
+
+// KDF are the key derivation parameters of an encrypted note, stored as the
+// client sent them. Salt is in base64; Memory, in KiB, and Parallelism are
+// for memory-hard functions. NoteId is a random id, in base64, that clients
+// bind the content to.
+type KDF struct {
+	Algorithm   string `json:"algorithm" example:"pbkdf2-sha256"`
+	Salt        string `json:"salt" example:"c2FsdHNhbHRzYWx0c2FsdA=="`
+	Iterations  int    `json:"iterations" example:"600000"`
+	Memory      int    `json:"memory,omitempty"`
+	Parallelism int    `json:"parallelism,omitempty"`
+	NoteId      string `json:"note_id,omitempty" example:"bm90ZWlkbm90ZWlkbm90ZQ=="`
 }
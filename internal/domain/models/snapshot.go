@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// Snapshot is a copy of the database taken while the server runs.
+type Snapshot struct {
+	Name      string    `json:"name" example:"notes-20250102T150405.006Z.db"`
+	CreatedAt time.Time `json:"created_at" example:"2025-01-02T15:04:05.006Z"`
+	Size      int64     `json:"size" example:"32768"`
+}
@@ -0,0 +1,15 @@
+package models
+
+// Usage is what is stored, with the quotas it is held to. Bytes are the
+// UTF-8 bytes of note headers and contents plus the bytes of attachments, as
+// uploaded: attachments sharing their content count once each. Zero quotas
+// don't limit.
+type Usage struct {
+	Notes           int   `json:"notes" example:"120"`
+	NoteBytes       int64 `json:"note_bytes" example:"532110"`
+	Attachments     int   `json:"attachments" example:"14"`
+	AttachmentBytes int64 `json:"attachment_bytes" example:"20871502"`
+	Bytes           int64 `json:"bytes" example:"21403612"`
+	MaxNotes        int   `json:"max_notes,omitempty" example:"10000"`
+	MaxBytes        int64 `json:"max_bytes,omitempty" example:"1073741824"`
+}
@@ -0,0 +1,7 @@
+package models
+
+// FieldError describes what is wrong with one field of a request.
+type FieldError struct {
+	Field   string `json:"field" example:"header"`
+	Message string `json:"message" example:"must contain any characters"`
+}
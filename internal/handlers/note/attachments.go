@@ -0,0 +1,359 @@
+package notehandler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/attachments"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/problem"
+)
+
+// attachmentCSP keeps a downloaded attachment that a browser renders anyway
+// from running anything.
+const attachmentCSP = "default-src 'none'; sandbox"
+
+var errAttachmentsDisabled = &notes.Error{Code: notes.CodeNotFound, Message: "attachments are not configured"}
+
+// UploadAttachment godoc
+//
+//	@Summary		Upload an attachment
+//	@Description	Attaches the file of the "file" form field to a note. Other form fields are ignored.
+//	@Description	The media type of the file part is kept, unless it is missing or application/octet-stream: then it is detected from the content.
+//	@Description	Files with the same content are stored once. Images in JPEG, PNG, GIF or WebP have their dimensions and format set.
+//	@Description	With strip_gps the GPS position is removed from the EXIF and XMP data of JPEG images before they are stored.
+//	@Description	Only available when attachments are configured.
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Param			id			path		int		true	"Note id"
+//	@Param			strip_gps	query		bool	false	"Remove the GPS position of JPEG images"
+//	@Param			file		formData	file	true	"File to attach"
+//	@Success		200			{object}	models.Attachment
+//	@Failure		400			{object}	problem.Problem	"malformed id or upload"
+//	@Failure		404			{object}	problem.Problem	"note not found or attachments are not configured"
+//	@Failure		413			{object}	problem.Problem	"file too large"
+//	@Failure		415			{object}	problem.Problem	"unsupported Content-Type"
+//	@Failure		422			{object}	problem.Problem	"invalid id, strip_gps, no file, invalid file name or a malformed JPEG to strip"
+//	@Failure		500		{object}	problem.Problem	"internal server error"
+//	@Failure		503		{object}	problem.Problem	"query timed out"
+//	@Failure		507		{object}	problem.Problem	"quota exceeded"
+//	@Router			/notes/{id}/attachments [post]
+func (h Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.UploadAttachment"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.opts.Attachments == nil {
+		h.fail(w, r, "Failed to upload attachment", errAttachmentsDisabled)
+		return
+	}
+	noteId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.fail(w, r, "Failed to parse note id", malformed(err))
+		return
+	}
+	var opts attachments.UploadOptions
+	if s := r.URL.Query().Get("strip_gps"); s != "" {
+		if opts.StripGPS, err = strconv.ParseBool(s); err != nil {
+			h.fail(w, r, "Failed to upload attachment", notes.Invalid("strip_gps", "must be a boolean"))
+			return
+		}
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "multipart/form-data" {
+		problem.Write(w, r, problem.New(http.StatusUnsupportedMediaType, string(codeUnsupportedMediaType),
+			"Failed to upload attachment: unsupported Content-Type "+strconv.Quote(mediaType)+", want multipart/form-data"))
+		h.log.Debug("Failed to upload attachment", slog.String("content_type", mediaType))
+		return
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		h.fail(w, r, "Failed to read upload", malformed(err))
+		return
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			h.fail(w, r, "Failed to upload attachment", notes.Invalid("file", "is required"))
+			return
+		}
+		if err != nil {
+			h.fail(w, r, "Failed to read upload", malformed(err))
+			return
+		}
+		if part.FormName() != "file" || part.FileName() == "" {
+			continue
+		}
+
+		attachment, err := h.opts.Attachments.Upload(r.Context(), noteId, part.FileName(), part.Header.Get("Content-Type"), uploadReader{part}, opts)
+		if err != nil {
+			h.fail(w, r, "Failed to upload attachment", err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(attachment)
+		return
+	}
+}
+
+// uploadReader reports failures to read an upload as malformed requests:
+// the upload is cut short or isn't valid multipart.
+type uploadReader struct {
+	r io.Reader
+}
+
+func (u uploadReader) Read(p []byte) (int, error) {
+	n, err := u.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return n, malformed(err)
+	}
+	return n, err
+}
+
+// ListAttachments godoc
+//
+//	@Summary		List attachments
+//	@Description	Returns the attachments of a note ordered by id. Only available when attachments are configured.
+//	@Produce		json
+//	@Param			id	path		int	true	"Note id"
+//	@Success		200	{object}	[]models.Attachment
+//	@Failure		400	{object}	problem.Problem	"malformed id"
+//	@Failure		404	{object}	problem.Problem	"note not found or attachments are not configured"
+//	@Failure		422	{object}	problem.Problem	"invalid id"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Router			/notes/{id}/attachments [get]
+func (h Handler) ListAttachments(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.ListAttachments"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.opts.Attachments == nil {
+		h.fail(w, r, "Failed to list attachments", errAttachmentsDisabled)
+		return
+	}
+	noteId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.fail(w, r, "Failed to parse note id", malformed(err))
+		return
+	}
+
+	attachments, err := h.opts.Attachments.List(r.Context(), noteId)
+	if err != nil {
+		h.fail(w, r, "Failed to list attachments", err)
+		return
+	}
+	if attachments == nil {
+		attachments = []models.Attachment{}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(attachments)
+}
+
+// DownloadAttachment godoc
+//
+//	@Summary		Download an attachment
+//	@Description	Returns the content of an attachment with its media type, as a download named after the file.
+//	@Description	Supports Range requests for parts of the content and conditional requests by ETag, which is the SHA-256 of the content.
+//	@Description	Only available when attachments are configured.
+//	@Produce		octet-stream
+//	@Param			id				path		int		true	"Note id"
+//	@Param			attachmentId	path		int		true	"Attachment id"
+//	@Param			Range			header		string	false	"Byte ranges to return"
+//	@Success		200				{file}		file
+//	@Success		206				{file}		file
+//	@Failure		400				{object}	problem.Problem	"malformed id"
+//	@Failure		404				{object}	problem.Problem	"attachment not found or attachments are not configured"
+//	@Failure		416				{string}	string			"unsatisfiable range"
+//	@Failure		422				{object}	problem.Problem	"invalid id"
+//	@Failure		500				{object}	problem.Problem	"internal server error"
+//	@Failure		503				{object}	problem.Problem	"query timed out"
+//	@Router			/notes/{id}/attachments/{attachmentId} [get]
+func (h Handler) DownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.DownloadAttachment"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.opts.Attachments == nil {
+		h.fail(w, r, "Failed to download attachment", errAttachmentsDisabled)
+		return
+	}
+	noteId, id, err := attachmentIds(r)
+	if err != nil {
+		h.fail(w, r, "Failed to parse id", err)
+		return
+	}
+
+	attachment, content, err := h.opts.Attachments.Open(r.Context(), noteId, id)
+	if err != nil {
+		h.fail(w, r, "Failed to download attachment", err)
+		return
+	}
+	defer content.Close()
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Type", attachment.MediaType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("ETag", `"`+attachment.SHA256+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", attachmentCSP)
+	liftWriteDeadline(w)
+	http.ServeContent(w, r, attachment.Name, attachment.CreatedAt, content)
+}
+
+// AttachmentThumbnail godoc
+//
+//	@Summary		Get a thumbnail of an image attachment
+//	@Description	Returns the image of an attachment scaled down to fit a square of the size, turned as its EXIF orientation says.
+//	@Description	Thumbnails of JPEG images are JPEG, of PNG, GIF and WebP images PNG. A thumbnail is made on first request and kept.
+//	@Description	Only available when attachments are configured.
+//	@Produce		jpeg,png
+//	@Param			id				path		int		true	"Note id"
+//	@Param			attachmentId	path		int		true	"Attachment id"
+//	@Param			size			query		int		false	"Bound of both dimensions"	Enums(64, 256, 1024)	default(256)
+//	@Success		200				{file}		file
+//	@Failure		400				{object}	problem.Problem	"malformed id or size"
+//	@Failure		404				{object}	problem.Problem	"attachment not found, not an image or attachments are not configured"
+//	@Failure		422				{object}	problem.Problem	"invalid id or size"
+//	@Failure		500				{object}	problem.Problem	"internal server error"
+//	@Failure		503				{object}	problem.Problem	"query timed out"
+//	@Router			/notes/{id}/attachments/{attachmentId}/thumbnail [get]
+func (h Handler) AttachmentThumbnail(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.AttachmentThumbnail"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.opts.Attachments == nil {
+		h.fail(w, r, "Failed to get thumbnail", errAttachmentsDisabled)
+		return
+	}
+	noteId, id, err := attachmentIds(r)
+	if err != nil {
+		h.fail(w, r, "Failed to parse id", err)
+		return
+	}
+	size := attachments.DefaultThumbnailSize
+	if s := r.URL.Query().Get("size"); s != "" {
+		if size, err = strconv.Atoi(s); err != nil {
+			h.fail(w, r, "Failed to parse size", malformed(err))
+			return
+		}
+	}
+
+	attachment, mediaType, content, err := h.opts.Attachments.Thumbnail(r.Context(), noteId, id, size)
+	if err != nil {
+		h.fail(w, r, "Failed to get thumbnail", err)
+		return
+	}
+	defer content.Close()
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("ETag", `"`+attachment.SHA256+"-"+strconv.Itoa(size)+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", attachmentCSP)
+	http.ServeContent(w, r, "", attachment.CreatedAt, content)
+}
+
+// DeleteAttachment godoc
+//
+//	@Summary		Delete an attachment
+//	@Description	Removes an attachment from a note, and its content unless another attachment has the same content.
+//	@Description	Only available when attachments are configured.
+//	@Produce		json
+//	@Param			id				path	int	true	"Note id"
+//	@Param			attachmentId	path	int	true	"Attachment id"
+//	@Success		200
+//	@Failure		400	{object}	problem.Problem	"malformed id"
+//	@Failure		404	{object}	problem.Problem	"attachment not found or attachments are not configured"
+//	@Failure		422	{object}	problem.Problem	"invalid id"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Router			/notes/{id}/attachments/{attachmentId} [delete]
+func (h Handler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.DeleteAttachment"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.opts.Attachments == nil {
+		h.fail(w, r, "Failed to delete attachment", errAttachmentsDisabled)
+		return
+	}
+	noteId, id, err := attachmentIds(r)
+	if err != nil {
+		h.fail(w, r, "Failed to parse id", err)
+		return
+	}
+
+	if err := h.opts.Attachments.Delete(r.Context(), noteId, id); err != nil {
+		h.fail(w, r, "Failed to delete attachment", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// SweepAttachments godoc
+//
+//	@Summary		Sweep unreferenced attachment content
+//	@Description	Removes stored content that no attachment refers to anymore, as deleting notes leaves it behind, and its thumbnails. This also runs on a schedule.
+//	@Description	The space thumbnails took is counted as freed.
+//	@Description	Only available when attachments are configured.
+//	@Tags			admin
+//	@Security		AdminToken
+//	@Produce		json
+//	@Success		200	{object}	models.SweepReport
+//	@Failure		401	{object}	problem.Problem	"missing or wrong admin token"
+//	@Failure		404	{object}	problem.Problem	"attachments are not configured"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Router			/admin/attachments/sweep [post]
+func (h Handler) SweepAttachments(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.SweepAttachments"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.opts.Attachments == nil {
+		h.fail(w, r, "Failed to sweep attachments", errAttachmentsDisabled)
+		return
+	}
+
+	report, err := h.opts.Attachments.Sweep(r.Context())
+	if err != nil {
+		h.fail(w, r, "Failed to sweep attachments", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// attachmentIds parses the note and attachment ids of the path.
+func attachmentIds(r *http.Request) (noteId int64, id int64, err error) {
+	if noteId, err = strconv.ParseInt(r.PathValue("id"), 10, 64); err != nil {
+		return 0, 0, malformed(err)
+	}
+	if id, err = strconv.ParseInt(r.PathValue("attachmentId"), 10, 64); err != nil {
+		return 0, 0, malformed(err)
+	}
+	return noteId, id, nil
+}
@@ -0,0 +1,96 @@
+package notehandler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
+)
+
+// Backup godoc
+//
+//	@Summary		Back up the database
+//	@Description	Streams every note, link and attachment as JSON Lines: a header with the format version, one record per line and a trailer with the number of records and their SHA-256.
+//	@Description	Attachments are backed up as metadata: their content is the blob named by their sha256 in the attachment directory, which is copied with the files.
+//	@Description	The backup is read from one consistent view of the database, writes go on meanwhile. An error after the first line aborts the response and leaves the backup without a trailer.
+//	@Tags			admin
+//	@Security		AdminToken
+//	@Produce		application/x-ndjson
+//	@Success		200	{file}		file
+//	@Failure		401	{object}	problem.Problem	"missing or wrong admin token"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Router			/admin/backup [get]
+func (h Handler) Backup(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Backup"
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	liftWriteDeadline(w)
+	out := &lazyWriter{start: func() {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="notes-backup.jsonl"`)
+		w.WriteHeader(http.StatusOK)
+	}, w: w}
+
+	if _, err := h.notes.Backup(r.Context(), out); err != nil {
+		if !out.started {
+			h.fail(w, r, "Failed to back up notes", err)
+			return
+		}
+		log.Log(r.Context(), streamLevel(r, err), "Failed to back up notes", sl.Err(err))
+	}
+}
+
+// Restore godoc
+//
+//	@Summary		Restore a backup
+//	@Description	Restores a backup made by GET /admin/backup into an empty database, in one transaction. Notes and attachments keep their ids and timestamps; the blobs of the attachments are expected in the attachment directory.
+//	@Description	The restored data is read back and its checksum compared with the trailer of the backup; nothing is stored if they differ.
+//	@Tags			admin
+//	@Security		AdminToken
+//	@Accept			application/x-ndjson
+//	@Produce		json
+//	@Param			backup	body		string	true	"Backup"
+//	@Success		200		{object}	models.RestoreReport
+//	@Failure		401		{object}	problem.Problem	"missing or wrong admin token"
+//	@Failure		409		{object}	problem.Problem	"database is not empty"
+//	@Failure		413		{object}	problem.Problem	"request body too large"
+//	@Failure		422		{object}	problem.Problem	"invalid backup"
+//	@Failure		500		{object}	problem.Problem	"internal server error"
+//	@Failure		503		{object}	problem.Problem	"query timed out"
+//	@Router			/admin/restore [post]
+func (h Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Restore"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	report, err := h.notes.Restore(r.Context(), r.Body)
+	if err != nil {
+		h.fail(w, r, "Failed to restore backup", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// lazyWriter sends the response headers on the first write, so that errors
+// before anything is written can still be reported with a status.
+type lazyWriter struct {
+	start   func()
+	w       http.ResponseWriter
+	started bool
+}
+
+func (l *lazyWriter) Write(p []byte) (int, error) {
+	if !l.started {
+		l.start()
+		l.started = true
+	}
+	return l.w.Write(p)
+}
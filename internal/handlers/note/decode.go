@@ -0,0 +1,138 @@
+package notehandler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+)
+
+// decode reads the JSON body of r into v. Fields v doesn't have and values of
+// the wrong type are reported as validation errors of those fields, anything
+// that isn't a single JSON value as a malformed request.
+func decode(r *http.Request, v any) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if err := dec.Decode(v); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return notes.Invalid(typeErr.Field, "must be "+jsonType(typeErr.Type))
+		}
+		return malformed(err)
+	}
+	if dec.More() {
+		return malformed(errors.New("unexpected data after the JSON value"))
+	}
+
+	invalid := &notes.ValidationError{}
+	unknownFields(body, reflect.TypeOf(v), "", invalid)
+	return invalid.Err()
+}
+
+// unknownFields records the fields of the JSON value raw that t, which it
+// was decoded into, doesn't have, in objects nested at any depth. Keys must
+// match the names of fields exactly, as in PATCH /notes/{id}.
+func unknownFields(raw json.RawMessage, t reflect.Type, path string, invalid *notes.ValidationError) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return
+		}
+		known := jsonFields(t)
+		keys := slices.Sorted(maps.Keys(fields))
+		for _, key := range keys {
+			field, ok := known[key]
+			if !ok {
+				invalid.Add(path+key, "unknown field")
+				continue
+			}
+			unknownFields(fields[key], field, path+key+".", invalid)
+		}
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return
+		}
+		prefix := strings.TrimSuffix(path, ".")
+		for i, item := range items {
+			unknownFields(item, t.Elem(), fmt.Sprintf("%s[%d].", prefix, i), invalid)
+		}
+	}
+}
+
+// jsonFields maps the JSON names of the fields of the struct t to their
+// types, with the fields of embedded structs promoted.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		name, _, _ := strings.Cut(tag, ",")
+		switch {
+		case name == "-" && tag == "-":
+			continue
+		case f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct:
+			maps.Copy(fields, jsonFields(f.Type))
+			continue
+		case !f.IsExported():
+			continue
+		case name == "":
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// readBody reads the body of r, which must be valid UTF-8: the JSON decoder
+// would silently replace invalid bytes.
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, malformed(err)
+	}
+	if !utf8.Valid(body) {
+		return nil, notes.Invalid("body", "must be valid UTF-8")
+	}
+
+	return body, nil
+}
+
+// jsonType names the JSON type a Go type is decoded from.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Pointer:
+		return jsonType(t.Elem())
+	default:
+		return "an object"
+	}
+}
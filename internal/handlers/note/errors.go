@@ -0,0 +1,164 @@
+package notehandler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/jsonpatch"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/problem"
+)
+
+// StatusClientClosedRequest is the nginx convention for a request the client
+// gave up on before the response was ready.
+const StatusClientClosedRequest = 499
+
+// Codes of the problems that are specific to HTTP rather than to notes.
+const (
+	codeInternal             notes.Code = "internal"
+	codeMalformedRequest     notes.Code = "malformed_request"
+	codeUnsupportedMediaType notes.Code = "unsupported_media_type"
+	codeInvalidPatch         notes.Code = "invalid_patch"
+	codePatchTestFailed      notes.Code = "patch_test_failed"
+	codePatchPathNotFound    notes.Code = "patch_path_not_found"
+)
+
+// statuses maps every code to the status it is reported with.
+var statuses = map[notes.Code]int{
+	notes.CodeNotFound:        http.StatusNotFound,
+	notes.CodeConflict:        http.StatusConflict,
+	notes.CodeValidation:      http.StatusUnprocessableEntity,
+	notes.CodeNothingToChange: http.StatusBadRequest,
+	notes.CodeTooLarge:        http.StatusRequestEntityTooLarge,
+	notes.CodeCanceled:        StatusClientClosedRequest,
+	notes.CodeTimeout:         http.StatusServiceUnavailable,
+	notes.CodeQuotaExceeded:   http.StatusInsufficientStorage,
+	codeInternal:              http.StatusInternalServerError,
+	codeMalformedRequest:      http.StatusBadRequest,
+	codeUnsupportedMediaType:  http.StatusUnsupportedMediaType,
+	codeInvalidPatch:          http.StatusBadRequest,
+	codePatchTestFailed:       http.StatusConflict,
+	codePatchPathNotFound:     http.StatusUnprocessableEntity,
+}
+
+// requestError is a failure caused by the request itself rather than by
+// what it asks for, e.g. a body that can't be decoded.
+type requestError struct {
+	code notes.Code
+	err  error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+func malformed(err error) error {
+	return &requestError{code: codeMalformedRequest, err: err}
+}
+
+// codeOf tells what kind of failure err is.
+func codeOf(err error) notes.Code {
+	var reqErr *requestError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return notes.CodeTooLarge
+	case errors.As(err, &reqErr):
+		return reqErr.code
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return codePatchTestFailed
+	case errors.Is(err, jsonpatch.ErrPathNotFound):
+		return codePatchPathNotFound
+	case errors.Is(err, jsonpatch.ErrInvalidPatch), errors.Is(err, jsonpatch.ErrInvalidPointer):
+		return codeInvalidPatch
+	}
+
+	if code := notes.CodeOf(err); code != "" {
+		return code
+	}
+	return codeInternal
+}
+
+// serverFault tells whether errors with the code are the server's to fix.
+// An exceeded quota is reported with 507 like running out of space, but it
+// is the client's to fix by deleting what it stored.
+func serverFault(code notes.Code) bool {
+	return statuses[code] >= http.StatusInternalServerError && code != notes.CodeQuotaExceeded
+}
+
+// problemFor describes err as a problem. The details of server errors are
+// logged but not sent to the client.
+func problemFor(msg string, err error) *problem.Problem {
+	code := codeOf(err)
+	status := statuses[code]
+
+	if serverFault(code) {
+		return problem.New(status, string(code), msg)
+	}
+
+	p := problem.New(status, string(code), msg+": "+err.Error())
+
+	var validationErr *notes.ValidationError
+	if errors.As(err, &validationErr) {
+		p.Errors = validationErr.Fields
+
+		var batchErr *notes.BatchError
+		if errors.As(err, &batchErr) {
+			p.Errors = make([]models.FieldError, len(validationErr.Fields))
+			for i, f := range validationErr.Fields {
+				p.Errors[i] = models.FieldError{
+					Field:   fmt.Sprintf("operations[%d].%s", batchErr.Index, f.Field),
+					Message: f.Message,
+				}
+			}
+		}
+	}
+
+	return p
+}
+
+// logLevel is the level failures with the code are logged at: server errors
+// at error, requests the client gave up on at info, as they are worth seeing
+// but not worth paging anyone, and other client errors at debug.
+func logLevel(code notes.Code) slog.Level {
+	switch {
+	case serverFault(code):
+		return slog.LevelError
+	case code == notes.CodeCanceled:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
+
+// streamLevel is the level a failure is logged at once the response has
+// started, when no status can tell it anymore. The client going away shows
+// in the context rather than in err, which is then a failed write.
+func streamLevel(r *http.Request, err error) slog.Level {
+	if r.Context().Err() != nil {
+		return logLevel(notes.CodeCanceled)
+	}
+	return logLevel(codeOf(err))
+}
+
+// fail responds with the problem err maps to and logs it at the level of its
+// code.
+func (h Handler) fail(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	p := problemFor(msg, err)
+	h.write(w, r, p, msg, err)
+}
+
+func (h Handler) write(w http.ResponseWriter, r *http.Request, p *problem.Problem, msg string, err error) {
+	problem.Write(w, r, p)
+
+	h.log.Log(r.Context(), logLevel(notes.Code(p.Code)), msg,
+		sl.Err(err), slog.String("code", p.Code), slog.String("request_id", p.RequestId))
+}
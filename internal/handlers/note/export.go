@@ -0,0 +1,184 @@
+package notehandler
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/attachments"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/frontmatter"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
+)
+
+// maxSlugLength bounds the part of an exported file name taken from the
+// note header.
+const maxSlugLength = 48
+
+// ExportNotes godoc
+//
+//	@Summary		Export notes
+//	@Description	Streams a zip archive with one Markdown file per note, named <id>-<header>.md.
+//	@Description	Every file starts with a YAML front matter block holding the id, header and timestamps of the note.
+//	@Description	When attachments are configured, the attachments of a note follow it in a folder named like its file without .md, as <attachment id>-<name>.
+//	@Description	End-to-end encrypted notes aren't Markdown and are left out, backups keep them.
+//	@Description	The archive is written while the notes are read: an error after the first note aborts the response and leaves the archive truncated.
+//	@Produce		application/zip
+//	@Param			format	query		string	false	"Export format"	Enums(markdown)
+//	@Success		200		{file}		file
+//	@Failure		422		{object}	problem.Problem	"unsupported format"
+//	@Failure		500		{object}	problem.Problem	"internal server error"
+//	@Router			/export [get]
+func (h Handler) Export(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Export"
+	log := h.log.With(
+		slog.String("op", op),
+	)
+
+	if format := r.URL.Query().Get("format"); format != "" && format != "markdown" {
+		h.fail(w, r, "Failed to export notes", notes.Invalid("format", "must be markdown"))
+		return
+	}
+	liftWriteDeadline(w)
+
+	var archive *zip.Writer
+	start := func() {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="notes.zip"`)
+		w.WriteHeader(http.StatusOK)
+		archive = zip.NewWriter(w)
+	}
+
+	err := h.notes.Export(r.Context(), func(note models.Note) error {
+		if note.Type == models.NoteTypeEncrypted {
+			return nil
+		}
+		if archive == nil {
+			start()
+		}
+		if err := writeNote(archive, note); err != nil {
+			return err
+		}
+		return h.writeAttachments(r.Context(), archive, note)
+	})
+	if err != nil {
+		if archive == nil {
+			h.fail(w, r, "Failed to export notes", err)
+			return
+		}
+		// The status is sent already, all that is left is to not finish the
+		// archive so the client can tell it is broken.
+		log.Log(r.Context(), streamLevel(r, err), "Failed to export notes", sl.Err(err))
+		return
+	}
+
+	if archive == nil {
+		start()
+	}
+	if err := archive.Close(); err != nil {
+		log.Log(r.Context(), streamLevel(r, err), "Failed to export notes", sl.Err(err))
+	}
+}
+
+func writeNote(archive *zip.Writer, note models.Note) error {
+	doc, err := frontmatter.Format(frontmatter.Meta{
+		Id:        note.Id,
+		Header:    note.Header,
+		CreatedAt: note.CreatedAt,
+		UpdatedAt: note.UpdatedAt,
+	}, note.Content)
+	if err != nil {
+		return err
+	}
+
+	header := &zip.FileHeader{Name: exportName(note), Method: zip.Deflate, Modified: note.UpdatedAt}
+	f, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(doc)
+	return err
+}
+
+// writeAttachments adds the attachments of note to the archive, in the
+// folder named after the file of the note.
+func (h Handler) writeAttachments(ctx context.Context, archive *zip.Writer, note models.Note) error {
+	if h.opts.Attachments == nil {
+		return nil
+	}
+	list, err := h.opts.Attachments.List(ctx, note.Id)
+	if errors.Is(err, notes.ErrNoteNotFound) {
+		// Deleted since the export started.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dir := strings.TrimSuffix(exportName(note), ".md")
+	for _, attachment := range list {
+		if err := h.writeAttachment(ctx, archive, dir, attachment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h Handler) writeAttachment(ctx context.Context, archive *zip.Writer, dir string, attachment models.Attachment) error {
+	_, content, err := h.opts.Attachments.Open(ctx, attachment.NoteId, attachment.Id)
+	if errors.Is(err, attachments.ErrAttachmentNotFound) {
+		// Deleted since it was listed.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	// Names may hold slashes, which would make folders of their own.
+	name := strings.NewReplacer("/", "_", `\`, "_").Replace(attachment.Name)
+	header := &zip.FileHeader{
+		Name:     fmt.Sprintf("%s/%d-%s", dir, attachment.Id, name),
+		Method:   zip.Deflate,
+		Modified: attachment.CreatedAt,
+	}
+	f, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, content)
+	return err
+}
+
+// exportName names the file of a note after its id, which keeps names
+// unique, and its header, which makes them readable.
+func exportName(note models.Note) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(note.Header) {
+		if slug.Len() >= maxSlugLength {
+			break
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	if slug.Len() == 0 {
+		return fmt.Sprintf("%d.md", note.Id)
+	}
+	return fmt.Sprintf("%d-%s.md", note.Id, slug.String())
+}
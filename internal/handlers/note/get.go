@@ -0,0 +1,173 @@
+package notehandler
+
+import (
+	"encoding/json"
+	"html/template"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/markdown"
+)
+
+// Representations of a note, by the format query parameter.
+var formats = map[string]string{
+	"json":     "application/json",
+	"html":     "text/html",
+	"markdown": "text/markdown",
+}
+
+// offers are the media types a note can be negotiated as, preferred first.
+var offers = []string{"application/json", "text/html", "text/markdown"}
+
+// noteCSP keeps rendered notes from loading anything but inline styles and
+// images, in case something slips through the sanitizer.
+const noteCSP = "default-src 'none'; style-src 'unsafe-inline'; img-src https: data:"
+
+var noteTemplate = template.Must(template.New("note").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Header}}</title>
+<style>{{.CSS}}</style>
+</head>
+<body>
+<article>
+<h1>{{.Header}}</h1>
+{{.Content}}
+</article>
+</body>
+</html>
+`))
+
+// GetNote godoc
+//
+//	@Summary		Get note
+//	@Description	Returns a note as JSON, as an HTML page with the content rendered from Markdown, or as its raw Markdown content.
+//	@Description	The format query parameter wins over the Accept header.
+//	@Description	End-to-end encrypted notes are only returned as JSON, their content being opaque to the server: the Accept header is ignored for them and other formats are refused.
+//	@Description	Rendering supports CommonMark with GFM tables, task lists, strikethrough and autolinks, footnotes and highlighted code blocks; the HTML is sanitized.
+//	@Produce		json
+//	@Produce		html
+//	@Produce		text/markdown
+//	@Param			id		path		int		true	"Note id"
+//	@Param			format	query		string	false	"Representation"	Enums(json, html, markdown)
+//	@Success		200		{object}	models.Note
+//	@Failure		400		{object}	problem.Problem	"malformed id"
+//	@Failure		404		{object}	problem.Problem	"note not found"
+//	@Failure		422		{object}	problem.Problem	"invalid id or format"
+//	@Failure		500		{object}	problem.Problem	"internal server error"
+//	@Failure		503		{object}	problem.Problem	"query timed out"
+//	@Router			/notes/{id} [get]
+func (h Handler) Get(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Get"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.fail(w, r, "Failed to parse note id", malformed(err))
+		return
+	}
+
+	mediaType := negotiate(r.Header.Get("Accept"), offers)
+	format := r.URL.Query().Get("format")
+	if format != "" {
+		var ok bool
+		if mediaType, ok = formats[format]; !ok {
+			h.fail(w, r, "Failed to get note", notes.Invalid("format", "must be one of json, html, markdown"))
+			return
+		}
+	}
+
+	note, err := h.notes.GetById(r.Context(), id)
+	if err != nil {
+		h.fail(w, r, "Failed to get note", err)
+		return
+	}
+	if note.Type == models.NoteTypeEncrypted && mediaType != "application/json" {
+		if format != "" {
+			h.fail(w, r, "Failed to get note", notes.Invalid("format", "must be json for an encrypted note"))
+			return
+		}
+		mediaType = "application/json"
+	}
+
+	w.Header().Set("Vary", "Accept")
+	switch mediaType {
+	case "text/html":
+		content, err := markdown.ToHTML(note.Content)
+		if err != nil {
+			h.fail(w, r, "Failed to render note", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", noteCSP)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		noteTemplate.Execute(w, struct {
+			Header  string
+			CSS     template.CSS
+			Content template.HTML
+		}{note.Header, template.CSS(markdown.CSS()), template.HTML(content)})
+	case "text/markdown":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(note.Content))
+	default:
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(note)
+	}
+}
+
+// negotiate picks the offer the Accept header prefers. Each offer gets the
+// quality of the most specific media range matching it; ties go to the
+// earlier offer. Without an acceptable offer the first one is used anyway.
+func negotiate(accept string, offers []string) string {
+	if accept == "" {
+		return offers[0]
+	}
+
+	best, bestQuality := offers[0], 0.0
+	for _, offer := range offers {
+		quality, specificity := 0.0, -1
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil {
+				continue
+			}
+
+			s := -1
+			switch {
+			case mediaType == offer:
+				s = 2
+			case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(mediaType, "*")):
+				s = 1
+			case mediaType == "*/*":
+				s = 0
+			}
+			if s <= specificity {
+				continue
+			}
+
+			specificity, quality = s, 1
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil {
+				quality = q
+			}
+		}
+
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+
+	return best
+}
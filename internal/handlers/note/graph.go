@@ -0,0 +1,87 @@
+package notehandler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/graphexport"
+)
+
+// GetGraph godoc
+//
+//	@Summary		Export the link graph
+//	@Description	Returns the graph of notes and the resolved [[wiki links]] between them as JSON with degree stats, GraphViz DOT or GraphML.
+//	@Description	Broken links are left out and several links between the same notes make one edge.
+//	@Description	With a note, only the notes at most depth links away from it are returned, following links in either direction.
+//	@Produce		json
+//	@Produce		text/vnd.graphviz
+//	@Produce		application/graphml+xml
+//	@Param			format	query		string	false	"Export format"						Enums(json, dot, graphml)
+//	@Param			note	query		int		false	"Id of the note to start from"
+//	@Param			depth	query		int		false	"Links to follow from the note"	minimum(1)	maximum(5)	default(1)
+//	@Success		200		{object}	models.Graph
+//	@Failure		404		{object}	problem.Problem	"note not found"
+//	@Failure		422		{object}	problem.Problem	"invalid format, note or depth"
+//	@Failure		500		{object}	problem.Problem	"internal server error"
+//	@Failure		503		{object}	problem.Problem	"query timed out"
+//	@Router			/graph [get]
+func (h Handler) Graph(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Graph"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	query := r.URL.Query()
+	invalid := &notes.ValidationError{}
+
+	format := query.Get("format")
+	switch format {
+	case "", "json", "dot", "graphml":
+	default:
+		invalid.Add("format", "must be one of json, dot, graphml")
+	}
+
+	var q notes.GraphQuery
+	if s := query.Get("note"); s != "" {
+		var err error
+		if q.Root, err = strconv.ParseInt(s, 10, 64); err != nil {
+			invalid.Add("note", "must be an integer")
+		}
+		q.Depth = 1
+	}
+	if s := query.Get("depth"); s != "" {
+		var err error
+		if q.Depth, err = strconv.Atoi(s); err != nil {
+			invalid.Add("depth", "must be an integer")
+		}
+	}
+
+	if err := invalid.Err(); err != nil {
+		h.fail(w, r, "Failed to export graph", err)
+		return
+	}
+
+	graph, err := h.notes.Graph(r.Context(), q)
+	if err != nil {
+		h.fail(w, r, "Failed to export graph", err)
+		return
+	}
+
+	switch format {
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		graphexport.DOT(w, graph)
+	case "graphml":
+		w.Header().Set("Content-Type", "application/graphml+xml; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		graphexport.GraphML(w, graph)
+	default:
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(graph)
+	}
+}
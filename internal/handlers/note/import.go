@@ -0,0 +1,266 @@
+package notehandler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/attachments"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/problem"
+)
+
+// ImportNotes godoc
+//
+//	@Summary		Import notes
+//	@Description	Creates a note from every Markdown file (.md, .markdown) of a zip archive or of a multipart upload of files, e.g. a folder. Zip files in a multipart upload are unpacked.
+//	@Description	The header comes from the header or title of the YAML front matter, or else from the first heading; created_at and updated_at in the front matter are kept.
+//	@Description	Other files are skipped. Files that don't make a valid note fail without stopping the import; all notes are created in a single transaction.
+//	@Description	An Evernote export (.enex) is imported as well: its notes are converted to Markdown and reported as "note 1", "note 2" and so on. Created and updated dates are kept, tags are appended to the content as #hashtags and attachments are replaced by their file name.
+//	@Description	When attachments are configured, the attachments of an Evernote note are stored as attachments of the note it makes; one that can't be stored is reported in the error of its note, which is still created.
+//	@Description	With dry_run nothing is stored, the report shows what the import would do.
+//	@Accept			application/zip
+//	@Accept			multipart/form-data
+//	@Accept			application/enex+xml
+//	@Produce		json
+//	@Param			dry_run	query		bool	false	"Report without storing anything"
+//	@Param			archive	body		string	false	"Zip archive of Markdown files or Evernote export"
+//	@Success		200		{object}	models.ImportReport
+//	@Failure		400		{object}	problem.Problem	"malformed archive or upload"
+//	@Failure		413		{object}	problem.Problem	"request body too large"
+//	@Failure		415		{object}	problem.Problem	"unsupported Content-Type"
+//	@Failure		422		{object}	problem.Problem	"invalid dry_run, invalid Evernote export or no files"
+//	@Failure		500		{object}	problem.Problem	"internal server error"
+//	@Failure		503		{object}	problem.Problem	"query timed out"
+//	@Failure		507		{object}	problem.Problem	"quota exceeded"
+//	@Router			/import [post]
+func (h Handler) Import(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Import"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	dryRun := false
+	if s := r.URL.Query().Get("dry_run"); s != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(s); err != nil {
+			h.fail(w, r, "Failed to import notes", notes.Invalid("dry_run", "must be a boolean"))
+			return
+		}
+	}
+
+	var files []notes.ImportFile
+	var err error
+	var temps tempFiles
+	defer temps.remove()
+	enex := false
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/zip", "application/x-zip-compressed":
+		files, err = h.readArchive(r.Body, "", &temps)
+	case "multipart/form-data":
+		files, err = h.readUpload(r, &temps)
+	case "application/enex+xml", "application/xml", "text/xml":
+		// Evernote exports are read a note at a time by the import.
+		enex = true
+	default:
+		problem.Write(w, r, problem.New(http.StatusUnsupportedMediaType, string(codeUnsupportedMediaType),
+			"Failed to import notes: unsupported Content-Type "+strconv.Quote(mediaType)+", want application/zip, multipart/form-data or application/enex+xml"))
+		h.log.Debug("Failed to import notes", slog.String("content_type", mediaType))
+		return
+	}
+	if err != nil {
+		h.fail(w, r, "Failed to read import", err)
+		return
+	}
+
+	var report models.ImportReport
+	if enex {
+		report, err = h.notes.ImportENEX(r.Context(), r.Body, dryRun, h.attach())
+	} else {
+		report, err = h.notes.Import(r.Context(), files, dryRun)
+	}
+	if err != nil {
+		h.fail(w, r, "Failed to import notes", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(report)
+}
+
+// attach uploads the attachments of imported notes, or is nil if attachments
+// are not configured.
+func (h Handler) attach() notes.AttachFunc {
+	if h.opts.Attachments == nil {
+		return nil
+	}
+	return func(ctx context.Context, noteId int64, attachment notes.ImportAttachment) error {
+		_, err := h.opts.Attachments.Upload(ctx, noteId, attachment.Name, attachment.MediaType,
+			bytes.NewReader(attachment.Data), attachments.UploadOptions{})
+		return err
+	}
+}
+
+// readArchive lists the files of the zip archive in r, which is spooled to a
+// temporary file in temps. Their names are prefixed with dir.
+func (h Handler) readArchive(r io.Reader, dir string, temps *tempFiles) ([]notes.ImportFile, error) {
+	f, size, err := temps.spool(r)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(f, size)
+	if err != nil {
+		return nil, malformed(fmt.Errorf("not a zip archive: %w", err))
+	}
+
+	var files []notes.ImportFile
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, notes.ImportFile{
+			Name: path.Join(dir, f.Name),
+			Open: func() (io.ReadCloser, error) {
+				rc, err := f.Open()
+				if err != nil {
+					return nil, err
+				}
+				return h.limitFile(rc), nil
+			},
+		})
+	}
+
+	return files, nil
+}
+
+// readUpload lists the files of a multipart upload, unpacking zip archives.
+// The names keep the relative paths browsers send for uploaded folders.
+func (h Handler) readUpload(r *http.Request, temps *tempFiles) ([]notes.ImportFile, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, malformed(err)
+	}
+
+	var files []notes.ImportFile
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, malformed(err)
+		}
+
+		_, params, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if params["filename"] == "" {
+			// Not a file but a form field.
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(params["filename"], `\`, "/")), "/")
+
+		if strings.EqualFold(path.Ext(name), ".zip") {
+			archived, err := h.readArchive(part, strings.TrimSuffix(name, path.Ext(name)), temps)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, archived...)
+			continue
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			return nil, malformed(err)
+		}
+		files = append(files, notes.ImportFile{
+			Name: name,
+			Open: func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil },
+		})
+	}
+}
+
+// tempFiles are the temporary files an import is spooled to.
+type tempFiles []*os.File
+
+// spool copies r to a new temporary file and returns it with its size.
+// Failing to read r makes the request malformed.
+func (t *tempFiles) spool(r io.Reader) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "notion-import-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	*t = append(*t, f)
+
+	src := &sourceReader{r: r}
+	size, err := io.Copy(f, src)
+	switch {
+	case src.err != nil:
+		return nil, 0, malformed(src.err)
+	case err != nil:
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+// sourceReader keeps the error of r, to tell it from errors of writing what
+// was read.
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.err = err
+	}
+	return n, err
+}
+
+// remove closes and removes the files.
+func (t tempFiles) remove() {
+	for _, f := range t {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// limitFile fails reading an unpacked file past MaxImportSize, the sizes an
+// archive claims for its files can't be trusted.
+func (h Handler) limitFile(rc io.ReadCloser) io.ReadCloser {
+	if h.opts.MaxImportSize <= 0 {
+		return rc
+	}
+	return &limitedFile{ReadCloser: rc, left: h.opts.MaxImportSize}
+}
+
+type limitedFile struct {
+	io.ReadCloser
+	left int64
+}
+
+func (f *limitedFile) Read(p []byte) (int, error) {
+	// One byte more than allowed tells a file of the maximum size from a
+	// larger one.
+	if int64(len(p)) > f.left+1 {
+		p = p[:f.left+1]
+	}
+	n, err := f.ReadCloser.Read(p)
+	if f.left -= int64(n); f.left < 0 {
+		return 0, errors.New("file is too large")
+	}
+	return n, err
+}
@@ -0,0 +1,104 @@
+package notehandler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// GetLinks godoc
+//
+//	@Summary		Get links of a note
+//	@Description	Returns the [[wiki links]] in the content of a note, written as [[Title]] or [[#id]] with an optional |label.
+//	@Description	A title resolves to the oldest note with that header, ignoring case; target_id is 0 if the link resolves to no note.
+//	@Produce		json
+//	@Param			id	path		int	true	"Note id"
+//	@Success		200	{object}	[]models.Link
+//	@Failure		400	{object}	problem.Problem	"malformed id"
+//	@Failure		404	{object}	problem.Problem	"note not found"
+//	@Failure		422	{object}	problem.Problem	"invalid id"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Router			/notes/{id}/links [get]
+func (h Handler) Links(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Links"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	h.noteLinks(w, r, "Failed to get links", h.notes.Links)
+}
+
+// GetBacklinks godoc
+//
+//	@Summary		Get backlinks of a note
+//	@Description	Returns the links of other notes that resolve to this note, by its id or its header.
+//	@Produce		json
+//	@Param			id	path		int	true	"Note id"
+//	@Success		200	{object}	[]models.Link
+//	@Failure		400	{object}	problem.Problem	"malformed id"
+//	@Failure		404	{object}	problem.Problem	"note not found"
+//	@Failure		422	{object}	problem.Problem	"invalid id"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Router			/notes/{id}/backlinks [get]
+func (h Handler) Backlinks(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Backlinks"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	h.noteLinks(w, r, "Failed to get backlinks", h.notes.Backlinks)
+}
+
+// GetBrokenLinks godoc
+//
+//	@Summary		Get broken links
+//	@Description	Returns the links of all notes that resolve to no note.
+//	@Produce		json
+//	@Success		200	{object}	[]models.Link
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Router			/links/broken [get]
+func (h Handler) BrokenLinks(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.BrokenLinks"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	links, err := h.notes.BrokenLinks(r.Context())
+	if err != nil {
+		h.fail(w, r, "Failed to get broken links", err)
+		return
+	}
+	writeLinks(w, links)
+}
+
+func (h Handler) noteLinks(w http.ResponseWriter, r *http.Request, msg string, get func(ctx context.Context, id int64) ([]models.Link, error)) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.fail(w, r, "Failed to parse note id", malformed(err))
+		return
+	}
+
+	links, err := get(r.Context(), id)
+	if err != nil {
+		h.fail(w, r, msg, err)
+		return
+	}
+	writeLinks(w, links)
+}
+
+func writeLinks(w http.ResponseWriter, links []models.Link) {
+	if links == nil {
+		links = []models.Link{}
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(links)
+}
@@ -3,38 +3,103 @@ package notehandler
 import (
 	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/attachments"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
 	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
-	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/backup"
 )
 
 type Handler struct {
 	log   *slog.Logger
 	notes Notes
+	opts  Options
+}
+
+// Options tunes the handlers. Zero values disable the corresponding limits.
+type Options struct {
+	// MaxImportSize bounds every file unpacked from an imported archive.
+	MaxImportSize int64
+	// Snapshots serves the snapshot endpoints, which respond with 404 if it
+	// is nil.
+	Snapshots Snapshots
+	// Attachments serves the attachment endpoints, which respond with 404 if
+	// it is nil.
+	Attachments Attachments
 }
 
 type Notes interface {
 	GetAll(ctx context.Context) (notes []models.Note, err error)
+	Export(ctx context.Context, fn func(note models.Note) error) (err error)
+	GetById(ctx context.Context, id int64) (note models.Note, err error)
 	Add(ctx context.Context, header string, content string) (id int64, err error)
+	AddEncrypted(ctx context.Context, header string, content string, kdf models.KDF) (id int64, err error)
 	Edit(ctx context.Context, header string, content string, id int64) (err error)
+	Patch(ctx context.Context, id int64, fn func(note models.Note) (models.Note, error)) (note models.Note, err error)
 	Delete(ctx context.Context, id int64) (err error)
+	Batch(ctx context.Context, ops []models.BatchOperation, atomic bool) (results []models.BatchResult, err error)
+	Links(ctx context.Context, id int64) (links []models.Link, err error)
+	Backlinks(ctx context.Context, id int64) (links []models.Link, err error)
+	BrokenLinks(ctx context.Context) (links []models.Link, err error)
+	Graph(ctx context.Context, q notes.GraphQuery) (graph models.Graph, err error)
+	Import(ctx context.Context, files []notes.ImportFile, dryRun bool) (report models.ImportReport, err error)
+	ImportENEX(ctx context.Context, r io.Reader, dryRun bool, attach notes.AttachFunc) (report models.ImportReport, err error)
+	Backup(ctx context.Context, w io.Writer) (trailer backup.Trailer, err error)
+	Restore(ctx context.Context, r io.Reader) (report models.RestoreReport, err error)
+	Usage(ctx context.Context) (usage models.Usage, err error)
+}
+
+type Snapshots interface {
+	Take(ctx context.Context) (snapshot models.Snapshot, err error)
+	List(ctx context.Context) (snapshots []models.Snapshot, err error)
+}
+
+type Attachments interface {
+	Upload(ctx context.Context, noteId int64, name string, mediaType string, r io.Reader, opts attachments.UploadOptions) (attachment models.Attachment, err error)
+	List(ctx context.Context, noteId int64) (attachments []models.Attachment, err error)
+	Open(ctx context.Context, noteId int64, id int64) (attachment models.Attachment, content io.ReadSeekCloser, err error)
+	Thumbnail(ctx context.Context, noteId int64, id int64, size int) (attachment models.Attachment, mediaType string, content io.ReadSeekCloser, err error)
+	Delete(ctx context.Context, noteId int64, id int64) (err error)
+	Sweep(ctx context.Context) (report models.SweepReport, err error)
 }
 
-func New(log *slog.Logger, notes Notes) Handler {
+func New(log *slog.Logger, notes Notes, opts Options) Handler {
 	return Handler{
 		log:   log,
 		notes: notes,
+		opts:  opts,
 	}
 }
 
 func (h Handler) HandleRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("GET /", h.GetAll)
+	mux.HandleFunc("GET /notes/{id}", h.Get)
 	mux.HandleFunc("POST /", h.Add)
 	mux.HandleFunc("PATCH /", h.Edit)
+	mux.HandleFunc("PATCH /notes/{id}", h.Patch)
 	mux.HandleFunc("DELETE /", h.Delete)
+	mux.HandleFunc("POST /batch", h.Batch)
+	mux.HandleFunc("GET /notes/{id}/links", h.Links)
+	mux.HandleFunc("GET /notes/{id}/backlinks", h.Backlinks)
+	mux.HandleFunc("GET /notes/{id}/attachments", h.ListAttachments)
+	mux.HandleFunc("POST /notes/{id}/attachments", h.UploadAttachment)
+	mux.HandleFunc("GET /notes/{id}/attachments/{attachmentId}", h.DownloadAttachment)
+	mux.HandleFunc("DELETE /notes/{id}/attachments/{attachmentId}", h.DeleteAttachment)
+	mux.HandleFunc("GET /notes/{id}/attachments/{attachmentId}/thumbnail", h.AttachmentThumbnail)
+	mux.HandleFunc("GET /links/broken", h.BrokenLinks)
+	mux.HandleFunc("GET /graph", h.Graph)
+	mux.HandleFunc("GET /export", h.Export)
+	mux.HandleFunc("GET /usage", h.Usage)
+	mux.HandleFunc("POST /import", h.Import)
+	mux.HandleFunc("GET /admin/backup", h.Backup)
+	mux.HandleFunc("POST /admin/restore", h.Restore)
+	mux.HandleFunc("GET /admin/snapshots", h.ListSnapshots)
+	mux.HandleFunc("POST /admin/snapshots", h.TakeSnapshot)
+	mux.HandleFunc("POST /admin/attachments/sweep", h.SweepAttachments)
 }
 
 // GetAll godoc
@@ -46,8 +111,9 @@ func (h Handler) HandleRoutes(mux *http.ServeMux) {
 //	@Param			page	query		int	false	"Page number"
 //	@Param			results	query		int	false	"Results per page"
 //	@Success		200		{object}	[]models.Note
-//	@Failure		404		{string}	string	"page not found"
-//	@Failure		500		{string}	string	"internal server error"
+//	@Failure		404		{object}	problem.Problem	"page not found"
+//	@Failure		500		{object}	problem.Problem	"internal server error"
+//	@Failure		503		{object}	problem.Problem	"query timed out"
 //	@Router			/ [get]
 func (h Handler) GetAll(w http.ResponseWriter, r *http.Request) {
 	const op = "Note.GetAll"
@@ -57,13 +123,15 @@ func (h Handler) GetAll(w http.ResponseWriter, r *http.Request) {
 
 	notes, err := h.notes.GetAll(r.Context())
 	if err != nil {
-		http.Error(w, "Failed to get notes: "+err.Error(), http.StatusInternalServerError)
-		h.log.Error("Failed to get notes", sl.Err(err))
+		h.fail(w, r, "Failed to get notes", err)
 		return
 	}
+	if notes == nil {
+		notes = []models.Note{}
+	}
 
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(notes)
 }
 
@@ -71,13 +139,22 @@ func (h Handler) GetAll(w http.ResponseWriter, r *http.Request) {
 //
 //	@Summary		Add note
 //	@Description	Adds a new note
+//	@Description	A note of type "encrypted" is encrypted end to end: its content is the base64 blob the client encrypted and kdf the parameters it derives the key with. The server doesn't render it or parse links in it.
 //	@Accept			json
 //	@Produce		json
-//	@Param			header	body	string	true	"Notes header"
-//	@Param			content	body	string	true	"Notes content"
+//	@Param			header			body	string		true	"Notes header"
+//	@Param			content			body	string		true	"Notes content"
+//	@Param			type			body	string		false	"Note type, empty for Markdown"	Enums(encrypted)
+//	@Param			kdf				body	models.KDF	false	"Key derivation parameters of an encrypted note"
+//	@Param			Idempotency-Key	header	string	false	"Makes retries safe: a retry with the same key replays the first response"
 //	@Success		200
-//	@Failure		400	{string}	string	"bad request body"
-//	@Failure		500	{string}	string	"internal server error"
+//	@Failure		400	{object}	problem.Problem	"malformed request body"
+//	@Failure		409	{object}	problem.Problem	"a request with the same Idempotency-Key is in progress"
+//	@Failure		413	{object}	problem.Problem	"request body too large"
+//	@Failure		422	{object}	problem.Problem	"invalid fields or Idempotency-Key was used with a different request"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Failure		507	{object}	problem.Problem	"quota exceeded"
 //	@Router			/ [post]
 func (h Handler) Add(w http.ResponseWriter, r *http.Request) {
 	const op = "Note.Add"
@@ -86,31 +163,37 @@ func (h Handler) Add(w http.ResponseWriter, r *http.Request) {
 	)
 
 	var msg struct {
-		Header  string `json:"header"`
-		Content string `json:"content"`
+		Header  string     `json:"header"`
+		Content string     `json:"content"`
+		Type    string     `json:"type"`
+		KDF     models.KDF `json:"kdf"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		http.Error(w, "Failed to decode request body: "+err.Error(), http.StatusBadRequest)
-		h.log.Debug("Failed to decode request body", sl.Err(err))
+	if err := decode(r, &msg); err != nil {
+		h.fail(w, r, "Failed to decode request body", err)
 		return
 	}
 
-	if msg.Header == "" {
-		err := errors.New("Header must contain any characters")
-		http.Error(w, "Failed to add new note: "+err.Error(), http.StatusBadRequest)
-		h.log.Debug("Failed to add new note", sl.Err(err))
-		return
+	var id int64
+	var err error
+	switch msg.Type {
+	case "":
+		if msg.KDF != (models.KDF{}) {
+			err = notes.Invalid("kdf", "must be empty for a Markdown note")
+			break
+		}
+		id, err = h.notes.Add(r.Context(), msg.Header, msg.Content)
+	case models.NoteTypeEncrypted:
+		id, err = h.notes.AddEncrypted(r.Context(), msg.Header, msg.Content, msg.KDF)
+	default:
+		err = notes.Invalid("type", fmt.Sprintf("unknown type %q, want empty or %q", msg.Type, models.NoteTypeEncrypted))
 	}
-
-	id, err := h.notes.Add(r.Context(), msg.Header, msg.Content)
 	if err != nil {
-		http.Error(w, "Failed to add new note: "+err.Error(), http.StatusInternalServerError)
-		h.log.Error("Failed to add new note", sl.Err(err))
+		h.fail(w, r, "Failed to add new note", err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]int64{"id": id})
 }
 
@@ -122,9 +205,13 @@ func (h Handler) Add(w http.ResponseWriter, r *http.Request) {
 //	@Produce		json
 //	@Param			note	body	models.Note	true	"Notes body"
 //	@Success		200
-//	@Failure		400	{string}	string	"bad request body"
-//	@Failure		404	{string}	string	"note not found"
-//	@Failure		500	{string}	string	"internal server error"
+//	@Failure		400	{object}	problem.Problem	"malformed request body or nothing to change"
+//	@Failure		404	{object}	problem.Problem	"note not found"
+//	@Failure		413	{object}	problem.Problem	"request body too large"
+//	@Failure		422	{object}	problem.Problem	"invalid fields"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Failure		507	{object}	problem.Problem	"quota exceeded"
 //	@Router			/ [patch]
 func (h Handler) Edit(w http.ResponseWriter, r *http.Request) {
 	const op = "Note.Edit"
@@ -137,20 +224,13 @@ func (h Handler) Edit(w http.ResponseWriter, r *http.Request) {
 		Content string `json:"content"`
 		Id      int64  `json:"id"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		http.Error(w, "Failed to decode request body: "+err.Error(), http.StatusBadRequest)
-		h.log.Debug("Failed to decode request body", sl.Err(err))
+	if err := decode(r, &msg); err != nil {
+		h.fail(w, r, "Failed to decode request body", err)
 		return
 	}
 
 	if err := h.notes.Edit(r.Context(), msg.Header, msg.Content, msg.Id); err != nil {
-		if errors.Is(err, errors.New("note not found")) {
-			http.Error(w, "Failed to edit note:"+err.Error(), http.StatusNotFound)
-			h.log.Debug("Failed to edit note", sl.Err(err))
-		} else {
-			http.Error(w, "Failed to edit note: "+err.Error(), http.StatusInternalServerError)
-			h.log.Error("Failed to edit note", sl.Err(err))
-		}
+		h.fail(w, r, "Failed to edit note", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -164,9 +244,11 @@ func (h Handler) Edit(w http.ResponseWriter, r *http.Request) {
 //	@Produce		json
 //	@Param			id	body	int	true	"Notes id"
 //	@Success		200
-//	@Failure		400	{string}	string	"bad request body"
-//	@Failure		404	{string}	string	"note not found"
-//	@Failure		500	{string}	string	"internal server error"
+//	@Failure		400	{object}	problem.Problem	"malformed request body"
+//	@Failure		404	{object}	problem.Problem	"note not found"
+//	@Failure		422	{object}	problem.Problem	"invalid id"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
 //	@Router			/ [delete]
 func (h Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	const op = "Note.Delete"
@@ -177,23 +259,74 @@ func (h Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	var msg struct {
 		Id int64 `json:"id"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
-		http.Error(w, "Failed to decode request body: "+err.Error(), http.StatusBadRequest)
-		h.log.Debug("Failed to decode request body", sl.Err(err))
+	if err := decode(r, &msg); err != nil {
+		h.fail(w, r, "Failed to decode request body", err)
 		return
 	}
 
 	err := h.notes.Delete(r.Context(), msg.Id)
 	if err != nil {
-		if errors.Is(err, errors.New("note not found")) {
-			http.Error(w, "Failed to delete note:"+err.Error(), http.StatusNotFound)
-			h.log.Debug("Failed to delete note", sl.Err(err))
-		} else {
-			http.Error(w, "Failed to delete note: "+err.Error(), http.StatusInternalServerError)
-			h.log.Error("Failed to delete note", sl.Err(err))
+		h.fail(w, r, "Failed to delete note", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type batchRequest struct {
+	// Atomic defaults to true: the batch is applied entirely or not at all.
+	Atomic     *bool                   `json:"atomic,omitempty" example:"true"`
+	Operations []models.BatchOperation `json:"operations"`
+}
+
+type batchResponse struct {
+	Results []models.BatchResult `json:"results"`
+}
+
+// Batch godoc
+//
+//	@Summary		Batch operations
+//	@Description	Runs an ordered list of create, edit and delete operations.
+//	@Description	By default the batch is atomic: the first failing operation rolls back the whole batch.
+//	@Description	With "atomic": false every operation is applied on its own and failures are reported per operation.
+//	@Description	A failed batch is reported as a problem with the per operation results in "results".
+//	@Accept			json
+//	@Produce		json
+//	@Param			batch			body		batchRequest	true	"Operations"
+//	@Param			Idempotency-Key	header		string			false	"Makes retries safe: a retry with the same key replays the first response"
+//	@Success		200		{object}	batchResponse
+//	@Failure		400		{object}	problem.Problem{results=[]models.BatchResult}	"malformed request body or nothing to change"
+//	@Failure		404		{object}	problem.Problem{results=[]models.BatchResult}	"note not found"
+//	@Failure		413		{object}	problem.Problem									"too many operations or request body too large"
+//	@Failure		422		{object}	problem.Problem{results=[]models.BatchResult}	"invalid operations"
+//	@Failure		500		{object}	problem.Problem{results=[]models.BatchResult}	"internal server error"
+//	@Failure		503		{object}	problem.Problem{results=[]models.BatchResult}	"query timed out"
+//	@Failure		507		{object}	problem.Problem{results=[]models.BatchResult}	"quota exceeded"
+//	@Router			/batch [post]
+func (h Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Batch"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	var msg batchRequest
+	if err := decode(r, &msg); err != nil {
+		h.fail(w, r, "Failed to decode request body", err)
+		return
+	}
+	atomic := msg.Atomic == nil || *msg.Atomic
+
+	results, err := h.notes.Batch(r.Context(), msg.Operations, atomic)
+	if err != nil {
+		p := problemFor("Failed to run batch", err)
+		if results != nil {
+			p.Extensions = map[string]any{"results": results}
 		}
+		h.write(w, r, p, "Failed to run batch", err)
 		return
 	}
 
+	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(batchResponse{Results: results})
 }
@@ -0,0 +1,170 @@
+package notehandler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/jsonpatch"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/problem"
+)
+
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// PatchNote godoc
+//
+//	@Summary		Patch note
+//	@Description	Applies a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to the note {"header", "content", "id"} and returns the result.
+//	@Description	Unlike PATCH /, fields can be cleared: a merge patch with "content": null or a JSON Patch remove of /content stores an empty content.
+//	@Description	A plain application/json body is treated as a merge patch.
+//	@Description	The type of a note can't be changed; the kdf of an encrypted note can, but only together with its content.
+//	@Accept			application/merge-patch+json
+//	@Accept			application/json-patch+json
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int		true	"Note id"
+//	@Param			patch	body		object	true	"Merge patch object or array of JSON Patch operations"
+//	@Success		200		{object}	models.Note
+//	@Failure		400		{object}	problem.Problem	"malformed patch or id"
+//	@Failure		404		{object}	problem.Problem	"note not found"
+//	@Failure		409		{object}	problem.Problem	"a test operation failed"
+//	@Failure		413		{object}	problem.Problem	"request body too large"
+//	@Failure		415		{object}	problem.Problem	"unsupported patch format"
+//	@Failure		422		{object}	problem.Problem	"patch doesn't apply or the result is not a valid note"
+//	@Failure		500		{object}	problem.Problem	"internal server error"
+//	@Failure		503		{object}	problem.Problem	"query timed out"
+//	@Failure		507		{object}	problem.Problem	"quota exceeded"
+//	@Router			/notes/{id} [patch]
+func (h Handler) Patch(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Patch"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		h.fail(w, r, "Failed to parse note id", malformed(err))
+		return
+	}
+
+	var applyPatch func(doc []byte, patch []byte) ([]byte, error)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchType, "application/json":
+		applyPatch = jsonpatch.MergePatch
+	case jsonPatchType:
+		applyPatch = jsonpatch.Apply
+	default:
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		problem.Write(w, r, problem.New(http.StatusUnsupportedMediaType, string(codeUnsupportedMediaType),
+			"Failed to patch note: unsupported Content-Type "+strconv.Quote(mediaType)))
+		h.log.Debug("Failed to patch note", slog.String("content_type", mediaType))
+		return
+	}
+
+	patch, err := readBody(r)
+	if err != nil {
+		h.fail(w, r, "Failed to read request body", err)
+		return
+	}
+
+	note, err := h.notes.Patch(r.Context(), id, func(note models.Note) (models.Note, error) {
+		doc, err := json.Marshal(note)
+		if err != nil {
+			return models.Note{}, err
+		}
+		if doc, err = applyPatch(doc, patch); err != nil {
+			return models.Note{}, err
+		}
+		return decodeNote(doc, note)
+	})
+	if err != nil {
+		h.fail(w, r, "Failed to patch note", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(note)
+}
+
+// decodeNote turns a patched document back into a note. The document must
+// still be an object with a string header. The id, the type and the
+// timestamps of current can't be changed, but the type and the timestamps
+// may be left out. A missing or null content means the content was cleared,
+// and likewise for the kdf.
+func decodeNote(doc []byte, current models.Note) (models.Note, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &fields); err != nil || fields == nil {
+		return models.Note{}, notes.Invalid("note", "must be an object")
+	}
+
+	note := models.Note{Id: current.Id, Type: current.Type}
+	invalid := &notes.ValidationError{}
+
+	if raw, ok := fields["header"]; !ok || string(raw) == "null" {
+		invalid.Add("header", "is required")
+	} else if err := json.Unmarshal(raw, &note.Header); err != nil {
+		invalid.Add("header", "must be a string")
+	}
+	if raw, ok := fields["content"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &note.Content); err != nil {
+			invalid.Add("content", "must be a string or null")
+		}
+	}
+	if raw, ok := fields["kdf"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &note.KDF); err != nil {
+			invalid.Add("kdf", "must be an object or null")
+		}
+	}
+	if raw, ok := fields["type"]; ok && string(raw) != "null" {
+		var patched string
+		if err := json.Unmarshal(raw, &patched); err != nil || patched != current.Type {
+			invalid.Add("type", "can't be changed")
+		}
+	}
+	if raw, ok := fields["id"]; ok {
+		var patched int64
+		if err := json.Unmarshal(raw, &patched); err != nil || patched != current.Id {
+			invalid.Add("id", "can't be changed")
+		}
+	}
+	for _, timestamp := range []struct {
+		key   string
+		value time.Time
+	}{{"created_at", current.CreatedAt}, {"updated_at", current.UpdatedAt}} {
+		if raw, ok := fields[timestamp.key]; ok && string(raw) != "null" {
+			var patched time.Time
+			if err := json.Unmarshal(raw, &patched); err != nil || !patched.Equal(timestamp.value) {
+				invalid.Add(timestamp.key, "can't be changed")
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		switch key {
+		case "header", "content", "id", "type", "kdf", "created_at", "updated_at":
+		default:
+			invalid.Add(key, "unknown field")
+		}
+	}
+
+	if err := invalid.Err(); err != nil {
+		return models.Note{}, err
+	}
+	return note, nil
+}
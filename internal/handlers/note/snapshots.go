@@ -0,0 +1,80 @@
+package notehandler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+)
+
+var errSnapshotsDisabled = &notes.Error{Code: notes.CodeNotFound, Message: "snapshots are not configured"}
+
+// TakeSnapshot godoc
+//
+//	@Summary		Take a snapshot
+//	@Description	Takes a consistent copy of the SQLite database into the snapshot directory while the server keeps running, then removes the oldest snapshots past the retention.
+//	@Description	Only available when snapshots are configured.
+//	@Tags			admin
+//	@Security		AdminToken
+//	@Produce		json
+//	@Success		200	{object}	models.Snapshot
+//	@Failure		401	{object}	problem.Problem	"missing or wrong admin token"
+//	@Failure		404	{object}	problem.Problem	"snapshots are not configured"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Router			/admin/snapshots [post]
+func (h Handler) TakeSnapshot(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.TakeSnapshot"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.opts.Snapshots == nil {
+		h.fail(w, r, "Failed to take snapshot", errSnapshotsDisabled)
+		return
+	}
+
+	snapshot, err := h.opts.Snapshots.Take(r.Context())
+	if err != nil {
+		h.fail(w, r, "Failed to take snapshot", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// ListSnapshots godoc
+//
+//	@Summary		List snapshots
+//	@Description	Returns the snapshots of the database, newest first. Only available when snapshots are configured.
+//	@Tags			admin
+//	@Security		AdminToken
+//	@Produce		json
+//	@Success		200	{object}	[]models.Snapshot
+//	@Failure		401	{object}	problem.Problem	"missing or wrong admin token"
+//	@Failure		404	{object}	problem.Problem	"snapshots are not configured"
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Router			/admin/snapshots [get]
+func (h Handler) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.ListSnapshots"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	if h.opts.Snapshots == nil {
+		h.fail(w, r, "Failed to list snapshots", errSnapshotsDisabled)
+		return
+	}
+
+	snapshots, err := h.opts.Snapshots.List(r.Context())
+	if err != nil {
+		h.fail(w, r, "Failed to list snapshots", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(snapshots)
+}
@@ -0,0 +1,34 @@
+package notehandler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// GetUsage godoc
+//
+//	@Summary		Get storage usage
+//	@Description	Returns how many notes and attachments are stored and how many bytes they take, with the quotas they are held to.
+//	@Description	Bytes are the UTF-8 bytes of headers and contents plus the bytes of attachments as uploaded. Quotas that are not set are left out.
+//	@Produce		json
+//	@Success		200	{object}	models.Usage
+//	@Failure		500	{object}	problem.Problem	"internal server error"
+//	@Failure		503	{object}	problem.Problem	"query timed out"
+//	@Router			/usage [get]
+func (h Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	const op = "Note.Usage"
+	h.log.With(
+		slog.String("op", op),
+	)
+
+	usage, err := h.notes.Usage(r.Context())
+	if err != nil {
+		h.fail(w, r, "Failed to get usage", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(usage)
+}
@@ -0,0 +1,277 @@
+// Package backup reads and writes backups as JSON Lines.
+//
+// A backup starts with a header line naming the format and its version,
+// holds one line per record and ends with a trailer line with the number of
+// records and the SHA-256 of their lines. Every line is an object with a type
+// and the data of that type:
+//
+//	{"type":"header","data":{"format":"notion-backup","version":1,"created_at":"2025-01-02T15:04:05Z"}}
+//	{"type":"note","data":{"header":"Groceries","content":"- milk","id":1}}
+//	{"type":"trailer","data":{"records":1,"sha256":"..."}}
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+)
+
+const (
+	Format = "notion-backup"
+	// Version is the version of the format written, and the newest one read.
+	Version = 1
+)
+
+// DefaultMaxLineSize is how long the lines NewReader reads may be, in bytes:
+// well past any record, short enough that a line is held in memory.
+const DefaultMaxLineSize = 16 << 20
+
+const (
+	typeHeader  = "header"
+	typeTrailer = "trailer"
+)
+
+var (
+	// ErrInvalid is wrapped by the errors of input that is not a backup.
+	ErrInvalid = errors.New("invalid backup")
+	// ErrChecksum is returned when the records don't match the trailer,
+	// e.g. because the backup was truncated or edited.
+	ErrChecksum = fmt.Errorf("%w: records don't match the checksum", ErrInvalid)
+)
+
+type Header struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+}
+
+type Trailer struct {
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// Record is a line between the header and the trailer.
+type Record struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Writer writes a backup. Records must be written in a stable order for the
+// checksums of backups of the same data to match.
+type Writer struct {
+	w       *bufio.Writer
+	hash    hash.Hash
+	records int
+	err     error
+}
+
+// NewWriter starts a backup created at createdAt.
+func NewWriter(w io.Writer, createdAt time.Time) *Writer {
+	bw := &Writer{w: bufio.NewWriter(w), hash: sha256.New()}
+	bw.err = bw.writeLine(typeHeader, Header{Format: Format, Version: Version, CreatedAt: createdAt})
+	return bw
+}
+
+// Write adds a record of type typ holding data encoded as JSON.
+func (w *Writer) Write(typ string, data any) error {
+	if w.err != nil {
+		return w.err
+	}
+	switch typ {
+	case typeHeader, typeTrailer, "":
+		return fmt.Errorf("invalid record type %q", typ)
+	}
+
+	w.records++
+	w.err = w.writeLine(typ, data)
+	return w.err
+}
+
+// Close writes the trailer and flushes the backup. It doesn't close the
+// underlying writer.
+func (w *Writer) Close() (Trailer, error) {
+	if w.err != nil {
+		return Trailer{}, w.err
+	}
+
+	trailer := Trailer{Records: w.records, SHA256: hex.EncodeToString(w.hash.Sum(nil))}
+	if w.err = w.writeLine(typeTrailer, trailer); w.err != nil {
+		return Trailer{}, w.err
+	}
+	if w.err = w.w.Flush(); w.err != nil {
+		return Trailer{}, w.err
+	}
+	return trailer, nil
+}
+
+func (w *Writer) writeLine(typ string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(Record{Type: typ, Data: raw})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if typ != typeHeader && typ != typeTrailer {
+		w.hash.Write(line)
+	}
+	_, err = w.w.Write(line)
+	return err
+}
+
+// Reader reads a backup record by record.
+type Reader struct {
+	r           *bufio.Reader
+	maxLineSize int
+	header      Header
+	trailer     Trailer
+	hash        hash.Hash
+	records     int
+	line        int
+	done        bool
+}
+
+// NewReader reads the header of a backup. Backups of a newer version than
+// Version are rejected, and so are lines longer than DefaultMaxLineSize.
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderSize(r, DefaultMaxLineSize)
+}
+
+// NewReaderSize is NewReader with lines of up to maxLineSize bytes.
+func NewReaderSize(r io.Reader, maxLineSize int) (*Reader, error) {
+	br := &Reader{r: bufio.NewReader(r), maxLineSize: maxLineSize, hash: sha256.New()}
+
+	rec, err := br.readLine()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: empty", ErrInvalid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rec.Type != typeHeader {
+		return nil, br.errorf("want a header, got a %q record", rec.Type)
+	}
+	if err := json.Unmarshal(rec.Data, &br.header); err != nil {
+		return nil, br.errorf("header: %v", err)
+	}
+	if br.header.Format != Format {
+		return nil, br.errorf("unknown format %q", br.header.Format)
+	}
+	if br.header.Version < 1 || br.header.Version > Version {
+		return nil, br.errorf("unsupported version %d", br.header.Version)
+	}
+
+	return br, nil
+}
+
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Trailer returns the trailer once Next has returned io.EOF.
+func (r *Reader) Trailer() Trailer {
+	return r.trailer
+}
+
+// Next returns the next record, or io.EOF after the trailer once the records
+// are checked against it.
+func (r *Reader) Next() (Record, error) {
+	if r.done {
+		return Record{}, io.EOF
+	}
+
+	rec, err := r.readLine()
+	if errors.Is(err, io.EOF) {
+		return Record{}, fmt.Errorf("%w: no trailer, the backup is truncated", ErrInvalid)
+	}
+	if err != nil {
+		return Record{}, err
+	}
+
+	switch rec.Type {
+	case typeTrailer:
+		return Record{}, r.finish(rec)
+	case typeHeader:
+		return Record{}, r.errorf("unexpected header")
+	case "":
+		return Record{}, r.errorf("no record type")
+	}
+	r.records++
+	return rec, nil
+}
+
+func (r *Reader) finish(rec Record) error {
+	if err := json.Unmarshal(rec.Data, &r.trailer); err != nil {
+		return r.errorf("trailer: %v", err)
+	}
+	if _, err := r.readLine(); !errors.Is(err, io.EOF) {
+		if err != nil {
+			return err
+		}
+		return r.errorf("data after the trailer")
+	}
+
+	sum := hex.EncodeToString(r.hash.Sum(nil))
+	if r.trailer.Records != r.records || r.trailer.SHA256 != sum {
+		return ErrChecksum
+	}
+	r.done = true
+	return io.EOF
+}
+
+// readLine reads the next non-empty line. Lines of records are added to the
+// checksum as they are.
+func (r *Reader) readLine() (Record, error) {
+	for {
+		line, err := r.readBytes()
+		if err != nil && !errors.Is(err, io.EOF) {
+			return Record{}, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err != nil {
+				return Record{}, io.EOF
+			}
+			continue
+		}
+		r.line++
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return Record{}, r.errorf("%v", err)
+		}
+		if rec.Type != typeHeader && rec.Type != typeTrailer {
+			r.hash.Write(line)
+		}
+		return rec, nil
+	}
+}
+
+// readBytes reads up to and including the next newline, like
+// bufio.Reader.ReadBytes, but fails once the line gets too long.
+func (r *Reader) readBytes() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		if len(line)+len(chunk) > r.maxLineSize {
+			return nil, fmt.Errorf("%w: line %d: longer than %d bytes", ErrInvalid, r.line+1, r.maxLineSize)
+		}
+		line = append(line, chunk...)
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return line, err
+		}
+	}
+}
+
+func (r *Reader) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: line %d: %s", ErrInvalid, r.line, fmt.Sprintf(format, args...))
+}
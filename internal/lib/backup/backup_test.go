@@ -0,0 +1,187 @@
+package backup_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/backup"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+func write(t *testing.T, records ...string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := backup.NewWriter(&buf, time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC))
+	for _, name := range records {
+		if err := w.Write("item", item{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := write(t, "a", "b")
+
+	want := `{"type":"header","data":{"format":"notion-backup","version":1,"created_at":"2025-01-02T15:04:05Z"}}
+{"type":"item","data":{"name":"a"}}
+{"type":"item","data":{"name":"b"}}
+{"type":"trailer","data":{"records":2,"sha256":"`
+	if !strings.HasPrefix(src, want) {
+		t.Fatalf("got\n%s\nwant it to start with\n%s", src, want)
+	}
+
+	r, err := backup.NewReader(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Header(); got.Version != backup.Version || got.CreatedAt.IsZero() {
+		t.Errorf("Header = %+v", got)
+	}
+
+	var got []string
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rec.Type+" "+string(rec.Data))
+	}
+	if strings.Join(got, "\n") != "item {\"name\":\"a\"}\nitem {\"name\":\"b\"}" {
+		t.Errorf("records = %q", got)
+	}
+	if r.Trailer().Records != 2 {
+		t.Errorf("Trailer = %+v", r.Trailer())
+	}
+}
+
+func TestChecksumIgnoresCreatedAt(t *testing.T) {
+	trailer := func(createdAt time.Time) backup.Trailer {
+		w := backup.NewWriter(io.Discard, createdAt)
+		w.Write("item", item{Name: "a"})
+		got, err := w.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	if a, b := trailer(time.Now()), trailer(time.Time{}); a != b {
+		t.Errorf("trailers differ: %+v and %+v", a, b)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	valid := write(t, "a", "b")
+	lines := strings.SplitAfter(valid, "\n")
+
+	tests := []struct {
+		name    string
+		src     string
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:    "empty",
+			src:     "",
+			wantErr: backup.ErrInvalid,
+			wantMsg: "invalid backup: empty",
+		},
+		{
+			name:    "not JSON",
+			src:     "hello\n",
+			wantErr: backup.ErrInvalid,
+			wantMsg: "invalid backup: line 1: invalid character 'h' looking for beginning of value",
+		},
+		{
+			name:    "no header",
+			src:     lines[1],
+			wantErr: backup.ErrInvalid,
+			wantMsg: `invalid backup: line 1: want a header, got a "item" record`,
+		},
+		{
+			name:    "newer version",
+			src:     `{"type":"header","data":{"format":"notion-backup","version":2}}`,
+			wantErr: backup.ErrInvalid,
+			wantMsg: "invalid backup: line 1: unsupported version 2",
+		},
+		{
+			name:    "truncated",
+			src:     lines[0] + lines[1],
+			wantErr: backup.ErrInvalid,
+			wantMsg: "invalid backup: no trailer, the backup is truncated",
+		},
+		{
+			name:    "record removed",
+			src:     lines[0] + lines[2] + lines[3],
+			wantErr: backup.ErrChecksum,
+		},
+		{
+			name:    "record edited",
+			src:     strings.Replace(valid, `"b"`, `"c"`, 1),
+			wantErr: backup.ErrChecksum,
+		},
+		{
+			name:    "data after the trailer",
+			src:     valid + lines[1],
+			wantErr: backup.ErrInvalid,
+			wantMsg: "invalid backup: line 5: data after the trailer",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readAll(tt.src, backup.DefaultMaxLineSize)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && err.Error() != tt.wantMsg {
+				t.Errorf("got %q, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestLineTooLong(t *testing.T) {
+	valid := write(t, "a", strings.Repeat("b", 8192))
+
+	if err := readAll(valid, 8300); err != nil {
+		t.Fatalf("lines within the limit: %v", err)
+	}
+	err := readAll(valid, 8192)
+	if !errors.Is(err, backup.ErrInvalid) {
+		t.Fatalf("got %v, want %v", err, backup.ErrInvalid)
+	}
+	if want := "invalid backup: line 3: longer than 8192 bytes"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
+
+func readAll(src string, maxLineSize int) error {
+	r, err := backup.NewReaderSize(strings.NewReader(src), maxLineSize)
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := r.Next(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Package enex reads Evernote export files (.enex) one note at a time, so
+// that exports of any size can be imported.
+package enex
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// timeLayout is how ENEX writes dates.
+const timeLayout = "20060102T150405Z"
+
+// ErrInvalid is wrapped by the errors of files that are not well-formed
+// exports.
+var ErrInvalid = errors.New("invalid ENEX file")
+
+// Note is a note of an export. Content is ENML.
+type Note struct {
+	Title     string
+	Content   string
+	Created   time.Time
+	Updated   time.Time
+	Tags      []string
+	Resources []Resource
+}
+
+// Resource is a file embedded in a note. Hash is the hex MD5 of Data, which
+// is how <en-media> elements of the content refer to it.
+type Resource struct {
+	Data      []byte
+	MediaType string
+	FileName  string
+	Hash      string
+}
+
+type rawNote struct {
+	Title     string        `xml:"title"`
+	Content   string        `xml:"content"`
+	Created   string        `xml:"created"`
+	Updated   string        `xml:"updated"`
+	Tags      []string      `xml:"tag"`
+	Resources []rawResource `xml:"resource"`
+}
+
+type rawResource struct {
+	Data struct {
+		Encoding string `xml:"encoding,attr"`
+		Body     string `xml:",chardata"`
+	} `xml:"data"`
+	Mime     string `xml:"mime"`
+	FileName string `xml:"resource-attributes>file-name"`
+}
+
+// NoteError is a note that can't be read although the export is well
+// formed. Decoding can go on with the next note.
+type NoteError struct {
+	Title string
+	Err   error
+}
+
+func (e *NoteError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *NoteError) Unwrap() error {
+	return e.Err
+}
+
+type Decoder struct {
+	dec *xml.Decoder
+	// root is set once the <en-export> element is found.
+	root bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: xml.NewDecoder(r)}
+}
+
+// Next returns the next note of the export, or io.EOF after the last one.
+// Only that note is held in memory. A note with invalid dates or resources
+// is a *NoteError.
+func (d *Decoder) Next() (Note, error) {
+	for {
+		tok, err := d.dec.Token()
+		if errors.Is(err, io.EOF) {
+			if !d.root {
+				return Note{}, fmt.Errorf("%w: no en-export element", ErrInvalid)
+			}
+			return Note{}, io.EOF
+		}
+		if err != nil {
+			return Note{}, invalid(err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch {
+		case start.Name.Local == "en-export":
+			d.root = true
+		case start.Name.Local == "note" && d.root:
+			var raw rawNote
+			if err := d.dec.DecodeElement(&raw, &start); err != nil {
+				return Note{}, invalid(err)
+			}
+			return convert(raw)
+		case !d.root:
+			return Note{}, fmt.Errorf("%w: unexpected element %s", ErrInvalid, start.Name.Local)
+		}
+	}
+}
+
+// invalid marks XML syntax errors as ErrInvalid, errors of the underlying
+// reader are returned as they are.
+func invalid(err error) error {
+	var syntaxErr *xml.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return err
+}
+
+func convert(raw rawNote) (note Note, err error) {
+	note = Note{Title: strings.TrimSpace(raw.Title), Content: raw.Content, Tags: raw.Tags}
+
+	fail := func(err error) (Note, error) {
+		return Note{}, &NoteError{Title: note.Title, Err: err}
+	}
+
+	if note.Created, err = parseTime(raw.Created); err != nil {
+		return fail(fmt.Errorf("invalid created date %q", raw.Created))
+	}
+	if note.Updated, err = parseTime(raw.Updated); err != nil {
+		return fail(fmt.Errorf("invalid updated date %q", raw.Updated))
+	}
+
+	for _, r := range raw.Resources {
+		if r.Data.Encoding != "" && r.Data.Encoding != "base64" {
+			return fail(fmt.Errorf("unsupported resource encoding %q", r.Data.Encoding))
+		}
+		// Base64 in ENEX is wrapped, the decoder doesn't skip white space.
+		data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(r.Data.Body), ""))
+		if err != nil {
+			return fail(fmt.Errorf("resource %q: %w", r.FileName, err))
+		}
+
+		sum := md5.Sum(data)
+		note.Resources = append(note.Resources, Resource{
+			Data:      data,
+			MediaType: strings.TrimSpace(r.Mime),
+			FileName:  strings.TrimSpace(r.FileName),
+			Hash:      hex.EncodeToString(sum[:]),
+		})
+	}
+
+	return note, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(timeLayout, s)
+}
@@ -0,0 +1,108 @@
+package enex_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/enex"
+)
+
+const export = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export4.dtd">
+<en-export export-date="20250101T000000Z" application="Evernote" version="10">
+  <note>
+    <title>Groceries</title>
+    <created>20240102T030405Z</created>
+    <updated>20240203T040506Z</updated>
+    <tag>home</tag>
+    <tag>lists</tag>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8"?><en-note><div>milk</div><en-media hash="5d41402abc4b2a76b9719d911017c592" type="text/plain"/></en-note>]]></content>
+    <resource>
+      <data encoding="base64">
+aGVs
+bG8=
+      </data>
+      <mime>text/plain</mime>
+      <resource-attributes><file-name>hello.txt</file-name></resource-attributes>
+    </resource>
+  </note>
+  <note>
+    <title>Second</title>
+    <content><![CDATA[<en-note/>]]></content>
+  </note>
+</en-export>`
+
+func TestDecoder(t *testing.T) {
+	dec := enex.NewDecoder(strings.NewReader(export))
+
+	note, err := dec.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Title != "Groceries" || !strings.Contains(note.Content, "<div>milk</div>") {
+		t.Errorf("title, content = %q, %q", note.Title, note.Content)
+	}
+	if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !note.Created.Equal(want) {
+		t.Errorf("created = %v, want %v", note.Created, want)
+	}
+	if want := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC); !note.Updated.Equal(want) {
+		t.Errorf("updated = %v, want %v", note.Updated, want)
+	}
+	if strings.Join(note.Tags, ",") != "home,lists" {
+		t.Errorf("tags = %v", note.Tags)
+	}
+	if len(note.Resources) != 1 {
+		t.Fatalf("resources = %+v, want one", note.Resources)
+	}
+	if r := note.Resources[0]; string(r.Data) != "hello" || r.MediaType != "text/plain" || r.FileName != "hello.txt" || r.Hash != "5d41402abc4b2a76b9719d911017c592" {
+		t.Errorf("resource = %+v", r)
+	}
+
+	note, err = dec.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note.Title != "Second" || !note.Created.IsZero() {
+		t.Errorf("second note = %+v", note)
+	}
+
+	if _, err := dec.Next(); !errors.Is(err, io.EOF) {
+		t.Fatalf("Next after the last note = %v, want io.EOF", err)
+	}
+}
+
+func TestDecoderNoteErrors(t *testing.T) {
+	dec := enex.NewDecoder(strings.NewReader(`<en-export>
+		<note><title>bad date</title><created>yesterday</created></note>
+		<note><title>bad resource</title><resource><data encoding="base64">!!!</data></resource></note>
+		<note><title>fine</title></note>
+	</en-export>`))
+
+	for _, title := range []string{"bad date", "bad resource"} {
+		_, err := dec.Next()
+		var noteErr *enex.NoteError
+		if !errors.As(err, &noteErr) || noteErr.Title != title {
+			t.Fatalf("Next = %v, want a *NoteError for %q", err, title)
+		}
+	}
+	if note, err := dec.Next(); err != nil || note.Title != "fine" {
+		t.Fatalf("Next after note errors = %+v, %v", note, err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	for name, src := range map[string]string{
+		"not an export": `<html><body/></html>`,
+		"empty":         ``,
+		"truncated":     `<en-export><note><title>x</title>`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := enex.NewDecoder(strings.NewReader(src)).Next(); !errors.Is(err, enex.ErrInvalid) {
+				t.Errorf("got %v, want %v", err, enex.ErrInvalid)
+			}
+		})
+	}
+}
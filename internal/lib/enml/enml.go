@@ -0,0 +1,416 @@
+// Package enml converts ENML, the XHTML dialect Evernote stores note content
+// in, to Markdown.
+package enml
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MediaFunc returns the Markdown an embedded resource is replaced with, given
+// the hash and media type of its <en-media> element.
+type MediaFunc func(hash string, mediaType string) string
+
+type node struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*node
+}
+
+// ToMarkdown converts the ENML document src. Formatting Markdown has no
+// syntax for, like colors and underlines, is dropped; checkboxes become task
+// list items and tables GFM tables. media renders <en-media> elements.
+func ToMarkdown(src string, media MediaFunc) (string, error) {
+	root, err := parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	c := converter{media: media}
+	c.blocks(root)
+
+	lines := strings.Split(strings.TrimSpace(c.out.String()), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// parse reads src into a tree. The decoder is lenient so that the HTML
+// entities and unclosed tags Evernote lets through don't fail an import.
+func parse(src string) (*node, error) {
+	dec := xml.NewDecoder(strings.NewReader(src))
+	dec.Strict = false
+	dec.AutoClose = xml.HTMLAutoClose
+	dec.Entity = xml.HTMLEntity
+
+	root := &node{}
+	stack := []*node{root}
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return root, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		top := stack[len(stack)-1]
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &node{name: strings.ToLower(tok.Name.Local), attrs: make(map[string]string)}
+			for _, attr := range tok.Attr {
+				n.attrs[strings.ToLower(attr.Name.Local)] = attr.Value
+			}
+			top.children = append(top.children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			name := strings.ToLower(tok.Name.Local)
+			for i := len(stack) - 1; i > 0; i-- {
+				if stack[i].name == name {
+					stack = stack[:i]
+					break
+				}
+			}
+		case xml.CharData:
+			top.children = append(top.children, &node{text: string(tok)})
+		}
+	}
+}
+
+// converter writes Markdown line by line. Blocks ask for the newlines they
+// need before them; the newlines are written, with the prefixes of the
+// enclosing quotes and lists, only once there is text to follow.
+type converter struct {
+	media    MediaFunc
+	out      strings.Builder
+	prefixes []string
+	newlines int
+	// blankPrefix is what blank lines start with: the prefix of the
+	// outermost block asking for them.
+	blankPrefix string
+	// atLineStart is set when the next text starts a line.
+	atLineStart bool
+	// marker is written instead of the indentation at the start of the
+	// first line of a list item.
+	marker string
+}
+
+func (c *converter) breakLines(n int) {
+	if c.out.Len() == 0 {
+		return
+	}
+	prefix := strings.Join(c.prefixes, "")
+	if c.newlines == 0 || len(prefix) < len(c.blankPrefix) {
+		c.blankPrefix = prefix
+	}
+	c.newlines = max(c.newlines, n)
+}
+
+func (c *converter) write(s string) {
+	if s == "" {
+		return
+	}
+	for ; c.newlines > 0; c.newlines-- {
+		c.out.WriteString("\n")
+		if c.newlines > 1 {
+			c.out.WriteString(strings.TrimRight(c.blankPrefix, " "))
+		}
+		c.atLineStart = true
+	}
+	if c.atLineStart || c.out.Len() == 0 {
+		prefix := strings.Join(c.prefixes, "")
+		if c.marker != "" {
+			prefix = prefix[:len(prefix)-len(c.marker)] + c.marker
+			c.marker = ""
+		}
+		c.out.WriteString(prefix)
+		c.atLineStart = false
+	}
+	c.out.WriteString(s)
+}
+
+// blocks converts the children of n.
+func (c *converter) blocks(n *node) {
+	for _, child := range n.children {
+		c.convert(child)
+	}
+}
+
+func (c *converter) convert(n *node) {
+	if n.name == "" {
+		c.text(n.text)
+		return
+	}
+
+	switch n.name {
+	case "head", "title", "style", "script":
+	case "p", "div", "en-note", "center", "section", "article", "header", "footer":
+		c.breakLines(2)
+		c.blocks(n)
+		c.breakLines(2)
+	case "br":
+		c.breakLines(1)
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		c.breakLines(2)
+		c.write(strings.Repeat("#", int(n.name[1]-'0')) + " " + c.inline(n))
+		c.breakLines(2)
+	case "hr":
+		c.breakLines(2)
+		c.write("---")
+		c.breakLines(2)
+	case "blockquote":
+		c.breakLines(2)
+		c.prefixes = append(c.prefixes, "> ")
+		c.blocks(n)
+		c.prefixes = c.prefixes[:len(c.prefixes)-1]
+		c.breakLines(2)
+	case "ul", "ol":
+		c.list(n, 2)
+	case "pre":
+		c.breakLines(2)
+		c.write("```")
+		prefix := strings.Join(c.prefixes, "")
+		for _, line := range strings.Split(strings.Trim(rawText(n), "\n"), "\n") {
+			c.out.WriteString("\n" + prefix + line)
+		}
+		c.out.WriteString("\n" + prefix + "```")
+		c.breakLines(2)
+	case "table":
+		c.table(n)
+	case "en-todo":
+		box := "[ ] "
+		if n.attrs["checked"] == "true" {
+			box = "[x] "
+		}
+		// A checkbox starting a line outside a list makes a task list item.
+		if (c.atLineStart || c.out.Len() == 0 || c.newlines > 0) && c.marker == "" {
+			box = "- " + box
+		}
+		c.write(box)
+		// Self-closing in ENML, but a lenient parser may nest what follows.
+		c.blocks(n)
+	default:
+		c.write(c.inline(n))
+	}
+}
+
+// list converts a list separated from what surrounds it by gap newlines.
+func (c *converter) list(n *node, gap int) {
+	c.breakLines(gap)
+	number := 1
+	for _, item := range n.children {
+		if item.name != "li" {
+			if item.name != "" {
+				c.convert(item)
+			}
+			continue
+		}
+
+		marker := "- "
+		if n.name == "ol" {
+			marker = strconv.Itoa(number) + ". "
+			number++
+		}
+		c.breakLines(1)
+		c.prefixes = append(c.prefixes, strings.Repeat(" ", len(marker)))
+		c.marker = marker
+		c.listItem(item)
+		c.marker = ""
+		c.prefixes = c.prefixes[:len(c.prefixes)-1]
+	}
+	c.breakLines(gap)
+}
+
+// listItem converts an item without blank lines between its blocks, which
+// would make the list loose.
+func (c *converter) listItem(item *node) {
+	for _, child := range item.children {
+		switch child.name {
+		case "p", "div":
+			c.breakLines(1)
+			c.listItem(child)
+			c.breakLines(1)
+		case "ul", "ol":
+			c.list(child, 1)
+		default:
+			c.convert(child)
+			c.newlines = min(c.newlines, 1)
+		}
+	}
+}
+
+func (c *converter) table(n *node) {
+	var rows [][]string
+	var collect func(n *node)
+	collect = func(n *node) {
+		for _, child := range n.children {
+			switch child.name {
+			case "tr":
+				var row []string
+				for _, cell := range child.children {
+					if cell.name == "td" || cell.name == "th" {
+						text := strings.ReplaceAll(c.inline(cell), "|", `\|`)
+						row = append(row, strings.TrimSpace(text))
+					}
+				}
+				rows = append(rows, row)
+			case "thead", "tbody", "tfoot":
+				collect(child)
+			}
+		}
+	}
+	collect(n)
+	if len(rows) == 0 {
+		return
+	}
+
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+
+	c.breakLines(2)
+	for i, row := range rows {
+		for len(row) < columns {
+			row = append(row, "")
+		}
+		c.breakLines(1)
+		c.write("| " + strings.Join(row, " | ") + " |")
+		if i == 0 {
+			c.breakLines(1)
+			c.write("|" + strings.Repeat(" --- |", columns))
+		}
+	}
+	c.breakLines(2)
+}
+
+func (c *converter) text(s string) {
+	s = collapseSpace(s)
+	if c.atLineStart || c.out.Len() == 0 || c.newlines > 0 {
+		s = strings.TrimLeft(s, " ")
+	}
+	c.write(escape(s))
+}
+
+// inline converts n to a single line of Markdown.
+func (c *converter) inline(n *node) string {
+	if n.name == "" {
+		return escape(collapseSpace(n.text))
+	}
+
+	var sb strings.Builder
+	for _, child := range n.children {
+		sb.WriteString(c.inline(child))
+	}
+	inner := sb.String()
+
+	wrap := func(mark string) string {
+		trimmed := strings.TrimSpace(inner)
+		if trimmed == "" {
+			return inner
+		}
+		// Emphasis can't start or end with a space.
+		lead := inner[:len(inner)-len(strings.TrimLeft(inner, " "))]
+		trail := inner[len(strings.TrimRight(inner, " ")):]
+		return lead + mark + trimmed + mark + trail
+	}
+
+	switch n.name {
+	case "b", "strong":
+		return wrap("**")
+	case "i", "em":
+		return wrap("*")
+	case "s", "strike", "del":
+		return wrap("~~")
+	case "code", "tt":
+		return "`" + strings.ReplaceAll(rawText(n), "`", "") + "`"
+	case "a":
+		href := n.attrs["href"]
+		if href == "" || strings.TrimSpace(inner) == "" {
+			return inner
+		}
+		return "[" + inner + "](" + strings.ReplaceAll(href, " ", "%20") + ")"
+	case "img":
+		if src := n.attrs["src"]; src != "" {
+			return "![" + escape(n.attrs["alt"]) + "](" + src + ")"
+		}
+		return ""
+	case "en-media":
+		if c.media == nil {
+			return inner
+		}
+		return c.media(n.attrs["hash"], n.attrs["type"]) + inner
+	case "en-crypt":
+		return "[encrypted content]"
+	case "en-todo":
+		if n.attrs["checked"] == "true" {
+			return "[x] " + inner
+		}
+		return "[ ] " + inner
+	case "br", "p", "div", "li":
+		return " " + inner + " "
+	case "style", "script":
+		return ""
+	default:
+		return inner
+	}
+}
+
+// rawText returns the text inside n as it is, with line breaks for <br> and
+// the block elements Evernote wraps lines of code in.
+func rawText(n *node) string {
+	if n.name == "" {
+		return n.text
+	}
+
+	var sb strings.Builder
+	for _, child := range n.children {
+		sb.WriteString(rawText(child))
+	}
+	switch n.name {
+	case "br":
+		return "\n"
+	case "div", "p":
+		return sb.String() + "\n"
+	}
+	return sb.String()
+}
+
+// collapseSpace turns every run of white space into a single space, the way
+// HTML renders it.
+func collapseSpace(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range s {
+		if isSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+	if space {
+		sb.WriteByte(' ')
+	}
+	return sb.String()
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\u00a0'
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "<", `\<`)
+
+// escape keeps text from being read as Markdown. Brackets are left alone, so
+// that [[wiki links]] typed in Evernote keep working.
+func escape(s string) string {
+	return escaper.Replace(s)
+}
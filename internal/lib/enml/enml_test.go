@@ -0,0 +1,70 @@
+package enml_test
+
+import (
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/enml"
+)
+
+const header = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-note SYSTEM "http://xml.evernote.com/pub/enml2.dtd">
+`
+
+func TestToMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "lines and formatting",
+			src:  header + `<en-note><div>Hello <b>bold</b> and <i>italic </i>text&nbsp;here</div><div><br/></div><div>2 * 3 <s>gone</s></div></en-note>`,
+			want: "Hello **bold** and *italic* text here\n\n2 \\* 3 ~~gone~~\n",
+		},
+		{
+			name: "headings, links and rules",
+			src:  `<en-note><h2>Title</h2><p>See <a href="https://example.com/a b">the site</a></p><hr/><p>end</p></en-note>`,
+			want: "## Title\n\nSee [the site](https://example.com/a%20b)\n\n---\n\nend\n",
+		},
+		{
+			name: "checkboxes",
+			src:  `<en-note><div><en-todo checked="true"/>done</div><div><en-todo/>todo</div></en-note>`,
+			want: "- [x] done\n\n- [ ] todo\n",
+		},
+		{
+			name: "nested lists",
+			src:  `<en-note><ul><li>one<ol><li>a</li><li>b</li></ol></li><li><div>two</div></li></ul></en-note>`,
+			want: "- one\n  1. a\n  2. b\n- two\n",
+		},
+		{
+			name: "code and quotes",
+			src:  `<en-note><pre>if a &lt; b {` + "\n\n" + `}</pre><blockquote><div>quoted</div><div>twice</div></blockquote></en-note>`,
+			want: "```\nif a < b {\n\n}\n```\n\n> quoted\n>\n> twice\n",
+		},
+		{
+			name: "table",
+			src:  `<en-note><table><tr><th>a</th><th>b|c</th></tr><tr><td>1</td></tr></table></en-note>`,
+			want: "| a | b\\|c |\n| --- | --- |\n| 1 |  |\n",
+		},
+		{
+			name: "media and wiki links",
+			src:  `<en-note><div>see [[Other note]]</div><en-media hash="abc" type="image/png"/><en-crypt>xyz</en-crypt></en-note>`,
+			want: "see [[Other note]]\n\n![image/png](abc)[encrypted content]\n",
+		},
+	}
+
+	media := func(hash string, mediaType string) string {
+		return "![" + mediaType + "](" + hash + ")"
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := enml.ToMarkdown(tt.src, media)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
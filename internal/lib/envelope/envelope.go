@@ -0,0 +1,206 @@
+// Package envelope encrypts values with AES-256-GCM under data keys, which
+// are kept wrapped, encrypted themselves, by a master key that is never
+// stored next to them.
+//
+// A sealed value is a version byte, the id of its data key, a nonce and the
+// ciphertext with its tag, Overhead bytes longer than the plaintext.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size in bytes of master and data keys.
+const KeySize = 32
+
+const (
+	version   = 1
+	nonceSize = 12
+	tagSize   = 16
+	// Overhead is how much longer a sealed value is than its plaintext.
+	Overhead = 1 + 4 + nonceSize + tagSize
+)
+
+var (
+	ErrMalformed  = errors.New("malformed sealed value")
+	ErrUnknownKey = errors.New("sealed with an unknown data key")
+	// ErrWrongKey is returned when a value or a data key fails to decrypt:
+	// it was sealed with another key, or tampered with.
+	ErrWrongKey = errors.New("wrong key or tampered value")
+)
+
+// wrapContext is the additional data of wrapped data keys, which keeps them
+// from passing for sealed values and the other way round.
+var wrapContext = []byte("envelope data key")
+
+// NewKey returns a random key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ParseKey decodes a base64 key, as `openssl rand -base64 32` prints one.
+func ParseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("key isn't base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key is %d bytes long, want %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// ReadKeyFile reads a base64 key from a file.
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKey(string(data))
+}
+
+// KeyId is a fingerprint of a master key, which tells which master key
+// wrapped a data key without revealing it.
+func KeyId(master []byte) string {
+	sum := sha256.Sum256(master)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Wrap encrypts a data key with a master key.
+func Wrap(master []byte, dataKey []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, wrapContext), nil
+}
+
+// Unwrap decrypts a data key wrapped by Wrap.
+func Unwrap(master []byte, wrapped []byte) ([]byte, error) {
+	aead, err := newAEAD(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < nonceSize+tagSize {
+		return nil, ErrMalformed
+	}
+	dataKey, err := aead.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], wrapContext)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return dataKey, nil
+}
+
+// Keyring holds the data keys values may be sealed with. New values are
+// sealed with the active key, the one added last.
+type Keyring struct {
+	keys   map[uint32]cipher.AEAD
+	active uint32
+	// index is the key of Blind, derived from the active data key.
+	index []byte
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[uint32]cipher.AEAD)}
+}
+
+// Add adds a data key with its id and makes it the active key.
+func (k *Keyring) Add(id uint32, dataKey []byte) error {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return err
+	}
+	k.keys[id] = aead
+	k.active = id
+	mac := hmac.New(sha256.New, dataKey)
+	mac.Write([]byte("envelope blind index"))
+	k.index = mac.Sum(nil)
+	return nil
+}
+
+// Active returns the id of the active key, 0 if there is none.
+func (k *Keyring) Active() uint32 {
+	return k.active
+}
+
+// Seal encrypts plaintext with the active key. The same additional data must
+// be given to Open, which binds the value to where it is kept.
+func (k *Keyring) Seal(plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, ok := k.keys[k.active]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	sealed := make([]byte, 1+4+nonceSize, Overhead+len(plaintext))
+	sealed[0] = version
+	binary.BigEndian.PutUint32(sealed[1:], k.active)
+	nonce := sealed[5:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a value sealed by Seal with any key of the ring.
+func (k *Keyring) Open(sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < Overhead || sealed[0] != version {
+		return nil, ErrMalformed
+	}
+	id := binary.BigEndian.Uint32(sealed[1:])
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownKey, id)
+	}
+	plaintext, err := aead.Open(nil, sealed[5:5+nonceSize], sealed[5+nonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plaintext, nil
+}
+
+// KeyOf returns the id of the data key a value was sealed with, without
+// opening it.
+func KeyOf(sealed []byte) (uint32, error) {
+	if len(sealed) < Overhead || sealed[0] != version {
+		return 0, ErrMalformed
+	}
+	return binary.BigEndian.Uint32(sealed[1:]), nil
+}
+
+// Blind returns a keyed hash of value under the active key, which lets equal
+// values be found without storing them: a blind index. It reveals which
+// values are equal, and nothing else without the key.
+func (k *Keyring) Blind(value []byte) []byte {
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key is %d bytes long, want %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,129 @@
+package envelope_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/envelope"
+)
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := envelope.NewKey()
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	return key
+}
+
+func TestSealOpen(t *testing.T) {
+	keys := envelope.NewKeyring()
+	if err := keys.Add(1, newKey(t)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	plaintext := []byte("the note")
+
+	sealed, err := keys.Seal(plaintext, []byte("content"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if len(sealed) != len(plaintext)+envelope.Overhead {
+		t.Errorf("sealed value is %d bytes long, want %d", len(sealed), len(plaintext)+envelope.Overhead)
+	}
+	if bytes.Contains(sealed, plaintext) {
+		t.Error("sealed value contains the plaintext")
+	}
+	if again, _ := keys.Seal(plaintext, []byte("content")); bytes.Equal(again, sealed) {
+		t.Error("sealing twice gave the same value")
+	}
+
+	// Keys added later become active, the older ones still open.
+	if err := keys.Add(2, newKey(t)); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	got, err := keys.Open(sealed, []byte("content"))
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("Open = %q, %v, want %q", got, err, plaintext)
+	}
+	if id, err := envelope.KeyOf(sealed); id != 1 || err != nil {
+		t.Errorf("KeyOf = %d, %v, want 1", id, err)
+	}
+	if _, err := envelope.KeyOf(sealed[:envelope.Overhead-1]); !errors.Is(err, envelope.ErrMalformed) {
+		t.Errorf("KeyOf of a truncated value: got %v, want %v", err, envelope.ErrMalformed)
+	}
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name           string
+		sealed         []byte
+		additionalData string
+		want           error
+	}{
+		{"other additional data", sealed, "header", envelope.ErrWrongKey},
+		{"tampered", tampered, "content", envelope.ErrWrongKey},
+		{"truncated", sealed[:envelope.Overhead-1], "content", envelope.ErrMalformed},
+		{"unknown key", append([]byte{1, 0, 0, 0, 9}, sealed[5:]...), "content", envelope.ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := keys.Open(tt.sealed, []byte(tt.additionalData)); !errors.Is(err, tt.want) {
+				t.Errorf("Open: got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	master, dataKey := newKey(t), newKey(t)
+
+	wrapped, err := envelope.Wrap(master, dataKey)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if got, err := envelope.Unwrap(master, wrapped); err != nil || !bytes.Equal(got, dataKey) {
+		t.Fatalf("Unwrap = %x, %v, want %x", got, err, dataKey)
+	}
+	if _, err := envelope.Unwrap(newKey(t), wrapped); !errors.Is(err, envelope.ErrWrongKey) {
+		t.Errorf("Unwrap with another master key: got %v, want %v", err, envelope.ErrWrongKey)
+	}
+	if envelope.KeyId(master) == envelope.KeyId(dataKey) {
+		t.Error("KeyId is the same for different keys")
+	}
+}
+
+func TestBlind(t *testing.T) {
+	key := newKey(t)
+	keys, same := envelope.NewKeyring(), envelope.NewKeyring()
+	keys.Add(1, key)
+	same.Add(7, key)
+	other := envelope.NewKeyring()
+	other.Add(1, newKey(t))
+
+	if !bytes.Equal(keys.Blind([]byte("title")), same.Blind([]byte("title"))) {
+		t.Error("Blind differs under the same data key")
+	}
+	if bytes.Equal(keys.Blind([]byte("title")), keys.Blind([]byte("other"))) {
+		t.Error("Blind is the same for different values")
+	}
+	if bytes.Equal(keys.Blind([]byte("title")), other.Blind([]byte("title"))) {
+		t.Error("Blind is the same under different data keys")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	key := newKey(t)
+
+	if got, err := envelope.ParseKey(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("ParseKey = %x, %v, want %x", got, err, key)
+	}
+	if _, err := envelope.ParseKey(base64.StdEncoding.EncodeToString(key[:16])); err == nil {
+		t.Error("ParseKey of a short key succeeded")
+	}
+	if _, err := envelope.ParseKey("not base64!"); err == nil {
+		t.Error("ParseKey of invalid base64 succeeded")
+	}
+}
@@ -0,0 +1,338 @@
+// Package exif reads and scrubs the EXIF metadata of JPEG files: the
+// orientation a camera recorded, and the GPS position it may have recorded
+// along with it.
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrNotJPEG   = errors.New("not a JPEG file")
+	ErrMalformed = errors.New("malformed JPEG file")
+)
+
+// JPEG markers the scrubber looks at.
+const (
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerAPP1 = 0xe1
+)
+
+// TIFF tags of the IFD0 of EXIF data.
+const (
+	tagOrientation = 0x0112
+	tagGPSIFD      = 0x8825
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// StripGPS copies the JPEG file read from r to w without GPS data: the GPS
+// IFD of its EXIF data is zeroed and unlinked, the rest of the EXIF data is
+// kept. EXIF data too malformed to scrub is dropped, and so is XMP data that
+// mentions a GPS position.
+func StripGPS(w io.Writer, r io.Reader) error {
+	br := bufio.NewReader(r)
+	if err := readSOI(br); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{0xff, markerSOI}); err != nil {
+		return err
+	}
+
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			return err
+		}
+		if !hasLength(marker) {
+			if _, err := w.Write([]byte{0xff, marker}); err != nil {
+				return err
+			}
+			if marker == markerEOI {
+				return nil
+			}
+			continue
+		}
+
+		data, err := readSegment(br)
+		if err != nil {
+			return err
+		}
+		if marker == markerAPP1 {
+			switch {
+			case bytes.HasPrefix(data, exifHeader):
+				if scrubGPS(data[len(exifHeader):]) != nil {
+					continue
+				}
+			case bytes.HasPrefix(data, xmpHeader):
+				if bytes.Contains(data, []byte("exif:GPS")) {
+					continue
+				}
+			}
+		}
+		if err := writeSegment(w, marker, data); err != nil {
+			return err
+		}
+
+		if marker == markerSOS {
+			// The compressed image data follows, to the end of the file.
+			_, err := io.Copy(w, br)
+			return err
+		}
+	}
+}
+
+// Orientation returns the EXIF orientation of the JPEG file read from r,
+// from 1 to 8, or 1 if it has none. Only the segments before the image data
+// are read.
+func Orientation(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	if err := readSOI(br); err != nil {
+		return 1, err
+	}
+
+	for {
+		marker, err := readMarker(br)
+		if err != nil {
+			return 1, err
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return 1, nil
+		}
+		if !hasLength(marker) {
+			continue
+		}
+
+		data, err := readSegment(br)
+		if err != nil {
+			return 1, err
+		}
+		if marker != markerAPP1 || !bytes.HasPrefix(data, exifHeader) {
+			continue
+		}
+
+		t, err := parseTIFF(data[len(exifHeader):])
+		if err != nil {
+			return 1, nil
+		}
+		entry, ok := t.find(t.ifd0, tagOrientation)
+		if !ok || entry.typ != typeShort {
+			return 1, nil
+		}
+		if o := int(t.order.Uint16(entry.value)); o >= 1 && o <= 8 {
+			return o, nil
+		}
+		return 1, nil
+	}
+}
+
+func readSOI(br *bufio.Reader) error {
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if soi != [2]byte{0xff, markerSOI} {
+		return ErrNotJPEG
+	}
+	return nil
+}
+
+// readMarker reads the next marker, skipping the fill bytes before it.
+func readMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, truncated(err)
+	}
+	if b != 0xff {
+		return 0, ErrMalformed
+	}
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, truncated(err)
+		}
+		if b != 0xff {
+			return b, nil
+		}
+	}
+}
+
+// truncated reports the end of the file before its end marker as malformed,
+// other errors of the reader as they are.
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrMalformed
+	}
+	return err
+}
+
+// hasLength tells whether a segment with the marker has a length and data.
+func hasLength(marker byte) bool {
+	return !(marker >= 0xd0 && marker <= markerEOI) && marker != 0x01
+}
+
+func readSegment(br *bufio.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(br, length[:]); err != nil {
+		return nil, truncated(err)
+	}
+	n := int(binary.BigEndian.Uint16(length[:]))
+	if n < 2 {
+		return nil, ErrMalformed
+	}
+	data := make([]byte, n-2)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, truncated(err)
+	}
+	return data, nil
+}
+
+func writeSegment(w io.Writer, marker byte, data []byte) error {
+	header := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)+2))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// scrubGPS zeroes the GPS IFD of TIFF data in place and removes the entry
+// of IFD0 that links it, keeping the length of the data.
+func scrubGPS(data []byte) error {
+	t, err := parseTIFF(data)
+	if err != nil {
+		return err
+	}
+	entry, ok := t.find(t.ifd0, tagGPSIFD)
+	if !ok {
+		return nil
+	}
+
+	gps := int(t.order.Uint32(entry.value))
+	entries, err := t.entries(gps)
+	if err != nil {
+		return err
+	}
+
+	// Shift the entries after the link, and the offset of the next IFD,
+	// over it and leave the freed bytes zeroed.
+	n := int(t.order.Uint16(data[t.ifd0:]))
+	t.order.PutUint16(data[t.ifd0:], uint16(n-1))
+	start := t.ifd0 + 2 + 12*entry.index
+	end := t.ifd0 + 2 + 12*n + 4
+	copy(data[start:end-12], data[start+12:end])
+	clear(data[end-12 : end])
+
+	for _, e := range entries {
+		if e.offset >= 0 {
+			clear(data[e.offset : e.offset+e.size])
+		}
+	}
+	clear(data[gps : gps+2+12*len(entries)+4])
+
+	return nil
+}
+
+// typeShort is the TIFF field type of 16-bit values.
+const typeShort = 3
+
+// typeSizes are the sizes in bytes of the TIFF field types by number.
+var typeSizes = [...]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+	ifd0  int
+}
+
+// entry is a field of an IFD. Values of up to 4 bytes are held in the entry
+// itself, then offset is -1; larger values are at offset of the TIFF data.
+type entry struct {
+	index  int
+	tag    uint16
+	typ    uint16
+	value  []byte
+	offset int
+	size   int
+}
+
+func parseTIFF(data []byte) (tiff, error) {
+	if len(data) < 8 {
+		return tiff{}, ErrMalformed
+	}
+	t := tiff{data: data}
+	switch string(data[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return tiff{}, ErrMalformed
+	}
+	t.ifd0 = int(t.order.Uint32(data[4:]))
+	if _, err := t.entries(t.ifd0); err != nil {
+		return tiff{}, err
+	}
+	return t, nil
+}
+
+// entries returns the entries of the IFD at offset, failing if the IFD or
+// any value is out of the data.
+func (t tiff) entries(offset int) ([]entry, error) {
+	if offset < 8 || offset+2 > len(t.data) {
+		return nil, fmt.Errorf("%w: IFD at %d is out of the EXIF data", ErrMalformed, offset)
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	if offset+2+12*n+4 > len(t.data) {
+		return nil, fmt.Errorf("%w: IFD at %d is out of the EXIF data", ErrMalformed, offset)
+	}
+
+	entries := make([]entry, n)
+	for i := range entries {
+		raw := t.data[offset+2+12*i:]
+		e := entry{
+			index:  i,
+			tag:    t.order.Uint16(raw),
+			typ:    t.order.Uint16(raw[2:]),
+			value:  raw[8:12],
+			offset: -1,
+		}
+		if int(e.typ) < len(typeSizes) && typeSizes[e.typ] > 0 {
+			count := int64(t.order.Uint32(raw[4:]))
+			size := count * int64(typeSizes[e.typ])
+			if size > 4 {
+				off := int64(t.order.Uint32(e.value))
+				if off+size > int64(len(t.data)) {
+					return nil, fmt.Errorf("%w: value of tag %#04x is out of the EXIF data", ErrMalformed, e.tag)
+				}
+				e.offset, e.size = int(off), int(size)
+			}
+		}
+		entries[i] = e
+	}
+	return entries, nil
+}
+
+func (t tiff) find(ifd int, tag uint16) (entry, bool) {
+	entries, err := t.entries(ifd)
+	if err != nil {
+		return entry{}, false
+	}
+	for _, e := range entries {
+		if e.tag == tag {
+			return e, true
+		}
+	}
+	return entry{}, false
+}
@@ -0,0 +1,175 @@
+package exif_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/exif"
+)
+
+// latitude is the value of the GPSLatitude tag of exifData, 55° 45' 12.34".
+var latitude = []uint32{55, 1, 45, 1, 1234, 100}
+
+// exifData builds the content of an EXIF APP1 segment with an orientation
+// and a GPS IFD holding a latitude.
+func exifData(order binary.ByteOrder) []byte {
+	var b bytes.Buffer
+	b.WriteString("Exif\x00\x00")
+	if order == binary.LittleEndian {
+		b.WriteString("II*\x00")
+	} else {
+		b.WriteString("MM\x00*")
+	}
+	put := func(v any) { binary.Write(&b, order, v) }
+	entry := func(tag, typ uint16, count, value uint32) {
+		put(tag)
+		put(typ)
+		put(count)
+		put(value)
+	}
+
+	// IFD0 at 8, the GPS IFD at 38 and the latitude at 56.
+	put(uint32(8))
+	put(uint16(2))
+	if order == binary.LittleEndian {
+		entry(0x0112, 3, 1, 6)
+	} else {
+		entry(0x0112, 3, 1, 6<<16)
+	}
+	entry(0x8825, 4, 1, 38)
+	put(uint32(0))
+	put(uint16(1))
+	entry(0x0002, 5, 3, 56)
+	put(uint32(0))
+	put(latitude)
+
+	return b.Bytes()
+}
+
+// jpegWith encodes a small image as JPEG with the APP1 segments after its
+// start marker.
+func jpegWith(t *testing.T, segments ...[]byte) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	b.Write(encoded.Bytes()[:2])
+	for _, segment := range segments {
+		b.Write([]byte{0xff, 0xe1})
+		binary.Write(&b, binary.BigEndian, uint16(len(segment)+2))
+		b.Write(segment)
+	}
+	b.Write(encoded.Bytes()[2:])
+
+	return b.Bytes()
+}
+
+func latitudeBytes(order binary.ByteOrder) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, order, latitude)
+	return b.Bytes()
+}
+
+func TestStripGPS(t *testing.T) {
+	xmp := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta><rdf:Description exif:GPSLatitude=\"55,45.2N\"/></x:xmpmeta>")
+	plainXMP := []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta><rdf:Description dc:title=\"Park\"/></x:xmpmeta>")
+
+	tests := []struct {
+		name            string
+		in              []byte
+		wantOrientation int
+		wantGone        [][]byte
+		wantKept        [][]byte
+	}{
+		{
+			name:            "little endian",
+			in:              jpegWith(t, exifData(binary.LittleEndian)),
+			wantOrientation: 6,
+			wantGone:        [][]byte{latitudeBytes(binary.LittleEndian)},
+		},
+		{
+			name:            "big endian",
+			in:              jpegWith(t, exifData(binary.BigEndian)),
+			wantOrientation: 6,
+			wantGone:        [][]byte{latitudeBytes(binary.BigEndian)},
+		},
+		{
+			name:            "malformed EXIF is dropped",
+			in:              jpegWith(t, []byte("Exif\x00\x00II*\x00\xff\xff\x00\x00")),
+			wantOrientation: 1,
+			wantGone:        [][]byte{[]byte("Exif")},
+		},
+		{
+			name:            "XMP with a position is dropped",
+			in:              jpegWith(t, xmp, plainXMP),
+			wantOrientation: 1,
+			wantGone:        [][]byte{[]byte("GPSLatitude")},
+			wantKept:        [][]byte{[]byte("dc:title")},
+		},
+		{
+			name:            "no metadata",
+			in:              jpegWith(t),
+			wantOrientation: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := exif.StripGPS(&out, bytes.NewReader(tt.in)); err != nil {
+				t.Fatalf("StripGPS: %v", err)
+			}
+
+			if _, err := jpeg.Decode(bytes.NewReader(out.Bytes())); err != nil {
+				t.Fatalf("decoding the stripped file: %v", err)
+			}
+			if got, err := exif.Orientation(bytes.NewReader(out.Bytes())); err != nil || got != tt.wantOrientation {
+				t.Errorf("Orientation = %d, %v, want %d", got, err, tt.wantOrientation)
+			}
+			for _, gone := range tt.wantGone {
+				if bytes.Contains(out.Bytes(), gone) {
+					t.Errorf("stripped file still contains %q", gone)
+				}
+			}
+			for _, kept := range tt.wantKept {
+				if !bytes.Contains(out.Bytes(), kept) {
+					t.Errorf("stripped file lost %q", kept)
+				}
+			}
+		})
+	}
+}
+
+func TestStripGPSKeepsLength(t *testing.T) {
+	in := jpegWith(t, exifData(binary.LittleEndian))
+
+	var out bytes.Buffer
+	if err := exif.StripGPS(&out, bytes.NewReader(in)); err != nil {
+		t.Fatalf("StripGPS: %v", err)
+	}
+	if out.Len() != len(in) {
+		t.Errorf("stripped file is %d bytes long, want %d", out.Len(), len(in))
+	}
+}
+
+func TestNotJPEG(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+
+	if err := exif.StripGPS(&bytes.Buffer{}, bytes.NewReader(png)); !errors.Is(err, exif.ErrNotJPEG) {
+		t.Errorf("StripGPS: got %v, want %v", err, exif.ErrNotJPEG)
+	}
+	if _, err := exif.Orientation(bytes.NewReader(png)); !errors.Is(err, exif.ErrNotJPEG) {
+		t.Errorf("Orientation: got %v, want %v", err, exif.ErrNotJPEG)
+	}
+	if err := exif.StripGPS(&bytes.Buffer{}, bytes.NewReader([]byte{0xff, 0xd8, 0xff, 0xe1, 0x00})); !errors.Is(err, exif.ErrMalformed) {
+		t.Errorf("StripGPS of a truncated file: got %v, want %v", err, exif.ErrMalformed)
+	}
+}
@@ -0,0 +1,102 @@
+// Package frontmatter writes Markdown documents with a YAML front matter
+// block, the way static site generators and note apps exchange notes.
+package frontmatter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const delimiter = "---\n"
+
+var ErrUnterminated = errors.New("front matter is not terminated")
+
+// Meta is what the front matter of a note holds. Zero fields are left out.
+type Meta struct {
+	Id        int64     `yaml:"id,omitempty"`
+	Header    string    `yaml:"header,omitempty"`
+	CreatedAt time.Time `yaml:"created_at,omitempty"`
+	UpdatedAt time.Time `yaml:"updated_at,omitempty"`
+}
+
+// Format returns body preceded by meta as front matter.
+func Format(meta Meta, body string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(delimiter)
+
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(meta); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	buf.WriteString(delimiter)
+	buf.WriteString(body)
+
+	return buf.Bytes(), nil
+}
+
+// Parse splits src into its front matter and the body after it. A document
+// without front matter is all body. Keys other than those of Meta are
+// ignored, except that title stands in for a missing header as many tools
+// write it that way.
+func Parse(src []byte) (meta Meta, body []byte, err error) {
+	rest, ok := cutLine(src, "---")
+	if !ok {
+		return Meta{}, src, nil
+	}
+
+	var block []byte
+	for {
+		if len(rest) == 0 {
+			return Meta{}, nil, ErrUnterminated
+		}
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		if after, ok := cutLine(rest, "---"); ok {
+			body = after
+			break
+		}
+		if after, ok := cutLine(rest, "..."); ok {
+			body = after
+			break
+		}
+		block = append(block, line...)
+		rest = rest[len(line):]
+	}
+
+	var doc struct {
+		Meta  `yaml:",inline"`
+		Title string `yaml:"title"`
+	}
+	if err := yaml.Unmarshal(block, &doc); err != nil {
+		return Meta{}, nil, fmt.Errorf("invalid front matter: %w", err)
+	}
+	if doc.Header == "" {
+		doc.Header = doc.Title
+	}
+
+	return doc.Meta, body, nil
+}
+
+// cutLine returns what follows the first line of src if that line is want,
+// ignoring trailing spaces and a \r.
+func cutLine(src []byte, want string) (rest []byte, ok bool) {
+	line, rest, found := bytes.Cut(src, []byte("\n"))
+	if string(bytes.TrimRight(line, " \t\r")) != want {
+		return nil, false
+	}
+	if !found {
+		return nil, true
+	}
+	return rest, true
+}
@@ -0,0 +1,122 @@
+package frontmatter_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/frontmatter"
+)
+
+func TestFormat(t *testing.T) {
+	created := time.Date(2025, 1, 2, 15, 4, 5, 6e6, time.UTC)
+
+	tests := []struct {
+		name string
+		meta frontmatter.Meta
+		body string
+		want string
+	}{
+		{
+			name: "all fields",
+			meta: frontmatter.Meta{Id: 7, Header: "Groceries", CreatedAt: created, UpdatedAt: created.Add(time.Hour)},
+			body: "- milk\n",
+			want: "---\nid: 7\nheader: Groceries\ncreated_at: 2025-01-02T15:04:05.006Z\nupdated_at: 2025-01-02T16:04:05.006Z\n---\n- milk\n",
+		},
+		{
+			name: "header that needs quoting",
+			meta: frontmatter.Meta{Id: 1, Header: "key: value #not a comment"},
+			want: "---\nid: 1\nheader: 'key: value #not a comment'\n---\n",
+		},
+		{
+			name: "header that looks like a delimiter",
+			meta: frontmatter.Meta{Header: "---"},
+			body: "body",
+			want: "---\nheader: '---'\n---\nbody",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := frontmatter.Format(tt.meta, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	created := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		src      string
+		wantMeta frontmatter.Meta
+		wantBody string
+		wantErr  error
+	}{
+		{
+			name:     "no front matter",
+			src:      "# Title\n---\nbody",
+			wantBody: "# Title\n---\nbody",
+		},
+		{
+			name:     "front matter",
+			src:      "---\nid: 3\nheader: 'a: b'\ncreated_at: 2025-01-02T15:04:05Z\ntags: [x, y]\n---\nbody\n",
+			wantMeta: frontmatter.Meta{Id: 3, Header: "a: b", CreatedAt: created},
+			wantBody: "body\n",
+		},
+		{
+			name:     "title and CRLF",
+			src:      "---\r\ntitle: Notes\r\n...\r\nbody",
+			wantMeta: frontmatter.Meta{Header: "Notes"},
+			wantBody: "body",
+		},
+		{
+			name:     "empty front matter",
+			src:      "---\n---\n",
+			wantBody: "",
+		},
+		{
+			name:    "unterminated",
+			src:     "---\nheader: x\n",
+			wantErr: frontmatter.ErrUnterminated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, body, err := frontmatter.Parse([]byte(tt.src))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if meta != tt.wantMeta || string(body) != tt.wantBody {
+				t.Errorf("got %+v %q, want %+v %q", meta, body, tt.wantMeta, tt.wantBody)
+			}
+		})
+	}
+
+	if _, _, err := frontmatter.Parse([]byte("---\nheader: [\n---\n")); err == nil {
+		t.Error("invalid YAML: got no error")
+	}
+}
+
+func TestFormatParse(t *testing.T) {
+	meta := frontmatter.Meta{Id: 1, Header: "---\n# not a heading", CreatedAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)}
+	doc, err := frontmatter.Format(meta, "---\nbody")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, body, err := frontmatter.Parse(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != meta || string(body) != "---\nbody" {
+		t.Errorf("round trip: got %+v %q", got, body)
+	}
+}
@@ -0,0 +1,120 @@
+// Package graphexport writes a note graph in the formats graph tools read:
+// GraphViz DOT and GraphML.
+package graphexport
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// DOT writes graph as a GraphViz digraph. Nodes are named n<id> and labelled
+// with the note header, edges are weighted by the number of links.
+func DOT(w io.Writer, graph models.Graph) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "digraph notes {")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(bw, "\tn%d [label=%s];\n", node.Id, dotQuote(node.Header))
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(bw, "\tn%d -> n%d [weight=%d];\n", edge.Source, edge.Target, edge.Links)
+	}
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// dotQuote quotes s as a DOT string. Backslashes are escaped too, so that
+// GraphViz doesn't take them for label escapes like \n.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		Id          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	Id       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLNode struct {
+	Id   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// GraphML writes graph as a directed GraphML document. The header and the
+// degrees of a node and the number of links of an edge are kept as data.
+func GraphML(w io.Writer, graph models.Graph) error {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{Id: "header", For: "node", AttrName: "header", AttrType: "string"},
+			{Id: "in_degree", For: "node", AttrName: "in_degree", AttrType: "int"},
+			{Id: "out_degree", For: "node", AttrName: "out_degree", AttrType: "int"},
+			{Id: "links", For: "edge", AttrName: "links", AttrType: "int"},
+		},
+	}
+	doc.Graph.Id = "notes"
+	doc.Graph.EdgeDefault = "directed"
+
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			Id: nodeId(node.Id),
+			Data: []graphMLData{
+				{Key: "header", Value: node.Header},
+				{Key: "in_degree", Value: fmt.Sprint(node.InDegree)},
+				{Key: "out_degree", Value: fmt.Sprint(node.OutDegree)},
+			},
+		})
+	}
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+			Source: nodeId(edge.Source),
+			Target: nodeId(edge.Target),
+			Data:   []graphMLData{{Key: "links", Value: fmt.Sprint(edge.Links)}},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func nodeId(id int64) string {
+	return fmt.Sprintf("n%d", id)
+}
@@ -0,0 +1,65 @@
+package graphexport_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/graphexport"
+)
+
+var graph = models.Graph{
+	Nodes: []models.GraphNode{
+		{Id: 1, Header: `say "hi" \n`, OutDegree: 1},
+		{Id: 2, Header: "<b>&</b>", InDegree: 1},
+	},
+	Edges: []models.GraphEdge{{Source: 1, Target: 2, Links: 3}},
+}
+
+func TestDOT(t *testing.T) {
+	var sb strings.Builder
+	if err := graphexport.DOT(&sb, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "digraph notes {\n" +
+		"\tn1 [label=\"say \\\"hi\\\" \\\\n\"];\n" +
+		"\tn2 [label=\"<b>&</b>\"];\n" +
+		"\tn1 -> n2 [weight=3];\n" +
+		"}\n"
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestGraphML(t *testing.T) {
+	var sb strings.Builder
+	if err := graphexport.GraphML(&sb, graph); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`<graph id="notes" edgedefault="directed">`,
+		`<data key="header">&lt;b&gt;&amp;&lt;/b&gt;</data>`,
+		`<edge source="n1" target="n2">`,
+		`<data key="links">3</data>`,
+	} {
+		if !strings.Contains(sb.String(), want) {
+			t.Errorf("output doesn't contain %s:\n%s", want, sb.String())
+		}
+	}
+
+	// The output must stay well-formed whatever the headers contain.
+	dec := xml.NewDecoder(strings.NewReader(sb.String()))
+	for {
+		if _, err := dec.Token(); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatalf("malformed XML: %v", err)
+			}
+			break
+		}
+	}
+}
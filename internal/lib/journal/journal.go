@@ -0,0 +1,410 @@
+// Package journal keeps an append-only journal of entries in a directory of
+// segment files.
+//
+// A segment is named after the sequence number of its first entry and holds
+// one JSON line per entry. Every line carries the SHA-256 of the previous
+// one's sum and its own content, chaining the lines of a segment, so an
+// edited, removed or reordered line breaks the chain. A segment that is full
+// is sealed with a line holding the number of its entries; only the last
+// segment may be unsealed, and only its last line may be torn by a crash.
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segmentPrefix = "journal-"
+	segmentSuffix = ".jsonl"
+)
+
+var (
+	// ErrCorrupt is wrapped by the errors of segments that fail their
+	// integrity checks.
+	ErrCorrupt = errors.New("corrupt journal")
+	// ErrTorn is returned after the last entry when the last line of the
+	// journal is incomplete, as a crash during a write leaves it.
+	ErrTorn = errors.New("journal ends in a torn line")
+)
+
+// Entry is a journaled record. Seq numbers entries from 1 without gaps.
+type Entry struct {
+	Seq  int64           `json:"seq"`
+	Time time.Time       `json:"time"`
+	Data json.RawMessage `json:"data"`
+}
+
+type seal struct {
+	Entries int `json:"entries"`
+}
+
+// line is a line of a segment: an entry or a seal.
+type line struct {
+	Seq  int64           `json:"seq,omitempty"`
+	Time time.Time       `json:"time,omitzero"`
+	Data json.RawMessage `json:"data,omitempty"`
+	Seal *seal           `json:"seal,omitempty"`
+	Sum  string          `json:"sum"`
+}
+
+// chain returns the sum of l following the line with sum prev.
+func (l line) chain(prev string) (string, error) {
+	l.Sum = ""
+	content, err := json.Marshal(l)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(prev), content...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Writer appends entries to the journal in a directory.
+type Writer struct {
+	mu      sync.Mutex
+	dir     string
+	maxSize int64
+
+	f       *os.File
+	size    int64
+	entries int
+	seq     int64
+	sum     string
+	// err is the error of a failed write, which may have left part of a
+	// line behind. Nothing more is appended until the journal is reopened
+	// and the line cut off.
+	err error
+}
+
+// Open opens the journal in dir for appending, creating dir if needed. The
+// last segment is verified and a torn last line is cut off; a journal with
+// any other damage isn't opened. A segment is sealed and a new one started
+// once it grows past maxSegmentSize bytes, zero never starts one.
+func Open(dir string, maxSegmentSize int64) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := &Writer{dir: dir, maxSize: maxSegmentSize}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return w, w.startSegment(1)
+	}
+
+	last := segments[len(segments)-1]
+	info, err := readSegment(filepath.Join(dir, last.name), last.firstSeq, nil)
+	if errors.Is(err, ErrTorn) {
+		if err := os.Truncate(filepath.Join(dir, last.name), info.validSize); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if info.sealed {
+		return w, w.startSegment(info.nextSeq)
+	}
+
+	w.f, err = os.OpenFile(filepath.Join(dir, last.name), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w.size, w.entries, w.seq, w.sum = info.validSize, info.entries, info.nextSeq-1, info.sum
+	return w, nil
+}
+
+// Append writes an entry holding data encoded as JSON and syncs it to disk
+// before returning its sequence number.
+func (w *Writer) Append(t time.Time, data any) (seq int64, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return 0, errors.New("journal is closed")
+	}
+	if w.err != nil {
+		return 0, fmt.Errorf("journal failed earlier: %w", w.err)
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return 0, err
+	}
+	l := line{Seq: w.seq + 1, Time: t.UTC(), Data: raw}
+	if err := w.writeLine(l); err != nil {
+		return 0, err
+	}
+	w.seq++
+	w.entries++
+
+	if w.maxSize > 0 && w.size >= w.maxSize {
+		if err := w.rotate(); err != nil {
+			return w.seq, fmt.Errorf("entry %d is written, starting the next segment failed: %w", w.seq, err)
+		}
+	}
+	return w.seq, nil
+}
+
+// Dir returns the directory of the journal.
+func (w *Writer) Dir() string {
+	return w.dir
+}
+
+// Close closes the current segment without sealing it.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+func (w *Writer) writeLine(l line) error {
+	sum, err := l.chain(w.sum)
+	if err != nil {
+		return err
+	}
+	l.Sum = sum
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if _, err := w.f.Write(data); err != nil {
+		w.err = err
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		w.err = err
+		return err
+	}
+	w.size += int64(len(data))
+	w.sum = sum
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	if err := w.writeLine(line{Seal: &seal{Entries: w.entries}}); err != nil {
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	return w.startSegment(w.seq + 1)
+}
+
+func (w *Writer) startSegment(firstSeq int64) error {
+	f, err := os.OpenFile(filepath.Join(w.dir, segmentName(firstSeq)), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if dir, err := os.Open(w.dir); err == nil {
+		// Make the new file itself survive a crash.
+		dir.Sync()
+		dir.Close()
+	}
+
+	w.f, w.size, w.entries, w.seq, w.sum = f, 0, 0, firstSeq-1, ""
+	return nil
+}
+
+// Read verifies the journal in dir segment by segment and calls fn for every
+// entry in order. It stops at the first error of fn or at the first damage
+// found: ErrTorn if the journal only ends in a torn line, which leaves every
+// complete entry read, and an error wrapping ErrCorrupt otherwise.
+func Read(dir string, fn func(entry Entry) error) error {
+	return ReadFrom(dir, 0, fn)
+}
+
+// ReadFrom is Read starting from the segment that holds entry seq: the
+// segments before it are neither verified nor read. fn is still called for
+// the entries of that segment before seq.
+func ReadFrom(dir string, seq int64, fn func(entry Entry) error) error {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	for len(segments) > 1 && segments[1].firstSeq <= seq {
+		segments = segments[1:]
+	}
+
+	next := int64(0)
+	for i, segment := range segments {
+		if next != 0 && segment.firstSeq != next {
+			return fmt.Errorf("%w: %s: starts at entry %d, want %d", ErrCorrupt, segment.name, segment.firstSeq, next)
+		}
+
+		info, err := readSegment(filepath.Join(dir, segment.name), segment.firstSeq, fn)
+		last := i == len(segments)-1
+		if errors.Is(err, ErrTorn) && !last {
+			return fmt.Errorf("%w: %s: torn line in a segment that isn't the last one", ErrCorrupt, segment.name)
+		}
+		if err != nil {
+			return err
+		}
+		if !info.sealed && !last {
+			return fmt.Errorf("%w: %s: not sealed but followed by another segment", ErrCorrupt, segment.name)
+		}
+		next = info.nextSeq
+	}
+
+	return nil
+}
+
+// Prune removes the segments in dir whose entries all have sequence numbers
+// up to throughSeq, oldest first, and returns their names. The last segment
+// is never removed, the journal goes on from it.
+func Prune(dir string, throughSeq int64) (removed []string, err error) {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i+1 < len(segments) && segments[i+1].firstSeq-1 <= throughSeq; i++ {
+		err := os.Remove(filepath.Join(dir, segments[i].name))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, err
+		}
+		removed = append(removed, segments[i].name)
+	}
+	return removed, nil
+}
+
+type segmentFile struct {
+	name     string
+	firstSeq int64
+}
+
+func segmentName(firstSeq int64) string {
+	return fmt.Sprintf("%s%020d%s", segmentPrefix, firstSeq, segmentSuffix)
+}
+
+// listSegments returns the segments in dir in order, ignoring other files.
+func listSegments(dir string) ([]segmentFile, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segmentFile
+	for _, entry := range entries {
+		number, ok := strings.CutPrefix(entry.Name(), segmentPrefix)
+		if !ok {
+			continue
+		}
+		if number, ok = strings.CutSuffix(number, segmentSuffix); !ok {
+			continue
+		}
+		firstSeq, err := strconv.ParseInt(number, 10, 64)
+		if err != nil || firstSeq < 1 {
+			continue
+		}
+		segments = append(segments, segmentFile{name: entry.Name(), firstSeq: firstSeq})
+	}
+	slices.SortFunc(segments, func(a, b segmentFile) int {
+		return cmp.Compare(a.firstSeq, b.firstSeq)
+	})
+
+	return segments, nil
+}
+
+type segmentInfo struct {
+	entries   int
+	nextSeq   int64
+	sum       string
+	sealed    bool
+	validSize int64
+}
+
+// readSegment checks the segment at path and calls fn, if not nil, for its
+// entries. On ErrTorn the info describes the segment up to the torn line.
+func readSegment(path string, firstSeq int64, fn func(Entry) error) (info segmentInfo, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return segmentInfo{}, err
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	corrupt := func(n int, format string, args ...any) error {
+		return fmt.Errorf("%w: %s: line %d: %s", ErrCorrupt, name, n, fmt.Sprintf(format, args...))
+	}
+
+	info.nextSeq = firstSeq
+	r := bufio.NewReader(f)
+	for n := 1; ; n++ {
+		data, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(data) == 0 {
+				return info, nil
+			}
+			// A line without its newline wasn't finished.
+			return info, ErrTorn
+		}
+		if err != nil {
+			return info, err
+		}
+		if info.sealed {
+			return info, corrupt(n, "line after the seal")
+		}
+
+		var l line
+		if err := json.Unmarshal(bytes.TrimSuffix(data, []byte("\n")), &l); err != nil {
+			if _, err := r.Peek(1); errors.Is(err, io.EOF) {
+				return info, ErrTorn
+			}
+			return info, corrupt(n, "%v", err)
+		}
+		sum, err := l.chain(info.sum)
+		if err != nil {
+			return info, err
+		}
+		if sum != l.Sum {
+			return info, corrupt(n, "checksum mismatch")
+		}
+
+		if l.Seal != nil {
+			if l.Seal.Entries != info.entries {
+				return info, corrupt(n, "sealed with %d entries, has %d", l.Seal.Entries, info.entries)
+			}
+			info.sealed = true
+		} else {
+			if l.Seq != info.nextSeq {
+				return info, corrupt(n, "entry %d, want %d", l.Seq, info.nextSeq)
+			}
+			if fn != nil {
+				if err := fn(Entry{Seq: l.Seq, Time: l.Time, Data: l.Data}); err != nil {
+					return info, err
+				}
+			}
+			info.entries++
+			info.nextSeq++
+		}
+		info.sum = sum
+		info.validSize += int64(len(data))
+	}
+}
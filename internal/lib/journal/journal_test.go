@@ -0,0 +1,244 @@
+package journal_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/journal"
+)
+
+var start = time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// write appends n entries numbered from first to a journal in dir.
+func write(t *testing.T, dir string, maxSegmentSize int64, first int, n int) {
+	t.Helper()
+
+	w, err := journal.Open(dir, maxSegmentSize)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer w.Close()
+
+	for i := first; i < first+n; i++ {
+		seq, err := w.Append(start.Add(time.Duration(i)*time.Second), map[string]int{"n": i})
+		if err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+		if seq != int64(i) {
+			t.Fatalf("Append returned %d, want %d", seq, i)
+		}
+	}
+}
+
+// read returns the n of every entry of the journal in dir.
+func read(t *testing.T, dir string) ([]int, error) {
+	t.Helper()
+
+	var got []int
+	err := journal.Read(dir, func(entry journal.Entry) error {
+		var data map[string]int
+		if err := json.Unmarshal(entry.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if !entry.Time.Equal(start.Add(time.Duration(data["n"]) * time.Second)) {
+			t.Errorf("entry %d has time %v", entry.Seq, entry.Time)
+		}
+		got = append(got, data["n"])
+		return nil
+	})
+	return got, err
+}
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestAppendAndRead(t *testing.T) {
+	dir := t.TempDir()
+	// Every segment fills up after two entries.
+	write(t, dir, 200, 1, 3)
+	// Reopening goes on where the journal ended.
+	write(t, dir, 200, 4, 2)
+
+	want := []string{
+		"journal-00000000000000000001.jsonl",
+		"journal-00000000000000000003.jsonl",
+		"journal-00000000000000000005.jsonl",
+	}
+	if got := segments(t, dir); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("segments = %q, want %q", got, want)
+	}
+
+	got, err := read(t, dir)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(got) != 5 || got[0] != 1 || got[4] != 5 {
+		t.Fatalf("entries = %v, want 1 to 5", got)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	// Segments start at entries 1, 3, 5 and 7.
+	write(t, dir, 200, 1, 7)
+
+	// The second segment holds entry 4, which is past 3: it is kept.
+	removed, err := journal.Prune(dir, 3)
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if want := []string{"journal-00000000000000000001.jsonl"}; strings.Join(removed, " ") != strings.Join(want, " ") {
+		t.Fatalf("Prune removed %q, want %q", removed, want)
+	}
+	got, err := read(t, dir)
+	if err != nil || len(got) != 5 || got[0] != 3 {
+		t.Fatalf("Read after Prune = %v, %v, want entries 3 to 7", got, err)
+	}
+
+	// The last segment stays, however far the pruning goes.
+	if _, err := journal.Prune(dir, 100); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if got := segments(t, dir); len(got) != 1 || got[0] != "journal-00000000000000000007.jsonl" {
+		t.Fatalf("segments = %q, want the last one", got)
+	}
+	write(t, dir, 200, 8, 1)
+	if got, err := read(t, dir); err != nil || len(got) != 2 || got[0] != 7 {
+		t.Fatalf("Read = %v, %v, want entries 7 and 8", got, err)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, 200, 1, 5)
+
+	var got []int64
+	err := journal.ReadFrom(dir, 4, func(entry journal.Entry) error {
+		got = append(got, entry.Seq)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadFrom: %v", err)
+	}
+	// Reading starts at the segment that holds entry 4.
+	if len(got) != 3 || got[0] != 3 {
+		t.Fatalf("ReadFrom read entries %v, want 3 to 5", got)
+	}
+}
+
+func TestTornLine(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, 0, 1, 2)
+
+	path := filepath.Join(dir, "journal-00000000000000000001.jsonl")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":3,"time":"2025-`)
+	f.Close()
+
+	got, err := read(t, dir)
+	if !errors.Is(err, journal.ErrTorn) {
+		t.Fatalf("Read: got %v, want %v", err, journal.ErrTorn)
+	}
+	if len(got) != 2 {
+		t.Fatalf("entries = %v, want the two complete ones", got)
+	}
+
+	// Opening cuts the torn line off.
+	write(t, dir, 0, 3, 1)
+	if got, err := read(t, dir); err != nil || len(got) != 3 {
+		t.Fatalf("Read = %v, %v, want 3 entries", got, err)
+	}
+}
+
+func TestCorruption(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, dir string)
+		wantMsg string
+	}{
+		{
+			name: "edited entry",
+			corrupt: func(t *testing.T, dir string) {
+				edit(t, filepath.Join(dir, "journal-00000000000000000001.jsonl"), `{"n":1}`, `{"n":7}`)
+			},
+			wantMsg: "corrupt journal: journal-00000000000000000001.jsonl: line 1: checksum mismatch",
+		},
+		{
+			name: "removed entry",
+			corrupt: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "journal-00000000000000000001.jsonl")
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := strings.SplitAfter(string(data), "\n")
+				os.WriteFile(path, []byte(lines[0]+lines[2]), 0o644)
+			},
+			wantMsg: "corrupt journal: journal-00000000000000000001.jsonl: line 2: checksum mismatch",
+		},
+		{
+			name: "removed segment",
+			corrupt: func(t *testing.T, dir string) {
+				os.Remove(filepath.Join(dir, "journal-00000000000000000003.jsonl"))
+			},
+			wantMsg: "corrupt journal: journal-00000000000000000005.jsonl: starts at entry 5, want 3",
+		},
+		{
+			name: "truncated segment",
+			corrupt: func(t *testing.T, dir string) {
+				path := filepath.Join(dir, "journal-00000000000000000003.jsonl")
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				lines := strings.SplitAfter(string(data), "\n")
+				os.WriteFile(path, []byte(lines[0]+lines[1]), 0o644)
+			},
+			wantMsg: "corrupt journal: journal-00000000000000000003.jsonl: not sealed but followed by another segment",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			write(t, dir, 200, 1, 5)
+			tt.corrupt(t, dir)
+
+			_, err := read(t, dir)
+			if !errors.Is(err, journal.ErrCorrupt) || err.Error() != tt.wantMsg {
+				t.Fatalf("Read: got %v, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func edit(t *testing.T, path string, old string, new string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), old, new, 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,87 @@
+// Package markdown renders note content written in Markdown to HTML that is
+// safe to embed in a page.
+package markdown
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// highlightStyle is the chroma style code blocks are colored with.
+const highlightStyle = "github"
+
+var (
+	// Raw HTML is passed through by the renderer, the policy is what keeps
+	// the output safe.
+	md = goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Footnote,
+			highlighting.NewHighlighting(
+				highlighting.WithStyle(highlightStyle),
+				highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+			),
+		),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		goldmark.WithRendererOptions(html.WithUnsafe()),
+	)
+	policy = newPolicy()
+	css    = highlightCSS()
+)
+
+// ToHTML renders CommonMark with the GitHub extensions (tables, task lists,
+// strikethrough, autolinks) and footnotes. Fenced code blocks are highlighted
+// with the classes styled by CSS.
+func ToHTML(src string) (string, error) {
+	var buf bytes.Buffer
+	if err := md.Convert([]byte(src), &buf); err != nil {
+		return "", err
+	}
+
+	return policy.Sanitize(buf.String()), nil
+}
+
+// CSS returns the stylesheet for highlighted code blocks.
+func CSS() string {
+	return css
+}
+
+// newPolicy allows what user generated content usually needs, plus what the
+// renderer emits for task lists, footnotes, heading anchors and highlighting.
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^(|checked|disabled)$`)).OnElements("input")
+
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^(fn|fnref\d*):\d+$`)).OnElements("li", "sup")
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)).OnElements("h1", "h2", "h3", "h4", "h5", "h6")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|endnotes|backlink)$`)).OnElements("a", "div", "sup")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(footnote-ref|footnote-backref|footnotes)$`)).OnElements("a", "div")
+
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(chroma|[a-z]{1,3})$`)).OnElements("pre", "code", "span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+
+	p.AllowAttrs("style").Matching(regexp.MustCompile(`^text-align:(left|center|right)$`)).OnElements("th", "td")
+
+	return p
+}
+
+func highlightCSS() string {
+	var sb strings.Builder
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	if err := formatter.WriteCSS(&sb, styles.Get(highlightStyle)); err != nil {
+		panic(err)
+	}
+	return sb.String()
+}
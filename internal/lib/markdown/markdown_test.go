@@ -0,0 +1,94 @@
+package markdown_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/markdown"
+)
+
+func TestToHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "commonmark",
+			src:  "# Title\n\nSome *emphasis* and `code`.",
+			want: []string{`<h1 id="title">Title</h1>`, `<em>emphasis</em>`, `<code>code</code>`},
+		},
+		{
+			name: "table",
+			src:  "| a | b |\n|:--|--:|\n| 1 | 2 |",
+			want: []string{`<table>`, `<th style="text-align:left">a</th>`, `<td style="text-align:right">2</td>`},
+		},
+		{
+			name: "task list",
+			src:  "- [x] done\n- [ ] todo",
+			want: []string{`<input checked="" disabled="" type="checkbox"> done`, `<input disabled="" type="checkbox"> todo`},
+		},
+		{
+			name: "strikethrough and autolink",
+			src:  "~~gone~~ https://example.com",
+			want: []string{`<del>gone</del>`, `<a href="https://example.com" rel="nofollow">https://example.com</a>`},
+		},
+		{
+			name: "footnote",
+			src:  "Text[^1]\n\n[^1]: The note.",
+			want: []string{`<sup id="fnref:1"><a href="#fn:1" class="footnote-ref" role="doc-noteref"`, `<li id="fn:1">`, `class="footnote-backref"`},
+		},
+		{
+			name: "highlighted code",
+			src:  "```go\nfunc main() {}\n```",
+			want: []string{`<pre class="chroma">`, `<span class="kd">func</span>`},
+		},
+		{
+			name:    "script",
+			src:     "<script>alert(1)</script>text",
+			want:    []string{"text"},
+			notWant: []string{"<script", "alert"},
+		},
+		{
+			name:    "event handler",
+			src:     `<img src="cat.png" onerror="alert(1)">`,
+			want:    []string{`<img src="cat.png">`},
+			notWant: []string{"onerror"},
+		},
+		{
+			name:    "javascript link",
+			src:     `[click](javascript:alert(1)) <a href="javascript:alert(1)">here</a>`,
+			notWant: []string{"javascript:"},
+		},
+		{
+			name:    "style and iframe",
+			src:     `<p style="position:fixed">x</p><iframe src="https://example.com"></iframe>`,
+			notWant: []string{"style=", "<iframe"},
+		},
+		{
+			name:    "foreign class",
+			src:     `<div class="modal">x</div><span class="footnotes">y</span>`,
+			notWant: []string{`class=`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := markdown.ToHTML(tt.src)
+			if err != nil {
+				t.Fatalf("ToHTML: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("output doesn't contain %s:\n%s", want, got)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("output contains %s:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Package problem renders errors as RFC 7807 problem details.
+package problem
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/requestid"
+)
+
+const (
+	ContentType = "application/problem+json"
+	// TypePrefix is followed by the problem code to form the problem type URI.
+	TypePrefix = "urn:notion:problem:"
+)
+
+// Problem is an RFC 7807 problem details object. Code, RequestId and Errors
+// are extension members: Code is a stable identifier of the problem that
+// clients should rely on rather than on Title or Detail.
+type Problem struct {
+	Type      string              `json:"type" example:"urn:notion:problem:not_found"`
+	Title     string              `json:"title" example:"Not Found"`
+	Status    int                 `json:"status" example:"404"`
+	Detail    string              `json:"detail,omitempty" example:"Failed to edit note: note not found"`
+	Instance  string              `json:"instance,omitempty" example:"/notes/1"`
+	Code      string              `json:"code" example:"not_found"`
+	RequestId string              `json:"request_id,omitempty" example:"4bf92f3577b34da6a3ce929d0e0e4736"`
+	Errors    []models.FieldError `json:"errors,omitempty"`
+	// Extensions holds further members specific to the problem.
+	Extensions map[string]any `json:"-"`
+}
+
+// New creates a problem of the given status and code.
+func New(status int, code string, detail string) *Problem {
+	title := http.StatusText(status)
+	if status == 499 {
+		title = "Client Closed Request"
+	}
+
+	return &Problem{
+		Type:   TypePrefix + code,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	type problem Problem
+	data, err := json.Marshal((*problem)(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return data, err
+	}
+
+	var members map[string]any
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	// Standard members win over extensions with the same name.
+	extended := maps.Clone(p.Extensions)
+	maps.Copy(extended, members)
+
+	return json.Marshal(extended)
+}
+
+// Write responds to r with p, filling in the instance and the request id.
+func Write(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.Instance == "" {
+		p.Instance = r.URL.Path
+	}
+	if p.RequestId == "" {
+		p.RequestId = requestid.FromContext(r.Context())
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
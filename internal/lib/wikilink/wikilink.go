@@ -0,0 +1,132 @@
+// Package wikilink finds [[wiki links]] in Markdown.
+//
+// A link is written as [[Title]] or [[#42]] to point at a note by title or by
+// id, optionally followed by a label: [[Title|label]]. Links inside code spans
+// and fenced code blocks are ignored.
+package wikilink
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+var (
+	linkRe     = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|[^\[\]\n]*)?\]\]`)
+	idTargetRe = regexp.MustCompile(`^#([1-9][0-9]*)$`)
+)
+
+// Parse returns the distinct targets linked from content in order of first
+// appearance. Titles are compared case-insensitively.
+func Parse(content string) []models.LinkTarget {
+	var targets []models.LinkTarget
+	seen := make(map[string]bool)
+
+	for _, text := range prose(content) {
+		for _, m := range linkRe.FindAllStringSubmatch(stripCodeSpans(text), -1) {
+			target := ParseTarget(strings.TrimSpace(m[1]))
+			if target == (models.LinkTarget{}) {
+				continue
+			}
+
+			key := strings.ToLower(target.String())
+			if !seen[key] {
+				seen[key] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+
+	return targets
+}
+
+// ParseTarget reads a target the way it is written inside the brackets, the
+// inverse of models.LinkTarget.String.
+func ParseTarget(s string) models.LinkTarget {
+	if m := idTargetRe.FindStringSubmatch(s); m != nil {
+		if id, err := strconv.ParseInt(m[1], 10, 64); err == nil {
+			return models.LinkTarget{Id: id}
+		}
+	}
+	return models.LinkTarget{Title: s}
+}
+
+// prose splits content into the runs of lines outside fenced code blocks.
+func prose(content string) []string {
+	var runs []string
+	var run strings.Builder
+	fence := ""
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) && strings.TrimSpace(strings.TrimLeft(trimmed, fence[:1])) == "" {
+				fence = ""
+			}
+			continue
+		}
+
+		if opener := fenceOf(trimmed); opener != "" {
+			fence = opener
+			runs = append(runs, run.String())
+			run.Reset()
+			continue
+		}
+		run.WriteString(line)
+	}
+
+	return append(runs, run.String())
+}
+
+// fenceOf returns the fence a line opens, e.g. "````", or "".
+func fenceOf(line string) string {
+	for _, c := range "`~" {
+		if n := len(line) - len(strings.TrimLeft(line, string(c))); n >= 3 {
+			return line[:n]
+		}
+	}
+	return ""
+}
+
+// stripCodeSpans removes inline code: a run of backticks up to the next run
+// of the same length.
+func stripCodeSpans(text string) string {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(text, '`')
+		if start < 0 {
+			sb.WriteString(text)
+			return sb.String()
+		}
+		sb.WriteString(text[:start])
+
+		n := len(text[start:]) - len(strings.TrimLeft(text[start:], "`"))
+		ticks, rest := text[start:start+n], text[start+n:]
+
+		end := -1
+		for i := 0; i < len(rest); {
+			j := strings.Index(rest[i:], ticks)
+			if j < 0 {
+				break
+			}
+			j += i
+			if k := j + n; (k == len(rest) || rest[k] != '`') && (j == 0 || rest[j-1] != '`') {
+				end = j
+				break
+			}
+			i = j + n
+			for i < len(rest) && rest[i] == '`' {
+				i++
+			}
+		}
+		if end < 0 {
+			// An unmatched run of backticks is literal text.
+			sb.WriteString(ticks)
+			text = rest
+			continue
+		}
+		text = rest[end+n:]
+	}
+}
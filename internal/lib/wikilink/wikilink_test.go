@@ -0,0 +1,38 @@
+package wikilink_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/wikilink"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []models.LinkTarget
+	}{
+		{"none", "plain [text](https://example.com)", nil},
+		{"title", "see [[Shopping list]]", []models.LinkTarget{{Title: "Shopping list"}}},
+		{"id", "see [[#42]]", []models.LinkTarget{{Id: 42}}},
+		{"label", "[[Shopping list|the list]] and [[#7|seven]]", []models.LinkTarget{{Title: "Shopping list"}, {Id: 7}}},
+		{"trimmed", "[[  Spaced  ]]", []models.LinkTarget{{Title: "Spaced"}}},
+		{"not an id", "[[#tag]] [[#0]] [[#12a]]", []models.LinkTarget{{Title: "#tag"}, {Title: "#0"}, {Title: "#12a"}}},
+		{"duplicates", "[[A]] [[a]] [[B]] [[A|again]]", []models.LinkTarget{{Title: "A"}, {Title: "B"}}},
+		{"empty and broken", "[[]] [[ ]] [[a\nb]] [[unclosed", nil},
+		{"code span", "`[[code]]` ``[[more ` code]]`` [[real]]", []models.LinkTarget{{Title: "real"}}},
+		{"unmatched backtick", "a ` [[real]]", []models.LinkTarget{{Title: "real"}}},
+		{"fenced code", "[[before]]\n```\n[[inside]]\n```\n~~~~md\n[[tilde]]\n~~~~\n[[after]]", []models.LinkTarget{{Title: "before"}, {Title: "after"}}},
+		{"unclosed fence", "[[before]]\n```\n[[inside]]", []models.LinkTarget{{Title: "before"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := wikilink.Parse(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %v, want %v", tt.content, got, tt.want)
+			}
+		})
+	}
+}
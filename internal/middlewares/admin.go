@@ -0,0 +1,43 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/problem"
+)
+
+// AdminPrefix starts the paths of the routes that AdminMiddleware guards.
+const AdminPrefix = "/admin/"
+
+// AdminMiddleware lets requests to the routes under AdminPrefix through only
+// with an "Authorization: Bearer <token>" header, and rejects the others
+// with 401. Without a token the routes are not served at all and answer 404.
+func AdminMiddleware(next http.Handler, token string) http.Handler {
+	// Hashes are compared so that the time taken gives away nothing about
+	// the token, not even its length.
+	want := sha256.Sum256([]byte(token))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, AdminPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if token == "" {
+			problem.Write(w, r, problem.New(http.StatusNotFound, "not_found", "Not found"))
+			return
+		}
+
+		scheme, got, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		gotHash := sha256.Sum256([]byte(got))
+		if !ok || !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare(gotHash[:], want[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			problem.Write(w, r, problem.New(http.StatusUnauthorized, "unauthorized",
+				"Admin routes need an Authorization: Bearer header with the admin token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"net/http"
+	"time"
+)
+
+// BodyDeadlineMiddleware lifts the read deadline of the server for the
+// routes whose bodies may take longer to arrive than the read timeout, like
+// imports and uploads, keyed by http.ServeMux patterns like "POST /import".
+// How much they may send is up to BodyLimitMiddleware.
+func BodyDeadlineMiddleware(next http.Handler, patterns ...string) http.Handler {
+	routes := http.NewServeMux()
+	for _, pattern := range patterns {
+		routes.Handle(pattern, http.NotFoundHandler())
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := routes.Handler(r); pattern != "" {
+			// Writers that can't tell the connection, like recorders in
+			// tests, are left as they are.
+			http.NewResponseController(w).SetReadDeadline(time.Time{})
+		}
+		next.ServeHTTP(w, r)
+	})
+}
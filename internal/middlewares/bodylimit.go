@@ -0,0 +1,46 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/problem"
+)
+
+// BodyLimitMiddleware rejects request bodies larger than maxBytes with 413.
+// Bodies that announce their size are rejected upfront, others fail with an
+// *http.MaxBytesError once the handler reads past the limit.
+//
+// overrides sets other limits for some routes, keyed by http.ServeMux
+// patterns like "POST /import" or "POST /notes/{id}/attachments". An override
+// of zero lifts the limit.
+func BodyLimitMiddleware(next http.Handler, maxBytes int64, overrides map[string]int64) http.Handler {
+	routes := http.NewServeMux()
+	for pattern := range overrides {
+		routes.Handle(pattern, http.NotFoundHandler())
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := maxBytes
+		// Requests that the mux would redirect come back with a path for
+		// their pattern, which isn't an override.
+		if _, pattern := routes.Handler(r); pattern != "" {
+			if override, ok := overrides[pattern]; ok {
+				limit = override
+			}
+		}
+		if limit <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.ContentLength > limit {
+			problem.Write(w, r, problem.New(http.StatusRequestEntityTooLarge, "too_large",
+				"Request body is too large: the limit is "+strconv.FormatInt(limit, 10)+" bytes"))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,226 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/problem"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	// maxBufferedRequestBytes is how much of a request body is kept in
+	// memory, larger bodies are spooled to a temporary file. How large a
+	// body may be is up to the body limits of the route.
+	maxBufferedRequestBytes = 1 << 20
+	// statusClientClosedRequest is what handlers respond with when the client
+	// gave up, such a request didn't complete and may be retried.
+	statusClientClosedRequest = 499
+)
+
+type IdempotencyStore interface {
+	// ReserveIdempotencyKey stores rec as in progress unless a record for the
+	// same key exists that was completed after expiredBefore, or is still in
+	// progress and was reserved after abandonedBefore. In that case the
+	// existing record is returned and reserved is false.
+	ReserveIdempotencyKey(ctx context.Context, rec models.IdempotencyRecord, expiredBefore time.Time, abandonedBefore time.Time) (existing models.IdempotencyRecord, reserved bool, err error)
+	// CompleteIdempotencyKey stores the response of the key reserved at
+	// reservedAt. Nothing is stored if the reservation was abandoned and the
+	// key reserved again since.
+	CompleteIdempotencyKey(ctx context.Context, key string, reservedAt time.Time, status int, contentType string, body []byte) (err error)
+	// ReleaseIdempotencyKey forgets the key reserved at reservedAt so the
+	// request can be retried.
+	ReleaseIdempotencyKey(ctx context.Context, key string, reservedAt time.Time) (err error)
+}
+
+// IdempotencyMiddleware makes POST requests that carry an Idempotency-Key
+// header safe to retry. The first request with a key is served and its
+// response is stored for ttl; retries with the same key and body get the
+// stored response replayed, reusing the key with a different request is
+// rejected with 422. Server errors and canceled requests are not stored, so
+// such requests can be retried with the same key. Retries get 409 while the
+// first request is in progress, but for no longer than lease: a request that
+// doesn't complete by then is taken for abandoned, e.g. by a crash, and the
+// key may be reserved again. A zero lease keeps them in progress for ttl.
+func IdempotencyMiddleware(next http.Handler, store IdempotencyStore, ttl time.Duration, lease time.Duration, log *slog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			problem.Write(w, r, problem.New(http.StatusBadRequest, "malformed_request", "Idempotency-Key is too long"))
+			return
+		}
+
+		hash := sha256.New()
+		hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+		remove, err := rereadableBody(r, hash)
+		var readErr *bodyReadError
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			problem.Write(w, r, problem.New(http.StatusRequestEntityTooLarge, "too_large", "Failed to read request body: "+err.Error()))
+			return
+		case errors.As(err, &readErr):
+			problem.Write(w, r, problem.New(http.StatusBadRequest, "malformed_request", "Failed to read request body: "+err.Error()))
+			return
+		case err != nil:
+			problem.Write(w, r, problem.New(http.StatusInternalServerError, "internal", "Failed to read request body"))
+			log.Error("Failed to spool request body", sl.Err(err))
+			return
+		}
+		defer remove()
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		if lease <= 0 {
+			lease = ttl
+		}
+		now := time.Now()
+		existing, reserved, err := store.ReserveIdempotencyKey(r.Context(), models.IdempotencyRecord{
+			Key:         key,
+			RequestHash: requestHash,
+			CreatedAt:   now,
+		}, now.Add(-ttl), now.Add(-lease))
+		if err != nil {
+			problem.Write(w, r, problem.New(http.StatusInternalServerError, "internal", "Failed to check Idempotency-Key"))
+			log.Error("Failed to reserve idempotency key", sl.Err(err))
+			return
+		}
+
+		if !reserved {
+			switch {
+			case existing.RequestHash != requestHash:
+				problem.Write(w, r, problem.New(http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used with a different request"))
+			case existing.Status == 0:
+				problem.Write(w, r, problem.New(http.StatusConflict, "request_in_progress", "A request with this Idempotency-Key is still in progress"))
+			default:
+				if existing.ContentType != "" {
+					w.Header().Set("Content-Type", existing.ContentType)
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(existing.Status)
+				w.Write(existing.Body)
+			}
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			// The request context may be canceled by now, the outcome must be
+			// stored regardless.
+			ctx := context.WithoutCancel(r.Context())
+
+			if p := recover(); p != nil || rec.status >= http.StatusInternalServerError || rec.status == statusClientClosedRequest {
+				if err := store.ReleaseIdempotencyKey(ctx, key, now); err != nil {
+					log.Error("Failed to release idempotency key", sl.Err(err))
+				}
+				if p != nil {
+					panic(p)
+				}
+				return
+			}
+
+			if err := store.CompleteIdempotencyKey(ctx, key, now, rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes()); err != nil {
+				log.Error("Failed to store idempotent response", sl.Err(err))
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// bodyReadError is an error reading the body of a request, rather than
+// spooling it.
+type bodyReadError struct {
+	err error
+}
+
+func (e *bodyReadError) Error() string { return e.err.Error() }
+func (e *bodyReadError) Unwrap() error { return e.err }
+
+// bodyReader wraps the errors of r in bodyReadError.
+type bodyReader struct {
+	r io.Reader
+}
+
+func (b bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = &bodyReadError{err}
+	}
+	return n, err
+}
+
+// rereadableBody reads the body of r into hash and replaces it by a copy,
+// kept in memory or in a temporary file that remove deletes.
+func rereadableBody(r *http.Request, hash io.Writer) (remove func(), err error) {
+	body := bodyReader{r.Body}
+	var buffered bytes.Buffer
+	n, err := io.CopyN(io.MultiWriter(&buffered, hash), body, maxBufferedRequestBytes+1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if n <= maxBufferedRequestBytes {
+		r.Body = io.NopCloser(&buffered)
+		return func() {}, nil
+	}
+
+	f, err := os.CreateTemp("", "idempotent-request-*")
+	if err != nil {
+		return nil, err
+	}
+	remove = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err := f.Write(buffered.Bytes()); err != nil {
+		remove()
+		return nil, err
+	}
+	if _, err := io.Copy(io.MultiWriter(f, hash), body); err != nil {
+		remove()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		remove()
+		return nil, err
+	}
+	r.Body = io.NopCloser(f)
+	return remove, nil
+}
+
+// recorder passes a response through while keeping a copy of it.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -3,6 +3,8 @@ package middlewares
 import (
 	"log/slog"
 	"net/http"
+
+	"github.com/sergeyreshetnyakov/notion/internal/lib/requestid"
 )
 
 func LoggingMiddleware(next http.Handler, log *slog.Logger) http.Handler {
@@ -11,6 +13,7 @@ func LoggingMiddleware(next http.Handler, log *slog.Logger) http.Handler {
 			"Incoming request",
 			slog.String("method", r.Method),
 			slog.String("url", r.URL.Path),
+			slog.String("request_id", requestid.FromContext(r.Context())),
 		)
 		next.ServeHTTP(w, r)
 	})
@@ -0,0 +1,201 @@
+package notestorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/attachments"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+var ErrAttachmentNotFound = attachments.ErrAttachmentNotFound
+
+func (s *Storage) AddAttachment(ctx context.Context, attachment models.Attachment) (id int64, err error) {
+	const op = "storage.AddAttachment"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	res, err := s.write.addAttachment.ExecContext(ctx,
+		attachment.NoteId,
+		attachment.Name,
+		attachment.MediaType,
+		attachment.Size,
+		attachment.SHA256,
+		attachment.Width,
+		attachment.Height,
+		attachment.Format,
+		attachment.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	if rows, err := res.RowsAffected(); rows == 0 {
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrNoteNotFound
+	}
+
+	return res.LastInsertId()
+}
+
+func (s *Storage) RestoreAttachment(ctx context.Context, attachment models.Attachment) (err error) {
+	const op = "storage.RestoreAttachment"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	res, err := s.write.restoreAttachment.ExecContext(ctx,
+		attachment.Id,
+		attachment.NoteId,
+		attachment.Name,
+		attachment.MediaType,
+		attachment.Size,
+		attachment.SHA256,
+		attachment.Width,
+		attachment.Height,
+		attachment.Format,
+		attachment.CreatedAt.UnixMilli(),
+	)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); rows == 0 {
+		if err != nil {
+			return err
+		}
+		return ErrNoteNotFound
+	}
+
+	return nil
+}
+
+func (s *Storage) GetAttachments(ctx context.Context, noteId int64) (attachments []models.Attachment, err error) {
+	const op = "storage.GetAttachments"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	rows, err := s.read.getAttachments.QueryContext(ctx, noteId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+func (s *Storage) GetAllAttachments(ctx context.Context) (attachments []models.Attachment, err error) {
+	const op = "storage.GetAllAttachments"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	rows, err := s.read.getAllAttachments.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		attachment, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, rows.Err()
+}
+
+func (s *Storage) GetAttachment(ctx context.Context, noteId int64, id int64) (attachment models.Attachment, err error) {
+	const op = "storage.GetAttachment"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	attachment, err = scanAttachment(s.read.getAttachment.QueryRowContext(ctx, noteId, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Attachment{}, ErrAttachmentNotFound
+	}
+	return attachment, err
+}
+
+func (s *Storage) DeleteAttachment(ctx context.Context, noteId int64, id int64) (err error) {
+	const op = "storage.DeleteAttachment"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	res, err := s.write.deleteAttachment.ExecContext(ctx, noteId, id)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); rows == 0 {
+		if err != nil {
+			return err
+		}
+		return ErrAttachmentNotFound
+	}
+	return nil
+}
+
+func (s *Storage) CountBlobReferences(ctx context.Context, sha256 string) (n int, err error) {
+	const op = "storage.CountBlobReferences"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	err = s.read.countBlobReferences.QueryRowContext(ctx, sha256).Scan(&n)
+	return n, err
+}
+
+func (s *Storage) GetBlobs(ctx context.Context) (digests []string, err error) {
+	const op = "storage.GetBlobs"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	rows, err := s.read.getBlobs.QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+
+	return digests, rows.Err()
+}
+
+// scanAttachment reads an attachment selected as id, note_id, name,
+// media_type, size, sha256, width, height, format, created_at.
+func scanAttachment(row interface{ Scan(dest ...any) error }) (attachment models.Attachment, err error) {
+	var createdAt int64
+	err = row.Scan(
+		&attachment.Id,
+		&attachment.NoteId,
+		&attachment.Name,
+		&attachment.MediaType,
+		&attachment.Size,
+		&attachment.SHA256,
+		&attachment.Width,
+		&attachment.Height,
+		&attachment.Format,
+		&createdAt,
+	)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+	attachment.CreatedAt = time.UnixMilli(createdAt).UTC()
+
+	return attachment, nil
+}
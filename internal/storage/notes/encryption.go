@@ -0,0 +1,455 @@
+package notestorage
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/envelope"
+)
+
+// ErrEncrypted is returned when a sealed value is read without a master key.
+var ErrEncrypted = errors.New("note is encrypted, a master key is needed to read it")
+
+// crypt seals the headers and contents of notes, and the targets of their
+// links, before they are stored. A nil crypt stores them as they are. Values
+// stored before encryption was turned on stay readable, as TEXT is told from
+// the BLOBs sealed values are stored as.
+type crypt struct {
+	keys *envelope.Keyring
+	// columnOnly are the data keys from before values were bound to the id
+	// of their note, whose values are bound to their column only.
+	columnOnly map[uint32]bool
+	// titleIndex stores a keyed hash of titles, which lets [[title]] links
+	// resolve. Without it they are all broken.
+	titleIndex bool
+}
+
+// seal returns what value is stored as. A sealed value is bound to the
+// column it is stored in and to the note it belongs to, id, so that it
+// can't pass for the value of another column or note.
+func (c *crypt) seal(value string, column string, id int64) (any, error) {
+	if c == nil {
+		return value, nil
+	}
+	return c.keys.Seal([]byte(value), additionalData(column, id))
+}
+
+// open is the inverse of seal. A NULL value is empty.
+func (c *crypt) open(value any, column string, id int64) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case []byte:
+		if c == nil {
+			return "", ErrEncrypted
+		}
+		ad := additionalData(column, id)
+		if key, err := envelope.KeyOf(value); err == nil && c.columnOnly[key] {
+			ad = []byte(column)
+		}
+		plaintext, err := c.keys.Open(value, ad)
+		if err != nil {
+			return "", fmt.Errorf("cannot decrypt %s: %w", column, err)
+		}
+		return string(plaintext), nil
+	default:
+		return "", fmt.Errorf("unexpected %T in %s", value, column)
+	}
+}
+
+func additionalData(column string, id int64) []byte {
+	return []byte(column + ":" + strconv.FormatInt(id, 10))
+}
+
+// sealBody returns what the response body kept for an idempotency key is
+// stored as, and whether it is sealed: responses hold notes too. A sealed
+// body is bound to its key.
+func (c *crypt) sealBody(body []byte, key string) ([]byte, bool, error) {
+	if c == nil || body == nil {
+		return body, false, nil
+	}
+	sealed, err := c.keys.Seal(body, []byte("body:"+key))
+	if err != nil {
+		return nil, false, err
+	}
+	return sealed, true, nil
+}
+
+// openBody is the inverse of sealBody.
+func (c *crypt) openBody(body []byte, sealed bool, key string) ([]byte, error) {
+	if !sealed {
+		return body, nil
+	}
+	if c == nil {
+		return nil, ErrEncrypted
+	}
+	plaintext, err := c.keys.Open(body, []byte("body:"+key))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt the body kept for idempotency key %q: %w", key, err)
+	}
+	return plaintext, nil
+}
+
+// titleKey returns the title_key of a note with the header, or of a link to
+// the title: a keyed hash of it with ASCII folded to lower case, to match the
+// way the NOCASE collation compares plaintext titles. It is NULL unless the
+// title index is on.
+func (c *crypt) titleKey(title string) any {
+	if c == nil || !c.titleIndex {
+		return nil
+	}
+	folded := []byte(title)
+	for i, b := range folded {
+		folded[i] = lowerASCII(b)
+	}
+	return c.keys.Blind(folded)
+}
+
+// loadKeys unwraps the data keys of the database with the master key of the
+// options, adding one if there is none yet or if the newest one doesn't bind
+// values to their note. Without a master key the database must have no data
+// keys, or the notes they sealed couldn't be read.
+func (s *Storage) loadKeys(ctx context.Context) error {
+	rows, err := s.writer.QueryContext(ctx, "SELECT id, wrapped, master_key_id, binds_id FROM data_keys ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	master := s.opts.MasterKey
+	keys := envelope.NewKeyring()
+	columnOnly := make(map[uint32]bool)
+	for rows.Next() {
+		var id uint32
+		var wrapped []byte
+		var masterKeyId string
+		var bindsId bool
+		if err := rows.Scan(&id, &wrapped, &masterKeyId, &bindsId); err != nil {
+			return err
+		}
+		if !bindsId {
+			columnOnly[id] = true
+		}
+		if master == nil {
+			return errors.New("the database is encrypted, but no master key is set")
+		}
+		if masterKeyId != envelope.KeyId(master) {
+			return fmt.Errorf("data key %d is wrapped by master key %s, not by the one set, %s", id, masterKeyId, envelope.KeyId(master))
+		}
+		dataKey, err := envelope.Unwrap(master, wrapped)
+		if err != nil {
+			return fmt.Errorf("cannot unwrap data key %d: %w", id, err)
+		}
+		if err := keys.Add(id, dataKey); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if master == nil {
+		return nil
+	}
+
+	// New values are sealed with a key that binds them to their note; the
+	// older keys stay to open the values they sealed, until Reencrypt.
+	if active := keys.Active(); active == 0 || columnOnly[active] {
+		dataKey, id, err := newDataKey(ctx, s.writer, master)
+		if err != nil {
+			return err
+		}
+		if err := keys.Add(id, dataKey); err != nil {
+			return err
+		}
+	}
+	s.crypt = &crypt{keys: keys, columnOnly: columnOnly, titleIndex: s.opts.TitleIndex}
+	return nil
+}
+
+// addDataKey stores a new data key wrapped by master, and returns a keyring
+// with only that key.
+func addDataKey(ctx context.Context, db queryRower, master []byte) (*envelope.Keyring, error) {
+	dataKey, id, err := newDataKey(ctx, db, master)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := envelope.NewKeyring()
+	return keys, keys.Add(id, dataKey)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// newDataKey stores a new data key wrapped by master, which binds the values
+// it seals to their note, and returns it with its id.
+func newDataKey(ctx context.Context, db queryRower, master []byte) (dataKey []byte, id uint32, err error) {
+	dataKey, err = envelope.NewKey()
+	if err != nil {
+		return nil, 0, err
+	}
+	wrapped, err := envelope.Wrap(master, dataKey)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = db.QueryRowContext(ctx,
+		"INSERT INTO data_keys(wrapped, master_key_id, binds_id, created_at) VALUES(?, ?, 1, ?) RETURNING id",
+		wrapped, envelope.KeyId(master), now().UnixMilli(),
+	).Scan(&id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return dataKey, id, nil
+}
+
+// ReencryptReport tells what Reencrypt did.
+type ReencryptReport struct {
+	Notes int
+	Links int
+	// IdempotencyKeys counts the response bodies kept for idempotency keys.
+	IdempotencyKeys int
+	// MasterKeyId is the fingerprint of the master key the new data key is
+	// wrapped by.
+	MasterKeyId string
+}
+
+// reencryptBatch is how many rows Reencrypt reads at once.
+const reencryptBatch = 256
+
+// Reencrypt seals every note, link and response body kept for an idempotency
+// key under a new data key, wrapped by master, or by the current master key
+// if master is nil, and drops the old data keys. Notes stored before
+// encryption was turned on are encrypted, values bound to their column only are bound to their note as well, and
+// title keys are set or cleared as the title index now says. The database is
+// vacuumed afterwards, so that the old values leave its file.
+//
+// It runs in one transaction that isn't bound by the query timeout. Servers
+// using the database keep the data keys they loaded, so it must be run with
+// them stopped.
+func (s *Storage) Reencrypt(ctx context.Context, master []byte) (report ReencryptReport, err error) {
+	if s.crypt == nil {
+		return ReencryptReport{}, errors.New("encryption is off, no master key is set")
+	}
+	if master == nil {
+		master = s.opts.MasterKey
+	}
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	keys, err := addDataKey(ctx, tx, master)
+	if err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+	next := &crypt{keys: keys, titleIndex: s.opts.TitleIndex}
+
+	if report.Notes, err = s.reencryptNotes(ctx, tx, next); err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+	if report.Links, err = s.reencryptLinks(ctx, tx, next); err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+	if report.IdempotencyKeys, err = s.reencryptBodies(ctx, tx, next); err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM data_keys WHERE id != ?", keys.Active()); err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+	s.crypt = next
+	s.opts.MasterKey = master
+
+	// The old values may linger in the WAL and in free pages, as the
+	// database may have been written without secure delete.
+	if _, err := s.writer.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+	if _, err := s.writer.ExecContext(ctx, "VACUUM"); err != nil {
+		return ReencryptReport{}, storageError(ctx, err)
+	}
+
+	report.MasterKeyId = envelope.KeyId(master)
+	return report, nil
+}
+
+func (s *Storage) reencryptNotes(ctx context.Context, tx *sql.Tx, next *crypt) (n int, err error) {
+	type row struct {
+		id              int64
+		header, content any
+	}
+
+	var after int64
+	for {
+		var batch []row
+		rows, err := tx.QueryContext(ctx, "SELECT id, header, content FROM notes WHERE id > ? ORDER BY id LIMIT ?", after, reencryptBatch)
+		if err != nil {
+			return n, err
+		}
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.header, &r.content); err != nil {
+				rows.Close()
+				return n, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return n, err
+		}
+		if len(batch) == 0 {
+			return n, nil
+		}
+
+		for _, r := range batch {
+			header, err := s.crypt.open(r.header, "header", r.id)
+			if err != nil {
+				return n, fmt.Errorf("note %d: %w", r.id, err)
+			}
+			content, err := s.crypt.open(r.content, "content", r.id)
+			if err != nil {
+				return n, fmt.Errorf("note %d: %w", r.id, err)
+			}
+			sealedHeader, err := next.seal(header, "header", r.id)
+			if err != nil {
+				return n, err
+			}
+			sealedContent, err := next.seal(content, "content", r.id)
+			if err != nil {
+				return n, err
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE notes SET header = ?, content = ?, title_key = ? WHERE id = ?",
+				sealedHeader, sealedContent, next.titleKey(header), r.id); err != nil {
+				return n, err
+			}
+			n++
+		}
+		after = batch[len(batch)-1].id
+	}
+}
+
+func (s *Storage) reencryptLinks(ctx context.Context, tx *sql.Tx, next *crypt) (n int, err error) {
+	type row struct {
+		rowid    int64
+		sourceId int64
+		target   any
+		targetId sql.NullInt64
+	}
+
+	var after int64
+	for {
+		var batch []row
+		rows, err := tx.QueryContext(ctx, "SELECT rowid, source_id, target, target_id FROM links WHERE rowid > ? ORDER BY rowid LIMIT ?", after, reencryptBatch)
+		if err != nil {
+			return n, err
+		}
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.rowid, &r.sourceId, &r.target, &r.targetId); err != nil {
+				rows.Close()
+				return n, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return n, err
+		}
+		if len(batch) == 0 {
+			return n, nil
+		}
+
+		for _, r := range batch {
+			target, err := s.crypt.open(r.target, "target", r.sourceId)
+			if err != nil {
+				return n, err
+			}
+			sealed, err := next.seal(target, "target", r.sourceId)
+			if err != nil {
+				return n, err
+			}
+			// The target of a [[title]] link is its title.
+			var titleKey any
+			if !r.targetId.Valid {
+				titleKey = next.titleKey(target)
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE links SET target = ?, title = NULL, title_key = ? WHERE rowid = ?",
+				sealed, titleKey, r.rowid); err != nil {
+				return n, err
+			}
+			n++
+		}
+		after = batch[len(batch)-1].rowid
+	}
+}
+
+func (s *Storage) reencryptBodies(ctx context.Context, tx *sql.Tx, next *crypt) (n int, err error) {
+	type row struct {
+		key    string
+		body   []byte
+		sealed bool
+	}
+
+	// Bodies are kept for a short while only, there are few of them.
+	rows, err := tx.QueryContext(ctx, "SELECT key, body, sealed FROM idempotency_keys WHERE body IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+	var all []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.key, &r.body, &r.sealed); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		all = append(all, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range all {
+		body, err := s.crypt.openBody(r.body, r.sealed, r.key)
+		if err != nil {
+			return n, err
+		}
+		sealed, _, err := next.sealBody(body, r.key)
+		if err != nil {
+			return n, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE idempotency_keys SET body = ?, sealed = 1 WHERE key = ?", sealed, r.key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// sortLinks orders links as the queries do, by source and target, once
+// their targets are decrypted: the queries order sealed targets by their
+// bytes.
+func sortLinks(links []models.Link) {
+	slices.SortFunc(links, func(a, b models.Link) int {
+		if c := cmp.Compare(a.SourceId, b.SourceId); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Target, b.Target)
+	})
+}
@@ -0,0 +1,34 @@
+package notestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+)
+
+// storageError translates a failed call into the errors of the notes package.
+// If ctx is done it reports why: the driver may fail with its own error (e.g.
+// an interrupted query) once ctx is canceled, so the context is checked rather
+// than err alone. Constraint violations are reported as conflicts.
+func storageError(ctx context.Context, err error) error {
+	if err == nil || notes.CodeOf(err) != "" {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", notes.ErrTimeout, context.DeadlineExceeded)
+	case errors.Is(err, context.Canceled), errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("%w: %w", notes.ErrCanceled, context.Canceled)
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		return fmt.Errorf("%w: %w", notes.ErrConflict, err)
+	}
+
+	return err
+}
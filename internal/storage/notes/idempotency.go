@@ -0,0 +1,75 @@
+package notestorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, rec models.IdempotencyRecord, expiredBefore time.Time, abandonedBefore time.Time) (existing models.IdempotencyRecord, reserved bool, err error) {
+	const op = "storage.ReserveIdempotencyKey"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	if _, err := s.write.purgeIdempotencyKeys.ExecContext(ctx, expiredBefore.UnixMilli(), abandonedBefore.UnixMilli()); err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+
+	res, err := s.write.reserveIdempotencyKey.ExecContext(ctx, rec.Key, rec.RequestHash, rec.CreatedAt.UnixMilli())
+	if err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+	if rows, err := res.RowsAffected(); rows == 1 || err != nil {
+		return models.IdempotencyRecord{}, err == nil, err
+	}
+
+	var createdAt int64
+	var sealed bool
+	err = s.write.getIdempotencyKey.QueryRowContext(ctx, rec.Key).Scan(
+		&existing.Key,
+		&existing.RequestHash,
+		&existing.Status,
+		&existing.ContentType,
+		&existing.Body,
+		&sealed,
+		&createdAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// The key expired and was purged concurrently, try again.
+			return s.ReserveIdempotencyKey(ctx, rec, expiredBefore, abandonedBefore)
+		}
+		return models.IdempotencyRecord{}, false, err
+	}
+	if existing.Body, err = s.crypt.openBody(existing.Body, sealed, existing.Key); err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+	existing.CreatedAt = time.UnixMilli(createdAt)
+
+	return existing, false, nil
+}
+
+func (s *Storage) CompleteIdempotencyKey(ctx context.Context, key string, reservedAt time.Time, status int, contentType string, body []byte) (err error) {
+	const op = "storage.CompleteIdempotencyKey"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	body, sealed, err := s.crypt.sealBody(body, key)
+	if err != nil {
+		return err
+	}
+	_, err = s.write.completeIdempotencyKey.ExecContext(ctx, status, contentType, body, sealed, key, reservedAt.UnixMilli())
+	return err
+}
+
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, key string, reservedAt time.Time) (err error) {
+	const op = "storage.ReleaseIdempotencyKey"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	_, err = s.write.releaseIdempotencyKey.ExecContext(ctx, key, reservedAt.UnixMilli())
+	return err
+}
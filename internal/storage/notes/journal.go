@@ -0,0 +1,484 @@
+package notestorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/attachments"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/journal"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/logger/sl"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/wikilink"
+)
+
+// Kinds of journaled changes. Changes hold the state they leave behind rather
+// than the call that made it, so that replaying a change that is already in
+// the database changes nothing.
+const (
+	changePut              = "put"
+	changeDelete           = "delete"
+	changeLinks            = "links"
+	changeAttachment       = "attachment"
+	changeDeleteAttachment = "delete_attachment"
+)
+
+type change struct {
+	Op         string             `json:"op"`
+	Note       *models.Note       `json:"note,omitempty"`
+	Attachment *models.Attachment `json:"attachment,omitempty"`
+	Id         int64              `json:"id,omitempty"`
+	NoteId     int64              `json:"note_id,omitempty"`
+	Targets    []string           `json:"targets,omitempty"`
+}
+
+// journalEntry is the data of a journal entry: the changes of a transaction,
+// or the sequence number of an entry whose transaction failed to commit.
+type journalEntry struct {
+	Changes []change `json:"changes,omitempty"`
+	Abort   int64    `json:"abort,omitempty"`
+}
+
+// backend is what JournaledStorage writes through: the notes of a database
+// and the metadata of their attachments.
+type backend interface {
+	notes.Storage
+	attachments.Storage
+}
+
+// JournaledStorage records every change made through it to a journal, from
+// which Replay restores the database up to a point in time. Attachments are
+// journaled without their content, whose blobs are kept as long as the
+// database refers to them.
+//
+// Each write runs in a transaction whose changes are appended to the journal,
+// and synced, before it commits: a committed change is always journaled. If
+// the commit fails afterwards, an abort entry tells Replay to skip the
+// changes. The transaction also stores the sequence number of its entry, so
+// that an entry whose transaction never committed because the process died
+// in between is found and aborted by NewJournaled.
+type JournaledStorage struct {
+	backend
+	journal *journal.Writer
+	log     *slog.Logger
+	// changes collects the changes of the transaction the storage is bound
+	// to, it is nil outside transactions.
+	changes *[]change
+}
+
+// journalState is implemented by storages that keep the sequence number of
+// the last journal entry they committed, as Storage does.
+type journalState interface {
+	// journalSeq returns the number, ok is false if none was stored yet.
+	journalSeq(ctx context.Context) (seq int64, ok bool, err error)
+	setJournalSeq(ctx context.Context, seq int64) error
+}
+
+// NewJournaled journals the changes made to storage. Entries left behind by
+// a crash between syncing them and committing their transaction are aborted
+// first.
+func NewJournaled(storage backend, journal *journal.Writer, log *slog.Logger) (*JournaledStorage, error) {
+	s := &JournaledStorage{backend: storage, journal: journal, log: log}
+	if err := s.abortUncommitted(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to abort uncommitted journal entries: %w", err)
+	}
+	return s, nil
+}
+
+// abortUncommitted journals an abort for every entry past the last one the
+// database committed, unless one was journaled already.
+func (s *JournaledStorage) abortUncommitted(ctx context.Context) error {
+	state, ok := s.backend.(journalState)
+	if !ok {
+		return nil
+	}
+	committed, ok, err := state.journalSeq(ctx)
+	if err != nil || !ok {
+		// Databases from before the state was kept start keeping it
+		// with their next write.
+		return err
+	}
+
+	var uncommitted []int64
+	aborted := make(map[int64]bool)
+	err = journal.ReadFrom(s.journal.Dir(), committed+1, func(entry journal.Entry) error {
+		if entry.Seq <= committed {
+			return nil
+		}
+		var data journalEntry
+		if err := json.Unmarshal(entry.Data, &data); err != nil {
+			return fmt.Errorf("entry %d: %w", entry.Seq, err)
+		}
+		if data.Abort != 0 {
+			aborted[data.Abort] = true
+		} else {
+			uncommitted = append(uncommitted, entry.Seq)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, journal.ErrTorn) {
+		return err
+	}
+
+	for _, seq := range uncommitted {
+		if aborted[seq] {
+			continue
+		}
+		if _, err := s.journal.Append(time.Now(), journalEntry{Abort: seq}); err != nil {
+			return err
+		}
+		s.log.Warn("Aborted a journal entry whose transaction didn't commit", slog.Int64("seq", seq))
+	}
+	return nil
+}
+
+func (s *JournaledStorage) WithTx(ctx context.Context, fn func(tx notes.Storage) error) (err error) {
+	if s.changes != nil {
+		return fn(s)
+	}
+
+	var seq int64
+	err = s.backend.WithTx(ctx, func(tx notes.Storage) error {
+		bound := &JournaledStorage{backend: tx.(backend), journal: s.journal, log: s.log, changes: &[]change{}}
+		if err := fn(bound); err != nil {
+			return err
+		}
+		if len(*bound.changes) == 0 {
+			return nil
+		}
+
+		var err error
+		seq, err = s.journal.Append(time.Now(), journalEntry{Changes: *bound.changes})
+		if err != nil && seq == 0 {
+			return fmt.Errorf("failed to journal changes: %w", err)
+		}
+		if err != nil {
+			// The entry is written, the journal can't take the next one.
+			s.log.Error("Failed to rotate journal", sl.Err(err))
+		}
+		if state, ok := tx.(journalState); ok {
+			return state.setJournalSeq(ctx, seq)
+		}
+		return nil
+	})
+	if err != nil && seq != 0 {
+		if _, abortErr := s.journal.Append(time.Now(), journalEntry{Abort: seq}); abortErr != nil {
+			s.log.Error("Failed to journal aborted transaction", slog.Int64("seq", seq), sl.Err(abortErr))
+		}
+	}
+
+	return err
+}
+
+// write runs fn in a transaction of its own, unless s is in one already.
+func (s *JournaledStorage) write(ctx context.Context, fn func(tx *JournaledStorage) error) error {
+	return s.WithTx(ctx, func(tx notes.Storage) error {
+		return fn(tx.(*JournaledStorage))
+	})
+}
+
+// put records the note with id as it is now stored.
+func (s *JournaledStorage) put(ctx context.Context, id int64) error {
+	note, err := s.backend.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	*s.changes = append(*s.changes, change{Op: changePut, Note: &note})
+	return nil
+}
+
+func (s *JournaledStorage) Add(ctx context.Context, header string, content string) (id int64, err error) {
+	err = s.write(ctx, func(tx *JournaledStorage) error {
+		if id, err = tx.backend.Add(ctx, header, content); err != nil {
+			return err
+		}
+		return tx.put(ctx, id)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *JournaledStorage) Insert(ctx context.Context, note models.Note) (id int64, err error) {
+	err = s.write(ctx, func(tx *JournaledStorage) error {
+		if id, err = tx.backend.Insert(ctx, note); err != nil {
+			return err
+		}
+		return tx.put(ctx, id)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *JournaledStorage) Restore(ctx context.Context, note models.Note) (err error) {
+	return s.write(ctx, func(tx *JournaledStorage) error {
+		if err := tx.backend.Restore(ctx, note); err != nil {
+			return err
+		}
+		return tx.put(ctx, note.Id)
+	})
+}
+
+func (s *JournaledStorage) Edit(ctx context.Context, header string, content string, id int64) (err error) {
+	return s.write(ctx, func(tx *JournaledStorage) error {
+		if err := tx.backend.Edit(ctx, header, content, id); err != nil {
+			return err
+		}
+		return tx.put(ctx, id)
+	})
+}
+
+func (s *JournaledStorage) SetKDF(ctx context.Context, id int64, kdf models.KDF) (err error) {
+	return s.write(ctx, func(tx *JournaledStorage) error {
+		if err := tx.backend.SetKDF(ctx, id, kdf); err != nil {
+			return err
+		}
+		return tx.put(ctx, id)
+	})
+}
+
+func (s *JournaledStorage) Delete(ctx context.Context, id int64) (err error) {
+	return s.write(ctx, func(tx *JournaledStorage) error {
+		if err := tx.backend.Delete(ctx, id); err != nil {
+			return err
+		}
+		*tx.changes = append(*tx.changes, change{Op: changeDelete, Id: id})
+		return nil
+	})
+}
+
+func (s *JournaledStorage) SetLinks(ctx context.Context, sourceId int64, targets []models.LinkTarget) (err error) {
+	return s.write(ctx, func(tx *JournaledStorage) error {
+		if err := tx.backend.SetLinks(ctx, sourceId, targets); err != nil {
+			return err
+		}
+		written := make([]string, len(targets))
+		for i, target := range targets {
+			written[i] = target.String()
+		}
+		*tx.changes = append(*tx.changes, change{Op: changeLinks, Id: sourceId, Targets: written})
+		return nil
+	})
+}
+
+// putAttachment records the attachment with id as it is now stored.
+func (s *JournaledStorage) putAttachment(ctx context.Context, noteId int64, id int64) error {
+	attachment, err := s.backend.GetAttachment(ctx, noteId, id)
+	if err != nil {
+		return err
+	}
+	*s.changes = append(*s.changes, change{Op: changeAttachment, Attachment: &attachment})
+	return nil
+}
+
+func (s *JournaledStorage) AddAttachment(ctx context.Context, attachment models.Attachment) (id int64, err error) {
+	err = s.write(ctx, func(tx *JournaledStorage) error {
+		if id, err = tx.backend.AddAttachment(ctx, attachment); err != nil {
+			return err
+		}
+		return tx.putAttachment(ctx, attachment.NoteId, id)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *JournaledStorage) RestoreAttachment(ctx context.Context, attachment models.Attachment) (err error) {
+	return s.write(ctx, func(tx *JournaledStorage) error {
+		if err := tx.backend.RestoreAttachment(ctx, attachment); err != nil {
+			return err
+		}
+		return tx.putAttachment(ctx, attachment.NoteId, attachment.Id)
+	})
+}
+
+func (s *JournaledStorage) DeleteAttachment(ctx context.Context, noteId int64, id int64) (err error) {
+	return s.write(ctx, func(tx *JournaledStorage) error {
+		if err := tx.backend.DeleteAttachment(ctx, noteId, id); err != nil {
+			return err
+		}
+		*tx.changes = append(*tx.changes, change{Op: changeDeleteAttachment, NoteId: noteId, Id: id})
+		return nil
+	})
+}
+
+// ReplayReport sums up a replay. Torn is set when the journal ended in a line
+// torn by a crash, which is left out.
+type ReplayReport struct {
+	Entries  int
+	Changes  int
+	Aborted  int
+	LastSeq  int64
+	LastTime time.Time
+	Torn     bool
+}
+
+// Replay applies the changes journaled in dir until the first entry after
+// until, in one transaction. The database is expected to be a snapshot taken
+// while the journal was kept: replaying changes the snapshot already holds
+// leaves them as they are, so the whole journal can be replayed over it.
+//
+// The database then holds the number of the last entry replayed as the last
+// it committed: if it is journaled to the same journal afterwards, the
+// entries past until are aborted.
+func (s *Storage) Replay(ctx context.Context, dir string, until time.Time) (report ReplayReport, err error) {
+	// Aborts follow the entries they cancel, maybe past until, they are
+	// collected from the whole journal first.
+	aborted := make(map[int64]bool)
+	err = journal.Read(dir, func(entry journal.Entry) error {
+		var data journalEntry
+		if err := json.Unmarshal(entry.Data, &data); err != nil {
+			return fmt.Errorf("entry %d: %w", entry.Seq, err)
+		}
+		if data.Abort != 0 {
+			aborted[data.Abort] = true
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, journal.ErrTorn) {
+		return ReplayReport{}, err
+	}
+
+	err = s.WithTx(ctx, func(tx notes.Storage) error {
+		report = ReplayReport{}
+		err := journal.Read(dir, func(entry journal.Entry) error {
+			if entry.Time.After(until) {
+				return errReplayDone
+			}
+			report.Entries++
+			report.LastSeq, report.LastTime = entry.Seq, entry.Time
+
+			var data journalEntry
+			if err := json.Unmarshal(entry.Data, &data); err != nil {
+				return fmt.Errorf("entry %d: %w", entry.Seq, err)
+			}
+			if aborted[entry.Seq] {
+				report.Aborted++
+				return nil
+			}
+			for _, c := range data.Changes {
+				if err := apply(ctx, tx.(*Storage), c); err != nil {
+					return fmt.Errorf("entry %d: %s: %w", entry.Seq, c.Op, err)
+				}
+				report.Changes++
+			}
+			return nil
+		})
+		if errors.Is(err, journal.ErrTorn) {
+			report.Torn, err = true, nil
+		}
+		if errors.Is(err, errReplayDone) {
+			err = nil
+		}
+		if err != nil || report.LastSeq == 0 {
+			return err
+		}
+		return tx.(*Storage).setJournalSeq(ctx, report.LastSeq)
+	})
+	if err != nil {
+		return ReplayReport{}, err
+	}
+
+	return report, nil
+}
+
+var errReplayDone = errors.New("replay done")
+
+// apply makes the database hold what c recorded. The database may hold
+// changes from later in the journal, as snapshots are taken while it is
+// written: deleting a note or an attachment that is gone, storing an
+// attachment that is there already, or linking from or attaching to a note
+// that will be gone by the end of the replay, is skipped.
+func apply(ctx context.Context, s *Storage, c change) error {
+	switch c.Op {
+	case changePut:
+		if c.Note == nil {
+			return errors.New("no note")
+		}
+		return s.Put(ctx, *c.Note)
+	case changeDelete:
+		if err := s.Delete(ctx, c.Id); err != nil && !errors.Is(err, ErrNoteNotFound) {
+			return err
+		}
+		return nil
+	case changeLinks:
+		if _, err := s.GetById(ctx, c.Id); errors.Is(err, ErrNoteNotFound) {
+			return nil
+		}
+		targets := make([]models.LinkTarget, len(c.Targets))
+		for i, target := range c.Targets {
+			targets[i] = wikilink.ParseTarget(target)
+		}
+		return s.SetLinks(ctx, c.Id, targets)
+	case changeAttachment:
+		if c.Attachment == nil {
+			return errors.New("no attachment")
+		}
+		// Attachments don't change once added, the one stored is the same.
+		err := s.RestoreAttachment(ctx, *c.Attachment)
+		if err != nil && !errors.Is(err, notes.ErrConflict) && !errors.Is(err, ErrNoteNotFound) {
+			return err
+		}
+		return nil
+	case changeDeleteAttachment:
+		if err := s.DeleteAttachment(ctx, c.NoteId, c.Id); err != nil && !errors.Is(err, ErrAttachmentNotFound) {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown change %q", c.Op)
+	}
+}
+
+func (s *Storage) journalSeq(ctx context.Context) (seq int64, ok bool, err error) {
+	err = s.read.getJournalSeq.QueryRowContext(ctx).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, storageError(ctx, err)
+	}
+	return seq, true, nil
+}
+
+func (s *Storage) setJournalSeq(ctx context.Context, seq int64) error {
+	_, err := s.write.setJournalSeq.ExecContext(ctx, seq)
+	return storageError(ctx, err)
+}
+
+// PruneJournal removes the segments of the journal in dir that replaying it
+// over the snapshot at snapshotPath doesn't need, because the snapshot holds
+// all their changes, and returns their names. Snapshots of databases that
+// didn't keep the journal state prune nothing.
+func PruneJournal(dir string, snapshotPath string) (removed []string, err error) {
+	db, err := sql.Open("sqlite3", "file:"+snapshotPath+"?mode=ro&immutable=1")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var tables int
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'journal_state'").Scan(&tables)
+	if err != nil || tables == 0 {
+		return nil, err
+	}
+	var seq int64
+	err = db.QueryRow("SELECT seq FROM journal_state").Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return journal.Prune(dir, seq)
+}
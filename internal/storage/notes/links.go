@@ -0,0 +1,104 @@
+package notestorage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// SetLinks replaces the links of a note in one transaction.
+func (s *Storage) SetLinks(ctx context.Context, sourceId int64, targets []models.LinkTarget) (err error) {
+	if s.tx == nil {
+		return s.WithTx(ctx, func(tx notes.Storage) error {
+			return tx.SetLinks(ctx, sourceId, targets)
+		})
+	}
+
+	const op = "storage.SetLinks"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	if _, err := s.write.deleteLinks.ExecContext(ctx, sourceId); err != nil {
+		return err
+	}
+	for _, target := range targets {
+		var targetId, title, titleKey any
+		if target.Id != 0 {
+			targetId = target.Id
+		} else if s.crypt == nil {
+			title = target.Title
+		} else {
+			titleKey = s.crypt.titleKey(target.Title)
+		}
+		sealed, err := s.crypt.seal(target.String(), "target", sourceId)
+		if err != nil {
+			return err
+		}
+		if _, err := s.write.addLink.ExecContext(ctx, sourceId, sealed, targetId, title, titleKey); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) GetLinks(ctx context.Context, sourceId int64) (links []models.Link, err error) {
+	const op = "storage.GetLinks"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	return s.queryLinks(s.read.getLinks.QueryContext(ctx, sourceId))
+}
+
+func (s *Storage) GetBacklinks(ctx context.Context, targetId int64) (links []models.Link, err error) {
+	const op = "storage.GetBacklinks"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	return s.queryLinks(s.read.getBacklinks.QueryContext(ctx, targetId))
+}
+
+func (s *Storage) GetBrokenLinks(ctx context.Context) (links []models.Link, err error) {
+	const op = "storage.GetBrokenLinks"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	return s.queryLinks(s.read.getBrokenLinks.QueryContext(ctx))
+}
+
+func (s *Storage) GetAllLinks(ctx context.Context) (links []models.Link, err error) {
+	const op = "storage.GetAllLinks"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	return s.queryLinks(s.read.getAllLinks.QueryContext(ctx))
+}
+
+func (s *Storage) queryLinks(rows *sql.Rows, err error) (links []models.Link, _ error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var link models.Link
+		var target any
+		if err := rows.Scan(&link.SourceId, &target, &link.TargetId); err != nil {
+			return nil, err
+		}
+		if link.Target, err = s.crypt.open(target, "target", link.SourceId); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if s.crypt != nil {
+		sortLinks(links)
+	}
+	return links, nil
+}
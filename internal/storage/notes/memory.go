@@ -0,0 +1,895 @@
+package notestorage
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// MemoryStorage keeps notes in process memory. It has the same semantics as
+// Storage and is meant for tests and ephemeral runs.
+type MemoryStorage struct {
+	mu    sync.RWMutex
+	state memoryState
+	// idempotencyKeys aren't transactional and aren't part of the snapshot.
+	idempotencyKeys map[string]models.IdempotencyRecord
+}
+
+// memoryState holds the data of a MemoryStorage. Its methods don't lock, the
+// caller is responsible for that.
+type memoryState struct {
+	notes map[int64]models.Note
+	// links are replaced as a whole by setLinks, so clones can share them.
+	links map[int64][]models.LinkTarget
+	// lastId is the highest note id handed out. Ids aren't reused, as in
+	// Storage.
+	lastId int64
+	// attachments are keyed by their id.
+	attachments      map[int64]models.Attachment
+	lastAttachmentId int64
+}
+
+// memoryTx is the view of a MemoryStorage handed to WithTx callbacks. It works
+// on a copy of the state that replaces the original on commit.
+type memoryTx struct {
+	state *memoryState
+}
+
+type memorySnapshot struct {
+	LastId           int64                         `json:"last_id"`
+	Notes            []models.Note                 `json:"notes"`
+	Links            map[int64][]models.LinkTarget `json:"links,omitempty"`
+	LastAttachmentId int64                         `json:"last_attachment_id,omitempty"`
+	Attachments      []models.Attachment           `json:"attachments,omitempty"`
+}
+
+// NewMemory creates an in-memory storage. If snapshotPath is not empty the
+// notes are loaded from it on startup and written back to it on shutdown.
+func NewMemory(snapshotPath string, log *slog.Logger) (*MemoryStorage, shutdownFunc) {
+	s := &MemoryStorage{
+		state: memoryState{
+			notes:       make(map[int64]models.Note),
+			links:       make(map[int64][]models.LinkTarget),
+			attachments: make(map[int64]models.Attachment),
+		},
+		idempotencyKeys: make(map[string]models.IdempotencyRecord),
+	}
+
+	if snapshotPath == "" {
+		log.Info("In-memory storage is ready")
+		return s, func() error { return nil }
+	}
+
+	if err := s.load(snapshotPath); err != nil {
+		panic(err)
+	}
+	log.Info("In-memory storage is ready", slog.String("snapshot", snapshotPath), slog.Int("notes", len(s.state.notes)))
+
+	return s, func() error {
+		return s.save(snapshotPath)
+	}
+}
+
+func (s *MemoryStorage) GetAll(ctx context.Context) (notes []models.Note, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state.getAll(), nil
+}
+
+func (s *MemoryStorage) GetById(ctx context.Context, id int64) (note models.Note, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return models.Note{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state.getById(id)
+}
+
+func (s *MemoryStorage) Add(ctx context.Context, header string, content string) (id int64, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.add(header, content), nil
+}
+
+func (s *MemoryStorage) Insert(ctx context.Context, note models.Note) (id int64, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.insert(note)
+}
+
+func (s *MemoryStorage) Restore(ctx context.Context, note models.Note) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.restore(note)
+}
+
+func (s *MemoryStorage) Edit(ctx context.Context, header string, content string, id int64) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.edit(header, content, id)
+}
+
+func (s *MemoryStorage) SetKDF(ctx context.Context, id int64, kdf models.KDF) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.setKDF(id, kdf)
+}
+
+func (s *MemoryStorage) Delete(ctx context.Context, id int64) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.delete(id)
+}
+
+// EachNote calls fn on a copy of the notes taken when it starts, so fn may use
+// the storage.
+func (s *MemoryStorage) EachNote(ctx context.Context, fn func(note models.Note) error) (err error) {
+	s.mu.RLock()
+	all := s.state.getAll()
+	s.mu.RUnlock()
+
+	return eachNote(ctx, all, fn)
+}
+
+func (s *MemoryStorage) SetLinks(ctx context.Context, sourceId int64, targets []models.LinkTarget) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.setLinks(sourceId, targets)
+}
+
+func (s *MemoryStorage) GetLinks(ctx context.Context, sourceId int64) (links []models.Link, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state.getLinks(sourceId), nil
+}
+
+func (s *MemoryStorage) GetBacklinks(ctx context.Context, targetId int64) (links []models.Link, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state.getBacklinks(targetId), nil
+}
+
+func (s *MemoryStorage) GetBrokenLinks(ctx context.Context) (links []models.Link, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state.getBacklinks(0), nil
+}
+
+func (s *MemoryStorage) GetAllLinks(ctx context.Context) (links []models.Link, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state.getAllLinks(), nil
+}
+
+// WithTx runs fn on a copy of the notes while holding the write lock, so
+// transactions are serialized. The copy replaces the notes if fn succeeds.
+func (s *MemoryStorage) WithTx(ctx context.Context, fn func(tx notes.Storage) error) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.state.clone()
+	if err := fn(memoryTx{state: &state}); err != nil {
+		return err
+	}
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+	s.state = state
+
+	return nil
+}
+
+// WithReadTx runs fn on a copy of the notes, taken under the read lock.
+func (s *MemoryStorage) WithReadTx(ctx context.Context, fn func(tx notes.Storage) error) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	state := s.state.clone()
+	s.mu.RUnlock()
+
+	return fn(memoryTx{state: &state})
+}
+
+func (s *MemoryStorage) AddAttachment(ctx context.Context, attachment models.Attachment) (id int64, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.addAttachment(attachment)
+}
+
+func (s *MemoryStorage) RestoreAttachment(ctx context.Context, attachment models.Attachment) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state.restoreAttachment(attachment)
+}
+
+func (s *MemoryStorage) GetAllAttachments(ctx context.Context) (attachments []models.Attachment, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state.getAttachments(), nil
+}
+
+func (s *MemoryStorage) GetAttachments(ctx context.Context, noteId int64) (attachments []models.Attachment, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, attachment := range s.state.getAttachments() {
+		if attachment.NoteId == noteId {
+			attachments = append(attachments, attachment)
+		}
+	}
+	return attachments, nil
+}
+
+func (s *MemoryStorage) GetAttachment(ctx context.Context, noteId int64, id int64) (attachment models.Attachment, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return models.Attachment{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	attachment, ok := s.state.attachments[id]
+	if !ok || attachment.NoteId != noteId {
+		return models.Attachment{}, ErrAttachmentNotFound
+	}
+	return attachment, nil
+}
+
+func (s *MemoryStorage) DeleteAttachment(ctx context.Context, noteId int64, id int64) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attachment, ok := s.state.attachments[id]; !ok || attachment.NoteId != noteId {
+		return ErrAttachmentNotFound
+	}
+	delete(s.state.attachments, id)
+	return nil
+}
+
+func (s *MemoryStorage) CountBlobReferences(ctx context.Context, sha256 string) (n int, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, attachment := range s.state.attachments {
+		if attachment.SHA256 == sha256 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryStorage) GetBlobs(ctx context.Context) (digests []string, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, attachment := range s.state.attachments {
+		if !seen[attachment.SHA256] {
+			seen[attachment.SHA256] = true
+			digests = append(digests, attachment.SHA256)
+		}
+	}
+	slices.Sort(digests)
+	return digests, nil
+}
+
+func (s *MemoryStorage) Usage(ctx context.Context) (usage models.Usage, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return models.Usage{}, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state.usage(), nil
+}
+
+func (s *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, rec models.IdempotencyRecord, expiredBefore time.Time, abandonedBefore time.Time) (existing models.IdempotencyRecord, reserved bool, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return models.IdempotencyRecord{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := func(rec models.IdempotencyRecord) bool {
+		return rec.CreatedAt.Before(expiredBefore) || rec.Status == 0 && rec.CreatedAt.Before(abandonedBefore)
+	}
+	if existing, ok := s.idempotencyKeys[rec.Key]; ok && !expired(existing) {
+		return existing, false, nil
+	}
+	rec.Status, rec.ContentType, rec.Body = 0, "", nil
+	s.idempotencyKeys[rec.Key] = rec
+
+	for key, existing := range s.idempotencyKeys {
+		if expired(existing) {
+			delete(s.idempotencyKeys, key)
+		}
+	}
+
+	return models.IdempotencyRecord{}, true, nil
+}
+
+func (s *MemoryStorage) CompleteIdempotencyKey(ctx context.Context, key string, reservedAt time.Time, status int, contentType string, body []byte) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.idempotencyKeys[key]; ok && rec.CreatedAt.Equal(reservedAt) {
+		rec.Status, rec.ContentType, rec.Body = status, contentType, bytes.Clone(body)
+		s.idempotencyKeys[key] = rec
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) ReleaseIdempotencyKey(ctx context.Context, key string, reservedAt time.Time) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec, ok := s.idempotencyKeys[key]; ok && rec.CreatedAt.Equal(reservedAt) {
+		delete(s.idempotencyKeys, key)
+	}
+
+	return nil
+}
+
+func (tx memoryTx) GetAll(ctx context.Context) (notes []models.Note, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+	return tx.state.getAll(), nil
+}
+
+func (tx memoryTx) GetById(ctx context.Context, id int64) (note models.Note, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return models.Note{}, err
+	}
+	return tx.state.getById(id)
+}
+
+func (tx memoryTx) Add(ctx context.Context, header string, content string) (id int64, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+	return tx.state.add(header, content), nil
+}
+
+func (tx memoryTx) Insert(ctx context.Context, note models.Note) (id int64, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return 0, err
+	}
+	return tx.state.insert(note)
+}
+
+func (tx memoryTx) Restore(ctx context.Context, note models.Note) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+	return tx.state.restore(note)
+}
+
+func (tx memoryTx) Edit(ctx context.Context, header string, content string, id int64) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+	return tx.state.edit(header, content, id)
+}
+
+func (tx memoryTx) SetKDF(ctx context.Context, id int64, kdf models.KDF) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+	return tx.state.setKDF(id, kdf)
+}
+
+func (tx memoryTx) Delete(ctx context.Context, id int64) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+	return tx.state.delete(id)
+}
+
+func (tx memoryTx) Usage(ctx context.Context) (usage models.Usage, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return models.Usage{}, err
+	}
+	return tx.state.usage(), nil
+}
+
+func (tx memoryTx) EachNote(ctx context.Context, fn func(note models.Note) error) (err error) {
+	return eachNote(ctx, tx.state.getAll(), fn)
+}
+
+func (tx memoryTx) SetLinks(ctx context.Context, sourceId int64, targets []models.LinkTarget) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+	return tx.state.setLinks(sourceId, targets)
+}
+
+func (tx memoryTx) GetLinks(ctx context.Context, sourceId int64) (links []models.Link, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+	return tx.state.getLinks(sourceId), nil
+}
+
+func (tx memoryTx) GetBacklinks(ctx context.Context, targetId int64) (links []models.Link, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+	return tx.state.getBacklinks(targetId), nil
+}
+
+func (tx memoryTx) GetBrokenLinks(ctx context.Context) (links []models.Link, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+	return tx.state.getBacklinks(0), nil
+}
+
+func (tx memoryTx) GetAllLinks(ctx context.Context) (links []models.Link, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+	return tx.state.getAllLinks(), nil
+}
+
+func (tx memoryTx) RestoreAttachment(ctx context.Context, attachment models.Attachment) (err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return err
+	}
+	return tx.state.restoreAttachment(attachment)
+}
+
+func (tx memoryTx) GetAllAttachments(ctx context.Context) (attachments []models.Attachment, err error) {
+	if err := storageError(ctx, ctx.Err()); err != nil {
+		return nil, err
+	}
+	return tx.state.getAttachments(), nil
+}
+
+// WithTx runs fn in the current transaction.
+func (tx memoryTx) WithTx(ctx context.Context, fn func(tx notes.Storage) error) (err error) {
+	return fn(tx)
+}
+
+// WithReadTx runs fn in the current transaction.
+func (tx memoryTx) WithReadTx(ctx context.Context, fn func(tx notes.Storage) error) (err error) {
+	return fn(tx)
+}
+
+func eachNote(ctx context.Context, all []models.Note, fn func(note models.Note) error) error {
+	for _, note := range all {
+		if err := storageError(ctx, ctx.Err()); err != nil {
+			return err
+		}
+		if err := fn(note); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *memoryState) getAll() (notes []models.Note) {
+	for _, note := range st.notes {
+		notes = append(notes, note)
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].Id < notes[j].Id })
+
+	return notes
+}
+
+func (st *memoryState) getById(id int64) (models.Note, error) {
+	note, ok := st.notes[id]
+	if !ok {
+		return models.Note{}, ErrNoteNotFound
+	}
+
+	return note, nil
+}
+
+func (st *memoryState) add(header string, content string) int64 {
+	st.lastId++
+	created := now()
+	st.notes[st.lastId] = models.Note{
+		Header:    header,
+		Content:   content,
+		Id:        st.lastId,
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+
+	return st.lastId
+}
+
+func (st *memoryState) insert(note models.Note) (int64, error) {
+	if note.Id == 0 {
+		st.lastId++
+		note.Id = st.lastId
+	} else if _, ok := st.notes[note.Id]; ok {
+		return 0, fmt.Errorf("%w: note %d already exists", notes.ErrConflict, note.Id)
+	}
+	st.lastId = max(st.lastId, note.Id)
+	st.notes[note.Id] = stamp(note)
+
+	return note.Id, nil
+}
+
+// restore stores note with the precision of the SQL storage, keeping zero
+// timestamps.
+func (st *memoryState) restore(note models.Note) error {
+	if _, ok := st.notes[note.Id]; ok {
+		return fmt.Errorf("%w: note %d already exists", notes.ErrConflict, note.Id)
+	}
+	st.lastId = max(st.lastId, note.Id)
+	for _, t := range []*time.Time{&note.CreatedAt, &note.UpdatedAt} {
+		if !t.IsZero() {
+			*t = t.UTC().Truncate(time.Millisecond)
+		}
+	}
+	st.notes[note.Id] = note
+
+	return nil
+}
+
+func (st *memoryState) edit(header string, content string, id int64) error {
+	note, ok := st.notes[id]
+	if !ok {
+		return ErrNoteNotFound
+	}
+	note.Header, note.Content, note.UpdatedAt = header, content, now()
+	st.notes[id] = note
+
+	return nil
+}
+
+func (st *memoryState) setKDF(id int64, kdf models.KDF) error {
+	note, ok := st.notes[id]
+	if !ok {
+		return ErrNoteNotFound
+	}
+	note.KDF = kdf
+	st.notes[id] = note
+
+	return nil
+}
+
+func (st *memoryState) delete(id int64) error {
+	if _, ok := st.notes[id]; !ok {
+		return ErrNoteNotFound
+	}
+	delete(st.notes, id)
+	delete(st.links, id)
+	maps.DeleteFunc(st.attachments, func(_ int64, attachment models.Attachment) bool {
+		return attachment.NoteId == id
+	})
+
+	return nil
+}
+
+// addAttachment fails like the SQL storage if the note is gone.
+func (st *memoryState) addAttachment(attachment models.Attachment) (int64, error) {
+	if _, ok := st.notes[attachment.NoteId]; !ok {
+		return 0, ErrNoteNotFound
+	}
+	st.lastAttachmentId++
+	attachment.Id = st.lastAttachmentId
+	attachment.CreatedAt = attachment.CreatedAt.UTC().Truncate(time.Millisecond)
+	st.attachments[attachment.Id] = attachment
+
+	return attachment.Id, nil
+}
+
+// restoreAttachment keeps the id of attachment, which must not be taken.
+func (st *memoryState) restoreAttachment(attachment models.Attachment) error {
+	if _, ok := st.notes[attachment.NoteId]; !ok {
+		return ErrNoteNotFound
+	}
+	if _, ok := st.attachments[attachment.Id]; ok {
+		return fmt.Errorf("%w: attachment %d already exists", notes.ErrConflict, attachment.Id)
+	}
+	st.lastAttachmentId = max(st.lastAttachmentId, attachment.Id)
+	attachment.CreatedAt = attachment.CreatedAt.UTC().Truncate(time.Millisecond)
+	st.attachments[attachment.Id] = attachment
+
+	return nil
+}
+
+// getAttachments returns all attachments ordered by id.
+func (st *memoryState) getAttachments() []models.Attachment {
+	attachments := slices.Collect(maps.Values(st.attachments))
+	slices.SortFunc(attachments, func(a, b models.Attachment) int { return cmp.Compare(a.Id, b.Id) })
+
+	return attachments
+}
+
+// usage adds up what is stored, like the usage table of the SQL storage.
+func (st *memoryState) usage() (usage models.Usage) {
+	usage.Notes = len(st.notes)
+	for _, note := range st.notes {
+		usage.NoteBytes += int64(len(note.Header) + len(note.Content))
+	}
+	usage.Attachments = len(st.attachments)
+	for _, attachment := range st.attachments {
+		usage.AttachmentBytes += attachment.Size
+	}
+	return usage
+}
+
+// setLinks fails like the foreign key of the links table if the note is gone.
+func (st *memoryState) setLinks(sourceId int64, targets []models.LinkTarget) error {
+	if _, ok := st.notes[sourceId]; !ok {
+		return fmt.Errorf("%w: note %d doesn't exist", notes.ErrConflict, sourceId)
+	}
+	if len(targets) == 0 {
+		delete(st.links, sourceId)
+	} else {
+		st.links[sourceId] = slices.Clone(targets)
+	}
+
+	return nil
+}
+
+func (st *memoryState) getLinks(sourceId int64) (links []models.Link) {
+	for _, target := range st.links[sourceId] {
+		links = append(links, models.Link{SourceId: sourceId, Target: target.String(), TargetId: st.resolve(target)})
+	}
+	slices.SortFunc(links, func(a, b models.Link) int { return strings.Compare(a.Target, b.Target) })
+
+	return links
+}
+
+// getBacklinks returns the links resolving to targetId, 0 stands for none.
+func (st *memoryState) getBacklinks(targetId int64) (links []models.Link) {
+	return slices.DeleteFunc(st.getAllLinks(), func(link models.Link) bool {
+		return link.TargetId != targetId
+	})
+}
+
+func (st *memoryState) getAllLinks() (links []models.Link) {
+	for sourceId, targets := range st.links {
+		for _, target := range targets {
+			links = append(links, models.Link{SourceId: sourceId, Target: target.String(), TargetId: st.resolve(target)})
+		}
+	}
+	slices.SortFunc(links, func(a, b models.Link) int {
+		return cmp.Or(cmp.Compare(a.SourceId, b.SourceId), strings.Compare(a.Target, b.Target))
+	})
+
+	return links
+}
+
+// resolve works like the resolved_links view: an id must exist, a title
+// matches the oldest note with that header, ignoring ASCII case like SQLite's
+// NOCASE collation does.
+func (st *memoryState) resolve(target models.LinkTarget) int64 {
+	if target.Id != 0 {
+		if _, ok := st.notes[target.Id]; ok {
+			return target.Id
+		}
+		return 0
+	}
+
+	var resolved int64
+	for id, note := range st.notes {
+		if (resolved == 0 || id < resolved) && asciiEqualFold(note.Header, target.Title) {
+			resolved = id
+		}
+	}
+	return resolved
+}
+
+func asciiEqualFold(a string, b string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if lowerASCII(a[i]) != lowerASCII(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func lowerASCII(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func (st *memoryState) clone() memoryState {
+	return memoryState{
+		notes:            maps.Clone(st.notes),
+		links:            maps.Clone(st.links),
+		lastId:           st.lastId,
+		attachments:      maps.Clone(st.attachments),
+		lastAttachmentId: st.lastAttachmentId,
+	}
+}
+
+func (s *MemoryStorage) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.lastId = snapshot.LastId
+	for _, note := range snapshot.Notes {
+		s.state.notes[note.Id] = note
+		if note.Id > s.state.lastId {
+			s.state.lastId = note.Id
+		}
+	}
+	for sourceId, targets := range snapshot.Links {
+		if _, ok := s.state.notes[sourceId]; ok {
+			s.state.links[sourceId] = targets
+		}
+	}
+	s.state.lastAttachmentId = snapshot.LastAttachmentId
+	for _, attachment := range snapshot.Attachments {
+		if _, ok := s.state.notes[attachment.NoteId]; ok {
+			s.state.attachments[attachment.Id] = attachment
+			s.state.lastAttachmentId = max(s.state.lastAttachmentId, attachment.Id)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) save(path string) error {
+	s.mu.RLock()
+	snapshot := memorySnapshot{
+		LastId:           s.state.lastId,
+		Notes:            s.state.getAll(),
+		Links:            s.state.links,
+		LastAttachmentId: s.state.lastAttachmentId,
+		Attachments:      s.state.getAttachments(),
+	}
+	s.mu.RUnlock()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a torn snapshot.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,25 @@
+package notestorage
+
+import (
+	"fmt"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+// Migrate applies every pending migration from migrationsPath to the database
+// at storagePath. It returns migrate.ErrNoChange if the schema is up to date.
+func Migrate(storagePath string, migrationsPath string) error {
+	var migrationsTable string
+	m, err := migrate.New(
+		"file://"+migrationsPath,
+		fmt.Sprintf("sqlite3://%s?x-migrations-table=%s", storagePath, migrationsTable),
+	)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return m.Up()
+}
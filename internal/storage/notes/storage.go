@@ -3,103 +3,529 @@ package notestorage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
+	"runtime"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
 	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
 )
 
 type Storage struct {
-	db *sql.DB
+	// writer is limited to a single connection, SQLite serializes writes anyway.
+	writer *sql.DB
+	// reader is a pool of read-only connections that WAL lets run concurrently
+	// with the writer.
+	reader *sql.DB
+	// read and write are the statements prepared on reader and writer. Inside
+	// WithTx both are bound to the transaction.
+	read  statements
+	write statements
+	tx    *sql.Tx
+	// crypt is nil unless encryption at rest is on.
+	crypt *crypt
+	log   *slog.Logger
+	opts  Options
 }
 
-var ErrNoteNotFound = errors.New("note not found")
+// statements are prepared once in New and reused by every call.
+type statements struct {
+	getAll  *sql.Stmt
+	getById *sql.Stmt
+	add     *sql.Stmt
+	insert  *sql.Stmt
+	put     *sql.Stmt
+	edit    *sql.Stmt
+	delete  *sql.Stmt
+	setKDF  *sql.Stmt
+	seal    *sql.Stmt
+
+	deleteLinks    *sql.Stmt
+	addLink        *sql.Stmt
+	getLinks       *sql.Stmt
+	getBacklinks   *sql.Stmt
+	getBrokenLinks *sql.Stmt
+	getAllLinks    *sql.Stmt
+
+	addAttachment       *sql.Stmt
+	restoreAttachment   *sql.Stmt
+	getAttachments      *sql.Stmt
+	getAllAttachments   *sql.Stmt
+	getAttachment       *sql.Stmt
+	deleteAttachment    *sql.Stmt
+	countBlobReferences *sql.Stmt
+	getBlobs            *sql.Stmt
+
+	getUsage *sql.Stmt
+
+	getIdempotencyKey      *sql.Stmt
+	reserveIdempotencyKey  *sql.Stmt
+	completeIdempotencyKey *sql.Stmt
+	releaseIdempotencyKey  *sql.Stmt
+	purgeIdempotencyKeys   *sql.Stmt
+
+	getJournalSeq *sql.Stmt
+	setJournalSeq *sql.Stmt
+}
+
+// Options tunes how queries are run. Zero values disable the corresponding
+// behaviour.
+type Options struct {
+	// QueryTimeout bounds every storage call.
+	QueryTimeout time.Duration
+	// SlowQueryThreshold is the duration above which a call is logged as slow.
+	SlowQueryThreshold time.Duration
+	// MasterKey turns encryption at rest on: headers, contents and link
+	// targets are sealed under data keys it wraps. TitleIndex keeps a keyed
+	// hash of titles along, which [[title]] links need to resolve.
+	MasterKey  []byte
+	TitleIndex bool
+}
+
+var ErrNoteNotFound = notes.ErrNoteNotFound
 
 type shutdownFunc func() error
 
-func New(storagePath string, log *slog.Logger) (*Storage, shutdownFunc) {
-	db, err := sql.Open("sqlite3", storagePath)
+const (
+	// writerOptions are applied to every connection of the writer. The
+	// immediate transaction lock makes a transaction take the write lock up
+	// front instead of failing with SQLITE_BUSY when it upgrades.
+	writerOptions = "_journal_mode=WAL&_busy_timeout=5000&_synchronous=NORMAL&_foreign_keys=on&_txlock=immediate"
+	readerOptions = "mode=ro&_busy_timeout=5000&_foreign_keys=on"
+	// secureDelete zeroes what is deleted or overwritten, so that plaintext
+	// doesn't linger in the free pages of an encrypted database.
+	secureDelete = "&_secure_delete=on"
+)
+
+func New(storagePath string, log *slog.Logger, opts Options) (*Storage, shutdownFunc) {
+	dsn := "file:" + storagePath + "?" + writerOptions
+	if opts.MasterKey != nil {
+		dsn += secureDelete
+	}
+	writer, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		panic(err)
+	}
+	writer.SetMaxOpenConns(1)
+	// The first connection switches the database to WAL mode, which the
+	// read-only connections can't do themselves.
+	if err := writer.Ping(); err != nil {
+		panic(err)
+	}
+
+	reader, err := sql.Open("sqlite3", "file:"+storagePath+"?"+readerOptions)
 	if err != nil {
 		panic(err)
 	}
+	reader.SetMaxOpenConns(max(4, runtime.NumCPU()))
+
+	s := &Storage{writer: writer, reader: reader, log: log, opts: opts}
+	if s.read, err = prepareStatements(reader); err == nil {
+		s.write, err = prepareStatements(writer)
+	}
+	if err != nil {
+		panic("failed to prepare statements, is the database migrated? " + err.Error())
+	}
+	if err := s.loadKeys(context.Background()); err != nil {
+		panic("cannot load the data keys: " + err.Error())
+	}
 	log.Info("DB is connected")
 
-	return &Storage{db: db}, func() error {
-		err := db.Close()
+	return s, func() error {
+		return errors.Join(s.read.close(), s.write.close(), reader.Close(), writer.Close())
+	}
+}
+
+func prepareStatements(db *sql.DB) (st statements, err error) {
+	prepare := func(query string) *sql.Stmt {
+		if err != nil {
+			return nil
+		}
+		var stmt *sql.Stmt
+		stmt, err = db.Prepare(query)
+		return stmt
+	}
+
+	st = statements{
+		getAll:  prepare("SELECT header, content, id, created_at, updated_at, type, kdf FROM notes ORDER BY id"),
+		getById: prepare("SELECT header, content, id, created_at, updated_at, type, kdf FROM notes WHERE id = ?"),
+		add:     prepare("INSERT INTO notes(header, content, title_key, created_at, updated_at) VALUES(?1, ?2, ?3, ?4, ?4)"),
+		insert:  prepare("INSERT INTO notes(id, header, content, title_key, type, kdf, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)"),
+		edit:    prepare("UPDATE notes SET header = ?, content = ?, title_key = ?, updated_at = ? WHERE id = ?"),
+		delete:  prepare("DELETE FROM notes WHERE id = ?"),
+		setKDF:  prepare("UPDATE notes SET kdf = ? WHERE id = ?"),
+		seal:    prepare("UPDATE notes SET header = ?, content = ? WHERE id = ?"),
+		// put upserts without deleting the row, which would take the links
+		// of the note with it.
+		put: prepare(`INSERT INTO notes(id, header, content, title_key, type, kdf, created_at, updated_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET header = excluded.header, content = excluded.content, title_key = excluded.title_key,
+				type = excluded.type, kdf = excluded.kdf,
+				created_at = excluded.created_at, updated_at = excluded.updated_at`),
+
+		deleteLinks: prepare("DELETE FROM links WHERE source_id = ?"),
+		addLink:     prepare("INSERT INTO links(source_id, target, target_id, title, title_key) VALUES(?, ?, ?, ?, ?)"),
+		getLinks:    prepare("SELECT source_id, target, resolved_id FROM resolved_links WHERE source_id = ? ORDER BY target"),
+		// The title conditions narrow the search down to the candidates the
+		// indexes can find; resolved_id then drops the links that resolve to
+		// another note of the same title.
+		getBacklinks: prepare(`SELECT source_id, target, resolved_id FROM resolved_links
+			WHERE (target_id = ?1 OR title = (SELECT header FROM notes WHERE id = ?1)
+				OR title_key = (SELECT title_key FROM notes WHERE id = ?1)) AND resolved_id = ?1
+			ORDER BY source_id, target`),
+		getBrokenLinks: prepare("SELECT source_id, target, resolved_id FROM resolved_links WHERE resolved_id = 0 ORDER BY source_id, target"),
+		getAllLinks:    prepare("SELECT source_id, target, resolved_id FROM resolved_links ORDER BY source_id, target"),
+
+		// addAttachment adds nothing if the note doesn't exist, which tells
+		// that case from other constraint violations.
+		addAttachment: prepare(`INSERT INTO attachments(note_id, name, media_type, size, sha256, width, height, format, created_at)
+			SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9 WHERE EXISTS (SELECT 1 FROM notes WHERE id = ?1)`),
+		restoreAttachment: prepare(`INSERT INTO attachments(id, note_id, name, media_type, size, sha256, width, height, format, created_at)
+			SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10 WHERE EXISTS (SELECT 1 FROM notes WHERE id = ?2)`),
+		getAttachments:      prepare("SELECT id, note_id, name, media_type, size, sha256, width, height, format, created_at FROM attachments WHERE note_id = ? ORDER BY id"),
+		getAllAttachments:   prepare("SELECT id, note_id, name, media_type, size, sha256, width, height, format, created_at FROM attachments ORDER BY id"),
+		getAttachment:       prepare("SELECT id, note_id, name, media_type, size, sha256, width, height, format, created_at FROM attachments WHERE note_id = ? AND id = ?"),
+		deleteAttachment:    prepare("DELETE FROM attachments WHERE note_id = ? AND id = ?"),
+		countBlobReferences: prepare("SELECT COUNT(*) FROM attachments WHERE sha256 = ?"),
+		getBlobs:            prepare("SELECT DISTINCT sha256 FROM attachments"),
+
+		getUsage: prepare("SELECT notes, note_bytes, attachments, attachment_bytes FROM usage"),
+
+		getIdempotencyKey:      prepare("SELECT key, request_hash, status, content_type, body, sealed, created_at FROM idempotency_keys WHERE key = ?"),
+		reserveIdempotencyKey:  prepare("INSERT INTO idempotency_keys(key, request_hash, created_at) VALUES(?, ?, ?) ON CONFLICT(key) DO NOTHING"),
+		completeIdempotencyKey: prepare("UPDATE idempotency_keys SET status = ?, content_type = ?, body = ?, sealed = ? WHERE key = ? AND created_at = ?"),
+		releaseIdempotencyKey:  prepare("DELETE FROM idempotency_keys WHERE key = ? AND created_at = ?"),
+		purgeIdempotencyKeys:   prepare("DELETE FROM idempotency_keys WHERE created_at < ? OR status = 0 AND created_at < ?"),
+
+		getJournalSeq: prepare("SELECT seq FROM journal_state"),
+		setJournalSeq: prepare("INSERT INTO journal_state(id, seq) VALUES(1, ?) ON CONFLICT(id) DO UPDATE SET seq = excluded.seq"),
+	}
+	if err != nil {
+		st.close()
+	}
+
+	return st, err
+}
+
+// bind returns the statements as part of tx. They are closed together with tx.
+func (st statements) bind(ctx context.Context, tx *sql.Tx) statements {
+	return statements{
+		getAll:  tx.StmtContext(ctx, st.getAll),
+		getById: tx.StmtContext(ctx, st.getById),
+		add:     tx.StmtContext(ctx, st.add),
+		insert:  tx.StmtContext(ctx, st.insert),
+		put:     tx.StmtContext(ctx, st.put),
+		edit:    tx.StmtContext(ctx, st.edit),
+		delete:  tx.StmtContext(ctx, st.delete),
+		setKDF:  tx.StmtContext(ctx, st.setKDF),
+		seal:    tx.StmtContext(ctx, st.seal),
+
+		deleteLinks:    tx.StmtContext(ctx, st.deleteLinks),
+		addLink:        tx.StmtContext(ctx, st.addLink),
+		getLinks:       tx.StmtContext(ctx, st.getLinks),
+		getBacklinks:   tx.StmtContext(ctx, st.getBacklinks),
+		getBrokenLinks: tx.StmtContext(ctx, st.getBrokenLinks),
+		getAllLinks:    tx.StmtContext(ctx, st.getAllLinks),
+
+		addAttachment:       tx.StmtContext(ctx, st.addAttachment),
+		restoreAttachment:   tx.StmtContext(ctx, st.restoreAttachment),
+		getAttachments:      tx.StmtContext(ctx, st.getAttachments),
+		getAllAttachments:   tx.StmtContext(ctx, st.getAllAttachments),
+		getAttachment:       tx.StmtContext(ctx, st.getAttachment),
+		deleteAttachment:    tx.StmtContext(ctx, st.deleteAttachment),
+		countBlobReferences: tx.StmtContext(ctx, st.countBlobReferences),
+		getBlobs:            tx.StmtContext(ctx, st.getBlobs),
+
+		getUsage: tx.StmtContext(ctx, st.getUsage),
+
+		getIdempotencyKey:      tx.StmtContext(ctx, st.getIdempotencyKey),
+		reserveIdempotencyKey:  tx.StmtContext(ctx, st.reserveIdempotencyKey),
+		completeIdempotencyKey: tx.StmtContext(ctx, st.completeIdempotencyKey),
+		releaseIdempotencyKey:  tx.StmtContext(ctx, st.releaseIdempotencyKey),
+		purgeIdempotencyKeys:   tx.StmtContext(ctx, st.purgeIdempotencyKeys),
+
+		getJournalSeq: tx.StmtContext(ctx, st.getJournalSeq),
+		setJournalSeq: tx.StmtContext(ctx, st.setJournalSeq),
+	}
+}
+
+func (st statements) close() error {
+	var errs []error
+	for _, stmt := range []*sql.Stmt{
+		st.getAll, st.getById, st.add, st.insert, st.put, st.edit, st.delete, st.setKDF, st.seal,
+		st.deleteLinks, st.addLink, st.getLinks, st.getBacklinks, st.getBrokenLinks, st.getAllLinks,
+		st.addAttachment, st.restoreAttachment, st.getAttachments, st.getAllAttachments, st.getAttachment, st.deleteAttachment, st.countBlobReferences, st.getBlobs,
+		st.getUsage,
+		st.getIdempotencyKey, st.reserveIdempotencyKey, st.completeIdempotencyKey, st.releaseIdempotencyKey, st.purgeIdempotencyKeys,
+		st.getJournalSeq, st.setJournalSeq,
+	} {
+		if stmt != nil {
+			errs = append(errs, stmt.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WithTx runs fn in a single write transaction. The transaction is committed
+// if fn returns nil and rolled back otherwise. fn must only use the storage it
+// is given: the writer has one connection, so calling s from fn deadlocks.
+// Calling WithTx on a storage that is already inside a transaction runs fn in
+// that transaction.
+func (s *Storage) WithTx(ctx context.Context, fn func(tx notes.Storage) error) (err error) {
+	return s.withTx(ctx, func(tx *Storage) error { return fn(tx) })
+}
+
+func (s *Storage) withTx(ctx context.Context, fn func(tx *Storage) error) (err error) {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.writer.BeginTx(ctx, nil)
+	if err != nil {
+		return storageError(ctx, err)
+	}
+	// A commit makes the rollback a no-op; a panic in fn must not leave the
+	// transaction holding the only write connection.
+	defer tx.Rollback()
+
+	bound := s.write.bind(ctx, tx)
+	txStorage := &Storage{
+		writer: s.writer,
+		reader: s.reader,
+		read:   bound,
+		write:  bound,
+		tx:     tx,
+		crypt:  s.crypt,
+		log:    s.log,
+		opts:   s.opts,
+	}
+	if err := fn(txStorage); err != nil {
 		return err
 	}
+
+	return storageError(ctx, tx.Commit())
+}
+
+// WithReadTx runs fn in a read transaction on a read connection, whose view
+// of the database doesn't change while it runs and doesn't hold up writes.
+// The transaction is always rolled back, writing through it fails.
+func (s *Storage) WithReadTx(ctx context.Context, fn func(tx notes.Storage) error) (err error) {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.reader.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return storageError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	bound := s.read.bind(ctx, tx)
+	return fn(&Storage{
+		writer: s.writer,
+		reader: s.reader,
+		read:   bound,
+		write:  bound,
+		tx:     tx,
+		crypt:  s.crypt,
+		log:    s.log,
+		opts:   s.opts,
+	})
 }
 
 func (s *Storage) GetAll(ctx context.Context) (notes []models.Note, err error) {
-	stmt, err := s.db.Prepare("SELECT header, content, id FROM notes")
+	const op = "storage.GetAll"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	rows, err := s.read.getAll.QueryContext(ctx)
 	if err != nil {
 		return nil, err
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	rows, err := stmt.Query()
 	for rows.Next() {
-		var header, content string
-		var id int64
-		if err := rows.Scan(&header, &content, &id); err != nil {
+		note, err := s.scanNote(rows)
+		if err != nil {
 			return nil, err
 		}
-		notes = append(notes, models.Note{
-			Header:  header,
-			Content: content,
-			Id:      id,
-		})
+		notes = append(notes, note)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
 	return notes, nil
 }
 
-func (s *Storage) GetById(ctx context.Context, id int64) (note models.Note, err error) {
-	stmt, err := s.db.Prepare("SELECT header, content, id FROM notes WHERE id = ?")
+func (s *Storage) EachNote(ctx context.Context, fn func(note models.Note) error) (err error) {
+	rows, err := s.read.getAll.QueryContext(ctx)
 	if err != nil {
-		return models.Note{}, err
+		return storageError(ctx, err)
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	row := stmt.QueryRowContext(ctx, id)
-	if err := row.Scan(&note.Header, &note.Content, &note.Id); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return models.Note{}, ErrNoteNotFound
+	for rows.Next() {
+		note, err := s.scanNote(rows)
+		if err != nil {
+			return storageError(ctx, err)
+		}
+		if err := fn(note); err != nil {
+			return err
 		}
-		return models.Note{}, err
 	}
 
-	return note, nil
+	return storageError(ctx, rows.Err())
+}
+
+// Snapshot writes a consistent copy of the database to path, which must not
+// exist, with VACUUM INTO. It runs on a read connection, so writes go on
+// meanwhile, and isn't bound by the query timeout.
+func (s *Storage) Snapshot(ctx context.Context, path string) (err error) {
+	_, err = s.reader.ExecContext(ctx, "VACUUM INTO ?", path)
+	return storageError(ctx, err)
+}
+
+func (s *Storage) GetById(ctx context.Context, id int64) (note models.Note, err error) {
+	const op = "storage.GetById"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	note, err = s.scanNote(s.read.getById.QueryRowContext(ctx, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Note{}, ErrNoteNotFound
+	}
+	return note, err
 }
 
 func (s *Storage) Add(ctx context.Context, header string, content string) (id int64, err error) {
-	stmt, err := s.db.Prepare("INSERT INTO notes(header, content) VALUES(?, ?)")
+	const op = "storage.Add"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	if s.crypt != nil {
+		return s.insertSealed(ctx, stamp(models.Note{Header: header, Content: content}))
+	}
+	res, err := s.write.add.ExecContext(ctx, header, content, nil, now().UnixMilli())
 	if err != nil {
 		return 0, err
 	}
-	defer stmt.Close()
 
-	res, err := stmt.ExecContext(ctx, header, content)
+	id, err = res.LastInsertId()
 	if err != nil {
 		return 0, err
 	}
+	return id, err
+}
 
-	id, err = res.LastInsertId()
+func (s *Storage) Insert(ctx context.Context, note models.Note) (id int64, err error) {
+	const op = "storage.Insert"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	note = stamp(note)
+	if note.Id == 0 && s.crypt != nil {
+		return s.insertSealed(ctx, note)
+	}
+
+	var noteId any
+	if note.Id != 0 {
+		noteId = note.Id
+	}
+	header, content, err := s.sealNote(note.Id, note.Header, note.Content)
 	if err != nil {
 		return 0, err
 	}
-	return id, err
+	kdf, err := kdfValue(note.KDF)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.write.insert.ExecContext(ctx, noteId, header, content, s.crypt.titleKey(note.Header), note.Type, kdf, note.CreatedAt.UnixMilli(), note.UpdatedAt.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
 }
 
-func (s *Storage) Edit(ctx context.Context, header string, content string, id int64) (err error) {
-	stmt, err := s.db.Prepare("UPDATE notes SET header = ?, content = ? WHERE id = ?")
+// insertSealed stores a note without an id when encryption is on. Its sealed
+// header and content are bound to its id, which is only known once the row is
+// inserted: the row is inserted empty and sealed in the same transaction.
+func (s *Storage) insertSealed(ctx context.Context, note models.Note) (id int64, err error) {
+	kdf, err := kdfValue(note.KDF)
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.withTx(ctx, func(tx *Storage) error {
+		res, err := tx.write.insert.ExecContext(ctx, nil, "", nil, tx.crypt.titleKey(note.Header), note.Type, kdf, note.CreatedAt.UnixMilli(), note.UpdatedAt.UnixMilli())
+		if err != nil {
+			return err
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		header, content, err := tx.sealNote(id, note.Header, note.Content)
+		if err != nil {
+			return err
+		}
+		_, err = tx.write.seal.ExecContext(ctx, header, content, id)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (s *Storage) Restore(ctx context.Context, note models.Note) (err error) {
+	const op = "storage.Restore"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	header, content, err := s.sealNote(note.Id, note.Header, note.Content)
+	if err != nil {
+		return err
+	}
+	kdf, err := kdfValue(note.KDF)
+	if err != nil {
+		return err
+	}
+	_, err = s.write.insert.ExecContext(ctx, note.Id, header, content, s.crypt.titleKey(note.Header), note.Type, kdf, toMillis(note.CreatedAt), toMillis(note.UpdatedAt))
+	return err
+}
+
+// Put stores note exactly as it is, replacing the note with the same id
+// without touching its links.
+func (s *Storage) Put(ctx context.Context, note models.Note) (err error) {
+	const op = "storage.Put"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	header, content, err := s.sealNote(note.Id, note.Header, note.Content)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	kdf, err := kdfValue(note.KDF)
+	if err != nil {
+		return err
+	}
+	_, err = s.write.put.ExecContext(ctx, note.Id, header, content, s.crypt.titleKey(note.Header), note.Type, kdf, toMillis(note.CreatedAt), toMillis(note.UpdatedAt))
+	return err
+}
+
+func (s *Storage) Edit(ctx context.Context, header string, content string, id int64) (err error) {
+	const op = "storage.Edit"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
 
-	res, err := stmt.ExecContext(ctx, header, content, id)
+	sealedHeader, sealedContent, err := s.sealNote(id, header, content)
+	if err != nil {
+		return err
+	}
+	res, err := s.write.edit.ExecContext(ctx, sealedHeader, sealedContent, s.crypt.titleKey(header), now().UnixMilli(), id)
 	if err != nil {
 		return err
 	}
@@ -114,14 +540,37 @@ func (s *Storage) Edit(ctx context.Context, header string, content string, id in
 	return err
 }
 
-func (s *Storage) Delete(ctx context.Context, id int64) (err error) {
-	stmt, err := s.db.Prepare("DELETE FROM notes WHERE id = ?")
+func (s *Storage) SetKDF(ctx context.Context, id int64, kdf models.KDF) (err error) {
+	const op = "storage.SetKDF"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	value, err := kdfValue(kdf)
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
+	res, err := s.write.setKDF.ExecContext(ctx, value, id)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); rows == 0 {
+		if err != nil {
+			return err
+		}
+		return ErrNoteNotFound
+	}
+	return err
+}
 
-	res, err := stmt.ExecContext(ctx, id)
+func (s *Storage) Delete(ctx context.Context, id int64) (err error) {
+	const op = "storage.Delete"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	res, err := s.write.delete.ExecContext(ctx, id)
+	if err != nil {
+		return err
+	}
 	if rows, err := res.RowsAffected(); rows == 0 {
 		if err != nil {
 			return err
@@ -130,3 +579,117 @@ func (s *Storage) Delete(ctx context.Context, id int64) (err error) {
 	}
 	return err
 }
+
+// scanNote reads a note selected as header, content, id, created_at,
+// updated_at, type, kdf, decrypting its header and content.
+func (s *Storage) scanNote(row interface{ Scan(dest ...any) error }) (note models.Note, err error) {
+	var header, content any
+	var createdAt, updatedAt sql.NullInt64
+	var kdf sql.NullString
+	if err := row.Scan(&header, &content, &note.Id, &createdAt, &updatedAt, &note.Type, &kdf); err != nil {
+		return models.Note{}, err
+	}
+	if kdf.Valid {
+		if err := json.Unmarshal([]byte(kdf.String), &note.KDF); err != nil {
+			return models.Note{}, fmt.Errorf("note %d: malformed kdf: %w", note.Id, err)
+		}
+	}
+	if note.Header, err = s.crypt.open(header, "header", note.Id); err != nil {
+		return models.Note{}, fmt.Errorf("note %d: %w", note.Id, err)
+	}
+	if note.Content, err = s.crypt.open(content, "content", note.Id); err != nil {
+		return models.Note{}, fmt.Errorf("note %d: %w", note.Id, err)
+	}
+	note.CreatedAt = fromMillis(createdAt)
+	note.UpdatedAt = fromMillis(updatedAt)
+
+	return note, nil
+}
+
+// sealNote returns what the header and content of note id are stored as.
+func (s *Storage) sealNote(id int64, header string, content string) (sealedHeader any, sealedContent any, err error) {
+	if sealedHeader, err = s.crypt.seal(header, "header", id); err != nil {
+		return nil, nil, err
+	}
+	if sealedContent, err = s.crypt.seal(content, "content", id); err != nil {
+		return nil, nil, err
+	}
+	return sealedHeader, sealedContent, nil
+}
+
+// kdfValue returns what the key derivation parameters of a note are stored
+// as: JSON, or NULL if there are none.
+func kdfValue(kdf models.KDF) (any, error) {
+	if kdf == (models.KDF{}) {
+		return nil, nil
+	}
+	data, err := json.Marshal(kdf)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// now returns the current time at the precision timestamps are stored with.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// stamp sets the zero timestamps of note to now, at the precision they are
+// stored with.
+func stamp(note models.Note) models.Note {
+	current := now()
+	if note.CreatedAt.IsZero() {
+		note.CreatedAt = current
+	}
+	if note.UpdatedAt.IsZero() {
+		note.UpdatedAt = current
+	}
+	note.CreatedAt = note.CreatedAt.UTC().Truncate(time.Millisecond)
+	note.UpdatedAt = note.UpdatedAt.UTC().Truncate(time.Millisecond)
+
+	return note
+}
+
+// toMillis is the inverse of fromMillis: a zero time is stored as NULL.
+func toMillis(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+func fromMillis(ms sql.NullInt64) time.Time {
+	if !ms.Valid {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms.Int64).UTC()
+}
+
+// begin applies the query timeout to ctx. The returned done func must be
+// called with the result of the call: it releases the timeout, logs slow
+// queries and translates context failures into notes.ErrCanceled and
+// notes.ErrTimeout.
+func (s *Storage) begin(ctx context.Context, op string) (context.Context, func(error) error) {
+	start := time.Now()
+
+	cancel := context.CancelFunc(func() {})
+	if s.opts.QueryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.opts.QueryTimeout)
+	}
+
+	return ctx, func(err error) error {
+		err = storageError(ctx, err)
+		cancel()
+
+		if elapsed := time.Since(start); s.opts.SlowQueryThreshold > 0 && elapsed > s.opts.SlowQueryThreshold {
+			s.log.Warn(
+				"Slow query",
+				slog.String("op", op),
+				slog.Duration("elapsed", elapsed),
+			)
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,184 @@
+package notestorage_test
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
+)
+
+// Run with: go test -run '^$' -bench . ./internal/storage/notes
+//
+// Every benchmark compares Storage with a baseline that opens the database
+// with the driver defaults and prepares every statement on each call, the way
+// the storage used to work.
+
+func BenchmarkAdd(b *testing.B) {
+	for name, s := range benchStorages(b) {
+		b.Run(name, func(b *testing.B) {
+			ctx := context.Background()
+			for b.Loop() {
+				if _, err := s.Add(ctx, "header", "content"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkGetById(b *testing.B) {
+	for name, s := range benchStorages(b) {
+		b.Run(name, func(b *testing.B) {
+			ids := seed(b, s, 1000)
+			ctx := context.Background()
+			i := 0
+			for b.Loop() {
+				if _, err := s.GetById(ctx, ids[i%len(ids)]); err != nil {
+					b.Fatal(err)
+				}
+				i++
+			}
+		})
+	}
+}
+
+func BenchmarkGetByIdParallel(b *testing.B) {
+	for name, s := range benchStorages(b) {
+		b.Run(name, func(b *testing.B) {
+			ids := seed(b, s, 1000)
+			ctx := context.Background()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if _, err := s.GetById(ctx, ids[i%len(ids)]); err != nil {
+						b.Error(err)
+						return
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkMixedParallel runs one write for every nine reads.
+func BenchmarkMixedParallel(b *testing.B) {
+	for name, s := range benchStorages(b) {
+		b.Run(name, func(b *testing.B) {
+			ids := seed(b, s, 1000)
+			ctx := context.Background()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					var err error
+					if i%10 == 0 {
+						err = s.Edit(ctx, "header "+strconv.Itoa(i), "content", ids[i%len(ids)])
+					} else {
+						_, err = s.GetById(ctx, ids[i%len(ids)])
+					}
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+// benchStorage is the part of notes.Storage the benchmarks exercise.
+type benchStorage interface {
+	GetById(ctx context.Context, id int64) (note models.Note, err error)
+	Add(ctx context.Context, header string, content string) (id int64, err error)
+	Edit(ctx context.Context, header string, content string, id int64) (err error)
+}
+
+func benchStorages(b *testing.B) map[string]benchStorage {
+	b.Helper()
+
+	tunedPath := migratedPath(b)
+	tuned, shutdown := notestorage.New(tunedPath, slog.New(slog.DiscardHandler), notestorage.Options{})
+	b.Cleanup(func() { shutdown() })
+
+	db, err := sql.Open("sqlite3", migratedPath(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	return map[string]benchStorage{
+		"tuned":    tuned,
+		"baseline": baselineStorage{db},
+	}
+}
+
+func migratedPath(b *testing.B) string {
+	b.Helper()
+
+	storagePath := filepath.Join(b.TempDir(), "notes.db")
+	if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+		b.Fatalf("Migrate: %v", err)
+	}
+	return storagePath
+}
+
+func seed(b *testing.B, s benchStorage, n int) []int64 {
+	b.Helper()
+
+	ids := make([]int64, n)
+	for i := range ids {
+		id, err := s.Add(context.Background(), "header "+strconv.Itoa(i), "content")
+		if err != nil {
+			b.Fatal(err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+// baselineStorage prepares every statement per call on a default connection.
+type baselineStorage struct {
+	db *sql.DB
+}
+
+func (s baselineStorage) GetById(ctx context.Context, id int64) (note models.Note, err error) {
+	stmt, err := s.db.PrepareContext(ctx, "SELECT header, content, id FROM notes WHERE id = ?")
+	if err != nil {
+		return note, err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, id).Scan(&note.Header, &note.Content, &note.Id)
+	return note, err
+}
+
+func (s baselineStorage) Add(ctx context.Context, header string, content string) (int64, error) {
+	stmt, err := s.db.PrepareContext(ctx, "INSERT INTO notes(header, content) VALUES(?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, header, content)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s baselineStorage) Edit(ctx context.Context, header string, content string, id int64) error {
+	stmt, err := s.db.PrepareContext(ctx, "UPDATE notes SET header = ?, content = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, header, content, id)
+	return err
+}
@@ -0,0 +1,856 @@
+package notestorage_test
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/envelope"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/journal"
+	"github.com/sergeyreshetnyakov/notion/internal/middlewares"
+	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/storage/notes/storagetest"
+)
+
+const migrationsPath = "../../../migrations"
+
+func newStorage(t *testing.T) *notestorage.Storage {
+	t.Helper()
+
+	storagePath := filepath.Join(t.TempDir(), "notes.db")
+	if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	storage, shutdown := notestorage.New(storagePath, slog.New(slog.DiscardHandler), notestorage.Options{})
+	t.Cleanup(func() { shutdown() })
+
+	return storage
+}
+
+func newMemoryStorage(t *testing.T) *notestorage.MemoryStorage {
+	t.Helper()
+
+	storage, shutdown := notestorage.NewMemory("", slog.New(slog.DiscardHandler))
+	t.Cleanup(func() { shutdown() })
+
+	return storage
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) notes.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) notes.Storage {
+		return newMemoryStorage(t)
+	})
+}
+
+func TestJournaledStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) notes.Storage {
+		return newJournaled(t, newStorage(t), t.TempDir())
+	})
+}
+
+func TestAttachmentStore(t *testing.T) {
+	storagetest.RunAttachmentStore(t, func(t *testing.T) storagetest.AttachmentStore {
+		return newStorage(t)
+	})
+}
+
+func TestMemoryAttachmentStore(t *testing.T) {
+	storagetest.RunAttachmentStore(t, func(t *testing.T) storagetest.AttachmentStore {
+		return newMemoryStorage(t)
+	})
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	storagetest.RunIdempotencyStore(t, func(t *testing.T) middlewares.IdempotencyStore {
+		return newStorage(t)
+	})
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	storagetest.RunIdempotencyStore(t, func(t *testing.T) middlewares.IdempotencyStore {
+		return newMemoryStorage(t)
+	})
+}
+
+func TestEncryptedStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) notes.Storage {
+		storagePath := filepath.Join(t.TempDir(), "notes.db")
+		if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+		return openEncrypted(t, storagePath, newKey(t), true)
+	})
+}
+
+func newKey(t *testing.T) []byte {
+	t.Helper()
+
+	key, err := envelope.NewKey()
+	if err != nil {
+		t.Fatalf("NewKey: %v", err)
+	}
+	return key
+}
+
+func openEncrypted(t *testing.T, storagePath string, key []byte, titleIndex bool) *notestorage.Storage {
+	t.Helper()
+
+	storage, shutdown := notestorage.New(storagePath, slog.New(slog.DiscardHandler), notestorage.Options{
+		MasterKey:  key,
+		TitleIndex: titleIndex,
+	})
+	t.Cleanup(func() { shutdown() })
+
+	return storage
+}
+
+// mustNotOpen checks that New fails on the database with the key.
+func mustNotOpen(t *testing.T, storagePath string, key []byte) {
+	t.Helper()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("New with master key %v succeeded, want a panic", key)
+		}
+	}()
+	openEncrypted(t, storagePath, key, false)
+}
+
+// assertNoPlaintext checks that none of secrets is in the files of the
+// database, once it is closed.
+func assertNoPlaintext(t *testing.T, storagePath string, secrets ...string) {
+	t.Helper()
+
+	paths, err := filepath.Glob(storagePath + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		for _, secret := range secrets {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s holds %q in plaintext", filepath.Base(path), secret)
+			}
+		}
+	}
+}
+
+func TestEncryptionAtRest(t *testing.T) {
+	ctx := t.Context()
+	storagePath := filepath.Join(t.TempDir(), "notes.db")
+	if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	key := newKey(t)
+
+	storage, shutdown := notestorage.New(storagePath, slog.New(slog.DiscardHandler), notestorage.Options{MasterKey: key})
+	target, err := storage.Add(ctx, "Secret Target", "secret content")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	source, err := storage.Add(ctx, "Secret Source", "links to [[secret target]] and [[#1]]")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := storage.SetLinks(ctx, source, []models.LinkTarget{{Title: "secret target"}, {Id: target}}); err != nil {
+		t.Fatalf("SetLinks: %v", err)
+	}
+	if err := storage.Edit(ctx, "Secret Source", "edited secret", source); err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	reservedAt := time.Now().Truncate(time.Millisecond)
+	rec := models.IdempotencyRecord{Key: "key", RequestHash: "hash", CreatedAt: reservedAt}
+	if _, _, err := storage.ReserveIdempotencyKey(ctx, rec, time.Time{}, time.Time{}); err != nil {
+		t.Fatalf("ReserveIdempotencyKey: %v", err)
+	}
+	if err := storage.CompleteIdempotencyKey(ctx, "key", reservedAt, 200, "application/json", []byte(`{"header":"Secret Target"}`)); err != nil {
+		t.Fatalf("CompleteIdempotencyKey: %v", err)
+	}
+
+	// Without the title index a link to a title can't be resolved.
+	links, err := storage.GetLinks(ctx, source)
+	if err != nil {
+		t.Fatalf("GetLinks: %v", err)
+	}
+	want := []models.Link{{SourceId: source, Target: "#1", TargetId: target}, {SourceId: source, Target: "secret target"}}
+	if !reflect.DeepEqual(links, want) {
+		t.Fatalf("GetLinks = %+v, want %+v", links, want)
+	}
+	if err := shutdown(); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	assertNoPlaintext(t, storagePath, "Secret", "secret", "edited")
+	mustNotOpen(t, storagePath, nil)
+	mustNotOpen(t, storagePath, newKey(t))
+
+	storage = openEncrypted(t, storagePath, key, false)
+	note, err := storage.GetById(ctx, source)
+	if err != nil || note.Header != "Secret Source" || note.Content != "edited secret" {
+		t.Fatalf("GetById after reopening = %+v, %v", note, err)
+	}
+	existing, reserved, err := storage.ReserveIdempotencyKey(ctx, rec, time.Time{}, time.Time{})
+	if err != nil || reserved || string(existing.Body) != `{"header":"Secret Target"}` {
+		t.Fatalf("ReserveIdempotencyKey after reopening = %+v, %t, %v", existing, reserved, err)
+	}
+	usage, err := storage.Usage(ctx)
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if wantBytes := int64(len("Secret Target") + len("secret content") + len("Secret Source") + len("edited secret")); usage.NoteBytes != wantBytes {
+		t.Errorf("Usage counts %d note bytes, want the %d of the plaintext", usage.NoteBytes, wantBytes)
+	}
+}
+
+func TestReencrypt(t *testing.T) {
+	ctx := t.Context()
+	storagePath := filepath.Join(t.TempDir(), "notes.db")
+	if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// Notes stored before encryption is turned on.
+	plain, shutdown := notestorage.New(storagePath, slog.New(slog.DiscardHandler), notestorage.Options{})
+	target, err := plain.Add(ctx, "Plain Target", "plain content")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	source, err := plain.Add(ctx, "Plain Source", "[[plain target]]")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := plain.SetLinks(ctx, source, []models.LinkTarget{{Title: "plain target"}}); err != nil {
+		t.Fatalf("SetLinks: %v", err)
+	}
+	want := stateOf(t, plain)
+	shutdown()
+
+	key := newKey(t)
+	encrypted, shutdown := notestorage.New(storagePath, slog.New(slog.DiscardHandler), notestorage.Options{MasterKey: key, TitleIndex: true})
+	if got := stateOf(t, encrypted); !reflect.DeepEqual(got, want) {
+		t.Fatalf("state before Reencrypt = %+v, want %+v", got, want)
+	}
+	report, err := encrypted.Reencrypt(ctx, nil)
+	if err != nil {
+		t.Fatalf("Reencrypt: %v", err)
+	}
+	if report.Notes != 2 || report.Links != 1 || report.MasterKeyId != envelope.KeyId(key) {
+		t.Fatalf("Reencrypt report = %+v", report)
+	}
+	if got := stateOf(t, encrypted); !reflect.DeepEqual(got, want) {
+		t.Fatalf("state after Reencrypt = %+v, want %+v", got, want)
+	}
+	backlinks, err := encrypted.GetBacklinks(ctx, target)
+	if err != nil || len(backlinks) != 1 || backlinks[0].SourceId != source {
+		t.Fatalf("GetBacklinks after Reencrypt = %+v, %v", backlinks, err)
+	}
+
+	// Rotating the master key.
+	rotated := newKey(t)
+	if _, err := encrypted.Reencrypt(ctx, rotated); err != nil {
+		t.Fatalf("Reencrypt with a new master key: %v", err)
+	}
+	shutdown()
+
+	assertNoPlaintext(t, storagePath, "Plain", "plain")
+	mustNotOpen(t, storagePath, key)
+	if got := stateOf(t, openEncrypted(t, storagePath, rotated, true)); !reflect.DeepEqual(got, want) {
+		t.Fatalf("state with the rotated key = %+v, want %+v", got, want)
+	}
+}
+
+func TestEncryptionBindsValuesToTheirNote(t *testing.T) {
+	ctx := t.Context()
+	storagePath := filepath.Join(t.TempDir(), "notes.db")
+	if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	key := newKey(t)
+
+	storage, shutdown := notestorage.New(storagePath, slog.New(slog.DiscardHandler), notestorage.Options{MasterKey: key})
+	for _, content := range []string{"first secret", "second secret"} {
+		if _, err := storage.Add(ctx, "header", content); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	shutdown()
+
+	// A sealed value copied into another note doesn't open there.
+	db, err := sql.Open("sqlite3", storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec("UPDATE notes SET content = (SELECT content FROM notes WHERE id = 2) WHERE id = 1")
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage = openEncrypted(t, storagePath, key, false)
+	if note, err := storage.GetById(ctx, 1); !errors.Is(err, envelope.ErrWrongKey) {
+		t.Fatalf("GetById of a note with the content of another = %+v, %v, want %v", note, err, envelope.ErrWrongKey)
+	}
+	if note, err := storage.GetById(ctx, 2); err != nil || note.Content != "second secret" {
+		t.Fatalf("GetById = %+v, %v", note, err)
+	}
+}
+
+func TestReencryptColumnBoundValues(t *testing.T) {
+	ctx := t.Context()
+	storagePath := filepath.Join(t.TempDir(), "notes.db")
+	if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	key := newKey(t)
+
+	// A note and a link sealed before values were bound to their note, with
+	// their column as the only additional data.
+	dataKey := newKey(t)
+	wrapped, err := envelope.Wrap(key, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := envelope.NewKeyring()
+	if err := keys.Add(1, dataKey); err != nil {
+		t.Fatal(err)
+	}
+	seal := func(value string, column string) []byte {
+		sealed, err := keys.Seal([]byte(value), []byte(column))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	db, err := sql.Open("sqlite3", storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []struct {
+		query string
+		args  []any
+	}{
+		{"INSERT INTO data_keys(id, wrapped, master_key_id, created_at) VALUES(1, ?, ?, 0)", []any{wrapped, envelope.KeyId(key)}},
+		{"INSERT INTO notes(id, header, content, created_at, updated_at) VALUES(1, ?, ?, 0, 0)", []any{seal("Old", "header"), seal("old secret", "content")}},
+		{"INSERT INTO links(source_id, target, target_id) VALUES(1, ?, 1)", []any{seal("#1", "target")}},
+	} {
+		if _, err := db.Exec(stmt.query, stmt.args...); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	storage, shutdown := notestorage.New(storagePath, slog.New(slog.DiscardHandler), notestorage.Options{MasterKey: key})
+	defer shutdown()
+	if note, err := storage.GetById(ctx, 1); err != nil || note.Header != "Old" || note.Content != "old secret" {
+		t.Fatalf("GetById of a column bound note = %+v, %v", note, err)
+	}
+	// New notes are sealed with a data key of their own, that binds them.
+	if _, err := storage.Add(ctx, "New", "new secret"); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	want := stateOf(t, storage)
+
+	if _, err := storage.Reencrypt(ctx, nil); err != nil {
+		t.Fatalf("Reencrypt: %v", err)
+	}
+	if got := stateOf(t, storage); !reflect.DeepEqual(got, want) {
+		t.Fatalf("state after Reencrypt = %+v, want %+v", got, want)
+	}
+	if links, err := storage.GetLinks(ctx, 1); err != nil || len(links) != 1 || links[0].Target != "#1" {
+		t.Fatalf("GetLinks after Reencrypt = %+v, %v", links, err)
+	}
+
+	// Only the new data key is left, and it binds values to their note.
+	db, err = sql.Open("sqlite3", storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n, binding int
+	if err := db.QueryRow("SELECT COUNT(*), SUM(binds_id) FROM data_keys").Scan(&n, &binding); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || binding != 1 {
+		t.Errorf("%d data keys, %d binding values to their note after Reencrypt, want 1 and 1", n, binding)
+	}
+}
+
+func TestMemoryStorageSnapshot(t *testing.T) {
+	snapshotPath := filepath.Join(t.TempDir(), "notes.json")
+	log := slog.New(slog.DiscardHandler)
+
+	storage, shutdown := notestorage.NewMemory(snapshotPath, log)
+	id, err := storage.Add(t.Context(), "header", "content")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := storage.Delete(t.Context(), id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	id, err = storage.Add(t.Context(), "kept", "across restarts")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	attachment := models.Attachment{NoteId: id, Name: "kept.txt", SHA256: "digest", CreatedAt: time.Now().UTC().Truncate(time.Millisecond)}
+	if attachment.Id, err = storage.AddAttachment(t.Context(), attachment); err != nil {
+		t.Fatalf("AddAttachment: %v", err)
+	}
+	if err := shutdown(); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	storage, _ = notestorage.NewMemory(snapshotPath, log)
+	note, err := storage.GetById(t.Context(), id)
+	if err != nil {
+		t.Fatalf("GetById after reload: %v", err)
+	}
+	if note.Header != "kept" || note.Content != "across restarts" {
+		t.Fatalf("GetById after reload = %+v", note)
+	}
+	if got, err := storage.GetAttachment(t.Context(), id, attachment.Id); err != nil || got != attachment {
+		t.Fatalf("GetAttachment after reload = %+v, %v, want %+v", got, err, attachment)
+	}
+
+	newId, err := storage.Add(t.Context(), "new", "note")
+	if err != nil {
+		t.Fatalf("Add after reload: %v", err)
+	}
+	if newId <= id {
+		t.Fatalf("Add after reload reused id %d, last id was %d", newId, id)
+	}
+}
+
+func TestStorageQueryTimeout(t *testing.T) {
+	storagePath := filepath.Join(t.TempDir(), "notes.db")
+	if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	storage, shutdown := notestorage.New(storagePath, slog.New(slog.DiscardHandler), notestorage.Options{
+		QueryTimeout: time.Nanosecond,
+	})
+	defer shutdown()
+
+	if _, err := storage.GetAll(t.Context()); !errors.Is(err, notes.ErrTimeout) {
+		t.Fatalf("GetAll: got %v, want %v", err, notes.ErrTimeout)
+	}
+	if _, err := storage.Add(t.Context(), "header", "content"); !errors.Is(err, notes.ErrTimeout) {
+		t.Fatalf("Add: got %v, want %v", err, notes.ErrTimeout)
+	}
+}
+
+func TestStorageSnapshot(t *testing.T) {
+	storage := newStorage(t)
+	id, err := storage.Add(t.Context(), "header", "content")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := storage.Snapshot(t.Context(), snapshotPath); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if err := storage.Snapshot(t.Context(), snapshotPath); err == nil {
+		t.Fatal("Snapshot over an existing file succeeded, want an error")
+	}
+	// Writes after the snapshot don't reach it.
+	if _, err := storage.Add(t.Context(), "later", ""); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	snapshot, shutdown := notestorage.New(snapshotPath, slog.New(slog.DiscardHandler), notestorage.Options{})
+	defer shutdown()
+	all, err := snapshot.GetAll(t.Context())
+	if err != nil {
+		t.Fatalf("GetAll of the snapshot: %v", err)
+	}
+	if len(all) != 1 || all[0].Id != id || all[0].Header != "header" {
+		t.Fatalf("GetAll of the snapshot = %+v, want the note added before it", all)
+	}
+}
+
+func newJournaled(t *testing.T, storage *notestorage.Storage, dir string) *notestorage.JournaledStorage {
+	t.Helper()
+
+	// Small segments make writes rotate them.
+	w, err := journal.Open(dir, 512)
+	if err != nil {
+		t.Fatalf("journal.Open: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+
+	s, err := notestorage.NewJournaled(storage, w, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewJournaled: %v", err)
+	}
+	return s
+}
+
+// openAt migrates the database at path, maybe a copy of a snapshot, and
+// opens it.
+func openAt(t *testing.T, path string) *notestorage.Storage {
+	t.Helper()
+
+	if err := notestorage.Migrate(path, migrationsPath); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("Migrate: %v", err)
+	}
+	storage, shutdown := notestorage.New(path, slog.New(slog.DiscardHandler), notestorage.Options{})
+	t.Cleanup(func() { shutdown() })
+	return storage
+}
+
+func copyOf(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	copyPath := filepath.Join(t.TempDir(), "restored.db")
+	if err := os.WriteFile(copyPath, data, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return copyPath
+}
+
+// journaledPut is a journal entry putting note, as JournaledStorage writes
+// them.
+func journaledPut(note models.Note) map[string]any {
+	return map[string]any{"changes": []map[string]any{{"op": "put", "note": note}}}
+}
+
+// state is what a replay has to restore.
+type state struct {
+	notes       []models.Note
+	links       []models.Link
+	attachments []models.Attachment
+}
+
+func stateOf(t *testing.T, s notes.Storage) state {
+	t.Helper()
+
+	all, err := s.GetAll(t.Context())
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	links, err := s.GetAllLinks(t.Context())
+	if err != nil {
+		t.Fatalf("GetAllLinks: %v", err)
+	}
+	attachments, err := s.GetAllAttachments(t.Context())
+	if err != nil {
+		t.Fatalf("GetAllAttachments: %v", err)
+	}
+	return state{all, links, attachments}
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	live := newStorage(t)
+	s := newJournaled(t, live, dir)
+	ctx := t.Context()
+
+	first, err := s.Add(ctx, "first", "content")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	second, err := s.Add(ctx, "second", "")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := live.Snapshot(ctx, snapshotPath); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	if err := s.Edit(ctx, "first", "edited", first); err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	if err := s.SetLinks(ctx, first, []models.LinkTarget{{Id: second}, {Title: "missing"}}); err != nil {
+		t.Fatalf("SetLinks: %v", err)
+	}
+	// A transaction that fails isn't journaled.
+	failure := errors.New("failure")
+	err = s.WithTx(ctx, func(tx notes.Storage) error {
+		if _, err := tx.Add(ctx, "rolled back", ""); err != nil {
+			return err
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithTx = %v, want %v", err, failure)
+	}
+	if err := s.Delete(ctx, second); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	until := time.Now()
+	wantUntil := stateOf(t, live)
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := s.Add(ctx, "after", ""); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := s.Delete(ctx, first); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	wantEnd := stateOf(t, live)
+
+	tests := []struct {
+		name  string
+		path  func(t *testing.T) string
+		until time.Time
+		want  state
+	}{
+		{"SnapshotUntil", func(t *testing.T) string { return copyOf(t, snapshotPath) }, until, wantUntil},
+		{"SnapshotToEnd", func(t *testing.T) string { return copyOf(t, snapshotPath) }, time.Now().Add(time.Hour), wantEnd},
+		{"EmptyUntil", func(t *testing.T) string { return filepath.Join(t.TempDir(), "restored.db") }, until, wantUntil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored := openAt(t, tt.path(t))
+			report, err := restored.Replay(ctx, dir, tt.until)
+			if err != nil {
+				t.Fatalf("Replay: %v", err)
+			}
+			if report.Aborted != 0 || report.Torn || report.LastTime.After(tt.until) {
+				t.Fatalf("Replay report = %+v", report)
+			}
+			if got := stateOf(t, restored); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("replayed state = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJournalReplayAttachments(t *testing.T) {
+	dir := t.TempDir()
+	live := newStorage(t)
+	s := newJournaled(t, live, dir)
+	ctx := t.Context()
+
+	attach := func(noteId int64, name string) int64 {
+		t.Helper()
+		id, err := s.AddAttachment(ctx, models.Attachment{
+			NoteId: noteId, Name: name, MediaType: "text/plain", Size: 5, SHA256: strings.Repeat("ab", 32), CreatedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("AddAttachment: %v", err)
+		}
+		return id
+	}
+
+	kept, err := s.Add(ctx, "kept", "")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	deleted, err := s.Add(ctx, "deleted", "")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	before := attach(kept, "before.txt")
+	attach(deleted, "with the note.txt")
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := live.Snapshot(ctx, snapshotPath); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	attach(kept, "after.txt")
+	if err := s.DeleteAttachment(ctx, kept, before); err != nil {
+		t.Fatalf("DeleteAttachment: %v", err)
+	}
+	if err := s.DeleteAttachment(ctx, kept, attach(kept, "short lived.txt")); err != nil {
+		t.Fatalf("DeleteAttachment: %v", err)
+	}
+	attach(deleted, "gone with the note.txt")
+	if err := s.Delete(ctx, deleted); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	want := stateOf(t, live)
+	if len(want.attachments) != 1 {
+		t.Fatalf("live attachments = %+v, want one", want.attachments)
+	}
+
+	for name, path := range map[string]string{
+		"Snapshot": copyOf(t, snapshotPath),
+		"Empty":    filepath.Join(t.TempDir(), "restored.db"),
+	} {
+		t.Run(name, func(t *testing.T) {
+			restored := openAt(t, path)
+			if _, err := restored.Replay(ctx, dir, time.Now()); err != nil {
+				t.Fatalf("Replay: %v", err)
+			}
+			if got := stateOf(t, restored); !reflect.DeepEqual(got, want) {
+				t.Fatalf("replayed state = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestJournalReplayLateAbort(t *testing.T) {
+	dir := t.TempDir()
+	w, err := journal.Open(dir, 0)
+	if err != nil {
+		t.Fatalf("journal.Open: %v", err)
+	}
+	defer w.Close()
+
+	at := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	kept := models.Note{Id: 1, Header: "kept", CreatedAt: at, UpdatedAt: at}
+	aborted := models.Note{Id: 2, Header: "aborted", CreatedAt: at, UpdatedAt: at}
+	// The abort of the second entry is journaled after until, its
+	// transaction failed to commit all the same.
+	for _, entry := range []struct {
+		at   time.Time
+		data any
+	}{
+		{at, journaledPut(kept)},
+		{at.Add(time.Second), journaledPut(aborted)},
+		{at.Add(3 * time.Second), map[string]any{"abort": 2}},
+	} {
+		if _, err := w.Append(entry.at, entry.data); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	restored := openAt(t, filepath.Join(t.TempDir(), "restored.db"))
+	report, err := restored.Replay(t.Context(), dir, at.Add(2*time.Second))
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if report.Entries != 2 || report.Changes != 1 || report.Aborted != 1 || report.LastSeq != 2 {
+		t.Fatalf("Replay report = %+v, want the second entry aborted", report)
+	}
+	if got := stateOf(t, restored); len(got.notes) != 1 || got.notes[0].Header != "kept" {
+		t.Fatalf("replayed notes = %+v, want only the kept one", got.notes)
+	}
+}
+
+func TestJournalUncommittedEntry(t *testing.T) {
+	dir := t.TempDir()
+	live := newStorage(t)
+	ctx := t.Context()
+
+	w, err := journal.Open(dir, 0)
+	if err != nil {
+		t.Fatalf("journal.Open: %v", err)
+	}
+	s, err := notestorage.NewJournaled(live, w, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("NewJournaled: %v", err)
+	}
+	if _, err := s.Add(ctx, "committed", ""); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	// The process dies once the entry of a transaction is synced, before
+	// the transaction commits.
+	if _, err := w.Append(time.Now(), journaledPut(models.Note{Id: 2, Header: "uncommitted"})); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	w.Close()
+
+	entries := func() (data []string) {
+		t.Helper()
+		err := journal.Read(dir, func(entry journal.Entry) error {
+			data = append(data, string(entry.Data))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("journal.Read: %v", err)
+		}
+		return data
+	}
+
+	// Opening the journal again aborts the entry, once.
+	newJournaled(t, live, dir)
+	newJournaled(t, live, dir)
+	got := entries()
+	if len(got) != 3 || got[2] != `{"abort":2}` {
+		t.Fatalf("journal = %q, want the second entry aborted once", got)
+	}
+
+	restored := openAt(t, filepath.Join(t.TempDir(), "restored.db"))
+	report, err := restored.Replay(ctx, dir, time.Now())
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if report.Aborted != 1 {
+		t.Fatalf("Replay report = %+v, want the uncommitted entry aborted", report)
+	}
+	if got, want := stateOf(t, restored), stateOf(t, live); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed state = %+v, want %+v", got, want)
+	}
+}
+
+func TestPruneJournal(t *testing.T) {
+	dir := t.TempDir()
+	live := newStorage(t)
+	s := newJournaled(t, live, dir)
+	ctx := t.Context()
+
+	segments := func() []string {
+		t.Helper()
+		names, err := filepath.Glob(filepath.Join(dir, "journal-*.jsonl"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	for range 4 {
+		if _, err := s.Add(ctx, "before", "the snapshot"); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := live.Snapshot(ctx, snapshotPath); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	for range 2 {
+		if _, err := s.Add(ctx, "after", "the snapshot"); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	before := segments()
+	removed, err := notestorage.PruneJournal(dir, snapshotPath)
+	if err != nil {
+		t.Fatalf("PruneJournal: %v", err)
+	}
+	if len(removed) == 0 || len(segments()) != len(before)-len(removed) {
+		t.Fatalf("PruneJournal removed %q of %q", removed, before)
+	}
+
+	// What is left of the journal still brings the snapshot up to date.
+	restored := openAt(t, copyOf(t, snapshotPath))
+	if _, err := restored.Replay(ctx, dir, time.Now()); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if got, want := stateOf(t, restored), stateOf(t, live); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed state = %+v, want %+v", got, want)
+	}
+}
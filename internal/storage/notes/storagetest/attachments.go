@@ -0,0 +1,286 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/attachments"
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// AttachmentStore keeps notes and their attachments.
+type AttachmentStore interface {
+	notes.Storage
+	attachments.Storage
+}
+
+// RunAttachmentStore runs the suite for attachments.Storage implementations.
+func RunAttachmentStore(t *testing.T, newStore func(t *testing.T) AttachmentStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s AttachmentStore)
+	}{
+		{"Attachments", testAttachments},
+		{"AttachmentsNotFound", testAttachmentsNotFound},
+		{"AttachmentsNoteDelete", testAttachmentsNoteDelete},
+		{"RestoreAttachment", testRestoreAttachment},
+		{"Blobs", testBlobs},
+		{"AttachmentsUsage", testAttachmentsUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func testAttachments(t *testing.T, s AttachmentStore) {
+	ctx := context.Background()
+
+	noteId := mustAdd(t, s, "note", "")
+	first := mustAddAttachment(t, s, noteId, "a.txt", digest("a"))
+	second := mustAddAttachment(t, s, noteId, "b.png", digest("b"), func(a *models.Attachment) {
+		a.MediaType = "image/png"
+		a.Width, a.Height, a.Format = 640, 480, "png"
+	})
+
+	got, err := s.GetAttachment(ctx, noteId, first.Id)
+	if err != nil {
+		t.Fatalf("GetAttachment: %v", err)
+	}
+	if !reflect.DeepEqual(got, first) {
+		t.Fatalf("GetAttachment = %+v, want %+v", got, first)
+	}
+
+	all, err := s.GetAttachments(ctx, noteId)
+	if err != nil {
+		t.Fatalf("GetAttachments: %v", err)
+	}
+	if want := []models.Attachment{first, second}; !reflect.DeepEqual(all, want) {
+		t.Fatalf("GetAttachments = %+v, want %+v", all, want)
+	}
+
+	if err := s.DeleteAttachment(ctx, noteId, first.Id); err != nil {
+		t.Fatalf("DeleteAttachment: %v", err)
+	}
+	all, err = s.GetAttachments(ctx, noteId)
+	if err != nil {
+		t.Fatalf("GetAttachments: %v", err)
+	}
+	if want := []models.Attachment{second}; !reflect.DeepEqual(all, want) {
+		t.Fatalf("GetAttachments after DeleteAttachment = %+v, want %+v", all, want)
+	}
+}
+
+func testAttachmentsNotFound(t *testing.T, s AttachmentStore) {
+	ctx := context.Background()
+
+	noteId := mustAdd(t, s, "note", "")
+	otherId := mustAdd(t, s, "other", "")
+	attachment := mustAddAttachment(t, s, noteId, "a.txt", digest("a"))
+
+	_, err := s.AddAttachment(ctx, models.Attachment{NoteId: otherId + 1000, Name: "a.txt", SHA256: digest("a"), CreatedAt: time.Now()})
+	if !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Errorf("AddAttachment to a missing note: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+
+	// An attachment is only found through its own note.
+	if _, err := s.GetAttachment(ctx, otherId, attachment.Id); !errors.Is(err, attachments.ErrAttachmentNotFound) {
+		t.Errorf("GetAttachment of another note: got %v, want %v", err, attachments.ErrAttachmentNotFound)
+	}
+	if err := s.DeleteAttachment(ctx, otherId, attachment.Id); !errors.Is(err, attachments.ErrAttachmentNotFound) {
+		t.Errorf("DeleteAttachment of another note: got %v, want %v", err, attachments.ErrAttachmentNotFound)
+	}
+
+	if err := s.DeleteAttachment(ctx, noteId, attachment.Id); err != nil {
+		t.Fatalf("DeleteAttachment: %v", err)
+	}
+	if _, err := s.GetAttachment(ctx, noteId, attachment.Id); !errors.Is(err, attachments.ErrAttachmentNotFound) {
+		t.Errorf("GetAttachment after DeleteAttachment: got %v, want %v", err, attachments.ErrAttachmentNotFound)
+	}
+	if err := s.DeleteAttachment(ctx, noteId, attachment.Id); !errors.Is(err, attachments.ErrAttachmentNotFound) {
+		t.Errorf("second DeleteAttachment: got %v, want %v", err, attachments.ErrAttachmentNotFound)
+	}
+}
+
+func testRestoreAttachment(t *testing.T, s AttachmentStore) {
+	ctx := context.Background()
+
+	first := mustAdd(t, s, "first", "")
+	second := mustAdd(t, s, "second", "")
+	added := mustAddAttachment(t, s, first, "a.txt", digest("a"))
+	restored := models.Attachment{
+		Id:        added.Id + 10,
+		NoteId:    second,
+		Name:      "b.png",
+		MediaType: "image/png",
+		Size:      2,
+		SHA256:    digest("b"),
+		Width:     640,
+		Height:    480,
+		Format:    "png",
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 6e6, time.UTC),
+	}
+	if err := s.RestoreAttachment(ctx, restored); err != nil {
+		t.Fatalf("RestoreAttachment: %v", err)
+	}
+
+	all, err := s.GetAllAttachments(ctx)
+	if err != nil {
+		t.Fatalf("GetAllAttachments: %v", err)
+	}
+	if want := []models.Attachment{added, restored}; !reflect.DeepEqual(all, want) {
+		t.Fatalf("GetAllAttachments = %+v, want %+v", all, want)
+	}
+
+	taken := restored
+	taken.NoteId = first
+	if err := s.RestoreAttachment(ctx, taken); !errors.Is(err, notes.ErrConflict) {
+		t.Errorf("RestoreAttachment with a taken id: got %v, want %v", err, notes.ErrConflict)
+	}
+	missing := restored
+	missing.Id, missing.NoteId = restored.Id+1, second+1000
+	if err := s.RestoreAttachment(ctx, missing); !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Errorf("RestoreAttachment to a missing note: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+
+	// Ids go on after the restored one.
+	next := mustAddAttachment(t, s, first, "c.txt", digest("c"))
+	if next.Id <= restored.Id {
+		t.Errorf("AddAttachment after RestoreAttachment returned id %d, want more than %d", next.Id, restored.Id)
+	}
+}
+
+func testAttachmentsNoteDelete(t *testing.T, s AttachmentStore) {
+	ctx := context.Background()
+
+	deleted := mustAdd(t, s, "deleted", "")
+	kept := mustAdd(t, s, "kept", "")
+	mustAddAttachment(t, s, deleted, "a.txt", digest("a"))
+	attachment := mustAddAttachment(t, s, kept, "b.txt", digest("b"))
+
+	if err := s.Delete(ctx, deleted); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	all, err := s.GetAttachments(ctx, deleted)
+	if err != nil {
+		t.Fatalf("GetAttachments: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("GetAttachments of a deleted note = %+v, want none", all)
+	}
+	all, err = s.GetAttachments(ctx, kept)
+	if err != nil {
+		t.Fatalf("GetAttachments: %v", err)
+	}
+	if want := []models.Attachment{attachment}; !reflect.DeepEqual(all, want) {
+		t.Fatalf("GetAttachments of another note = %+v, want %+v", all, want)
+	}
+}
+
+func testBlobs(t *testing.T, s AttachmentStore) {
+	ctx := context.Background()
+
+	first := mustAdd(t, s, "first", "")
+	second := mustAdd(t, s, "second", "")
+	mustAddAttachment(t, s, first, "a.txt", digest("a"))
+	mustAddAttachment(t, s, second, "copy of a.txt", digest("a"))
+	mustAddAttachment(t, s, second, "b.txt", digest("b"))
+
+	assertBlobs(t, s, digest("a"), digest("b"))
+	assertReferences(t, s, digest("a"), 2)
+	assertReferences(t, s, digest("c"), 0)
+
+	if err := s.Delete(ctx, second); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertBlobs(t, s, digest("a"))
+	assertReferences(t, s, digest("a"), 1)
+	assertReferences(t, s, digest("b"), 0)
+}
+
+func testAttachmentsUsage(t *testing.T, s AttachmentStore) {
+	ctx := context.Background()
+
+	first := mustAdd(t, s, "first", "")
+	second := mustAdd(t, s, "second", "")
+	// Attachments with the same content count once each.
+	a := mustAddAttachment(t, s, first, "a.txt", digest("a"))
+	mustAddAttachment(t, s, second, "copy of a.txt", digest("a"))
+	mustAddAttachment(t, s, second, "b.txt", digest("b"))
+	assertUsage(t, s, models.Usage{Notes: 2, NoteBytes: 11, Attachments: 3, AttachmentBytes: 5 + 13 + 5})
+
+	if err := s.DeleteAttachment(ctx, first, a.Id); err != nil {
+		t.Fatalf("DeleteAttachment: %v", err)
+	}
+	assertUsage(t, s, models.Usage{Notes: 2, NoteBytes: 11, Attachments: 2, AttachmentBytes: 13 + 5})
+
+	// The attachments of a deleted note go with it.
+	if err := s.Delete(ctx, second); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertUsage(t, s, models.Usage{Notes: 1, NoteBytes: 5})
+}
+
+// digest makes up the digest of a blob, named by c.
+func digest(c string) string {
+	return string(slices.Repeat([]byte(c), 64))
+}
+
+// mustAddAttachment adds a text attachment, unless edits change it.
+func mustAddAttachment(t *testing.T, s AttachmentStore, noteId int64, name string, sha256 string, edits ...func(a *models.Attachment)) models.Attachment {
+	t.Helper()
+
+	attachment := models.Attachment{
+		NoteId:    noteId,
+		Name:      name,
+		MediaType: "text/plain; charset=utf-8",
+		Size:      int64(len(name)),
+		SHA256:    sha256,
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	for _, edit := range edits {
+		edit(&attachment)
+	}
+	id, err := s.AddAttachment(context.Background(), attachment)
+	if err != nil {
+		t.Fatalf("AddAttachment: %v", err)
+	}
+	if id <= 0 {
+		t.Fatalf("AddAttachment returned id %d, want a positive id", id)
+	}
+	attachment.Id = id
+
+	return attachment
+}
+
+func assertBlobs(t *testing.T, s AttachmentStore, want ...string) {
+	t.Helper()
+
+	got, err := s.GetBlobs(context.Background())
+	if err != nil {
+		t.Fatalf("GetBlobs: %v", err)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Fatalf("GetBlobs = %v, want %v", got, want)
+	}
+}
+
+func assertReferences(t *testing.T, s AttachmentStore, sha256 string, want int) {
+	t.Helper()
+
+	n, err := s.CountBlobReferences(context.Background(), sha256)
+	if err != nil {
+		t.Fatalf("CountBlobReferences: %v", err)
+	}
+	if n != want {
+		t.Fatalf("CountBlobReferences(%s) = %d, want %d", sha256, n, want)
+	}
+}
@@ -0,0 +1,144 @@
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/middlewares"
+)
+
+// RunIdempotencyStore runs the suite for middlewares.IdempotencyStore
+// implementations.
+func RunIdempotencyStore(t *testing.T, newStore func(t *testing.T) middlewares.IdempotencyStore) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s middlewares.IdempotencyStore)
+	}{
+		{"ReserveComplete", testIdempotencyReserveComplete},
+		{"Release", testIdempotencyRelease},
+		{"Expiry", testIdempotencyExpiry},
+		{"Abandoned", testIdempotencyAbandoned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+func testIdempotencyReserveComplete(t *testing.T, s middlewares.IdempotencyStore) {
+	ctx := context.Background()
+	now := time.Now()
+	rec := models.IdempotencyRecord{Key: "key", RequestHash: "hash", CreatedAt: now}
+
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, rec, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil || !reserved {
+		t.Fatalf("first Reserve: reserved = %v, err = %v", reserved, err)
+	}
+
+	existing, reserved, err := s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "key", RequestHash: "other", CreatedAt: now}, now.Add(-time.Hour), now.Add(-time.Hour))
+	if err != nil || reserved {
+		t.Fatalf("second Reserve: reserved = %v, err = %v", reserved, err)
+	}
+	if existing.RequestHash != "hash" || existing.Status != 0 {
+		t.Fatalf("second Reserve returned %+v, want the in-progress record", existing)
+	}
+
+	body := []byte(`{"id":1}`)
+	if err := s.CompleteIdempotencyKey(ctx, "key", now, 200, "application/json", body); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	existing, reserved, err = s.ReserveIdempotencyKey(ctx, rec, now.Add(-time.Hour), now.Add(-time.Hour))
+	if err != nil || reserved {
+		t.Fatalf("Reserve after Complete: reserved = %v, err = %v", reserved, err)
+	}
+	if existing.Status != 200 || existing.ContentType != "application/json" || !bytes.Equal(existing.Body, body) {
+		t.Fatalf("Reserve after Complete returned %+v", existing)
+	}
+
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "another key", RequestHash: "hash", CreatedAt: now}, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil || !reserved {
+		t.Fatalf("Reserve of another key: reserved = %v, err = %v", reserved, err)
+	}
+}
+
+func testIdempotencyRelease(t *testing.T, s middlewares.IdempotencyStore) {
+	ctx := context.Background()
+	now := time.Now()
+	rec := models.IdempotencyRecord{Key: "key", RequestHash: "hash", CreatedAt: now}
+
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, rec, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil || !reserved {
+		t.Fatalf("Reserve: reserved = %v, err = %v", reserved, err)
+	}
+	if err := s.ReleaseIdempotencyKey(ctx, "key", now); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, rec, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil || !reserved {
+		t.Fatalf("Reserve after Release: reserved = %v, err = %v", reserved, err)
+	}
+}
+
+func testIdempotencyExpiry(t *testing.T, s middlewares.IdempotencyStore) {
+	ctx := context.Background()
+	created := time.Now().Add(-2 * time.Hour)
+
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "key", RequestHash: "old", CreatedAt: created}, created.Add(-time.Hour), created.Add(-time.Hour)); err != nil || !reserved {
+		t.Fatalf("Reserve: reserved = %v, err = %v", reserved, err)
+	}
+	if err := s.CompleteIdempotencyKey(ctx, "key", created, 200, "", nil); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+
+	now := time.Now()
+	existing, reserved, err := s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "key", RequestHash: "new", CreatedAt: now}, now.Add(-time.Hour), now.Add(-time.Hour))
+	if err != nil || !reserved {
+		t.Fatalf("Reserve of an expired key: reserved = %v, existing = %+v, err = %v", reserved, existing, err)
+	}
+
+	existing, reserved, err = s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "key", RequestHash: "new", CreatedAt: now}, now.Add(-time.Hour), now.Add(-time.Hour))
+	if err != nil || reserved || existing.RequestHash != "new" || existing.Status != 0 {
+		t.Fatalf("Reserve after expiry: reserved = %v, existing = %+v, err = %v", reserved, existing, err)
+	}
+}
+
+func testIdempotencyAbandoned(t *testing.T, s middlewares.IdempotencyStore) {
+	ctx := context.Background()
+	reservedAt := time.Now().Add(-2 * time.Minute)
+
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "key", RequestHash: "hash", CreatedAt: reservedAt}, reservedAt.Add(-time.Hour), reservedAt.Add(-time.Minute)); err != nil || !reserved {
+		t.Fatalf("Reserve: reserved = %v, err = %v", reserved, err)
+	}
+
+	// The first request is in progress for longer than the lease, a retry
+	// takes the key over.
+	now := time.Now()
+	if _, reserved, err := s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "key", RequestHash: "hash", CreatedAt: now}, now.Add(-time.Hour), now.Add(-time.Minute)); err != nil || !reserved {
+		t.Fatalf("Reserve of an abandoned key: reserved = %v, err = %v", reserved, err)
+	}
+
+	// The first request completing late must leave the retry alone.
+	if err := s.CompleteIdempotencyKey(ctx, "key", reservedAt, 200, "", []byte("late")); err != nil {
+		t.Fatalf("Complete of the abandoned reservation: %v", err)
+	}
+	if err := s.ReleaseIdempotencyKey(ctx, "key", reservedAt); err != nil {
+		t.Fatalf("Release of the abandoned reservation: %v", err)
+	}
+	existing, reserved, err := s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "key", RequestHash: "hash", CreatedAt: now}, now.Add(-time.Hour), now.Add(-time.Minute))
+	if err != nil || reserved || existing.Status != 0 {
+		t.Fatalf("Reserve after the late Complete: reserved = %v, existing = %+v, err = %v", reserved, existing, err)
+	}
+
+	// Completed responses are kept for the ttl, however long ago they were
+	// reserved.
+	if err := s.CompleteIdempotencyKey(ctx, "key", now, 201, "", nil); err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	later := now.Add(10 * time.Minute)
+	existing, reserved, err = s.ReserveIdempotencyKey(ctx, models.IdempotencyRecord{Key: "key", RequestHash: "hash", CreatedAt: later}, later.Add(-time.Hour), later.Add(-time.Minute))
+	if err != nil || reserved || existing.Status != 201 {
+		t.Fatalf("Reserve of a completed key past the lease: reserved = %v, existing = %+v, err = %v", reserved, existing, err)
+	}
+}
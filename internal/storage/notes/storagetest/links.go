@@ -0,0 +1,129 @@
+package storagetest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+func testLinks(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	target := mustAdd(t, s, "Target", "")
+	source := mustAdd(t, s, "source", "")
+
+	setLinks(t, s, source, models.LinkTarget{Title: "target"}, models.LinkTarget{Id: target}, models.LinkTarget{Title: "nowhere"})
+	assertLinks(t, "GetLinks", func() ([]models.Link, error) { return s.GetLinks(ctx, source) },
+		models.Link{SourceId: source, Target: models.LinkTarget{Id: target}.String(), TargetId: target},
+		models.Link{SourceId: source, Target: "nowhere", TargetId: 0},
+		models.Link{SourceId: source, Target: "target", TargetId: target},
+	)
+	assertLinks(t, "GetBacklinks", func() ([]models.Link, error) { return s.GetBacklinks(ctx, target) },
+		models.Link{SourceId: source, Target: models.LinkTarget{Id: target}.String(), TargetId: target},
+		models.Link{SourceId: source, Target: "target", TargetId: target},
+	)
+	assertLinks(t, "GetBrokenLinks", func() ([]models.Link, error) { return s.GetBrokenLinks(ctx) },
+		models.Link{SourceId: source, Target: "nowhere", TargetId: 0},
+	)
+
+	setLinks(t, s, target, models.LinkTarget{Title: "Source"})
+	assertLinks(t, "GetAllLinks", func() ([]models.Link, error) { return s.GetAllLinks(ctx) },
+		models.Link{SourceId: target, Target: "Source", TargetId: source},
+		models.Link{SourceId: source, Target: models.LinkTarget{Id: target}.String(), TargetId: target},
+		models.Link{SourceId: source, Target: "nowhere", TargetId: 0},
+		models.Link{SourceId: source, Target: "target", TargetId: target},
+	)
+	setLinks(t, s, target)
+
+	// Links are replaced as a whole.
+	setLinks(t, s, source, models.LinkTarget{Title: "Target"})
+	assertLinks(t, "GetLinks after SetLinks", func() ([]models.Link, error) { return s.GetLinks(ctx, source) },
+		models.Link{SourceId: source, Target: "Target", TargetId: target},
+	)
+	assertLinks(t, "GetBrokenLinks after SetLinks", func() ([]models.Link, error) { return s.GetBrokenLinks(ctx) })
+
+	setLinks(t, s, source)
+	assertLinks(t, "GetLinks after clearing", func() ([]models.Link, error) { return s.GetLinks(ctx, source) })
+}
+
+func testLinksResolution(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	first := mustAdd(t, s, "Twin", "")
+	second := mustAdd(t, s, "twin", "")
+	source := mustAdd(t, s, "source", "")
+	setLinks(t, s, source, models.LinkTarget{Title: "TWIN"}, models.LinkTarget{Title: "renamed"})
+
+	// A title resolves to the oldest note with that header.
+	assertLinks(t, "GetBacklinks of the first twin", func() ([]models.Link, error) { return s.GetBacklinks(ctx, first) },
+		models.Link{SourceId: source, Target: "TWIN", TargetId: first},
+	)
+	assertLinks(t, "GetBacklinks of the second twin", func() ([]models.Link, error) { return s.GetBacklinks(ctx, second) })
+
+	// Renaming a note moves the links by title along with the header.
+	if err := s.Edit(ctx, "Renamed", "", first); err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	assertLinks(t, "GetLinks after rename", func() ([]models.Link, error) { return s.GetLinks(ctx, source) },
+		models.Link{SourceId: source, Target: "TWIN", TargetId: second},
+		models.Link{SourceId: source, Target: "renamed", TargetId: first},
+	)
+}
+
+func testLinksDelete(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	target := mustAdd(t, s, "target", "")
+	source := mustAdd(t, s, "source", "")
+	setLinks(t, s, source, models.LinkTarget{Id: target})
+	setLinks(t, s, target, models.LinkTarget{Id: source})
+
+	// Deleting the target breaks the links to it, deleting the source drops
+	// the links in it.
+	if err := s.Delete(ctx, target); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertLinks(t, "GetBrokenLinks after deleting the target", func() ([]models.Link, error) { return s.GetBrokenLinks(ctx) },
+		models.Link{SourceId: source, Target: models.LinkTarget{Id: target}.String(), TargetId: 0},
+	)
+
+	if err := s.Delete(ctx, source); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertLinks(t, "GetBrokenLinks after deleting the source", func() ([]models.Link, error) { return s.GetBrokenLinks(ctx) })
+}
+
+func mustAdd(t *testing.T, s notes.Storage, header string, content string) int64 {
+	t.Helper()
+
+	id, err := s.Add(context.Background(), header, content)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	return id
+}
+
+func setLinks(t *testing.T, s notes.Storage, sourceId int64, targets ...models.LinkTarget) {
+	t.Helper()
+
+	if err := s.SetLinks(context.Background(), sourceId, targets); err != nil {
+		t.Fatalf("SetLinks: %v", err)
+	}
+}
+
+func assertLinks(t *testing.T, name string, get func() ([]models.Link, error), want ...models.Link) {
+	t.Helper()
+
+	got, err := get()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if len(got) != 0 || len(want) != 0 {
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", name, got, want)
+		}
+	}
+}
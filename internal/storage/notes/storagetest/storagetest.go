@@ -0,0 +1,785 @@
+// Package storagetest is a conformance suite for notes.Storage implementations.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sergeyreshetnyakov/notion/internal/bussines/notes"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// Factory returns a new, empty storage. It is called once per subtest.
+type Factory func(t *testing.T) notes.Storage
+
+// Run runs the whole suite against storages created by newStorage.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s notes.Storage)
+	}{
+		{"CRUD", testCRUD},
+		{"NotFound", testNotFound},
+		{"Empty", testEmpty},
+		{"Ordering", testOrdering},
+		{"Unicode", testUnicode},
+		{"LargeContent", testLargeContent},
+		{"ConcurrentWriters", testConcurrentWriters},
+		{"Canceled", testCanceled},
+		{"TxCommit", testTxCommit},
+		{"TxRollback", testTxRollback},
+		{"TxPanic", testTxPanic},
+		{"TxNested", testTxNested},
+		{"TxConcurrentReadModifyWrite", testTxConcurrentReadModifyWrite},
+		{"ReadTx", testReadTx},
+		{"Timestamps", testTimestamps},
+		{"EachNote", testEachNote},
+		{"Insert", testInsert},
+		{"IdsNotReused", testIdsNotReused},
+		{"Restore", testRestore},
+		{"EncryptedNotes", testEncryptedNotes},
+		{"Links", testLinks},
+		{"LinksResolution", testLinksResolution},
+		{"LinksDelete", testLinksDelete},
+		{"Usage", testUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func testCRUD(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Add(ctx, "wash the basement", "immediately")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if id <= 0 {
+		t.Fatalf("Add returned id %d, want a positive id", id)
+	}
+
+	assertNote(t, s, models.Note{Header: "wash the basement", Content: "immediately", Id: id})
+
+	if err := s.Edit(ctx, "immediately", "wash the basement", id); err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	assertNote(t, s, models.Note{Header: "immediately", Content: "wash the basement", Id: id})
+
+	if err := s.Edit(ctx, "immediately", "", id); err != nil {
+		t.Fatalf("Edit with empty content: %v", err)
+	}
+	assertNote(t, s, models.Note{Header: "immediately", Content: "", Id: id})
+
+	if err := s.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.GetById(ctx, id); !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Fatalf("GetById after Delete: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+}
+
+func testNotFound(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Add(ctx, "header", "content")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	missing := id + 1000
+
+	if _, err := s.GetById(ctx, missing); !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Errorf("GetById: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+	if err := s.Edit(ctx, "header", "content", missing); !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Errorf("Edit: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+	if err := s.Delete(ctx, missing); !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Errorf("Delete: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+
+	if err := s.Delete(ctx, id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := s.Delete(ctx, id); !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Errorf("second Delete: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+	if err := s.Edit(ctx, "header", "content", id); !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Errorf("Edit after Delete: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+}
+
+func testEmpty(t *testing.T, s notes.Storage) {
+	all, err := s.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("GetAll on empty storage returned %d notes", len(all))
+	}
+}
+
+func testTimestamps(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	before := time.Now().Truncate(time.Millisecond)
+	id, err := s.Add(ctx, "header", "content")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	added, err := s.GetById(ctx, id)
+	if err != nil {
+		t.Fatalf("GetById: %v", err)
+	}
+	if added.CreatedAt.Before(before) || added.CreatedAt.After(time.Now()) {
+		t.Fatalf("CreatedAt = %v, want the time of Add", added.CreatedAt)
+	}
+	if !added.UpdatedAt.Equal(added.CreatedAt) {
+		t.Fatalf("UpdatedAt = %v, want CreatedAt %v", added.UpdatedAt, added.CreatedAt)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if err := s.Edit(ctx, "header", "edited", id); err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	edited, err := s.GetById(ctx, id)
+	if err != nil {
+		t.Fatalf("GetById: %v", err)
+	}
+	if !edited.CreatedAt.Equal(added.CreatedAt) {
+		t.Errorf("CreatedAt changed by Edit: %v, want %v", edited.CreatedAt, added.CreatedAt)
+	}
+	if !edited.UpdatedAt.After(added.UpdatedAt) {
+		t.Errorf("UpdatedAt = %v, want after %v", edited.UpdatedAt, added.UpdatedAt)
+	}
+
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 1 || all[0] != edited {
+		t.Errorf("GetAll = %+v, want [%+v]", all, edited)
+	}
+}
+
+func testInsert(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	want := models.Note{Header: "kept", Content: "as is", Id: 10, CreatedAt: created, UpdatedAt: created.Add(time.Hour)}
+	id, err := s.Insert(ctx, want)
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if id != want.Id {
+		t.Fatalf("Insert returned id %d, want %d", id, want.Id)
+	}
+	if got, err := s.GetById(ctx, id); err != nil || got != want {
+		t.Fatalf("GetById = %+v, %v, want %+v", got, err, want)
+	}
+
+	if _, err := s.Insert(ctx, want); !errors.Is(err, notes.ErrConflict) {
+		t.Fatalf("Insert of a taken id: got %v, want %v", err, notes.ErrConflict)
+	}
+
+	// Ids go on after the highest one, zero timestamps are filled in.
+	id, err = s.Add(ctx, "next", "")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if id <= want.Id {
+		t.Fatalf("Add after Insert returned id %d, want more than %d", id, want.Id)
+	}
+	id, err = s.Insert(ctx, models.Note{Header: "stamped"})
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	got, err := s.GetById(ctx, id)
+	if err != nil {
+		t.Fatalf("GetById: %v", err)
+	}
+	if got.CreatedAt.IsZero() || !got.UpdatedAt.Equal(got.CreatedAt) {
+		t.Fatalf("Insert without timestamps stored %+v, want both set to now", got)
+	}
+}
+
+// testIdsNotReused checks that the id of a deleted note, even the newest one,
+// is never handed out again, so links to it don't reach another note.
+func testIdsNotReused(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	var highest int64
+	for _, header := range []string{"first", "second", "third"} {
+		id, err := s.Add(ctx, header, "")
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		highest = max(highest, id)
+	}
+	inserted, err := s.Insert(ctx, models.Note{Header: "inserted", Id: highest + 10})
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	highest = max(highest, inserted)
+
+	for range 2 {
+		if err := s.Delete(ctx, highest); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		id, err := s.Add(ctx, "next", "")
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if id <= highest {
+			t.Fatalf("Add after deleting the newest note returned id %d, want more than %d", id, highest)
+		}
+		highest = id
+	}
+}
+
+func testRestore(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	created := time.Date(2020, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	restored := []models.Note{
+		{Header: "dated", Content: "as is", Id: 3, CreatedAt: created, UpdatedAt: created.Add(time.Hour)},
+		// Notes from before timestamps were kept have none.
+		{Header: "undated", Id: 7},
+	}
+	for _, note := range restored {
+		if err := s.Restore(ctx, note); err != nil {
+			t.Fatalf("Restore: %v", err)
+		}
+		if got, err := s.GetById(ctx, note.Id); err != nil || got != note {
+			t.Fatalf("GetById = %+v, %v, want %+v", got, err, note)
+		}
+	}
+
+	if err := s.Restore(ctx, restored[0]); !errors.Is(err, notes.ErrConflict) {
+		t.Fatalf("Restore of a taken id: got %v, want %v", err, notes.ErrConflict)
+	}
+	id, err := s.Add(ctx, "next", "")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if id <= 7 {
+		t.Fatalf("Add after Restore returned id %d, want more than 7", id)
+	}
+}
+
+func testEncryptedNotes(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	kdf := models.KDF{Algorithm: "argon2id", Salt: "c2FsdHNhbHRzYWx0c2FsdA==", Iterations: 3, Memory: 65536, Parallelism: 4}
+	id, err := s.Insert(ctx, models.Note{Header: "diary", Content: "AQIDBA==", Type: models.NoteTypeEncrypted, KDF: kdf})
+	if err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	want, err := s.GetById(ctx, id)
+	if err != nil {
+		t.Fatalf("GetById: %v", err)
+	}
+	if want.Type != models.NoteTypeEncrypted || want.KDF != kdf {
+		t.Fatalf("GetById = %+v, want the type and KDF inserted", want)
+	}
+
+	// Edit keeps the type and the KDF, SetKDF replaces the KDF alone.
+	if err := s.Edit(ctx, "diary", "BQYHCA==", id); err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	want.Content = "BQYHCA=="
+	assertNote(t, s, want)
+
+	kdf.Salt = "b3RoZXJzYWx0b3RoZXJzYQ=="
+	if err := s.SetKDF(ctx, id, kdf); err != nil {
+		t.Fatalf("SetKDF: %v", err)
+	}
+	want.KDF = kdf
+	assertNote(t, s, want)
+
+	if err := s.SetKDF(ctx, id+1, kdf); !errors.Is(err, notes.ErrNoteNotFound) {
+		t.Fatalf("SetKDF of a missing note: got %v, want %v", err, notes.ErrNoteNotFound)
+	}
+}
+
+func testEachNote(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	for _, header := range []string{"a", "b", "c"} {
+		if _, err := s.Add(ctx, header, ""); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+
+	var got []models.Note
+	if err := s.EachNote(ctx, func(note models.Note) error {
+		got = append(got, note)
+		return nil
+	}); err != nil {
+		t.Fatalf("EachNote: %v", err)
+	}
+	if !slices.Equal(got, all) {
+		t.Fatalf("EachNote visited %+v, want %+v", got, all)
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err = s.EachNote(ctx, func(note models.Note) error {
+		visited++
+		return stop
+	})
+	if !errors.Is(err, stop) || visited != 1 {
+		t.Fatalf("EachNote = %v after %d notes, want %v after 1", err, visited, stop)
+	}
+}
+
+func testOrdering(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	var want []models.Note
+	for _, header := range []string{"c", "a", "e", "b", "d"} {
+		id, err := s.Add(ctx, header, "content "+header)
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		want = append(want, models.Note{Header: header, Content: "content " + header, Id: id})
+	}
+
+	if err := s.Delete(ctx, want[2].Id); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	want = append(want[:2], want[3:]...)
+
+	got, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("GetAll returned %d notes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		got[i].CreatedAt, got[i].UpdatedAt = time.Time{}, time.Time{}
+		if got[i] != want[i] {
+			t.Errorf("GetAll[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func testUnicode(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	cases := []models.Note{
+		{Header: "Привет, мир", Content: "кириллица"},
+		{Header: "日本語のメモ", Content: "漢字とかな"},
+		{Header: "שלום", Content: "مرحبا بالعالم"},
+		{Header: "emoji 🚀🔥", Content: "family: 👨‍👩‍👧‍👦, flag: 🇺🇦"},
+		{Header: "combining é", Content: "tabs\tand\nnew lines\r\n"},
+	}
+
+	for _, c := range cases {
+		id, err := s.Add(ctx, c.Header, c.Content)
+		if err != nil {
+			t.Fatalf("Add(%q): %v", c.Header, err)
+		}
+		c.Id = id
+		assertNote(t, s, c)
+	}
+}
+
+func testLargeContent(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	content := strings.Repeat("large note content ✓ ", 1<<18)
+	id, err := s.Add(ctx, "large", content)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	note, err := s.GetById(ctx, id)
+	if err != nil {
+		t.Fatalf("GetById: %v", err)
+	}
+	if note.Content != content {
+		t.Fatalf("GetById returned %d bytes of content, want %d", len(note.Content), len(content))
+	}
+}
+
+func testConcurrentWriters(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	const writers, perWriter = 8, 25
+
+	var wg sync.WaitGroup
+	ids := make(chan int64, writers*perWriter)
+	errs := make(chan error, writers*perWriter)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				id, err := s.Add(ctx, "concurrent", "writer")
+				if err != nil {
+					errs <- err
+					continue
+				}
+				ids <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent Add: %v", err)
+	}
+
+	seen := make(map[int64]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("id %d was returned twice", id)
+		}
+		seen[id] = true
+	}
+
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != writers*perWriter {
+		t.Fatalf("GetAll returned %d notes, want %d", len(all), writers*perWriter)
+	}
+}
+
+func testCanceled(t *testing.T, s notes.Storage) {
+	id, err := s.Add(context.Background(), "header", "content")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errs := map[string]error{}
+	_, errs["GetAll"] = s.GetAll(ctx)
+	_, errs["GetById"] = s.GetById(ctx, id)
+	_, errs["Add"] = s.Add(ctx, "header", "content")
+	errs["Edit"] = s.Edit(ctx, "edited", "content", id)
+	errs["Delete"] = s.Delete(ctx, id)
+
+	for method, err := range errs {
+		if !errors.Is(err, notes.ErrCanceled) || !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: got %v, want %v wrapping %v", method, err, notes.ErrCanceled, context.Canceled)
+		}
+	}
+
+	assertNote(t, s, models.Note{Header: "header", Content: "content", Id: id})
+	all, err := s.GetAll(context.Background())
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("canceled calls changed the storage: GetAll returned %d notes, want 1", len(all))
+	}
+}
+
+func testTxCommit(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	existing, err := s.Add(ctx, "existing", "note")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	var added int64
+	err = s.WithTx(ctx, func(tx notes.Storage) error {
+		var err error
+		if added, err = tx.Add(ctx, "added", "in tx"); err != nil {
+			return err
+		}
+		// A transaction sees its own writes.
+		if _, err := tx.GetById(ctx, added); err != nil {
+			return err
+		}
+		if all, err := tx.GetAll(ctx); err != nil || len(all) != 2 {
+			return fmt.Errorf("GetAll in tx returned %d notes, %v", len(all), err)
+		}
+		return tx.Edit(ctx, "existing", "edited in tx", existing)
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	assertNote(t, s, models.Note{Header: "added", Content: "in tx", Id: added})
+	assertNote(t, s, models.Note{Header: "existing", Content: "edited in tx", Id: existing})
+}
+
+func testTxRollback(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	edited, err := s.Add(ctx, "edited", "before")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	deleted, err := s.Add(ctx, "deleted", "before")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	errRollback := errors.New("rollback")
+	err = s.WithTx(ctx, func(tx notes.Storage) error {
+		if _, err := tx.Add(ctx, "added", "in tx"); err != nil {
+			return err
+		}
+		if err := tx.Edit(ctx, "edited", "in tx", edited); err != nil {
+			return err
+		}
+		if err := tx.Delete(ctx, deleted); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx: got %v, want %v", err, errRollback)
+	}
+
+	assertNote(t, s, models.Note{Header: "edited", Content: "before", Id: edited})
+	assertNote(t, s, models.Note{Header: "deleted", Content: "before", Id: deleted})
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("rolled back transaction left %d notes, want 2", len(all))
+	}
+}
+
+// testTxPanic checks that a transaction whose fn panics is rolled back and
+// doesn't hold up the writes after it.
+func testTxPanic(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("WithTx didn't pass the panic on")
+			}
+		}()
+		s.WithTx(ctx, func(tx notes.Storage) error {
+			if _, err := tx.Add(ctx, "added", "before the panic"); err != nil {
+				return err
+			}
+			panic("fn panicked")
+		})
+	}()
+
+	writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	id, err := s.Add(writeCtx, "after", "the panic")
+	if err != nil {
+		t.Fatalf("Add after a panic in WithTx: %v", err)
+	}
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 1 || all[0].Id != id {
+		t.Fatalf("GetAll = %+v, want only the note added after the panic", all)
+	}
+}
+
+func testTxNested(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	errRollback := errors.New("rollback")
+	err := s.WithTx(ctx, func(tx notes.Storage) error {
+		if _, err := tx.Add(ctx, "outer", "note"); err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(tx notes.Storage) error {
+			if _, err := tx.Add(ctx, "inner", "note"); err != nil {
+				return err
+			}
+			return errRollback
+		})
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx: got %v, want %v", err, errRollback)
+	}
+
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 0 {
+		t.Fatalf("failed nested transaction left %d notes, want 0", len(all))
+	}
+}
+
+func testTxConcurrentReadModifyWrite(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Add(ctx, "counter", "0")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	const workers, increments = 8, 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*increments)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				errs <- s.WithTx(ctx, func(tx notes.Storage) error {
+					note, err := tx.GetById(ctx, id)
+					if err != nil {
+						return err
+					}
+					n, err := strconv.Atoi(note.Content)
+					if err != nil {
+						return err
+					}
+					return tx.Edit(ctx, note.Header, strconv.Itoa(n+1), id)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("WithTx: %v", err)
+		}
+	}
+
+	assertNote(t, s, models.Note{Header: "counter", Content: strconv.Itoa(workers * increments), Id: id})
+}
+
+func testReadTx(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	id, err := s.Add(ctx, "before", "the read")
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	err = s.WithReadTx(ctx, func(tx notes.Storage) error {
+		if all, err := tx.GetAll(ctx); err != nil || len(all) != 1 {
+			return fmt.Errorf("GetAll in read tx returned %d notes, %v", len(all), err)
+		}
+
+		// Writes don't wait for the read, which doesn't see them.
+		writeCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+		defer cancel()
+		if _, err := s.Add(writeCtx, "during", "the read"); err != nil {
+			return fmt.Errorf("Add during read tx: %w", err)
+		}
+		if err := s.Edit(writeCtx, "edited", "during the read", id); err != nil {
+			return fmt.Errorf("Edit during read tx: %w", err)
+		}
+
+		all, err := tx.GetAll(ctx)
+		if err != nil {
+			return err
+		}
+		if len(all) != 1 || all[0].Header != "before" {
+			return fmt.Errorf("GetAll in read tx = %+v, want the note as it was", all)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WithReadTx: %v", err)
+	}
+
+	all, err := s.GetAll(ctx)
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("GetAll returned %d notes after the read tx, want 2", len(all))
+	}
+}
+
+func testUsage(t *testing.T, s notes.Storage) {
+	ctx := context.Background()
+
+	assertUsage(t, s, models.Usage{})
+
+	// Bytes are counted in UTF-8, not in characters.
+	first := mustAdd(t, s, "héllo", "wörld")
+	second := mustAdd(t, s, "second", "")
+	assertUsage(t, s, models.Usage{Notes: 2, NoteBytes: 12 + 6})
+
+	if err := s.Edit(ctx, "hello", "world, again", first); err != nil {
+		t.Fatalf("Edit: %v", err)
+	}
+	assertUsage(t, s, models.Usage{Notes: 2, NoteBytes: 17 + 6})
+
+	if _, err := s.Insert(ctx, models.Note{Header: "inserted", Content: "x"}); err != nil {
+		t.Fatalf("Insert: %v", err)
+	}
+	if err := s.Delete(ctx, second); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	assertUsage(t, s, models.Usage{Notes: 2, NoteBytes: 17 + 9})
+
+	// Rolled back writes don't count, the writes of a transaction count
+	// inside it.
+	errRollback := errors.New("rollback")
+	err := s.WithTx(ctx, func(tx notes.Storage) error {
+		if _, err := tx.Add(ctx, "rolled back", ""); err != nil {
+			return err
+		}
+		assertUsage(t, tx, models.Usage{Notes: 3, NoteBytes: 17 + 9 + 11})
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("WithTx: got %v, want %v", err, errRollback)
+	}
+	assertUsage(t, s, models.Usage{Notes: 2, NoteBytes: 17 + 9})
+}
+
+func assertUsage(t *testing.T, s notes.Storage, want models.Usage) {
+	t.Helper()
+
+	got, err := s.Usage(context.Background())
+	if err != nil {
+		t.Fatalf("Usage: %v", err)
+	}
+	if got != want {
+		t.Fatalf("Usage = %+v, want %+v", got, want)
+	}
+}
+
+// assertNote compares the stored note with want, ignoring the timestamps
+// which testTimestamps covers.
+func assertNote(t *testing.T, s notes.Storage, want models.Note) {
+	t.Helper()
+
+	got, err := s.GetById(context.Background(), want.Id)
+	if err != nil {
+		t.Fatalf("GetById(%d): %v", want.Id, err)
+	}
+	got.CreatedAt, got.UpdatedAt = want.CreatedAt, want.UpdatedAt
+	if got != want {
+		t.Fatalf("GetById(%d) = %+v, want %+v", want.Id, got, want)
+	}
+}
@@ -0,0 +1,22 @@
+package notestorage
+
+import (
+	"context"
+
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+)
+
+// Usage returns the totals the usage table keeps, see migration 7.
+func (s *Storage) Usage(ctx context.Context) (usage models.Usage, err error) {
+	const op = "storage.Usage"
+	ctx, done := s.begin(ctx, op)
+	defer func() { err = done(err) }()
+
+	err = s.read.getUsage.QueryRowContext(ctx).Scan(
+		&usage.Notes,
+		&usage.NoteBytes,
+		&usage.Attachments,
+		&usage.AttachmentBytes,
+	)
+	return usage, err
+}
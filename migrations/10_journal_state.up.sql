@@ -0,0 +1,9 @@
+-- journal_state holds the sequence number of the last journal entry whose
+-- transaction committed, in a single row written by that transaction. It
+-- tells entries journaled before a crash that never committed, and which
+-- journal segments a copy of the database no longer needs.
+CREATE TABLE IF NOT EXISTS journal_state
+(
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    seq INTEGER NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE data_keys DROP COLUMN binds_id;
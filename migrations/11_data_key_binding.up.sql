@@ -0,0 +1,4 @@
+-- binds_id tells the data keys whose values are bound to the id of their
+-- row as well as to their column. Values sealed with the older keys are
+-- bound to their column only, until they are encrypted again.
+ALTER TABLE data_keys ADD COLUMN binds_id INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- sealed tells the response bodies that are encrypted, like the notes, when
+-- encryption at rest is on.
+ALTER TABLE idempotency_keys ADD COLUMN sealed INTEGER NOT NULL DEFAULT 0;
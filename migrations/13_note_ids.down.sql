@@ -0,0 +1,62 @@
+-- Ids of notes may be reused again.
+DROP VIEW IF EXISTS resolved_links;
+DROP TRIGGER IF EXISTS usage_notes_insert;
+DROP TRIGGER IF EXISTS usage_notes_update;
+DROP TRIGGER IF EXISTS usage_notes_delete;
+
+CREATE TABLE notes_reused
+(
+    id INTEGER PRIMARY KEY,
+    header TEXT NOT NULL,
+    content TEXT,
+    created_at INTEGER,
+    updated_at INTEGER,
+    title_key BLOB,
+    type TEXT NOT NULL DEFAULT '',
+    kdf TEXT
+);
+INSERT INTO notes_reused (id, header, content, created_at, updated_at, title_key, type, kdf)
+SELECT id, header, content, created_at, updated_at, title_key, type, kdf FROM notes;
+
+-- Foreign keys are off while migrating, so links and attachments are kept.
+DROP TABLE notes;
+ALTER TABLE notes_reused RENAME TO notes;
+CREATE INDEX IF NOT EXISTS notes_header ON notes (header COLLATE NOCASE);
+CREATE INDEX IF NOT EXISTS notes_title_key ON notes (title_key);
+
+CREATE VIEW IF NOT EXISTS resolved_links AS
+SELECT l.source_id,
+       l.target,
+       l.target_id,
+       l.title,
+       l.title_key,
+       COALESCE(
+           (SELECT n.id FROM notes n WHERE n.id = l.target_id),
+           (SELECT n.id FROM notes n WHERE n.header = l.title COLLATE NOCASE ORDER BY n.id LIMIT 1),
+           (SELECT n.id FROM notes n WHERE n.title_key = l.title_key ORDER BY n.id LIMIT 1),
+           0
+       ) AS resolved_id
+FROM links l;
+
+CREATE TRIGGER IF NOT EXISTS usage_notes_insert AFTER INSERT ON notes
+BEGIN
+    UPDATE usage SET notes = notes + 1,
+        note_bytes = note_bytes
+            + CASE typeof(NEW.header) WHEN 'blob' THEN length(NEW.header) - 33 ELSE length(CAST(NEW.header AS BLOB)) END
+            + COALESCE(CASE typeof(NEW.content) WHEN 'blob' THEN length(NEW.content) - 33 ELSE length(CAST(NEW.content AS BLOB)) END, 0);
+END;
+CREATE TRIGGER IF NOT EXISTS usage_notes_update AFTER UPDATE OF header, content ON notes
+BEGIN
+    UPDATE usage SET note_bytes = note_bytes
+        - CASE typeof(OLD.header) WHEN 'blob' THEN length(OLD.header) - 33 ELSE length(CAST(OLD.header AS BLOB)) END
+        - COALESCE(CASE typeof(OLD.content) WHEN 'blob' THEN length(OLD.content) - 33 ELSE length(CAST(OLD.content AS BLOB)) END, 0)
+        + CASE typeof(NEW.header) WHEN 'blob' THEN length(NEW.header) - 33 ELSE length(CAST(NEW.header AS BLOB)) END
+        + COALESCE(CASE typeof(NEW.content) WHEN 'blob' THEN length(NEW.content) - 33 ELSE length(CAST(NEW.content AS BLOB)) END, 0);
+END;
+CREATE TRIGGER IF NOT EXISTS usage_notes_delete AFTER DELETE ON notes
+BEGIN
+    UPDATE usage SET notes = notes - 1,
+        note_bytes = note_bytes
+            - CASE typeof(OLD.header) WHEN 'blob' THEN length(OLD.header) - 33 ELSE length(CAST(OLD.header AS BLOB)) END
+            - COALESCE(CASE typeof(OLD.content) WHEN 'blob' THEN length(OLD.content) - 33 ELSE length(CAST(OLD.content AS BLOB)) END, 0);
+END;
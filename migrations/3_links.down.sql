@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS resolved_links;
+DROP INDEX IF EXISTS notes_header;
+DROP TABLE IF EXISTS links;
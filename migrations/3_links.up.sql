@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS links
+(
+    source_id INTEGER NOT NULL REFERENCES notes (id) ON DELETE CASCADE,
+    -- target is the link as written, target_id is set for [[#id]] links and
+    -- title for [[title]] links.
+    target TEXT NOT NULL,
+    target_id INTEGER,
+    title TEXT COLLATE NOCASE,
+    PRIMARY KEY (source_id, target)
+);
+CREATE INDEX IF NOT EXISTS links_target_id ON links (target_id);
+CREATE INDEX IF NOT EXISTS links_title ON links (title);
+CREATE INDEX IF NOT EXISTS notes_header ON notes (header COLLATE NOCASE);
+
+-- resolved_links resolves every link to the note it points at, or 0. A title
+-- shared by several notes resolves to the oldest of them.
+CREATE VIEW IF NOT EXISTS resolved_links AS
+SELECT l.source_id,
+       l.target,
+       l.target_id,
+       l.title,
+       COALESCE(
+           (SELECT n.id FROM notes n WHERE n.id = l.target_id),
+           (SELECT n.id FROM notes n WHERE n.header = l.title COLLATE NOCASE ORDER BY n.id LIMIT 1),
+           0
+       ) AS resolved_id
+FROM links l;
@@ -0,0 +1,2 @@
+ALTER TABLE notes DROP COLUMN updated_at;
+ALTER TABLE notes DROP COLUMN created_at;
@@ -0,0 +1,4 @@
+-- Timestamps are unix milliseconds. Notes created before this migration have
+-- none.
+ALTER TABLE notes ADD COLUMN created_at INTEGER;
+ALTER TABLE notes ADD COLUMN updated_at INTEGER;
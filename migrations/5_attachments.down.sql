@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS attachments_sha256;
+DROP INDEX IF EXISTS attachments_note_id;
+DROP TABLE IF EXISTS attachments;
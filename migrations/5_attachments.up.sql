@@ -0,0 +1,16 @@
+-- Attachments are files uploaded to a note. Their content is kept on disk as
+-- a blob named after its SHA-256, shared by attachments with the same content.
+-- Ids aren't reused, so that the URL of a deleted attachment never serves
+-- another one.
+CREATE TABLE IF NOT EXISTS attachments
+(
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    note_id INTEGER NOT NULL REFERENCES notes (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    media_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    sha256 TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS attachments_note_id ON attachments (note_id);
+CREATE INDEX IF NOT EXISTS attachments_sha256 ON attachments (sha256);
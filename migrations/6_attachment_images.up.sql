@@ -0,0 +1,5 @@
+-- Images have their dimensions and the format they are decoded from, other
+-- attachments and those uploaded before this migration have zeros.
+ALTER TABLE attachments ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE attachments ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE attachments ADD COLUMN format TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS usage_attachments_delete;
+DROP TRIGGER IF EXISTS usage_attachments_insert;
+DROP TRIGGER IF EXISTS usage_notes_delete;
+DROP TRIGGER IF EXISTS usage_notes_update;
+DROP TRIGGER IF EXISTS usage_notes_insert;
+DROP TABLE IF EXISTS usage;
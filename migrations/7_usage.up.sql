@@ -0,0 +1,43 @@
+-- usage holds the totals quotas are checked against, kept up to date by
+-- triggers so that checking them doesn't read every note. The bytes of a
+-- note are the UTF-8 bytes of its header and content.
+CREATE TABLE IF NOT EXISTS usage
+(
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    notes INTEGER NOT NULL,
+    note_bytes INTEGER NOT NULL,
+    attachments INTEGER NOT NULL,
+    attachment_bytes INTEGER NOT NULL
+);
+INSERT INTO usage(id, notes, note_bytes, attachments, attachment_bytes)
+SELECT 1,
+       (SELECT COUNT(*) FROM notes),
+       (SELECT COALESCE(SUM(length(CAST(header AS BLOB)) + COALESCE(length(CAST(content AS BLOB)), 0)), 0) FROM notes),
+       (SELECT COUNT(*) FROM attachments),
+       (SELECT COALESCE(SUM(size), 0) FROM attachments);
+
+CREATE TRIGGER IF NOT EXISTS usage_notes_insert AFTER INSERT ON notes
+BEGIN
+    UPDATE usage SET notes = notes + 1,
+        note_bytes = note_bytes + length(CAST(NEW.header AS BLOB)) + COALESCE(length(CAST(NEW.content AS BLOB)), 0);
+END;
+CREATE TRIGGER IF NOT EXISTS usage_notes_update AFTER UPDATE OF header, content ON notes
+BEGIN
+    UPDATE usage SET note_bytes = note_bytes
+        - length(CAST(OLD.header AS BLOB)) - COALESCE(length(CAST(OLD.content AS BLOB)), 0)
+        + length(CAST(NEW.header AS BLOB)) + COALESCE(length(CAST(NEW.content AS BLOB)), 0);
+END;
+CREATE TRIGGER IF NOT EXISTS usage_notes_delete AFTER DELETE ON notes
+BEGIN
+    UPDATE usage SET notes = notes - 1,
+        note_bytes = note_bytes - length(CAST(OLD.header AS BLOB)) - COALESCE(length(CAST(OLD.content AS BLOB)), 0);
+END;
+-- Deleting a note deletes its attachments, which fires this trigger too.
+CREATE TRIGGER IF NOT EXISTS usage_attachments_insert AFTER INSERT ON attachments
+BEGIN
+    UPDATE usage SET attachments = attachments + 1, attachment_bytes = attachment_bytes + NEW.size;
+END;
+CREATE TRIGGER IF NOT EXISTS usage_attachments_delete AFTER DELETE ON attachments
+BEGIN
+    UPDATE usage SET attachments = attachments - 1, attachment_bytes = attachment_bytes - OLD.size;
+END;
@@ -0,0 +1,38 @@
+DROP TRIGGER IF EXISTS usage_notes_insert;
+DROP TRIGGER IF EXISTS usage_notes_update;
+DROP TRIGGER IF EXISTS usage_notes_delete;
+CREATE TRIGGER IF NOT EXISTS usage_notes_insert AFTER INSERT ON notes
+BEGIN
+    UPDATE usage SET notes = notes + 1,
+        note_bytes = note_bytes + length(CAST(NEW.header AS BLOB)) + COALESCE(length(CAST(NEW.content AS BLOB)), 0);
+END;
+CREATE TRIGGER IF NOT EXISTS usage_notes_update AFTER UPDATE OF header, content ON notes
+BEGIN
+    UPDATE usage SET note_bytes = note_bytes
+        - length(CAST(OLD.header AS BLOB)) - COALESCE(length(CAST(OLD.content AS BLOB)), 0)
+        + length(CAST(NEW.header AS BLOB)) + COALESCE(length(CAST(NEW.content AS BLOB)), 0);
+END;
+CREATE TRIGGER IF NOT EXISTS usage_notes_delete AFTER DELETE ON notes
+BEGIN
+    UPDATE usage SET notes = notes - 1,
+        note_bytes = note_bytes - length(CAST(OLD.header AS BLOB)) - COALESCE(length(CAST(OLD.content AS BLOB)), 0);
+END;
+
+DROP VIEW IF EXISTS resolved_links;
+CREATE VIEW IF NOT EXISTS resolved_links AS
+SELECT l.source_id,
+       l.target,
+       l.target_id,
+       l.title,
+       COALESCE(
+           (SELECT n.id FROM notes n WHERE n.id = l.target_id),
+           (SELECT n.id FROM notes n WHERE n.header = l.title COLLATE NOCASE ORDER BY n.id LIMIT 1),
+           0
+       ) AS resolved_id
+FROM links l;
+
+DROP INDEX IF EXISTS links_title_key;
+DROP INDEX IF EXISTS notes_title_key;
+ALTER TABLE links DROP COLUMN title_key;
+ALTER TABLE notes DROP COLUMN title_key;
+DROP TABLE IF EXISTS data_keys;
@@ -0,0 +1,61 @@
+-- data_keys are the keys the headers and contents of notes are encrypted
+-- with when encryption at rest is on, each wrapped by the master key with the
+-- given fingerprint. Values are sealed with the newest key.
+CREATE TABLE IF NOT EXISTS data_keys
+(
+    id INTEGER PRIMARY KEY,
+    wrapped BLOB NOT NULL,
+    master_key_id TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+-- An encrypted note has a BLOB header and content, and its links a BLOB
+-- target and no title. title_key is then a keyed hash of the title, set only
+-- if the title index is on, which [[title]] links are resolved by instead.
+ALTER TABLE notes ADD COLUMN title_key BLOB;
+ALTER TABLE links ADD COLUMN title_key BLOB;
+CREATE INDEX IF NOT EXISTS notes_title_key ON notes (title_key);
+CREATE INDEX IF NOT EXISTS links_title_key ON links (title_key);
+
+DROP VIEW IF EXISTS resolved_links;
+CREATE VIEW IF NOT EXISTS resolved_links AS
+SELECT l.source_id,
+       l.target,
+       l.target_id,
+       l.title,
+       l.title_key,
+       COALESCE(
+           (SELECT n.id FROM notes n WHERE n.id = l.target_id),
+           (SELECT n.id FROM notes n WHERE n.header = l.title COLLATE NOCASE ORDER BY n.id LIMIT 1),
+           (SELECT n.id FROM notes n WHERE n.title_key = l.title_key ORDER BY n.id LIMIT 1),
+           0
+       ) AS resolved_id
+FROM links l;
+
+-- Usage counts the bytes of encrypted headers and contents as the plaintext
+-- they hold: a sealed value is 33 bytes longer, see envelope.Overhead.
+DROP TRIGGER IF EXISTS usage_notes_insert;
+DROP TRIGGER IF EXISTS usage_notes_update;
+DROP TRIGGER IF EXISTS usage_notes_delete;
+CREATE TRIGGER IF NOT EXISTS usage_notes_insert AFTER INSERT ON notes
+BEGIN
+    UPDATE usage SET notes = notes + 1,
+        note_bytes = note_bytes
+            + CASE typeof(NEW.header) WHEN 'blob' THEN length(NEW.header) - 33 ELSE length(CAST(NEW.header AS BLOB)) END
+            + COALESCE(CASE typeof(NEW.content) WHEN 'blob' THEN length(NEW.content) - 33 ELSE length(CAST(NEW.content AS BLOB)) END, 0);
+END;
+CREATE TRIGGER IF NOT EXISTS usage_notes_update AFTER UPDATE OF header, content ON notes
+BEGIN
+    UPDATE usage SET note_bytes = note_bytes
+        - CASE typeof(OLD.header) WHEN 'blob' THEN length(OLD.header) - 33 ELSE length(CAST(OLD.header AS BLOB)) END
+        - COALESCE(CASE typeof(OLD.content) WHEN 'blob' THEN length(OLD.content) - 33 ELSE length(CAST(OLD.content AS BLOB)) END, 0)
+        + CASE typeof(NEW.header) WHEN 'blob' THEN length(NEW.header) - 33 ELSE length(CAST(NEW.header AS BLOB)) END
+        + COALESCE(CASE typeof(NEW.content) WHEN 'blob' THEN length(NEW.content) - 33 ELSE length(CAST(NEW.content AS BLOB)) END, 0);
+END;
+CREATE TRIGGER IF NOT EXISTS usage_notes_delete AFTER DELETE ON notes
+BEGIN
+    UPDATE usage SET notes = notes - 1,
+        note_bytes = note_bytes
+            - CASE typeof(OLD.header) WHEN 'blob' THEN length(OLD.header) - 33 ELSE length(CAST(OLD.header AS BLOB)) END
+            - COALESCE(CASE typeof(OLD.content) WHEN 'blob' THEN length(OLD.content) - 33 ELSE length(CAST(OLD.content AS BLOB)) END, 0);
+END;
@@ -0,0 +1,4 @@
+-- type is empty for Markdown notes and 'encrypted' for notes encrypted end
+-- to end, whose key derivation parameters kdf holds as JSON.
+ALTER TABLE notes ADD COLUMN type TEXT NOT NULL DEFAULT '';
+ALTER TABLE notes ADD COLUMN kdf TEXT;
@@ -0,0 +1,183 @@
+package e2enote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Client reads and writes encrypted notes on a server. Passphrases and
+// plaintext never leave it.
+type Client struct {
+	// BaseURL is where the API is served, e.g. http://localhost:8080.
+	BaseURL string
+	// HTTPClient sends the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// Iterations are those of the keys derived for new notes and
+	// passphrases, DefaultIterations if zero.
+	Iterations int
+}
+
+// Error is a failed request, with the problem the server described.
+type Error struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Detail     string `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("server responded with %d", e.StatusCode)
+	}
+	return fmt.Sprintf("server responded with %d: %s", e.StatusCode, e.Detail)
+}
+
+// Create encrypts plaintext under the passphrase and adds it as a note with
+// the header, which is stored in the clear.
+func (c *Client) Create(ctx context.Context, header string, plaintext []byte, passphrase string) (id int64, err error) {
+	kdf, err := c.newKDF()
+	if err != nil {
+		return 0, err
+	}
+	content, err := encrypt(kdf, passphrase, plaintext)
+	if err != nil {
+		return 0, err
+	}
+
+	var created struct {
+		Id int64 `json:"id"`
+	}
+	err = c.do(ctx, http.MethodPost, "/", "application/json", map[string]any{
+		"header":  header,
+		"content": content,
+		"type":    TypeEncrypted,
+		"kdf":     kdf,
+	}, &created)
+	return created.Id, err
+}
+
+// Get fetches a note and decrypts its content with the passphrase.
+func (c *Client) Get(ctx context.Context, id int64, passphrase string) (note Note, plaintext []byte, err error) {
+	if err := c.do(ctx, http.MethodGet, "/notes/"+strconv.FormatInt(id, 10), "", nil, &note); err != nil {
+		return Note{}, nil, err
+	}
+	if note.Type != TypeEncrypted {
+		return Note{}, nil, fmt.Errorf("note %d isn't encrypted", id)
+	}
+	key, err := note.KDF.Key(passphrase)
+	if err != nil {
+		return Note{}, nil, err
+	}
+	plaintext, err = Decrypt(key, note.KDF.NoteId, note.Content)
+	if err != nil {
+		return Note{}, nil, err
+	}
+	return note, plaintext, nil
+}
+
+// Update replaces the content of a note by plaintext encrypted under the
+// passphrase, which must be the one the note is encrypted under.
+func (c *Client) Update(ctx context.Context, id int64, plaintext []byte, passphrase string) error {
+	note, _, err := c.Get(ctx, id, passphrase)
+	if err != nil {
+		return err
+	}
+	content, err := encrypt(note.KDF, passphrase, plaintext)
+	if err != nil {
+		return err
+	}
+
+	return c.do(ctx, http.MethodPatch, "/notes/"+strconv.FormatInt(id, 10), "application/merge-patch+json", map[string]any{
+		"content": content,
+	}, nil)
+}
+
+// ChangePassphrase encrypts a note again under a new passphrase, with a new
+// salt. The note keeps its note id, notes without one get one.
+func (c *Client) ChangePassphrase(ctx context.Context, id int64, oldPassphrase string, newPassphrase string) error {
+	note, plaintext, err := c.Get(ctx, id, oldPassphrase)
+	if err != nil {
+		return err
+	}
+	kdf, err := c.newKDF()
+	if err != nil {
+		return err
+	}
+	if note.KDF.NoteId != "" {
+		kdf.NoteId = note.KDF.NoteId
+	}
+	content, err := encrypt(kdf, newPassphrase, plaintext)
+	if err != nil {
+		return err
+	}
+
+	// The content and the kdf change in one request, so that the note is
+	// never left with one that doesn't match the other.
+	return c.do(ctx, http.MethodPatch, "/notes/"+strconv.FormatInt(id, 10), "application/merge-patch+json", map[string]any{
+		"content": content,
+		"kdf":     kdf,
+	}, nil)
+}
+
+func (c *Client) newKDF() (KDF, error) {
+	kdf, err := NewKDF()
+	if c.Iterations != 0 {
+		kdf.Iterations = c.Iterations
+	}
+	return kdf, err
+}
+
+func encrypt(kdf KDF, passphrase string, plaintext []byte) (string, error) {
+	key, err := kdf.Key(passphrase)
+	if err != nil {
+		return "", err
+	}
+	return Encrypt(key, kdf.NoteId, plaintext)
+}
+
+// do sends body as JSON, if it isn't nil, and decodes the response into out,
+// if it isn't nil.
+func (c *Client) do(ctx context.Context, method string, path string, contentType string, body any, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.BaseURL, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		e := &Error{StatusCode: resp.StatusCode}
+		json.NewDecoder(resp.Body).Decode(e)
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
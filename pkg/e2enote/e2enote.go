@@ -0,0 +1,162 @@
+// Package e2enote is a reference client for end-to-end encrypted notes. It
+// derives a key from a passphrase, encrypts the content of a note before it
+// is sent and decrypts it once it is fetched, so that the server only ever
+// stores ciphertext and the parameters to derive the key again.
+//
+// The key is derived with PBKDF2-SHA256 and the content sealed with
+// AES-256-GCM. The content of a note is the base64 of a version byte, the
+// nonce and the ciphertext with its tag, bound to the random id of the note
+// kept in its KDF. The header of a note isn't encrypted: pick one that gives
+// nothing away.
+package e2enote
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// AlgorithmPBKDF2 is the only key derivation function this package
+// implements.
+const AlgorithmPBKDF2 = "pbkdf2-sha256"
+
+// DefaultIterations is what OWASP recommends for PBKDF2-SHA256.
+const DefaultIterations = 600_000
+
+// TypeEncrypted is the type of encrypted notes.
+const TypeEncrypted = "encrypted"
+
+const (
+	version    = 1
+	keySize    = 32
+	saltSize   = 16
+	nonceSize  = 12
+	noteIdSize = 16
+)
+
+// additionalData binds sealed contents to this format and to the note they
+// belong to. Notes from before note ids have none, and are bound to the
+// format only.
+func additionalData(noteId string) []byte {
+	if noteId == "" {
+		return []byte("e2enote v1")
+	}
+	return []byte("e2enote v1:" + noteId)
+}
+
+var (
+	ErrUnsupported = errors.New("unsupported key derivation function")
+	// ErrDecrypt is returned when a content fails to decrypt: the
+	// passphrase is wrong, or the content was tampered with.
+	ErrDecrypt   = errors.New("wrong passphrase or tampered content")
+	ErrMalformed = errors.New("malformed encrypted content")
+)
+
+// KDF are the parameters a key is derived from a passphrase with, stored
+// with the note on the server. NoteId is a random id of the note, which its
+// content is bound to, so that it can't pass for the content of another note.
+type KDF struct {
+	Algorithm   string `json:"algorithm"`
+	Salt        string `json:"salt"`
+	Iterations  int    `json:"iterations"`
+	Memory      int    `json:"memory,omitempty"`
+	Parallelism int    `json:"parallelism,omitempty"`
+	NoteId      string `json:"note_id,omitempty"`
+}
+
+// Note is a note as the server returns it. The content of an encrypted note
+// is what Encrypt returned.
+type Note struct {
+	Id        int64     `json:"id"`
+	Header    string    `json:"header"`
+	Content   string    `json:"content"`
+	Type      string    `json:"type,omitempty"`
+	KDF       KDF       `json:"kdf,omitzero"`
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
+
+// NewKDF returns parameters with a fresh random salt and note id. A new salt
+// is due whenever the passphrase changes, the note id may be kept.
+func NewKDF() (KDF, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return KDF{}, err
+	}
+	noteId := make([]byte, noteIdSize)
+	if _, err := rand.Read(noteId); err != nil {
+		return KDF{}, err
+	}
+	return KDF{
+		Algorithm:  AlgorithmPBKDF2,
+		Salt:       base64.StdEncoding.EncodeToString(salt),
+		Iterations: DefaultIterations,
+		NoteId:     base64.StdEncoding.EncodeToString(noteId),
+	}, nil
+}
+
+// Key derives the key of a note from the passphrase.
+func (k KDF) Key(passphrase string) ([]byte, error) {
+	if k.Algorithm != AlgorithmPBKDF2 {
+		return nil, fmt.Errorf("%w %q", ErrUnsupported, k.Algorithm)
+	}
+	salt, err := base64.StdEncoding.DecodeString(k.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("salt isn't base64: %w", err)
+	}
+	if k.Iterations < 1 {
+		return nil, fmt.Errorf("%d iterations, want at least 1", k.Iterations)
+	}
+	return pbkdf2.Key(sha256.New, passphrase, salt, k.Iterations, keySize)
+}
+
+// Encrypt seals plaintext with a key derived by KDF.Key and returns it as
+// the content of an encrypted note, bound to the note id of the KDF.
+func Encrypt(key []byte, noteId string, plaintext []byte) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	sealed := make([]byte, 1+nonceSize, 1+nonceSize+len(plaintext)+aead.Overhead())
+	sealed[0] = version
+	if _, err := rand.Read(sealed[1:]); err != nil {
+		return "", err
+	}
+	sealed = aead.Seal(sealed, sealed[1:], plaintext, additionalData(noteId))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens the content of an encrypted note sealed by Encrypt for the
+// same note id.
+func Decrypt(key []byte, noteId string, content string) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(content)
+	if err != nil || len(sealed) < 1+nonceSize+aead.Overhead() || sealed[0] != version {
+		return nil, ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, sealed[1:1+nonceSize], sealed[1+nonceSize:], additionalData(noteId))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key is %d bytes long, want %d", len(key), keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
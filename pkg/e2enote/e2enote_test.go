@@ -0,0 +1,89 @@
+package e2enote_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/sergeyreshetnyakov/notion/pkg/e2enote"
+)
+
+// testKDF is cheap to derive keys with, unlike the defaults.
+func testKDF(t *testing.T) e2enote.KDF {
+	t.Helper()
+
+	kdf, err := e2enote.NewKDF()
+	if err != nil {
+		t.Fatalf("NewKDF: %v", err)
+	}
+	kdf.Iterations = 1000
+	return kdf
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	kdf := testKDF(t)
+	key, err := kdf.Key("correct horse")
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	plaintext := []byte("the plan")
+
+	content, err := e2enote.Encrypt(key, kdf.NoteId, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	if strings.Contains(content, "plan") {
+		t.Error("content holds the plaintext")
+	}
+	got, err := e2enote.Decrypt(key, kdf.NoteId, content)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("Decrypt = %q, %v, want %q", got, err, plaintext)
+	}
+
+	// The same passphrase under another salt derives another key.
+	wrongKey, err := kdf.Key("battery staple")
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	otherSalt, err := testKDF(t).Key("correct horse")
+	if err != nil {
+		t.Fatalf("Key: %v", err)
+	}
+	sealed, _ := base64.StdEncoding.DecodeString(content)
+	sealed[len(sealed)-1] ^= 1
+	tampered := base64.StdEncoding.EncodeToString(sealed)
+
+	tests := []struct {
+		name    string
+		key     []byte
+		noteId  string
+		content string
+		want    error
+	}{
+		{"wrong passphrase", wrongKey, kdf.NoteId, content, e2enote.ErrDecrypt},
+		{"other salt", otherSalt, kdf.NoteId, content, e2enote.ErrDecrypt},
+		{"other note", key, testKDF(t).NoteId, content, e2enote.ErrDecrypt},
+		{"no note id", key, "", content, e2enote.ErrDecrypt},
+		{"tampered", key, kdf.NoteId, tampered, e2enote.ErrDecrypt},
+		{"not base64", key, kdf.NoteId, "not base64!", e2enote.ErrMalformed},
+		{"truncated", key, kdf.NoteId, content[:8], e2enote.ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := e2enote.Decrypt(tt.key, tt.noteId, tt.content); !errors.Is(err, tt.want) {
+				t.Errorf("Decrypt: got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnsupportedKDF(t *testing.T) {
+	kdf := testKDF(t)
+	kdf.Algorithm = "argon2id"
+
+	if _, err := kdf.Key("passphrase"); !errors.Is(err, e2enote.ErrUnsupported) {
+		t.Errorf("Key: got %v, want %v", err, e2enote.ErrUnsupported)
+	}
+}
@@ -1,80 +1,3394 @@
 package notes_test
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime/multipart"
 	"net/http"
-	"strconv"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/sergeyreshetnyakov/notion/internal/app"
+	"github.com/sergeyreshetnyakov/notion/internal/config"
+	"github.com/sergeyreshetnyakov/notion/internal/domain/models"
+	"github.com/sergeyreshetnyakov/notion/internal/lib/exif"
+	"github.com/sergeyreshetnyakov/notion/internal/middlewares"
+	notestorage "github.com/sergeyreshetnyakov/notion/internal/storage/notes"
+	"github.com/sergeyreshetnyakov/notion/pkg/e2enote"
 )
 
-const url string = "http://localhost:8080"
+const migrationsPath = "../migrations"
+
+// newServer starts the full handler stack against a fresh temporary database.
+// configure may change the config before the stack is built.
+func newServer(t *testing.T, configure ...func(cfg *config.Config)) *httptest.Server {
+	t.Helper()
+	return newServerWith(t, func(*http.Server) {}, configure...)
+}
+
+// newServerWith is newServer whose http.Server serve may change, e.g. its
+// timeouts, before it starts.
+func newServerWith(t *testing.T, serve func(s *http.Server), configure ...func(cfg *config.Config)) *httptest.Server {
+	t.Helper()
+
+	cfg := config.Config{
+		Env:              "local",
+		Storage:          config.StorageSQLite,
+		StoragePath:      filepath.Join(t.TempDir(), "notes.db"),
+		MigrationsPath:   migrationsPath,
+		MaxBatchSize:     5,
+		MaxHeaderLength:  32,
+		MaxContentLength: 64,
+		MaxBodySize:      1024,
+		MaxImportSize:    4096,
+		IdempotencyTTL:   time.Hour,
+		AdminToken:       testAdminToken,
+	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+	if err := notestorage.Migrate(cfg.StoragePath, cfg.MigrationsPath); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	application := app.New(cfg, slog.New(slog.DiscardHandler))
+	server := httptest.NewUnstartedServer(application.Handler)
+	serve(server.Config)
+	server.Start()
+	// The tests act as the admin, unless a step sends a header of its own.
+	server.Client().Transport = adminTransport{server.Client().Transport}
+	t.Cleanup(func() {
+		server.Close()
+		application.Close()
+	})
+
+	return server
+}
+
+const testAdminToken = "test-admin-token"
+
+// adminTransport sends the admin token with the requests to admin routes
+// that have no Authorization header, not even an empty one.
+type adminTransport struct {
+	next http.RoundTripper
+}
+
+func (t adminTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasPrefix(req.URL.Path, middlewares.AdminPrefix) && req.Header.Values("Authorization") == nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	}
+	return t.next.RoundTrip(req)
+}
+
+type step struct {
+	name        string
+	method      string
+	path        string
+	contentType string
+	headers     map[string]string
+	body        string
+	wantStatus  int
+	wantJSON    string
+	wantBody    string
+	// wantContains and wantNotContains are looked for in the raw body.
+	wantContains    []string
+	wantNotContains []string
+	// wantProblem is compared with a problem+json body without its type,
+	// title, status, instance and request_id, which are checked separately.
+	wantProblem string
+	wantHeaders map[string]string
+}
+
+// run executes the steps in order against one server, so later steps can
+// rely on the state left by earlier ones.
+func run(t *testing.T, server *httptest.Server, steps []step) {
+	t.Helper()
+
+	for _, s := range steps {
+		ok := t.Run(s.name, func(t *testing.T) {
+			path := s.path
+			if path == "" {
+				path = "/"
+			}
+			req, err := http.NewRequest(s.method, server.URL+path, strings.NewReader(s.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.contentType != "" {
+				req.Header.Set("Content-Type", s.contentType)
+			}
+			for key, value := range s.headers {
+				req.Header.Set(key, value)
+			}
+
+			res, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res.StatusCode != s.wantStatus {
+				t.Fatalf("status = %d, want %d; body: %s", res.StatusCode, s.wantStatus, body)
+			}
+			if s.wantJSON != "" {
+				assertJSON(t, body, s.wantJSON)
+			}
+			if s.wantProblem != "" {
+				assertProblem(t, res, body, s.wantProblem)
+			}
+			if s.wantBody != "" && strings.TrimSpace(string(body)) != s.wantBody {
+				t.Fatalf("body = %q, want %q", strings.TrimSpace(string(body)), s.wantBody)
+			}
+			for _, want := range s.wantContains {
+				if !strings.Contains(string(body), want) {
+					t.Fatalf("body doesn't contain %q; body: %s", want, body)
+				}
+			}
+			for _, notWant := range s.wantNotContains {
+				if strings.Contains(string(body), notWant) {
+					t.Fatalf("body contains %q; body: %s", notWant, body)
+				}
+			}
+			for key, want := range s.wantHeaders {
+				if got := res.Header.Get(key); got != want {
+					t.Fatalf("header %s = %q, want %q", key, got, want)
+				}
+			}
+		})
+		if !ok {
+			t.FailNow()
+		}
+	}
+}
+
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+
+	var gotValue, wantValue any
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("response is not JSON: %v; body: %s", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("bad expectation %q: %v", want, err)
+	}
+
+	stripTimestamps(t, gotValue)
+
+	gotJSON, _ := json.Marshal(gotValue)
+	wantJSON, _ := json.Marshal(wantValue)
+	if string(gotJSON) != string(wantJSON) {
+		t.Fatalf("body = %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+// stripTimestamps removes the created_at and updated_at fields of notes, which
+// change from run to run, after checking they are valid timestamps.
+func stripTimestamps(t *testing.T, v any) {
+	t.Helper()
+
+	switch v := v.(type) {
+	case []any:
+		for _, item := range v {
+			stripTimestamps(t, item)
+		}
+	case map[string]any:
+		for _, key := range []string{"created_at", "updated_at"} {
+			if value, ok := v[key]; ok {
+				if s, _ := value.(string); s == "" {
+					t.Fatalf("%s = %v, want a timestamp", key, value)
+				} else if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+					t.Fatalf("%s: %v", key, err)
+				}
+				delete(v, key)
+			}
+		}
+		for _, value := range v {
+			stripTimestamps(t, value)
+		}
+	}
+}
+
+func assertProblem(t *testing.T, res *http.Response, body []byte, want string) {
+	t.Helper()
+
+	if got := res.Header.Get("Content-Type"); got != "application/problem+json" {
+		t.Fatalf("Content-Type = %q, want application/problem+json; body: %s", got, body)
+	}
+
+	var p map[string]any
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("response is not JSON: %v; body: %s", err, body)
+	}
+	if p["type"] != "urn:notion:problem:"+p["code"].(string) {
+		t.Fatalf("type = %v doesn't match code %v", p["type"], p["code"])
+	}
+	if p["title"] != http.StatusText(res.StatusCode) || p["status"] != float64(res.StatusCode) {
+		t.Fatalf("title = %v, status = %v don't match the response status %d", p["title"], p["status"], res.StatusCode)
+	}
+	if p["instance"] != res.Request.URL.Path {
+		t.Fatalf("instance = %v, want %s", p["instance"], res.Request.URL.Path)
+	}
+	if id := res.Header.Get("X-Request-Id"); id == "" || p["request_id"] != id {
+		t.Fatalf("request_id = %v, want the X-Request-Id header %q", p["request_id"], id)
+	}
+	for _, key := range []string{"type", "title", "status", "instance", "request_id"} {
+		delete(p, key)
+	}
+
+	rest, _ := json.Marshal(p)
+	assertJSON(t, rest, want)
+}
 
 func TestNotes(t *testing.T) {
-	t.Run("[GET] notes", func(t *testing.T) {
-		_, err := http.Get(url)
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[GET] empty list",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "wash the basement", "content": "immediatly"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:       "[ADD] note without content",
+			method:     http.MethodPost,
+			body:       `{"header": "call mom"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:       "[GET] list",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON: `[
+				{"header": "wash the basement", "content": "immediatly", "id": 1},
+				{"header": "call mom", "content": "", "id": 2}
+			]`,
+		},
+		{
+			name:       "[PATCH] note",
+			method:     http.MethodPatch,
+			body:       `{"header": "immediatly", "content": "wash the basement", "id": 1}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[PATCH] header only keeps content",
+			method:     http.MethodPatch,
+			body:       `{"header": "call dad", "id": 2}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[GET] list after edit",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON: `[
+				{"header": "immediatly", "content": "wash the basement", "id": 1},
+				{"header": "call dad", "content": "", "id": 2}
+			]`,
+		},
+		{
+			name:       "[DELETE] note",
+			method:     http.MethodDelete,
+			body:       `{"id": 1}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[GET] list after delete",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"header": "call dad", "content": "", "id": 2}]`,
+		},
+	})
+}
+
+func TestNotesErrors(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] seed",
+			method:     http.MethodPost,
+			body:       `{"header": "seed", "content": "note"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:        "[ADD] malformed body",
+			method:      http.MethodPost,
+			body:        `{"header": `,
+			wantStatus:  http.StatusBadRequest,
+			wantProblem: `{"code": "malformed_request", "detail": "Failed to decode request body: unexpected EOF"}`,
+		},
+		{
+			name:        "[ADD] empty header",
+			method:      http.MethodPost,
+			body:        `{"header": "", "content": "no header"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: header: must contain any characters", "errors": [{"field": "header", "message": "must contain any characters"}]}`,
+		},
+		{
+			name:        "[PATCH] malformed body",
+			method:      http.MethodPatch,
+			body:        `not json`,
+			wantStatus:  http.StatusBadRequest,
+			wantProblem: `{"code": "malformed_request", "detail": "Failed to decode request body: invalid character 'o' in literal null (expecting 'u')"}`,
+		},
+		{
+			name:        "[PATCH] missing note",
+			method:      http.MethodPatch,
+			body:        `{"header": "nope", "id": 42}`,
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to edit note: note not found"}`,
+		},
+		{
+			name:        "[PATCH] nothing to change",
+			method:      http.MethodPatch,
+			body:        `{"header": "seed", "content": "note", "id": 1}`,
+			wantStatus:  http.StatusBadRequest,
+			wantProblem: `{"code": "nothing_to_change", "detail": "Failed to edit note: nothing to change"}`,
+		},
+		{
+			name:        "[DELETE] malformed body",
+			method:      http.MethodDelete,
+			body:        `{"id": }`,
+			wantStatus:  http.StatusBadRequest,
+			wantProblem: `{"code": "malformed_request", "detail": "Failed to decode request body: invalid character '}' looking for beginning of value"}`,
+		},
+		{
+			name:        "[DELETE] missing note",
+			method:      http.MethodDelete,
+			body:        `{"id": 42}`,
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to delete note: note not found"}`,
+		},
+		{
+			name:       "[DELETE] seed",
+			method:     http.MethodDelete,
+			body:       `{"id": 1}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "[DELETE] already deleted",
+			method:      http.MethodDelete,
+			body:        `{"id": 1}`,
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to delete note: note not found"}`,
+		},
+		{
+			name:       "[PUT] unsupported method",
+			method:     http.MethodPut,
+			body:       `{"id": 1}`,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	})
+}
+
+func TestBatch(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] seed",
+			method:     http.MethodPost,
+			body:       `{"header": "seed", "content": "note"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:   "[BATCH] atomic",
+			method: http.MethodPost,
+			path:   "/batch",
+			body: `{"operations": [
+				{"op": "create", "header": "first", "content": "batch"},
+				{"op": "create", "header": "second"},
+				{"op": "edit", "id": 1, "content": "edited"},
+				{"op": "delete", "id": 2}
+			]}`,
+			wantStatus: http.StatusOK,
+			wantJSON: `{"results": [
+				{"op": "create", "status": "ok", "id": 2},
+				{"op": "create", "status": "ok", "id": 3},
+				{"op": "edit", "status": "ok", "id": 1},
+				{"op": "delete", "status": "ok", "id": 2}
+			]}`,
+		},
+		{
+			name:       "[GET] after atomic batch",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON: `[
+				{"header": "seed", "content": "edited", "id": 1},
+				{"header": "second", "content": "", "id": 3}
+			]`,
+		},
+		{
+			name:   "[BATCH] atomic failure rolls back",
+			method: http.MethodPost,
+			path:   "/batch",
+			body: `{"operations": [
+				{"op": "create", "header": "rolled back"},
+				{"op": "delete", "id": 42},
+				{"op": "delete", "id": 1}
+			]}`,
+			wantStatus: http.StatusNotFound,
+			wantProblem: `{
+				"code": "not_found",
+				"detail": "Failed to run batch: operation 1: note not found",
+				"results": [
+					{"op": "create", "status": "rolled_back"},
+					{"op": "delete", "status": "failed", "id": 42, "error": "note not found"},
+					{"op": "delete", "status": "skipped"}
+				]
+			}`,
+		},
+		{
+			name:   "[BATCH] invalid operation is rejected before writing",
+			method: http.MethodPost,
+			path:   "/batch",
+			body: `{"operations": [
+				{"op": "delete", "id": 1},
+				{"op": "move", "id": 3}
+			]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{
+				"code": "validation_failed",
+				"detail": "Failed to run batch: operation 1: op: unknown operation \"move\"",
+				"errors": [{"field": "operations[1].op", "message": "unknown operation \"move\""}],
+				"results": [
+					{"op": "delete", "status": "skipped"},
+					{"op": "move", "status": "failed", "error": "op: unknown operation \"move\""}
+				]
+			}`,
+		},
+		{
+			name:       "[GET] after failed batches",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON: `[
+				{"header": "seed", "content": "edited", "id": 1},
+				{"header": "second", "content": "", "id": 3}
+			]`,
+		},
+		{
+			name:   "[BATCH] best effort",
+			method: http.MethodPost,
+			path:   "/batch",
+			body: `{"atomic": false, "operations": [
+				{"op": "create", "header": "kept"},
+				{"op": "edit", "id": 1, "content": "edited"},
+				{"op": "create", "header": ""},
+				{"op": "delete", "id": 3}
+			]}`,
+			wantStatus: http.StatusOK,
+			wantJSON: `{"results": [
+				{"op": "create", "status": "ok", "id": 4},
+				{"op": "edit", "status": "failed", "id": 1, "error": "nothing to change"},
+				{"op": "create", "status": "failed", "error": "header: must contain any characters"},
+				{"op": "delete", "status": "ok", "id": 3}
+			]}`,
+		},
+		{
+			name:       "[GET] after best effort batch",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON: `[
+				{"header": "seed", "content": "edited", "id": 1},
+				{"header": "kept", "content": "", "id": 4}
+			]`,
+		},
+		{
+			name:        "[BATCH] empty",
+			method:      http.MethodPost,
+			path:        "/batch",
+			body:        `{"operations": []}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to run batch: operations: must contain at least one operation", "errors": [{"field": "operations", "message": "must contain at least one operation"}]}`,
+		},
+		{
+			name:   "[BATCH] too large",
+			method: http.MethodPost,
+			path:   "/batch",
+			body: `{"operations": [
+				{"op": "create", "header": "1"}, {"op": "create", "header": "2"},
+				{"op": "create", "header": "3"}, {"op": "create", "header": "4"},
+				{"op": "create", "header": "5"}, {"op": "create", "header": "6"}
+			]}`,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantProblem: `{"code": "too_large", "detail": "Failed to run batch: batch contains too many operations: 6, the limit is 5"}`,
+		},
+	})
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:        "[ADD] with key",
+			method:      http.MethodPost,
+			headers:     map[string]string{"Idempotency-Key": "first"},
+			body:        `{"header": "wash the basement", "content": "immediatly"}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"id": 1}`,
+			wantHeaders: map[string]string{"Idempotent-Replayed": ""},
+		},
+		{
+			name:        "[ADD] retry is replayed",
+			method:      http.MethodPost,
+			headers:     map[string]string{"Idempotency-Key": "first"},
+			body:        `{"header": "wash the basement", "content": "immediatly"}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"id": 1}`,
+			wantHeaders: map[string]string{"Idempotent-Replayed": "true", "Content-Type": "application/json"},
+		},
+		{
+			name:        "[ADD] key reused with another body",
+			method:      http.MethodPost,
+			headers:     map[string]string{"Idempotency-Key": "first"},
+			body:        `{"header": "something else"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "idempotency_key_reused", "detail": "Idempotency-Key was already used with a different request"}`,
+		},
+		{
+			name:       "[ADD] another key",
+			method:     http.MethodPost,
+			headers:    map[string]string{"Idempotency-Key": "second"},
+			body:       `{"header": "wash the basement", "content": "immediatly"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:        "[ADD] client errors are replayed too",
+			method:      http.MethodPost,
+			headers:     map[string]string{"Idempotency-Key": "third", "X-Request-Id": "retried-request"},
+			body:        `{"header": ""}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: header: must contain any characters", "errors": [{"field": "header", "message": "must contain any characters"}]}`,
+		},
+		{
+			name:        "[ADD] replayed client error",
+			method:      http.MethodPost,
+			headers:     map[string]string{"Idempotency-Key": "third", "X-Request-Id": "retried-request"},
+			body:        `{"header": ""}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: header: must contain any characters", "errors": [{"field": "header", "message": "must contain any characters"}]}`,
+			wantHeaders: map[string]string{"Idempotent-Replayed": "true"},
+		},
+		{
+			name:       "[ADD] without key is not deduplicated",
+			method:     http.MethodPost,
+			body:       `{"header": "wash the basement", "content": "immediatly"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 3}`,
+		},
+		{
+			name:       "[GET] only one note per key",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON: `[
+				{"header": "wash the basement", "content": "immediatly", "id": 1},
+				{"header": "wash the basement", "content": "immediatly", "id": 2},
+				{"header": "wash the basement", "content": "immediatly", "id": 3}
+			]`,
+		},
+	})
+}
+
+func TestIdempotencyKeysLargeBodies(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "attachments")
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.AttachmentDir = dir
+		cfg.MaxAttachmentSize = 16 << 20
+	})
+
+	content := strings.Repeat("x", 11<<20)
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	upload, uploadType := uploadOf(t, "file", "large.txt", "text/plain", content)
+	other, otherType := uploadOf(t, "file", "large.txt", "text/plain", content[1:]+"y")
+	want := `{"id": 1, "note_id": 1, "name": "large.txt", "media_type": "text/plain", "size": 11534336, "sha256": "` + sum + `"}`
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "note", "content": ""}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "[UPLOAD] with key, past what is kept in memory",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: uploadType,
+			headers:     map[string]string{"Idempotency-Key": "large"},
+			body:        upload,
+			wantStatus:  http.StatusOK,
+			wantJSON:    want,
+		},
+		{
+			name:        "[UPLOAD] retry is replayed",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: uploadType,
+			headers:     map[string]string{"Idempotency-Key": "large"},
+			body:        upload,
+			wantStatus:  http.StatusOK,
+			wantJSON:    want,
+			wantHeaders: map[string]string{"Idempotent-Replayed": "true"},
+		},
+		{
+			name:        "[UPLOAD] key reused with another file",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: otherType,
+			headers:     map[string]string{"Idempotency-Key": "large"},
+			body:        other,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "idempotency_key_reused", "detail": "Idempotency-Key was already used with a different request"}`,
+		},
+		{
+			name:       "[GET] one attachment",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[` + want + `]`,
+		},
+	})
+}
+
+func TestPatch(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] seed",
+			method:     http.MethodPost,
+			body:       `{"header": "seed", "content": "note"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:        "[MERGE PATCH] clear content",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"content": null}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"header": "seed", "content": "", "id": 1}`,
+		},
+		{
+			name:        "[MERGE PATCH] plain JSON sets fields",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/json",
+			body:        `{"header": "patched", "content": "again"}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"header": "patched", "content": "again", "id": 1}`,
+		},
+		{
+			name:        "[MERGE PATCH] no changes",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{}`,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"header": "patched", "content": "again", "id": 1}`,
+		},
+		{
+			name:        "[JSON PATCH] test and replace",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/json-patch+json",
+			body: `[
+				{"op": "test", "path": "/header", "value": "patched"},
+				{"op": "replace", "path": "/header", "value": "tested"},
+				{"op": "remove", "path": "/content"}
+			]`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"header": "tested", "content": "", "id": 1}`,
+		},
+		{
+			name:        "[JSON PATCH] failed test changes nothing",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/json-patch+json",
+			body: `[
+				{"op": "replace", "path": "/content", "value": "lost"},
+				{"op": "test", "path": "/header", "value": "patched"}
+			]`,
+			wantStatus:  http.StatusConflict,
+			wantProblem: `{"code": "patch_test_failed", "detail": "Failed to patch note: operation 1 (test \"/header\"): test failed"}`,
+		},
+		{
+			name:       "[GET] after patches",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"header": "tested", "content": "", "id": 1}]`,
+		},
+		{
+			name:        "[JSON PATCH] missing path",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/json-patch+json",
+			body:        `[{"op": "remove", "path": "/tags"}]`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "patch_path_not_found", "detail": "Failed to patch note: operation 0 (remove \"/tags\"): path not found"}`,
+		},
+		{
+			name:        "[JSON PATCH] not an array",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/json-patch+json",
+			body:        `{"op": "remove", "path": "/content"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "[MERGE PATCH] malformed",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"header": `,
+			wantStatus:  http.StatusBadRequest,
+			wantProblem: `{"code": "invalid_patch", "detail": "Failed to patch note: invalid patch: unexpected EOF"}`,
+		},
+		{
+			name:        "[MERGE PATCH] remove header",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"header": null}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: header: is required", "errors": [{"field": "header", "message": "is required"}]}`,
+		},
+		{
+			name:        "[MERGE PATCH] empty header",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"header": ""}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: header: must contain any characters", "errors": [{"field": "header", "message": "must contain any characters"}]}`,
+		},
+		{
+			name:        "[MERGE PATCH] wrong type",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"content": 42}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: content: must be a string or null", "errors": [{"field": "content", "message": "must be a string or null"}]}`,
+		},
+		{
+			name:        "[MERGE PATCH] unknown field",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"tags": ["a"]}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: tags: unknown field", "errors": [{"field": "tags", "message": "unknown field"}]}`,
+		},
+		{
+			name:        "[MERGE PATCH] change id",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"id": 2}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: id: can't be changed", "errors": [{"field": "id", "message": "can't be changed"}]}`,
+		},
+		{
+			name:        "[MERGE PATCH] change a timestamp",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"created_at": "2000-01-01T00:00:00Z"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: created_at: can't be changed", "errors": [{"field": "created_at", "message": "can't be changed"}]}`,
+		},
+		{
+			name:        "[MERGE PATCH] replace the whole note",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `"note"`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: note: must be an object", "errors": [{"field": "note", "message": "must be an object"}]}`,
+		},
+		{
+			name:        "[MERGE PATCH] missing note",
+			method:      http.MethodPatch,
+			path:        "/notes/42",
+			contentType: "application/merge-patch+json",
+			body:        `{"content": null}`,
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to patch note: note not found"}`,
+		},
+		{
+			name:        "[PATCH] bad id",
+			method:      http.MethodPatch,
+			path:        "/notes/one",
+			contentType: "application/merge-patch+json",
+			body:        `{}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "[PATCH] unsupported content type",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "text/plain",
+			body:        `content`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantProblem: `{"code": "unsupported_media_type", "detail": "Failed to patch note: unsupported Content-Type \"text/plain\""}`,
+			wantHeaders: map[string]string{"Accept-Patch": "application/merge-patch+json, application/json-patch+json"},
+		},
+	})
+}
+
+func TestValidation(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] seed",
+			method:     http.MethodPost,
+			body:       `{"header": "seed", "content": "note"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:       "[ADD] longest header and content",
+			method:     http.MethodPost,
+			body:       `{"header": "` + strings.Repeat("ж", 32) + `", "content": "` + strings.Repeat("ж", 64) + `"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:       "[ADD] every field is reported",
+			method:     http.MethodPost,
+			body:       `{"header": "` + strings.Repeat("h", 33) + `", "content": "` + strings.Repeat("c", 65) + `"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{
+				"code": "validation_failed",
+				"detail": "Failed to add new note: header: must be at most 32 characters long; content: must be at most 64 characters long",
+				"errors": [
+					{"field": "header", "message": "must be at most 32 characters long"},
+					{"field": "content", "message": "must be at most 64 characters long"}
+				]
+			}`,
+		},
+		{
+			name:       "[ADD] blank header",
+			method:     http.MethodPost,
+			body:       `{"header": "  ", "content": "note"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: header: must contain any characters",
+				"errors": [{"field": "header", "message": "must contain any characters"}]}`,
+		},
+		{
+			name:       "[ADD] line break in header",
+			method:     http.MethodPost,
+			body:       `{"header": "two\nlines"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: header: must not contain control characters or line breaks",
+				"errors": [{"field": "header", "message": "must not contain control characters or line breaks"}]}`,
+		},
+		{
+			name:       "[ADD] line breaks and tabs in content",
+			method:     http.MethodPost,
+			body:       `{"header": "lines", "content": "one\r\n\ttwo"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 3}`,
+		},
+		{
+			name:       "[ADD] control character in content",
+			method:     http.MethodPost,
+			body:       `{"header": "bell", "content": "\u0007"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: content: must not contain control characters other than tabs and line breaks",
+				"errors": [{"field": "content", "message": "must not contain control characters other than tabs and line breaks"}]}`,
+		},
+		{
+			name:       "[ADD] invalid UTF-8",
+			method:     http.MethodPost,
+			body:       "{\"header\": \"\xff\"}",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to decode request body: body: must be valid UTF-8",
+				"errors": [{"field": "body", "message": "must be valid UTF-8"}]}`,
+		},
+		{
+			name:       "[ADD] unknown field",
+			method:     http.MethodPost,
+			body:       `{"header": "tagged", "tags": ["a"]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to decode request body: tags: unknown field",
+				"errors": [{"field": "tags", "message": "unknown field"}]}`,
+		},
+		{
+			name:       "[ADD] unknown fields in nested objects",
+			method:     http.MethodPost,
+			body:       `{"header": "secret", "content": "c291cA==", "type": "encrypted", "Tags": [], "kdf": {"algorithm": "pbkdf2-sha256", "pepper": "x"}}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to decode request body: Tags: unknown field; kdf.pepper: unknown field",
+				"errors": [{"field": "Tags", "message": "unknown field"}, {"field": "kdf.pepper", "message": "unknown field"}]}`,
+		},
+		{
+			name:       "[BATCH] unknown field in an operation",
+			method:     http.MethodPost,
+			path:       "/batch",
+			body:       `{"operations": [{"op": "create", "header": "one"}, {"op": "create", "header": "two", "tags": ["a"]}]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to decode request body: operations[1].tags: unknown field",
+				"errors": [{"field": "operations[1].tags", "message": "unknown field"}]}`,
+		},
+		{
+			name:       "[ADD] wrong type",
+			method:     http.MethodPost,
+			body:       `{"header": 42}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to decode request body: header: must be a string",
+				"errors": [{"field": "header", "message": "must be a string"}]}`,
+		},
+		{
+			name:        "[ADD] trailing data",
+			method:      http.MethodPost,
+			body:        `{"header": "one"} {"header": "two"}`,
+			wantStatus:  http.StatusBadRequest,
+			wantProblem: `{"code": "malformed_request", "detail": "Failed to decode request body: unexpected data after the JSON value"}`,
+		},
+		{
+			name:        "[ADD] body too large",
+			method:      http.MethodPost,
+			body:        `{"header": "big", "content": "` + strings.Repeat("c", 1024) + `"}`,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantProblem: `{"code": "too_large", "detail": "Request body is too large: the limit is 1024 bytes"}`,
+		},
+		{
+			name:       "[PATCH] id must be positive",
+			method:     http.MethodPatch,
+			body:       `{"header": "nope"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to edit note: id: must be a positive number",
+				"errors": [{"field": "id", "message": "must be a positive number"}]}`,
+		},
+		{
+			name:       "[PATCH] header too long",
+			method:     http.MethodPatch,
+			body:       `{"header": "` + strings.Repeat("h", 33) + `", "id": 1}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to edit note: header: must be at most 32 characters long",
+				"errors": [{"field": "header", "message": "must be at most 32 characters long"}]}`,
+		},
+		{
+			name:        "[MERGE PATCH] content too long",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"content": "` + strings.Repeat("c", 65) + `"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: content: must be at most 64 characters long",
+				"errors": [{"field": "content", "message": "must be at most 64 characters long"}]}`,
+		},
+		{
+			name:   "[BATCH] fields are relative to the batch",
+			method: http.MethodPost,
+			path:   "/batch",
+			body: `{"operations": [
+				{"op": "delete", "id": 1},
+				{"op": "edit", "id": -1, "header": "` + strings.Repeat("h", 33) + `"}
+			]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{
+				"code": "validation_failed",
+				"detail": "Failed to run batch: operation 1: id: must be a positive number; header: must be at most 32 characters long",
+				"errors": [
+					{"field": "operations[1].id", "message": "must be a positive number"},
+					{"field": "operations[1].header", "message": "must be at most 32 characters long"}
+				],
+				"results": [
+					{"op": "delete", "status": "skipped"},
+					{"op": "edit", "status": "failed", "error": "id: must be a positive number; header: must be at most 32 characters long"}
+				]
+			}`,
+		},
+		{
+			name:       "[GET] nothing invalid was stored",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON: `[
+				{"header": "seed", "content": "note", "id": 1},
+				{"header": "` + strings.Repeat("ж", 32) + `", "content": "` + strings.Repeat("ж", 64) + `", "id": 2},
+				{"header": "lines", "content": "one\r\n\ttwo", "id": 3}
+			]`,
+		},
+	})
+}
+
+func TestGetNote(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] markdown note",
+			method:     http.MethodPost,
+			body:       `{"header": "<Groceries>", "content": "- [x] milk\n- [ ] eggs\n\n<script>alert(1)</script>"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:        "[GET] json by default",
+			method:      http.MethodGet,
+			path:        "/notes/1",
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"header": "<Groceries>", "content": "- [x] milk\n- [ ] eggs\n\n<script>alert(1)</script>", "id": 1}`,
+			wantHeaders: map[string]string{"Content-Type": "application/json", "Vary": "Accept"},
+		},
+		{
+			name:            "[GET] html by format",
+			method:          http.MethodGet,
+			path:            "/notes/1?format=html",
+			wantStatus:      http.StatusOK,
+			wantContains:    []string{"<title>&lt;Groceries&gt;</title>", `<input checked="" disabled="" type="checkbox"> milk`, ".chroma"},
+			wantNotContains: []string{"<script", "alert"},
+			wantHeaders: map[string]string{
+				"Content-Type":            "text/html; charset=utf-8",
+				"Content-Security-Policy": "default-src 'none'; style-src 'unsafe-inline'; img-src https: data:",
+			},
+		},
+		{
+			name:         "[GET] html by Accept",
+			method:       http.MethodGet,
+			path:         "/notes/1",
+			headers:      map[string]string{"Accept": "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+			wantStatus:   http.StatusOK,
+			wantContains: []string{"<article>"},
+			wantHeaders:  map[string]string{"Content-Type": "text/html; charset=utf-8"},
+		},
+		{
+			name:        "[GET] json preferred by Accept",
+			method:      http.MethodGet,
+			path:        "/notes/1",
+			headers:     map[string]string{"Accept": "text/html;q=0.5, application/*"},
+			wantStatus:  http.StatusOK,
+			wantHeaders: map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			name:        "[GET] format wins over Accept",
+			method:      http.MethodGet,
+			path:        "/notes/1?format=markdown",
+			headers:     map[string]string{"Accept": "text/html"},
+			wantStatus:  http.StatusOK,
+			wantBody:    "- [x] milk\n- [ ] eggs\n\n<script>alert(1)</script>",
+			wantHeaders: map[string]string{"Content-Type": "text/markdown; charset=utf-8"},
+		},
+		{
+			name:       "[GET] unknown format",
+			method:     http.MethodGet,
+			path:       "/notes/1?format=pdf",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to get note: format: must be one of json, html, markdown",
+				"errors": [{"field": "format", "message": "must be one of json, html, markdown"}]}`,
+		},
+		{
+			name:        "[GET] missing note",
+			method:      http.MethodGet,
+			path:        "/notes/42?format=html",
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to get note: note not found"}`,
+		},
+	})
+}
+
+func TestLinks(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] target",
+			method:     http.MethodPost,
+			body:       `{"header": "Recipes", "content": "soup"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:       "[ADD] source",
+			method:     http.MethodPost,
+			body:       `{"header": "Menu", "content": "see [[recipes|the recipes]], [[#1]] and [[Drinks]]\n` + "`[[code]]`" + `"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:       "[GET] links",
+			method:     http.MethodGet,
+			path:       "/notes/2/links",
+			wantStatus: http.StatusOK,
+			wantJSON: `[{"source_id": 2, "target": "#1", "target_id": 1},
+				{"source_id": 2, "target": "Drinks", "target_id": 0},
+				{"source_id": 2, "target": "recipes", "target_id": 1}]`,
+		},
+		{
+			name:       "[GET] backlinks",
+			method:     http.MethodGet,
+			path:       "/notes/1/backlinks",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"source_id": 2, "target": "#1", "target_id": 1}, {"source_id": 2, "target": "recipes", "target_id": 1}]`,
+		},
+		{
+			name:       "[GET] broken links",
+			method:     http.MethodGet,
+			path:       "/links/broken",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"source_id": 2, "target": "Drinks", "target_id": 0}]`,
+		},
+		{
+			name:       "[ADD] missing target",
+			method:     http.MethodPost,
+			body:       `{"header": "drinks", "content": "tea"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 3}`,
+		},
+		{
+			name:       "[GET] no broken links",
+			method:     http.MethodGet,
+			path:       "/links/broken",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:       "[EDIT] source drops links",
+			method:     http.MethodPatch,
+			body:       `{"content": "only [[Drinks]]", "id": 2}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[GET] backlinks after edit",
+			method:     http.MethodGet,
+			path:       "/notes/1/backlinks",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:       "[DELETE] target",
+			method:     http.MethodDelete,
+			body:       `{"id": 3}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[GET] broken after delete",
+			method:     http.MethodGet,
+			path:       "/links/broken",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"source_id": 2, "target": "Drinks", "target_id": 0}]`,
+		},
+		{
+			name:        "[GET] links of a missing note",
+			method:      http.MethodGet,
+			path:        "/notes/42/links",
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to get links: note not found"}`,
+		},
+	})
+}
+
+// TestLinksBackfill starts a server on notes stored before links were kept,
+// which have their links parsed on start.
+func TestLinksBackfill(t *testing.T) {
+	storagePath := filepath.Join(t.TempDir(), "notes.db")
+	if err := notestorage.Migrate(storagePath, migrationsPath); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	db, err := sql.Open("sqlite3", storagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO notes(id, header, content) VALUES
+		(1, 'Recipes', 'soup'),
+		(2, 'Menu', 'see [[recipes]], [[#1]] and [[Drinks]]')`)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.StoragePath = storagePath
+	})
+
+	run(t, server, []step{
+		{
+			name:       "[GET] backfilled links",
+			method:     http.MethodGet,
+			path:       "/notes/2/links",
+			wantStatus: http.StatusOK,
+			wantJSON: `[{"source_id": 2, "target": "#1", "target_id": 1},
+				{"source_id": 2, "target": "Drinks", "target_id": 0},
+				{"source_id": 2, "target": "recipes", "target_id": 1}]`,
+		},
+		{
+			name:       "[GET] backfilled backlinks",
+			method:     http.MethodGet,
+			path:       "/notes/1/backlinks",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"source_id": 2, "target": "#1", "target_id": 1}, {"source_id": 2, "target": "recipes", "target_id": 1}]`,
+		},
+	})
+}
+
+func TestGraph(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:   "[BATCH] chain of notes",
+			method: http.MethodPost,
+			path:   "/batch",
+			body: `{"operations": [
+				{"op": "create", "header": "a", "content": "[[b]] [[#2]] [[missing]]"},
+				{"op": "create", "header": "b", "content": "[[c]]"},
+				{"op": "create", "header": "c", "content": "[[d]]"},
+				{"op": "create", "header": "d"},
+				{"op": "create", "header": "alone"}
+			]}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[GET] whole graph",
+			method:     http.MethodGet,
+			path:       "/graph",
+			wantStatus: http.StatusOK,
+			wantJSON: `{
+				"nodes": [
+					{"id": 1, "header": "a", "in_degree": 0, "out_degree": 1},
+					{"id": 2, "header": "b", "in_degree": 1, "out_degree": 1},
+					{"id": 3, "header": "c", "in_degree": 1, "out_degree": 1},
+					{"id": 4, "header": "d", "in_degree": 1, "out_degree": 0},
+					{"id": 5, "header": "alone", "in_degree": 0, "out_degree": 0}
+				],
+				"edges": [
+					{"source": 1, "target": 2, "links": 2},
+					{"source": 2, "target": 3, "links": 1},
+					{"source": 3, "target": 4, "links": 1}
+				],
+				"stats": {"nodes": 5, "edges": 3, "max_in_degree": 1, "max_out_degree": 1, "average_degree": 1.2, "isolated": 1}
+			}`,
+		},
+		{
+			name:       "[GET] neighbourhood",
+			method:     http.MethodGet,
+			path:       "/graph?note=2",
+			wantStatus: http.StatusOK,
+			wantJSON: `{
+				"nodes": [
+					{"id": 1, "header": "a", "in_degree": 0, "out_degree": 1},
+					{"id": 2, "header": "b", "in_degree": 1, "out_degree": 1},
+					{"id": 3, "header": "c", "in_degree": 1, "out_degree": 0}
+				],
+				"edges": [
+					{"source": 1, "target": 2, "links": 2},
+					{"source": 2, "target": 3, "links": 1}
+				],
+				"stats": {"nodes": 3, "edges": 2, "max_in_degree": 1, "max_out_degree": 1, "average_degree": 1.3333333333333333, "isolated": 0}
+			}`,
+		},
+		{
+			name:            "[GET] dot",
+			method:          http.MethodGet,
+			path:            "/graph?format=dot&note=1&depth=2",
+			wantStatus:      http.StatusOK,
+			wantContains:    []string{"digraph notes {", `n3 [label="c"];`, "n1 -> n2 [weight=2];"},
+			wantNotContains: []string{"n4"},
+			wantHeaders:     map[string]string{"Content-Type": "text/vnd.graphviz; charset=utf-8"},
+		},
+		{
+			name:         "[GET] graphml",
+			method:       http.MethodGet,
+			path:         "/graph?format=graphml",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`<edge source="n3" target="n4">`, `<data key="header">alone</data>`},
+			wantHeaders:  map[string]string{"Content-Type": "application/graphml+xml; charset=utf-8"},
+		},
+		{
+			name:       "[GET] invalid query",
+			method:     http.MethodGet,
+			path:       "/graph?format=png&note=x",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to export graph: format: must be one of json, dot, graphml; note: must be an integer",
+				"errors": [{"field": "format", "message": "must be one of json, dot, graphml"}, {"field": "note", "message": "must be an integer"}]}`,
+		},
+		{
+			name:       "[GET] depth out of range",
+			method:     http.MethodGet,
+			path:       "/graph?note=1&depth=6",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to export graph: depth: must be between 1 and 5",
+				"errors": [{"field": "depth", "message": "must be between 1 and 5"}]}`,
+		},
+		{
+			name:        "[GET] neighbourhood of a missing note",
+			method:      http.MethodGet,
+			path:        "/graph?note=42",
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to export graph: note not found"}`,
+		},
+	})
+}
+
+func TestExport(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "Shopping: list", "content": "- milk\n- [[Recipes]]"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:       "[ADD] note without slug",
+			method:     http.MethodPost,
+			body:       `{"header": "???", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:       "[GET] unsupported format",
+			method:     http.MethodGet,
+			path:       "/export?format=pdf",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to export notes: format: must be markdown",
+				"errors": [{"field": "format", "message": "must be markdown"}]}`,
+		},
+	})
+
+	res, err := server.Client().Get(server.URL + "/export?format=markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("status = %d, Content-Type = %q, want a zip", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("response is not a zip: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		r, err := f.Open()
 		if err != nil {
-			t.Error(err.Error())
-			t.Fail()
+			t.Fatal(err)
 		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+
+	if len(files) != 2 {
+		t.Fatalf("archive has %d files, want 2: %v", len(files), files)
+	}
+	shopping, ok := files["1-shopping-list.md"]
+	if !ok {
+		t.Fatalf("archive has no 1-shopping-list.md: %v", files)
+	}
+	for _, want := range []string{"---\nid: 1\nheader: 'Shopping: list'\ncreated_at: ", "\n---\n- milk\n- [[Recipes]]"} {
+		if !strings.Contains(shopping, want) {
+			t.Errorf("1-shopping-list.md doesn't contain %q:\n%s", want, shopping)
+		}
+	}
+	if _, ok := files["2.md"]; !ok {
+		t.Errorf("archive has no 2.md: %v", files)
+	}
+}
+
+func TestExportAttachments(t *testing.T) {
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.AttachmentDir = filepath.Join(t.TempDir(), "attachments")
 	})
 
-	req := bytes.NewBufferString(`{
-		"header": "wash the basement",
-		"content": "immediatly"
-	}`)
+	upload, uploadType := uploadOf(t, "file", "milk.txt", "", "2 l")
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte("2 l")))
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "Shopping", "content": "- milk"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:        "[UPLOAD] attachment",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: uploadType,
+			body:        upload,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"id": 1, "note_id": 1, "name": "milk.txt", "media_type": "text/plain; charset=utf-8", "size": 3, "sha256": "` + sum + `"}`,
+		},
+	})
 
-	var addedNote struct {
-		Id int `json:"id"`
+	res, err := server.Client().Get(server.URL + "/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("response is not a zip: %v", err)
 	}
 
-	t.Run("[ADD] note", func(t *testing.T) {
-		res, err := http.Post(url, "application/json", req)
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if want := []string{"1-shopping.md", "1-shopping/1-milk.txt"}; !slices.Equal(names, want) {
+		t.Fatalf("archive has %q, want %q", names, want)
+	}
+	r, err := archive.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "2 l" {
+		t.Errorf("attachment content = %q, want %q", content, "2 l")
+	}
+}
+
+func TestSlowBodiesOutlastReadTimeout(t *testing.T) {
+	server := newServerWith(t, func(s *http.Server) {
+		s.ReadTimeout = 500 * time.Millisecond
+	}, func(cfg *config.Config) {
+		cfg.AttachmentDir = filepath.Join(t.TempDir(), "attachments")
+	})
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "slow", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+	})
+
+	upload, uploadType := uploadOf(t, "file", "slow.txt", "", "sent slowly")
+	note, noteType := uploadOf(t, "file", "slow.md", "", "# Slow")
+	tests := []struct {
+		name         string
+		path         string
+		contentType  string
+		body         string
+		headers      map[string]string
+		wantContains string
+	}{
+		{"upload", "/notes/1/attachments", uploadType, upload, nil, `"name":"slow.txt"`},
+		// The body is spooled before the handler runs.
+		{"idempotent upload", "/notes/1/attachments", uploadType, upload, map[string]string{"Idempotency-Key": "slow"}, `"name":"slow.txt"`},
+		{"import", "/import", noteType, note, nil, `"created":1`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Half of the body comes after the read timeout.
+			pr, pw := io.Pipe()
+			go func() {
+				half := len(tt.body) / 2
+				io.WriteString(pw, tt.body[:half])
+				time.Sleep(time.Second)
+				io.WriteString(pw, tt.body[half:])
+				pw.Close()
+			}()
+			req, err := http.NewRequest(http.MethodPost, server.URL+tt.path, pr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			res, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusOK || !strings.Contains(string(body), tt.wantContains) {
+				t.Fatalf("POST %s = %d %s, want 200 with %s", tt.path, res.StatusCode, body, tt.wantContains)
+			}
+		})
+	}
+}
+
+func TestStreamsOutlastWriteTimeout(t *testing.T) {
+	server := newServerWith(t, func(s *http.Server) {
+		s.WriteTimeout = 500 * time.Millisecond
+	}, func(cfg *config.Config) {
+		cfg.AttachmentDir = filepath.Join(t.TempDir(), "attachments")
+	})
+
+	// Random content doesn't compress, so that the export is as large and
+	// can't all be buffered by the connection.
+	large := make([]byte, 16<<20)
+	rand.Read(large)
+	upload, uploadType := uploadOf(t, "file", "large.bin", "", string(large))
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "large", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:         "[UPLOAD] large attachment",
+			method:       http.MethodPost,
+			path:         "/notes/1/attachments",
+			contentType:  uploadType,
+			body:         upload,
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"size":16777216`},
+		},
+	})
+
+	for _, path := range []string{"/notes/1/attachments/1", "/export"} {
+		t.Run(path, func(t *testing.T) {
+			res, err := server.Client().Get(server.URL + path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			// A slow client is still reading when the timeout is up.
+			time.Sleep(time.Second)
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("reading the response after the write timeout: %v", err)
+			}
+			if len(body) < len(large) {
+				t.Fatalf("response has %d bytes, want at least %d", len(body), len(large))
+			}
+		})
+	}
+}
+
+// zipOf returns a zip archive of files, given as name and content pairs.
+func zipOf(t *testing.T, files ...string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		f, err := archive.Create(files[i])
 		if err != nil {
-			t.Error(err.Error())
-			t.Fail()
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
 		}
-		json.NewDecoder(res.Body).Decode(&addedNote)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.String()
+}
+
+func TestImport(t *testing.T) {
+	server := newServer(t)
+
+	archive := zipOf(t,
+		"notes/a.md", "---\nheader: Alpha\ncreated_at: 2024-05-06T07:08:09Z\ntags: [x]\n---\nfirst",
+		"b.md", "```\n# not this\n```\n## Beta ##\nlinks to [[alpha]]",
+		"notes/image.png", "\x89PNG",
+		".DS_Store", "",
+		"bad.md", "no heading at all",
+		"long.md", "# "+strings.Repeat("x", 40),
+		"huge.md", "# Huge\n"+strings.Repeat("x", 5000),
+	)
+	report := `{
+		"created": 2, "skipped": 2, "failed": 3,
+		"files": [
+			{"name": "notes/a.md", "status": "created", %s"header": "Alpha"},
+			{"name": "b.md", "status": "created", %s"header": "Beta"},
+			{"name": "notes/image.png", "status": "skipped"},
+			{"name": ".DS_Store", "status": "skipped"},
+			{"name": "bad.md", "status": "failed", "error": "no header in the front matter and no heading"},
+			{"name": "long.md", "status": "failed", "header": "` + strings.Repeat("x", 40) + `", "error": "header: must be at most 32 characters long"},
+			{"name": "huge.md", "status": "failed", "error": "file is too large"}
+		]`
+
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	part, err := form.CreateFormFile("files", "folder/c.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("# Gamma"))
+	form.WriteField("comment", "not a file")
+	form.Close()
+
+	run(t, server, []step{
+		{
+			name:        "[IMPORT] dry run",
+			method:      http.MethodPost,
+			path:        "/import?dry_run=true",
+			contentType: "application/zip",
+			body:        archive,
+			wantStatus:  http.StatusOK,
+			wantJSON:    fmt.Sprintf(report, "", "") + `, "dry_run": true}`,
+		},
+		{
+			name:       "[GET] nothing stored by the dry run",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:        "[IMPORT] zip",
+			method:      http.MethodPost,
+			path:        "/import",
+			contentType: "application/zip",
+			body:        archive,
+			wantStatus:  http.StatusOK,
+			wantJSON:    fmt.Sprintf(report, `"id": 1, `, `"id": 2, `) + `, "dry_run": false}`,
+		},
+		{
+			name:         "[GET] imported note keeps its timestamp",
+			method:       http.MethodGet,
+			path:         "/notes/1",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"created_at":"2024-05-06T07:08:09Z","updated_at":"2024-05-06T07:08:09Z"`},
+		},
+		{
+			name:       "[GET] links of imported notes",
+			method:     http.MethodGet,
+			path:       "/notes/1/backlinks",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"source_id": 2, "target": "alpha", "target_id": 1}]`,
+		},
+		{
+			name:        "[IMPORT] multipart folder upload",
+			method:      http.MethodPost,
+			path:        "/import",
+			contentType: form.FormDataContentType(),
+			body:        upload.String(),
+			wantStatus:  http.StatusOK,
+			wantJSON: `{"dry_run": false, "created": 1, "skipped": 0, "failed": 0,
+				"files": [{"name": "folder/c.md", "status": "created", "id": 3, "header": "Gamma"}]}`,
+		},
+		{
+			name:        "[IMPORT] not a zip",
+			method:      http.MethodPost,
+			path:        "/import",
+			contentType: "application/zip",
+			body:        "plain text",
+			wantStatus:  http.StatusBadRequest,
+			wantProblem: `{"code": "malformed_request", "detail": "Failed to read import: not a zip archive: zip: not a valid zip file"}`,
+		},
+		{
+			name:        "[IMPORT] empty archive",
+			method:      http.MethodPost,
+			path:        "/import",
+			contentType: "application/zip",
+			body:        zipOf(t),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to import notes: files: must contain at least one file",
+				"errors": [{"field": "files", "message": "must contain at least one file"}]}`,
+		},
+		{
+			name:        "[IMPORT] unsupported Content-Type",
+			method:      http.MethodPost,
+			path:        "/import",
+			contentType: "text/markdown",
+			body:        "# note",
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantProblem: `{"code": "unsupported_media_type", "detail": "Failed to import notes: unsupported Content-Type \"text/markdown\", want application/zip, multipart/form-data or application/enex+xml"}`,
+		},
+		{
+			name:        "[IMPORT] invalid dry_run",
+			method:      http.MethodPost,
+			path:        "/import?dry_run=maybe",
+			contentType: "application/zip",
+			body:        archive,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to import notes: dry_run: must be a boolean",
+				"errors": [{"field": "dry_run", "message": "must be a boolean"}]}`,
+		},
+	})
+}
+
+func TestImportENEX(t *testing.T) {
+	server := newServer(t)
+
+	export := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE en-export SYSTEM "http://xml.evernote.com/pub/evernote-export3.dtd">
+<en-export export-date="20240101T000000Z" application="Evernote">
+  <note>
+    <title>Groceries</title>
+    <content><![CDATA[<?xml version="1.0" encoding="UTF-8"?><en-note><div><b>Buy</b>:</div><ul><li>milk</li></ul><div><en-todo checked="true"/>[[Recipes]]</div><en-media hash="0cc175b9c0f1b6a831c399e269772661" type="image/png"/></en-note>]]></content>
+    <created>20240102T030405Z</created>
+    <updated>20240103T030405Z</updated>
+    <tag>home</tag>
+    <resource>
+      <data encoding="base64">YQ==</data>
+      <mime>image/png</mime>
+      <resource-attributes><file-name>a.png</file-name></resource-attributes>
+    </resource>
+  </note>
+  <note>
+    <title>Recipes</title>
+    <content><![CDATA[<en-note>Pancakes</en-note>]]></content>
+    <created>20240104T030405Z</created>
+    <tag>sunday breakfast</tag>
+    <tag>#sweet</tag>
+    <tag>sweet</tag>
+    <tag> </tag>
+  </note>
+  <note>
+    <title>Broken</title>
+    <content><![CDATA[<en-note>x</en-note>]]></content>
+    <created>yesterday</created>
+  </note>
+  <note>
+    <title></title>
+    <content><![CDATA[<en-note>untitled</en-note>]]></content>
+  </note>
+</en-export>`
+
+	run(t, server, []step{
+		{
+			name:        "[IMPORT] dry run",
+			method:      http.MethodPost,
+			path:        "/import?dry_run=1",
+			contentType: "application/enex+xml",
+			body:        export,
+			wantStatus:  http.StatusOK,
+			wantJSON: `{"dry_run": true, "created": 2, "skipped": 0, "failed": 2,
+				"files": [
+					{"name": "note 1", "status": "created", "header": "Groceries"},
+					{"name": "note 2", "status": "created", "header": "Recipes"},
+					{"name": "note 3", "status": "failed", "header": "Broken", "error": "invalid created date \"yesterday\""},
+					{"name": "note 4", "status": "failed", "error": "header: must contain any characters"}
+				]}`,
+		},
+		{
+			name:        "[IMPORT] enex",
+			method:      http.MethodPost,
+			path:        "/import",
+			contentType: "application/xml",
+			body:        export,
+			wantStatus:  http.StatusOK,
+			wantContains: []string{
+				`{"name":"note 1","status":"created","id":1,"header":"Groceries"}`,
+				`{"name":"note 2","status":"created","id":2,"header":"Recipes"}`,
+			},
+		},
+		{
+			name:       "[GET] converted content and dates",
+			method:     http.MethodGet,
+			path:       "/notes/1",
+			wantStatus: http.StatusOK,
+			wantContains: []string{
+				`"content":"**Buy**:\n\n- milk\n\n- [x] [[Recipes]]\n\n[attachment: a.png]\n\n#home\n"`,
+				`"created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-03T03:04:05Z"`,
+			},
+		},
+		{
+			name:         "[GET] tags as hashtags",
+			method:       http.MethodGet,
+			path:         "/notes/2",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"content":"Pancakes\n\n#sunday-breakfast #sweet\n"`},
+		},
+		{
+			name:       "[GET] links of imported notes",
+			method:     http.MethodGet,
+			path:       "/notes/2/backlinks",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"source_id": 1, "target": "Recipes", "target_id": 2}]`,
+		},
+		{
+			name:        "[IMPORT] not well-formed",
+			method:      http.MethodPost,
+			path:        "/import",
+			contentType: "text/xml",
+			body:        "<en-export><note><title>x</note>",
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to import notes: body: invalid ENEX file: XML syntax error on line 1: element <title> closed by </note>",
+				"errors": [{"field": "body", "message": "invalid ENEX file: XML syntax error on line 1: element <title> closed by </note>"}]}`,
+		},
+		{
+			name:       "[GET] nothing stored by a failed import",
+			method:     http.MethodGet,
+			path:       "/notes/3",
+			wantStatus: http.StatusNotFound,
+		},
+	})
+}
+
+func TestImportENEXAttachments(t *testing.T) {
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.AttachmentDir = filepath.Join(t.TempDir(), "attachments")
+		cfg.MaxAttachmentSize = 4
 	})
-	t.Log(addedNote.Id)
 
-	req = bytes.NewBufferString(`{
-			"header": "immediatly",
-			"content": "wash the basement",
-			"id": `)
+	export := `<?xml version="1.0" encoding="UTF-8"?>
+<en-export>
+  <note>
+    <title>Receipts</title>
+    <content><![CDATA[<en-note><en-media hash="0cc175b9c0f1b6a831c399e269772661" type="image/png"/></en-note>]]></content>
+    <resource>
+      <data encoding="base64">YQ==</data>
+      <mime>image/png</mime>
+      <resource-attributes><file-name>a.png</file-name></resource-attributes>
+    </resource>
+    <resource>
+      <data encoding="base64">dG9vIGxhcmdl</data>
+      <mime>text/plain</mime>
+    </resource>
+  </note>
+</en-export>`
+
+	run(t, server, []step{
+		{
+			name:        "[IMPORT] dry run stores no attachments",
+			method:      http.MethodPost,
+			path:        "/import?dry_run=true",
+			contentType: "application/enex+xml",
+			body:        export,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"dry_run": true, "created": 1, "skipped": 0, "failed": 0, "files": [{"name": "note 1", "status": "created", "header": "Receipts"}]}`,
+		},
+		{
+			name:        "[IMPORT] enex with resources",
+			method:      http.MethodPost,
+			path:        "/import",
+			contentType: "application/enex+xml",
+			body:        export,
+			wantStatus:  http.StatusOK,
+			wantJSON: `{"dry_run": false, "created": 1, "skipped": 0, "failed": 0, "files": [{"name": "note 1", "status": "created", "id": 1, "header": "Receipts",
+				"error": "attachment text/plain: attachment is too large: the limit is 4 bytes"}]}`,
+		},
+		{
+			name:       "[GET] resources as attachments",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"id": 1, "note_id": 1, "name": "a.png", "media_type": "image/png", "size": 1, "sha256": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"}]`,
+		},
+		{
+			name:         "[GET] mention kept in the content",
+			method:       http.MethodGet,
+			path:         "/notes/1",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"content":"[attachment: a.png]\n"`},
+		},
+	})
+}
 
-	req.Write([]byte(strconv.Itoa(addedNote.Id)))
-	req.WriteString(`}`)
+func TestBackup(t *testing.T) {
+	server := newServer(t)
 
-	t.Run("[PATCH] note", func(t *testing.T) {
-		patchReq, err := http.NewRequest(http.MethodPatch, url, req)
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "Shopping", "content": "- [[Recipes]]\n- [[#2]]"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:       "[ADD] linked note",
+			method:     http.MethodPost,
+			body:       `{"header": "Recipes", "content": "Pancakes"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:       "[ADD] note to delete",
+			method:     http.MethodPost,
+			body:       `{"header": "Gone", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 3}`,
+		},
+		{
+			name:       "[ADD] note after a gap",
+			method:     http.MethodPost,
+			body:       `{"header": "Last", "content": "[[Gone]]"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 4}`,
+		},
+		{
+			name:       "[DELETE] note",
+			method:     http.MethodDelete,
+			body:       `{"id": 3}`,
+			wantStatus: http.StatusOK,
+		},
+	})
+
+	backup := func(server *httptest.Server) []string {
+		t.Helper()
+
+		res, err := server.Client().Get(server.URL + "/admin/backup")
 		if err != nil {
-			t.Error(err.Error())
-			t.FailNow()
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("status = %d, Content-Type = %q, want a backup", res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		return strings.SplitAfter(strings.TrimSuffix(string(body), "\n"), "\n")
+	}
+
+	lines := backup(server)
+	if len(lines) != 8 {
+		t.Fatalf("backup has %d lines, want 8:\n%s", len(lines), strings.Join(lines, ""))
+	}
+	for i, want := range []string{
+		`{"type":"header","data":{"format":"notion-backup","version":1,"created_at":`,
+		`{"type":"note","data":{"header":"Shopping","content":"- [[Recipes]]\n- [[#2]]","id":1,"created_at":`,
+		`{"type":"note","data":{"header":"Recipes","content":"Pancakes","id":2,`,
+		`{"type":"note","data":{"header":"Last","content":"[[Gone]]","id":4,`,
+		`{"type":"link","data":{"source_id":1,"target":"#2"}}`,
+		`{"type":"link","data":{"source_id":1,"target":"Recipes"}}`,
+		`{"type":"link","data":{"source_id":4,"target":"Gone"}}`,
+		`{"type":"trailer","data":{"records":6,"sha256":"`,
+	} {
+		if !strings.HasPrefix(lines[i], want) {
+			t.Fatalf("line %d = %s, want it to start with %s", i+1, lines[i], want)
 		}
+	}
+	dump := strings.Join(lines, "")
+
+	restored := newServer(t)
+	run(t, restored, []step{
+		{
+			name:        "[RESTORE] edited backup",
+			method:      http.MethodPost,
+			path:        "/admin/restore",
+			contentType: "application/x-ndjson",
+			body:        strings.Replace(dump, "Pancakes", "Waffles", 1),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to restore backup: body: invalid backup: records don't match the checksum",
+				"errors": [{"field": "body", "message": "invalid backup: records don't match the checksum"}]}`,
+		},
+		{
+			name:        "[RESTORE] truncated backup",
+			method:      http.MethodPost,
+			path:        "/admin/restore",
+			contentType: "application/x-ndjson",
+			body:        strings.Join(lines[:3], ""),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to restore backup: body: invalid backup: no trailer, the backup is truncated",
+				"errors": [{"field": "body", "message": "invalid backup: no trailer, the backup is truncated"}]}`,
+		},
+		{
+			name:       "[GET] nothing stored by failed restores",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:        "[RESTORE] backup",
+			method:      http.MethodPost,
+			path:        "/admin/restore",
+			contentType: "application/x-ndjson",
+			body:        dump,
+			wantStatus:  http.StatusOK,
+			wantContains: []string{
+				`{"notes":3,"links":3,"attachments":0,"sha256":"` + strings.TrimPrefix(lines[7], `{"type":"trailer","data":{"records":6,"sha256":"`)[:64] + `"}`,
+			},
+		},
+		{
+			name:       "[GET] links resolve as before",
+			method:     http.MethodGet,
+			path:       "/notes/2/backlinks",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"source_id": 1, "target": "#2", "target_id": 2}, {"source_id": 1, "target": "Recipes", "target_id": 2}]`,
+		},
+		{
+			name:       "[ADD] ids go on after the restored ones",
+			method:     http.MethodPost,
+			body:       `{"header": "New", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 5}`,
+		},
+		{
+			name:        "[RESTORE] into a database with notes",
+			method:      http.MethodPost,
+			path:        "/admin/restore",
+			contentType: "application/x-ndjson",
+			body:        dump,
+			wantStatus:  http.StatusConflict,
+			wantProblem: `{"code": "conflict", "detail": "Failed to restore backup: database is not empty"}`,
+		},
+	})
+
+	run(t, restored, []step{{
+		name:       "[DELETE] note added after the restore",
+		method:     http.MethodDelete,
+		body:       `{"id": 5}`,
+		wantStatus: http.StatusOK,
+	}})
+	again := backup(restored)
+	if got, want := strings.Join(again[1:], ""), strings.Join(lines[1:], ""); got != want {
+		t.Fatalf("backup of the restored database differs:\n%s\nwant\n%s", got, want)
+	}
+
+	limited := newServer(t, func(cfg *config.Config) { cfg.MaxRestoreSize = 256 })
+	run(t, limited, []step{{
+		name:        "[RESTORE] backup over max_restore_size",
+		method:      http.MethodPost,
+		path:        "/admin/restore",
+		contentType: "application/x-ndjson",
+		body:        dump,
+		wantStatus:  http.StatusRequestEntityTooLarge,
+		wantProblem: `{"code": "too_large", "detail": "Request body is too large: the limit is 256 bytes"}`,
+	}})
+}
+
+func TestBackupAttachments(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "attachments")
+	server := newServer(t, func(cfg *config.Config) { cfg.AttachmentDir = dir })
+
+	hello := "hello, world"
+	helloSum := fmt.Sprintf("%x", sha256.Sum256([]byte(hello)))
+	upload, uploadType := uploadOf(t, "file", "hello.txt", "", hello)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "first", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:        "[UPLOAD] attachment",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: uploadType,
+			body:        upload,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"id": 1, "note_id": 1, "name": "hello.txt", "media_type": "text/plain; charset=utf-8", "size": 12, "sha256": "` + helloSum + `"}`,
+		},
+	})
+
+	res, err := server.Client().Get(server.URL + "/admin/backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dump := string(body)
+	want := `{"type":"attachment","data":{"id":1,"note_id":1,"name":"hello.txt","media_type":"text/plain; charset=utf-8","size":12,"sha256":"` + helloSum + `","created_at":`
+	if !strings.Contains(dump, "\n"+want) {
+		t.Fatalf("backup has no attachment record %s:\n%s", want, dump)
+	}
+
+	// The blobs are copied with the attachment directory, here by sharing it.
+	restored := newServer(t, func(cfg *config.Config) { cfg.AttachmentDir = dir })
+	run(t, restored, []step{
+		{
+			name:        "[RESTORE] attachment of a missing note",
+			method:      http.MethodPost,
+			path:        "/admin/restore",
+			contentType: "application/x-ndjson",
+			body:        strings.Replace(dump, `"note_id":1`, `"note_id":9`, 1),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to restore backup: body: invalid backup: attachment 1 of a missing note 9",
+				"errors": [{"field": "body", "message": "invalid backup: attachment 1 of a missing note 9"}]}`,
+		},
+		{
+			name:         "[RESTORE] backup",
+			method:       http.MethodPost,
+			path:         "/admin/restore",
+			contentType:  "application/x-ndjson",
+			body:         dump,
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`{"notes":1,"links":0,"attachments":1,"sha256":"`},
+		},
+		{
+			name:       "[DOWNLOAD] restored attachment",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments/1",
+			wantStatus: http.StatusOK,
+			wantBody:   hello,
+		},
+	})
+}
 
-		_, err = http.DefaultClient.Do(patchReq)
+func TestSnapshots(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.SnapshotDir = dir
+		cfg.SnapshotRetention = 2
+	})
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Files that are not snapshots are neither listed nor rotated.
+	if err := os.WriteFile(filepath.Join(dir, "notes.db"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run(t, server, []step{
+		{
+			name:       "[GET] no snapshots",
+			method:     http.MethodGet,
+			path:       "/admin/snapshots",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "kept", "content": "in the snapshot"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+	})
+
+	take := func() models.Snapshot {
+		t.Helper()
+
+		res, err := server.Client().Post(server.URL+"/admin/snapshots", "", nil)
 		if err != nil {
-			t.Error(err.Error())
-			t.Fail()
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
 		}
+		var snapshot models.Snapshot
+		if err := json.NewDecoder(res.Body).Decode(&snapshot); err != nil {
+			t.Fatal(err)
+		}
+		// Snapshots are named after the millisecond they are taken in.
+		time.Sleep(2 * time.Millisecond)
+		return snapshot
+	}
+
+	first := take()
+	if !strings.HasPrefix(first.Name, "notes-") || first.Size == 0 || first.CreatedAt.IsZero() {
+		t.Fatalf("snapshot = %+v", first)
+	}
+	// A copy is opened, opening a database adds files next to it.
+	data, err := os.ReadFile(filepath.Join(dir, first.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := filepath.Join(t.TempDir(), first.Name)
+	if err := os.WriteFile(copied, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, shutdown := notestorage.New(copied, slog.New(slog.DiscardHandler), notestorage.Options{})
+	note, err := snapshot.GetById(t.Context(), 1)
+	shutdown()
+	if err != nil || note.Header != "kept" {
+		t.Fatalf("GetById of the snapshot = %+v, %v", note, err)
+	}
+
+	second, third := take(), take()
+	res, err := server.Client().Get(server.URL + "/admin/snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var listed []models.Snapshot
+	if err := json.NewDecoder(res.Body).Decode(&listed); err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 2 || listed[0] != third || listed[1] != second {
+		t.Fatalf("snapshots = %+v, want the newest two: %+v, %+v", listed, third, second)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{second.Name, third.Name, "notes.db"}; !slices.Equal(names, want) {
+		t.Fatalf("files = %q, want the snapshots and notes.db", names)
+	}
+}
+
+func TestSnapshotsPruneJournal(t *testing.T) {
+	journalDir := filepath.Join(t.TempDir(), "journal")
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.SnapshotDir = filepath.Join(t.TempDir(), "snapshots")
+		cfg.SnapshotRetention = 1
+		cfg.JournalDir = journalDir
+		// Every change fills a segment.
+		cfg.JournalSegmentSize = 1
 	})
 
-	t.Run("[DELETE] note", func(t *testing.T) {
-		req := bytes.NewBufferString(`{
-		"id":
-	`)
-		req.Write([]byte(strconv.Itoa(addedNote.Id)))
-		req.WriteString(`}`)
-		deleteReq, err := http.NewRequest(http.MethodDelete, url, req)
+	add := step{name: "[ADD] note", method: http.MethodPost, body: `{"header": "note", "content": ""}`, wantStatus: http.StatusOK}
+	snapshot := step{name: "[SNAPSHOT] take", method: http.MethodPost, path: "/admin/snapshots", wantStatus: http.StatusOK}
+	segments := func() []string {
+		t.Helper()
+		names, err := filepath.Glob(filepath.Join(journalDir, "journal-*.jsonl"))
 		if err != nil {
-			t.Error(err.Error())
-			t.FailNow()
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	// The snapshot holds the changes of the first two segments, the third
+	// one is empty.
+	run(t, server, []step{add, add, snapshot})
+	if got := segments(); len(got) != 1 || filepath.Base(got[0]) != "journal-00000000000000000003.jsonl" {
+		t.Fatalf("segments = %q, want only the one after the snapshot", got)
+	}
+	// Snapshots are named after the millisecond they are taken in.
+	time.Sleep(2 * time.Millisecond)
+
+	// The second snapshot replaces the first one.
+	run(t, server, []step{add, snapshot})
+	if got := segments(); len(got) != 1 || filepath.Base(got[0]) != "journal-00000000000000000004.jsonl" {
+		t.Fatalf("segments = %q, want only the one after the second snapshot", got)
+	}
+}
+
+func TestSnapshotsDisabled(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:        "[GET] snapshots without a snapshot dir",
+			method:      http.MethodGet,
+			path:        "/admin/snapshots",
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to list snapshots: snapshots are not configured"}`,
+		},
+	})
+}
+
+func TestSnapshotsScheduled(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "snapshots")
+	newServer(t, func(cfg *config.Config) {
+		cfg.SnapshotDir = dir
+		cfg.SnapshotInterval = 10 * time.Millisecond
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".db") {
+				return
+			}
+		}
+	}
+	t.Fatal("no snapshot was taken on schedule")
+}
+
+func TestJournal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "journal")
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.JournalDir = dir
+	})
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "first", "content": "links [[second]]"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:       "[ADD] linked note",
+			method:     http.MethodPost,
+			body:       `{"header": "second", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:       "[EDIT] note",
+			method:     http.MethodPatch,
+			body:       `{"header": "first", "content": "edited", "id": 1}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[DELETE] linked note",
+			method:     http.MethodDelete,
+			body:       `{"id": 2}`,
+			wantStatus: http.StatusOK,
+		},
+	})
+
+	// The journal alone restores the database.
+	restoredPath := filepath.Join(t.TempDir(), "restored.db")
+	if err := notestorage.Migrate(restoredPath, migrationsPath); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	restored, shutdown := notestorage.New(restoredPath, slog.New(slog.DiscardHandler), notestorage.Options{})
+	defer shutdown()
+	if _, err := restored.Replay(t.Context(), dir, time.Now()); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+
+	all, err := restored.GetAll(t.Context())
+	if err != nil {
+		t.Fatalf("GetAll: %v", err)
+	}
+	if len(all) != 1 || all[0].Id != 1 || all[0].Content != "edited" {
+		t.Fatalf("restored notes = %+v, want the edited first note", all)
+	}
+	links, err := restored.GetAllLinks(t.Context())
+	if err != nil {
+		t.Fatalf("GetAllLinks: %v", err)
+	}
+	if len(links) != 0 {
+		t.Fatalf("restored links = %+v, want none", links)
+	}
+}
+
+// uploadOf builds a multipart upload of one file. An empty contentType is
+// sent as application/octet-stream.
+func uploadOf(t *testing.T, field string, name string, contentType string, content string) (body string, formType string) {
+	t.Helper()
+
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	form.WriteField("comment", "not a file")
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte(content))
+	form.Close()
+
+	return upload.String(), form.FormDataContentType()
+}
+
+// blobs lists the names of the blobs kept in an attachment dir.
+func blobs(t *testing.T, dir string) []string {
+	t.Helper()
+
+	var names []string
+	err := filepath.WalkDir(filepath.Join(dir, "blobs"), func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && entry.Type().IsRegular() {
+			names = append(names, entry.Name())
+		}
+		return err
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestAttachments(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "attachments")
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.AttachmentDir = dir
+		cfg.MaxAttachmentSize = 64
+	})
+
+	hello := "hello, world"
+	helloSum := fmt.Sprintf("%x", sha256.Sum256([]byte(hello)))
+	other := "other"
+	otherSum := fmt.Sprintf("%x", sha256.Sum256([]byte(other)))
+
+	helloUpload, helloType := uploadOf(t, "file", "hello.txt", "", hello)
+	copyUpload, copyType := uploadOf(t, "file", "копия.txt", "text/markdown", hello)
+	otherUpload, otherType := uploadOf(t, "file", "other.txt", "", other)
+	largeUpload, largeType := uploadOf(t, "file", "large.txt", "", strings.Repeat("x", 65))
+	noFile, noFileType := uploadOf(t, "files", "hello.txt", "", hello)
+	noName, noNameType := uploadOf(t, "file", " ", "", hello)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "first", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:       "[ADD] another note",
+			method:     http.MethodPost,
+			body:       `{"header": "second", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:       "[GET] no attachments",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:        "[UPLOAD] media type is detected",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: helloType,
+			body:        helloUpload,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"id": 1, "note_id": 1, "name": "hello.txt", "media_type": "text/plain; charset=utf-8", "size": 12, "sha256": "` + helloSum + `"}`,
+		},
+		{
+			name:        "[UPLOAD] same content to another note",
+			method:      http.MethodPost,
+			path:        "/notes/2/attachments",
+			contentType: copyType,
+			body:        copyUpload,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"id": 2, "note_id": 2, "name": "копия.txt", "media_type": "text/markdown", "size": 12, "sha256": "` + helloSum + `"}`,
+		},
+		{
+			name:        "[UPLOAD] missing note",
+			method:      http.MethodPost,
+			path:        "/notes/3/attachments",
+			contentType: helloType,
+			body:        helloUpload,
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to upload attachment: note not found"}`,
+		},
+		{
+			name:        "[UPLOAD] too large",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: largeType,
+			body:        largeUpload,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantProblem: `{"code": "too_large", "detail": "Failed to upload attachment: attachment is too large: the limit is 64 bytes"}`,
+		},
+		{
+			name:        "[UPLOAD] no file field",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: noFileType,
+			body:        noFile,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to upload attachment: file: is required", "errors": [{"field": "file", "message": "is required"}]}`,
+		},
+		{
+			name:        "[UPLOAD] blank file name",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: noNameType,
+			body:        noName,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to upload attachment: name: must contain any characters", "errors": [{"field": "name", "message": "must contain any characters"}]}`,
+		},
+		{
+			name:        "[UPLOAD] not multipart",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: "text/plain",
+			body:        hello,
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantProblem: `{"code": "unsupported_media_type", "detail": "Failed to upload attachment: unsupported Content-Type \"text/plain\", want multipart/form-data"}`,
+		},
+		{
+			name:       "[GET] attachments",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[{"id": 1, "note_id": 1, "name": "hello.txt", "media_type": "text/plain; charset=utf-8", "size": 12, "sha256": "` + helloSum + `"}]`,
+		},
+		{
+			name:       "[GET] attachments of a missing note",
+			method:     http.MethodGet,
+			path:       "/notes/3/attachments",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "[DOWNLOAD] attachment",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments/1",
+			wantStatus: http.StatusOK,
+			wantBody:   hello,
+			wantHeaders: map[string]string{
+				"Content-Type":        "text/plain; charset=utf-8",
+				"Content-Disposition": "attachment; filename=hello.txt",
+				"ETag":                `"` + helloSum + `"`,
+				"Accept-Ranges":       "bytes",
+			},
+		},
+		{
+			name:       "[DOWNLOAD] file name that isn't ASCII",
+			method:     http.MethodGet,
+			path:       "/notes/2/attachments/2",
+			wantStatus: http.StatusOK,
+			wantBody:   hello,
+			wantHeaders: map[string]string{
+				"Content-Disposition": "attachment; filename*=utf-8''%D0%BA%D0%BE%D0%BF%D0%B8%D1%8F.txt",
+			},
+		},
+		{
+			name:       "[DOWNLOAD] range",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments/1",
+			headers:    map[string]string{"Range": "bytes=7-"},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "world",
+			wantHeaders: map[string]string{
+				"Content-Range": "bytes 7-11/12",
+			},
+		},
+		{
+			name:       "[DOWNLOAD] unchanged",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments/1",
+			headers:    map[string]string{"If-None-Match": `"` + helloSum + `"`},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			name:        "[DOWNLOAD] attachment of another note",
+			method:      http.MethodGet,
+			path:        "/notes/2/attachments/1",
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to download attachment: attachment not found"}`,
+		},
+		{
+			name:       "[DELETE] note",
+			method:     http.MethodDelete,
+			body:       `{"id": 1}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[SWEEP] content still attached to another note",
+			method:     http.MethodPost,
+			path:       "/admin/attachments/sweep",
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"removed": 0, "freed": 0}`,
+		},
+	})
+	if got := blobs(t, dir); !slices.Equal(got, []string{helloSum}) {
+		t.Fatalf("blobs = %q, want %q", got, helloSum)
+	}
+
+	run(t, server, []step{
+		{
+			name:       "[DELETE] attachment",
+			method:     http.MethodDelete,
+			path:       "/notes/2/attachments/2",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[DELETE] deleted attachment",
+			method:     http.MethodDelete,
+			path:       "/notes/2/attachments/2",
+			wantStatus: http.StatusNotFound,
+		},
+	})
+	// The content goes with its last attachment.
+	if got := blobs(t, dir); len(got) != 0 {
+		t.Fatalf("blobs = %q, want none", got)
+	}
+
+	run(t, server, []step{
+		{
+			name:        "[UPLOAD] other content",
+			method:      http.MethodPost,
+			path:        "/notes/2/attachments",
+			contentType: otherType,
+			body:        otherUpload,
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"id": 3, "note_id": 2, "name": "other.txt", "media_type": "text/plain; charset=utf-8", "size": 5, "sha256": "` + otherSum + `"}`,
+		},
+		{
+			name:       "[DELETE] note with an attachment",
+			method:     http.MethodDelete,
+			body:       `{"id": 2}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[SWEEP] content of the deleted note",
+			method:     http.MethodPost,
+			path:       "/admin/attachments/sweep",
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"removed": 1, "freed": 5}`,
+		},
+	})
+	if got := blobs(t, dir); len(got) != 0 {
+		t.Fatalf("blobs = %q, want none", got)
+	}
+}
+
+// photo encodes a JPEG image as a camera would: with EXIF data holding its
+// orientation and the GPS latitude gpsLatitude.
+func photo(t *testing.T, width int, height int, orientation uint16) string {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// IFD0 at 8 links the GPS IFD at 38, whose latitude is at 56.
+	var exif bytes.Buffer
+	le := func(values ...any) {
+		for _, v := range values {
+			binary.Write(&exif, binary.LittleEndian, v)
 		}
-		http.DefaultClient.Do(deleteReq)
+	}
+	exif.WriteString("Exif\x00\x00II*\x00")
+	le(uint32(8), uint16(2))
+	le(uint16(0x0112), uint16(3), uint32(1), uint32(orientation))
+	le(uint16(0x8825), uint16(4), uint32(1), uint32(38), uint32(0))
+	le(uint16(1), uint16(0x0002), uint16(5), uint32(3), uint32(56), uint32(0))
+	exif.WriteString(gpsLatitude)
+
+	var b bytes.Buffer
+	b.Write(encoded.Bytes()[:2])
+	b.Write([]byte{0xff, 0xe1})
+	binary.Write(&b, binary.BigEndian, uint16(exif.Len()+2))
+	b.Write(exif.Bytes())
+	b.Write(encoded.Bytes()[2:])
+	return b.String()
+}
+
+// gpsLatitude is the raw latitude photo records, as 3 rationals.
+const gpsLatitude = "\x37\x00\x00\x00\x01\x00\x00\x00\x2d\x00\x00\x00\x01\x00\x00\x00\xd2\x04\x00\x00\x64\x00\x00\x00"
+
+// thumbnailOf fetches a thumbnail and returns its media type and dimensions.
+func thumbnailOf(t *testing.T, server *httptest.Server, path string) (mediaType string, width int, height int) {
+	t.Helper()
+
+	res, err := server.Client().Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status = %d, want %d", path, res.StatusCode, http.StatusOK)
+	}
+	config, _, err := image.DecodeConfig(res.Body)
+	if err != nil {
+		t.Fatalf("decoding thumbnail: %v", err)
+	}
+	return res.Header.Get("Content-Type"), config.Width, config.Height
+}
+
+func TestAttachmentImages(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "attachments")
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.AttachmentDir = dir
+		// A limit, as there is by default, must not get past stripping.
+		cfg.MaxAttachmentSize = 1 << 20
+	})
+
+	// Stored 400x200, displayed 200x400 as it is turned clockwise.
+	turned := photo(t, 400, 200, 6)
+	var stripped bytes.Buffer
+	if err := exif.StripGPS(&stripped, strings.NewReader(turned)); err != nil {
+		t.Fatal(err)
+	}
+	var transparent bytes.Buffer
+	if err := png.Encode(&transparent, image.NewNRGBA(image.Rect(0, 0, 300, 30))); err != nil {
+		t.Fatal(err)
+	}
+	// A 1x1 lossless WebP image.
+	webp, err := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	turnedUpload, turnedType := uploadOf(t, "file", "turned.jpg", "image/jpeg", turned)
+	pngUpload, pngType := uploadOf(t, "file", "wide.png", "", transparent.String())
+	webpUpload, webpType := uploadOf(t, "file", "dot.webp", "", string(webp))
+	textUpload, textType := uploadOf(t, "file", "hello.txt", "", "hello, world")
+	brokenUpload, brokenType := uploadOf(t, "file", "broken.jpg", "", "\xff\xd8\xff\xe1\x00")
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "photos", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:         "[UPLOAD] photo keeping its position",
+			method:       http.MethodPost,
+			path:         "/notes/1/attachments",
+			contentType:  turnedType,
+			body:         turnedUpload,
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"width":200`, `"height":400`, `"format":"jpeg"`},
+		},
+		{
+			name:         "[UPLOAD] photo without its position",
+			method:       http.MethodPost,
+			path:         "/notes/1/attachments?strip_gps=true",
+			contentType:  turnedType,
+			body:         turnedUpload,
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"id":2`, `"width":200`, `"height":400`, `"format":"jpeg"`},
+		},
+		{
+			name:         "[UPLOAD] PNG",
+			method:       http.MethodPost,
+			path:         "/notes/1/attachments",
+			contentType:  pngType,
+			body:         pngUpload,
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"media_type":"image/png"`, `"width":300`, `"height":30`, `"format":"png"`},
+		},
+		{
+			name:         "[UPLOAD] WebP",
+			method:       http.MethodPost,
+			path:         "/notes/1/attachments",
+			contentType:  webpType,
+			body:         webpUpload,
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"media_type":"image/webp"`, `"width":1`, `"height":1`, `"format":"webp"`},
+		},
+		{
+			name:            "[UPLOAD] text has no dimensions",
+			method:          http.MethodPost,
+			path:            "/notes/1/attachments",
+			contentType:     textType,
+			body:            textUpload,
+			wantStatus:      http.StatusOK,
+			wantNotContains: []string{"width", "format"},
+		},
+		{
+			name:        "[UPLOAD] invalid strip_gps",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments?strip_gps=maybe",
+			contentType: turnedType,
+			body:        turnedUpload,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to upload attachment: strip_gps: must be a boolean", "errors": [{"field": "strip_gps", "message": "must be a boolean"}]}`,
+		},
+		{
+			name:        "[UPLOAD] malformed JPEG to strip",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments?strip_gps=1",
+			contentType: brokenType,
+			body:        brokenUpload,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to upload attachment: file: must be a well-formed JPEG file to strip its GPS position", "errors": [{"field": "file", "message": "must be a well-formed JPEG file to strip its GPS position"}]}`,
+		},
+		{
+			name:         "[DOWNLOAD] photo keeping its position",
+			method:       http.MethodGet,
+			path:         "/notes/1/attachments/1",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{gpsLatitude},
+		},
+		{
+			name:            "[DOWNLOAD] photo without its position",
+			method:          http.MethodGet,
+			path:            "/notes/1/attachments/2",
+			wantStatus:      http.StatusOK,
+			wantNotContains: []string{gpsLatitude},
+		},
+		{
+			name:       "[DOWNLOAD] photo without its position is otherwise whole",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments/2",
+			wantStatus: http.StatusOK,
+			wantBody:   strings.TrimSpace(stripped.String()),
+		},
+		{
+			name:        "[THUMBNAIL] not an image",
+			method:      http.MethodGet,
+			path:        "/notes/1/attachments/5/thumbnail",
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to get thumbnail: attachment has no thumbnail: it isn't an image"}`,
+		},
+		{
+			name:        "[THUMBNAIL] size that isn't made",
+			method:      http.MethodGet,
+			path:        "/notes/1/attachments/2/thumbnail?size=100",
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to get thumbnail: size: must be one of 64, 256, 1024", "errors": [{"field": "size", "message": "must be one of 64, 256, 1024"}]}`,
+		},
+		{
+			name:       "[THUMBNAIL] malformed size",
+			method:     http.MethodGet,
+			path:       "/notes/1/attachments/2/thumbnail?size=big",
+			wantStatus: http.StatusBadRequest,
+		},
+	})
+
+	tests := []struct {
+		path          string
+		wantType      string
+		wantWidth     int
+		wantHeight    int
+		wantThumbnail string
+	}{
+		{"/notes/1/attachments/2/thumbnail?size=64", "image/jpeg", 32, 64, "-64.jpg"},
+		{"/notes/1/attachments/2/thumbnail", "image/jpeg", 128, 256, "-256.jpg"},
+		{"/notes/1/attachments/3/thumbnail?size=64", "image/png", 64, 6, "-64.png"},
+		// Images that fit keep their dimensions.
+		{"/notes/1/attachments/3/thumbnail?size=1024", "image/png", 300, 30, "-1024.png"},
+		{"/notes/1/attachments/4/thumbnail?size=64", "image/png", 1, 1, "-64.png"},
+	}
+	for _, tt := range tests {
+		// The second request gets the thumbnail the first one made.
+		for range 2 {
+			mediaType, width, height := thumbnailOf(t, server, tt.path)
+			if mediaType != tt.wantType || width != tt.wantWidth || height != tt.wantHeight {
+				t.Errorf("GET %s = %s %dx%d, want %s %dx%d", tt.path, mediaType, width, height, tt.wantType, tt.wantWidth, tt.wantHeight)
+			}
+		}
+	}
+	thumbnails, err := filepath.Glob(filepath.Join(dir, "thumbs", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbnails) != len(tests) {
+		t.Fatalf("thumbnails = %q, want %d", thumbnails, len(tests))
+	}
+
+	run(t, server, []step{
+		{
+			name:       "[DELETE] stripped photo",
+			method:     http.MethodDelete,
+			path:       "/notes/1/attachments/2",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[DELETE] note",
+			method:     http.MethodDelete,
+			body:       `{"id": 1}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:         "[SWEEP] thumbnails go with their images",
+			method:       http.MethodPost,
+			path:         "/admin/attachments/sweep",
+			wantStatus:   http.StatusOK,
+			wantContains: []string{`"removed":4`},
+		},
+	})
+	thumbnails, err = filepath.Glob(filepath.Join(dir, "thumbs", "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thumbnails) != 0 {
+		t.Fatalf("thumbnails = %q, want none", thumbnails)
+	}
+}
+
+func TestAttachmentsDisabled(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:        "[GET] attachments without an attachment dir",
+			method:      http.MethodGet,
+			path:        "/notes/1/attachments",
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Failed to list attachments: attachments are not configured"}`,
+		},
+	})
+}
+
+func TestQuotas(t *testing.T) {
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.AttachmentDir = filepath.Join(t.TempDir(), "attachments")
+		cfg.MaxNotes = 2
+		cfg.MaxStoredBytes = 40
+	})
+
+	smallUpload, smallType := uploadOf(t, "file", "small.txt", "", "0123456789")
+	largeUpload, largeType := uploadOf(t, "file", "large.txt", "", strings.Repeat("x", 25))
+
+	run(t, server, []step{
+		{
+			name:       "[USAGE] empty",
+			method:     http.MethodGet,
+			path:       "/usage",
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"notes": 0, "note_bytes": 0, "attachments": 0, "attachment_bytes": 0, "bytes": 0, "max_notes": 2, "max_bytes": 40}`,
+		},
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "first", "content": "12345"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1}`,
+		},
+		{
+			name:       "[ADD] last note the quota allows",
+			method:     http.MethodPost,
+			body:       `{"header": "second", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:        "[ADD] note over the quota",
+			method:      http.MethodPost,
+			body:        `{"header": "third", "content": ""}`,
+			wantStatus:  http.StatusInsufficientStorage,
+			wantProblem: `{"code": "quota_exceeded", "detail": "Failed to add new note: quota exceeded: 2 notes are stored, the limit is 2"}`,
+		},
+		{
+			name:       "[BATCH] create over the quota",
+			method:     http.MethodPost,
+			path:       "/batch",
+			body:       `{"operations": [{"op": "delete", "id": 2}, {"op": "create", "header": "a"}, {"op": "create", "header": "b"}]}`,
+			wantStatus: http.StatusInsufficientStorage,
+			wantProblem: `{
+				"code": "quota_exceeded",
+				"detail": "Failed to run batch: operation 2: quota exceeded: 2 notes are stored, the limit is 2",
+				"results": [
+					{"op": "delete", "status": "rolled_back", "id": 2},
+					{"op": "create", "status": "rolled_back"},
+					{"op": "create", "status": "failed", "error": "quota exceeded: 2 notes are stored, the limit is 2"}
+				]
+			}`,
+		},
+		{
+			name:        "[EDIT] content over the quota",
+			method:      http.MethodPatch,
+			body:        `{"id": 1, "content": "` + strings.Repeat("y", 30) + `"}`,
+			wantStatus:  http.StatusInsufficientStorage,
+			wantProblem: `{"code": "quota_exceeded", "detail": "Failed to edit note: quota exceeded: 16 bytes are stored and 25 more would be, the limit is 40 bytes"}`,
+		},
+		{
+			name:        "[UPLOAD] attachment over the quota",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: largeType,
+			body:        largeUpload,
+			wantStatus:  http.StatusInsufficientStorage,
+			wantProblem: `{"code": "quota_exceeded", "detail": "Failed to upload attachment: quota exceeded: 16 bytes are stored and 25 more would be, the limit is 40 bytes"}`,
+		},
+		{
+			name:        "[UPLOAD] attachment within the quota",
+			method:      http.MethodPost,
+			path:        "/notes/1/attachments",
+			contentType: smallType,
+			body:        smallUpload,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:       "[USAGE] full",
+			method:     http.MethodGet,
+			path:       "/usage",
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"notes": 2, "note_bytes": 16, "attachments": 1, "attachment_bytes": 10, "bytes": 26, "max_notes": 2, "max_bytes": 40}`,
+		},
+		{
+			name:       "[EDIT] shrinking is allowed",
+			method:     http.MethodPatch,
+			body:       `{"id": 1, "content": "1"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[DELETE] note with its attachment",
+			method:     http.MethodDelete,
+			body:       `{"id": 1}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[ADD] note in the freed room",
+			method:     http.MethodPost,
+			body:       `{"header": "third", "content": ""}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 3}`,
+		},
+		{
+			name:       "[USAGE] after deleting",
+			method:     http.MethodGet,
+			path:       "/usage",
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"notes": 2, "note_bytes": 11, "attachments": 0, "attachment_bytes": 0, "bytes": 11, "max_notes": 2, "max_bytes": 40}`,
+		},
+	})
+}
+
+func TestUsageWithoutQuotas(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:       "[ADD] note",
+			method:     http.MethodPost,
+			body:       `{"header": "note", "content": ""}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[USAGE] quotas that aren't set are left out",
+			method:     http.MethodGet,
+			path:       "/usage",
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"notes": 1, "note_bytes": 4, "attachments": 0, "attachment_bytes": 0, "bytes": 4}`,
+		},
+	})
+}
+
+func TestEncryptedNotes(t *testing.T) {
+	server := newServer(t, func(cfg *config.Config) {
+		cfg.MaxContentLength = 256
+	})
+	client := &e2enote.Client{BaseURL: server.URL, HTTPClient: server.Client(), Iterations: 1000}
+	ctx := t.Context()
+
+	id, err := client.Create(ctx, "Diary", []byte("met [[Recipes]] at noon"), "correct horse")
+	if err != nil || id != 1 {
+		t.Fatalf("Create = %d, %v, want 1", id, err)
+	}
+	note, plaintext, err := client.Get(ctx, id, "correct horse")
+	if err != nil || string(plaintext) != "met [[Recipes]] at noon" {
+		t.Fatalf("Get = %q, %v", plaintext, err)
+	}
+	if note.Header != "Diary" || strings.Contains(note.Content, "noon") || note.KDF.Algorithm != e2enote.AlgorithmPBKDF2 {
+		t.Fatalf("note = %+v, want an encrypted note with its kdf", note)
+	}
+	if _, _, err := client.Get(ctx, id, "battery staple"); !errors.Is(err, e2enote.ErrDecrypt) {
+		t.Fatalf("Get with the wrong passphrase: got %v, want %v", err, e2enote.ErrDecrypt)
+	}
+
+	kdf, _ := json.Marshal(note.KDF)
+	run(t, server, []step{
+		{
+			name:       "[ADD] link target",
+			method:     http.MethodPost,
+			body:       `{"header": "Recipes", "content": "soup"}`,
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 2}`,
+		},
+		{
+			name:       "[GET] no links",
+			method:     http.MethodGet,
+			path:       "/notes/1/links",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:       "[GET] no backlinks",
+			method:     http.MethodGet,
+			path:       "/notes/2/backlinks",
+			wantStatus: http.StatusOK,
+			wantJSON:   `[]`,
+		},
+		{
+			name:        "[GET] json despite Accept",
+			method:      http.MethodGet,
+			path:        "/notes/1",
+			headers:     map[string]string{"Accept": "text/html"},
+			wantStatus:  http.StatusOK,
+			wantJSON:    `{"id": 1, "header": "Diary", "content": "` + note.Content + `", "type": "encrypted", "kdf": ` + string(kdf) + `}`,
+			wantHeaders: map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			name:       "[GET] html",
+			method:     http.MethodGet,
+			path:       "/notes/1?format=html",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to get note: format: must be json for an encrypted note",
+				"errors": [{"field": "format", "message": "must be json for an encrypted note"}]}`,
+		},
+		{
+			name:        "[PATCH] type",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"type": "", "content": "c291cA=="}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: type: can't be changed",
+				"errors": [{"field": "type", "message": "can't be changed"}]}`,
+		},
+		{
+			name:        "[PATCH] kdf without content",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"kdf": {"algorithm": "pbkdf2-sha256", "salt": "c2FsdHNhbHRzYWx0c2FsdA==", "iterations": 1}}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: kdf: can only change together with content",
+				"errors": [{"field": "kdf", "message": "can only change together with content"}]}`,
+		},
+		{
+			name:       "[GET] kdf kept",
+			method:     http.MethodGet,
+			path:       "/notes/1",
+			wantStatus: http.StatusOK,
+			wantJSON:   `{"id": 1, "header": "Diary", "content": "` + note.Content + `", "type": "encrypted", "kdf": ` + string(kdf) + `}`,
+		},
+		{
+			name:        "[PATCH] content not base64",
+			method:      http.MethodPatch,
+			path:        "/notes/1",
+			contentType: "application/merge-patch+json",
+			body:        `{"content": "soup!"}`,
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to patch note: content: must be base64 for an encrypted note",
+				"errors": [{"field": "content", "message": "must be base64 for an encrypted note"}]}`,
+		},
+		{
+			name:       "[EDIT] content not base64",
+			method:     http.MethodPatch,
+			body:       `{"id": 1, "content": "soup!"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to edit note: content: must be base64 for an encrypted note",
+				"errors": [{"field": "content", "message": "must be base64 for an encrypted note"}]}`,
+		},
+		{
+			name:       "[ADD] short salt",
+			method:     http.MethodPost,
+			body:       `{"header": "Secret", "content": "c291cA==", "type": "encrypted", "kdf": {"algorithm": "pbkdf2-sha256", "salt": "c2FsdA==", "iterations": 1}}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: kdf.salt: must be at least 16 bytes in base64",
+				"errors": [{"field": "kdf.salt", "message": "must be at least 16 bytes in base64"}]}`,
+		},
+		{
+			name:       "[ADD] note id not base64",
+			method:     http.MethodPost,
+			body:       `{"header": "Secret", "content": "c291cA==", "type": "encrypted", "kdf": {"algorithm": "pbkdf2-sha256", "salt": "c2FsdHNhbHRzYWx0c2FsdA==", "iterations": 1, "note_id": "note!"}}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: kdf.note_id: must be at most 64 characters of base64",
+				"errors": [{"field": "kdf.note_id", "message": "must be at most 64 characters of base64"}]}`,
+		},
+		{
+			name:       "[ADD] without kdf",
+			method:     http.MethodPost,
+			body:       `{"header": "Secret", "content": "c291cA==", "type": "encrypted"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: kdf.algorithm: must be 1 to 32 lowercase letters, digits and dashes",
+				"errors": [{"field": "kdf.algorithm", "message": "must be 1 to 32 lowercase letters, digits and dashes"}]}`,
+		},
+		{
+			name:       "[ADD] markdown with kdf",
+			method:     http.MethodPost,
+			body:       `{"header": "Secret", "content": "soup", "kdf": ` + string(kdf) + `}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: kdf: must be empty for a Markdown note",
+				"errors": [{"field": "kdf", "message": "must be empty for a Markdown note"}]}`,
+		},
+		{
+			name:       "[ADD] unknown type",
+			method:     http.MethodPost,
+			body:       `{"header": "Secret", "content": "soup", "type": "pgp"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantProblem: `{"code": "validation_failed", "detail": "Failed to add new note: type: unknown type \"pgp\", want empty or \"encrypted\"",
+				"errors": [{"field": "type", "message": "unknown type \"pgp\", want empty or \"encrypted\""}]}`,
+		},
+	})
+
+	if err := client.Update(ctx, id, []byte("met at one"), "correct horse"); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	if err := client.ChangePassphrase(ctx, id, "correct horse", "battery staple"); err != nil {
+		t.Fatalf("ChangePassphrase: %v", err)
+	}
+	if _, _, err := client.Get(ctx, id, "correct horse"); !errors.Is(err, e2enote.ErrDecrypt) {
+		t.Fatalf("Get with the old passphrase: got %v, want %v", err, e2enote.ErrDecrypt)
+	}
+	changed, plaintext, err := client.Get(ctx, id, "battery staple")
+	if err != nil || string(plaintext) != "met at one" {
+		t.Fatalf("Get with the new passphrase = %q, %v, want %q", plaintext, err, "met at one")
+	}
+	if changed.KDF.Salt == note.KDF.Salt {
+		t.Errorf("salt %q was kept with the new passphrase", changed.KDF.Salt)
+	}
+
+	// Export renders notes as Markdown, which encrypted ones aren't.
+	res, err := server.Client().Get(server.URL + "/export?format=markdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("response is not a zip: %v", err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+	}
+	if !slices.Equal(names, []string{"2-recipes.md"}) {
+		t.Errorf("archive has %v, want only 2-recipes.md", names)
+	}
+}
+
+func TestImportDoesNotBlockWrites(t *testing.T) {
+	server := newServer(t)
+
+	// The export is sent in two parts, and notes are added while the
+	// second one is held back.
+	body, w := io.Pipe()
+	defer w.Close()
+	imported := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post(server.URL+"/import", "application/enex+xml", body)
 		if err != nil {
-			t.Error(err.Error())
-			t.Fail()
+			t.Errorf("POST /import: %v", err)
+			close(imported)
+			return
 		}
+		imported <- resp
+	}()
+	if _, err := io.WriteString(w, `<en-export><note><title>First</title><content><![CDATA[<en-note>one</en-note>]]></content></note>`); err != nil {
+		t.Fatalf("write export: %v", err)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(server.URL+"/", "application/json", strings.NewReader(`{"header": "Meanwhile", "content": "added"}`))
+	if err != nil {
+		t.Fatalf("POST / during an import: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST / during an import: status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if _, err := io.WriteString(w, `<note><title>Second</title><content><![CDATA[<en-note>two</en-note>]]></content></note></en-export>`); err != nil {
+		t.Fatalf("write export: %v", err)
+	}
+	w.Close()
+	resp, ok := <-imported
+	if !ok {
+		return
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(data), `"created":2`) {
+		t.Fatalf("POST /import: status = %d, body = %s", resp.StatusCode, data)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	server := newServer(t)
+
+	run(t, server, []step{
+		{
+			name:        "[BACKUP] no token",
+			method:      http.MethodGet,
+			path:        "/admin/backup",
+			headers:     map[string]string{"Authorization": ""},
+			wantStatus:  http.StatusUnauthorized,
+			wantProblem: `{"code": "unauthorized", "detail": "Admin routes need an Authorization: Bearer header with the admin token"}`,
+			wantHeaders: map[string]string{"WWW-Authenticate": `Bearer realm="admin"`},
+		},
+		{
+			name:       "[BACKUP] wrong token",
+			method:     http.MethodGet,
+			path:       "/admin/backup",
+			headers:    map[string]string{"Authorization": "Bearer " + testAdminToken + "x"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "[BACKUP] token of another scheme",
+			method:     http.MethodGet,
+			path:       "/admin/backup",
+			headers:    map[string]string{"Authorization": "Basic " + testAdminToken},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:        "[RESTORE] no token, nothing read",
+			method:      http.MethodPost,
+			path:        "/admin/restore",
+			contentType: "application/x-ndjson",
+			headers:     map[string]string{"Authorization": "Bearer"},
+			body:        "not a backup",
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:       "[SWEEP] no token",
+			method:     http.MethodPost,
+			path:       "/admin/attachments/sweep",
+			headers:    map[string]string{"Authorization": ""},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "[BACKUP] token",
+			method:     http.MethodGet,
+			path:       "/admin/backup",
+			headers:    map[string]string{"Authorization": "bearer " + testAdminToken},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "[GET] other routes need no token",
+			method:     http.MethodGet,
+			path:       "/usage",
+			wantStatus: http.StatusOK,
+		},
+	})
+
+	// Without a token the admin routes aren't served.
+	server = newServer(t, func(cfg *config.Config) { cfg.AdminToken = "" })
+	run(t, server, []step{
+		{
+			name:        "[BACKUP] not served",
+			method:      http.MethodGet,
+			path:        "/admin/backup",
+			headers:     map[string]string{"Authorization": "Bearer "},
+			wantStatus:  http.StatusNotFound,
+			wantProblem: `{"code": "not_found", "detail": "Not found"}`,
+		},
 	})
 }